@@ -16,6 +16,8 @@ import (
 const (
 	gcInterval     = 5 * time.Minute
 	gcDiscardRatio = 0.5
+
+	compactWorkers = 2
 )
 
 // NewLogAdapter returns a badger.Logger backed by an oasis-node logger.
@@ -104,3 +106,45 @@ func NewGCWorker(logger *logging.Logger, db *badger.DB) *GCWorker {
 		startOne: cmSync.NewOne(),
 	}
 }
+
+// Compact flattens the LSM tree of the given database and then runs value log GC until there is
+// nothing left to rewrite.
+//
+// This may be long-running and I/O heavy. The context is only checked between steps as the
+// individual BadgerDB operations cannot be interrupted. Reads may proceed concurrently.
+func Compact(ctx context.Context, db *badger.DB) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := db.Flatten(compactWorkers); err != nil {
+		return fmt.Errorf("failed to flatten LSM tree: %w", err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch err := db.RunValueLogGC(gcDiscardRatio); err {
+		case nil:
+		case badger.ErrNoRewrite, badger.ErrRejected, badger.ErrGCInMemoryMode:
+			// Either nothing left to rewrite, another value log GC is already in progress or
+			// there is no value log.
+			return nil
+		default:
+			return fmt.Errorf("failed to GC value log: %w", err)
+		}
+	}
+}
+
+// BlockCacheUsage returns the current size (in bytes) and the number of entries of the block
+// cache of the given database.
+//
+// The values are derived from running cache metrics so this is cheap to call.
+func BlockCacheUsage(db *badger.DB) (int64, int) {
+	metrics := db.BlockCacheMetrics()
+	if metrics == nil {
+		return 0, 0
+	}
+	return int64(metrics.CostAdded() - metrics.CostEvicted()), int(metrics.KeysAdded() - metrics.KeysEvicted())
+}
@@ -123,6 +123,30 @@ func (ss *ServiceStore) Delete(key []byte) error {
 	})
 }
 
+// Keys returns all keys in the service store starting with the given prefix, in ascending order.
+func (ss *ServiceStore) Keys(prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := ss.store.db.View(func(tx *badger.Txn) error {
+		dbPrefix := ss.dbKey(prefix)
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = dbPrefix
+
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		nameLen := len(ss.name) + 1
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil)[nameLen:])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 func (ss *ServiceStore) dbKey(key []byte) []byte {
 	return bytes.Join([][]byte{ss.name, key}, []byte{'.'})
 }
@@ -30,4 +30,21 @@ func TestPersistent(t *testing.T) {
 	nonexistentKey := []byte("baz")
 	err = svc.GetCBOR(nonexistentKey, &valOut)
 	assert.Equal(t, ErrNotFound, err, "GetCBOR(nonexistent)")
+
+	// Keys.
+	for _, k := range []string{"prefix.b", "prefix.a", "other"} {
+		err = svc.PutCBOR([]byte(k), &val)
+		assert.NoError(t, err, "PutCBOR")
+	}
+	otherSvc := common.GetServiceStore("persistent_test_other")
+	err = otherSvc.PutCBOR([]byte("prefix.c"), &val)
+	assert.NoError(t, err, "PutCBOR")
+
+	keys, err := svc.Keys([]byte("prefix."))
+	assert.NoError(t, err, "Keys")
+	assert.Equal(t, [][]byte{[]byte("prefix.a"), []byte("prefix.b")}, keys, "Keys")
+
+	keys, err = svc.Keys([]byte("nonexistent"))
+	assert.NoError(t, err, "Keys(nonexistent)")
+	assert.Empty(t, keys, "Keys(nonexistent)")
 }
@@ -2,24 +2,125 @@ package pcs
 
 import (
 	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
 	"github.com/oasisprotocol/oasis-core/go/common/persistent"
 )
 
 const (
 	tcbBundleCacheKeyPrefix                = "tcb_bundle_cache"
+	tcbBundleCacheIndexKey                 = "tcb_bundle_cache_index"
 	tcbEvaluationDataNumbersCacheKeyPrefix = "tcb_evaluation_data_numbers_cache"
 
-	tcbCacheRefreshThreshold    = 14 * 24 * time.Hour
-	tcbCacheSlowRefreshInterval = 24 * time.Hour
+	defaultTCBCacheRefreshThreshold    = 14 * 24 * time.Hour
+	defaultTCBCacheSlowRefreshInterval = 24 * time.Hour
+	defaultTCBCacheMaxBundles          = 16
+	defaultTCBCacheNegativeTTL         = 5 * time.Minute
 )
 
-func tcbBundleCacheKey(teeType TeeType) []byte {
-	return []byte(fmt.Sprintf("%s.%d", tcbBundleCacheKeyPrefix, teeType))
+var (
+	// ErrTCBBundleOlderThanCached is the error returned when loading a TCB bundle that expires
+	// before the one already cached for the same TEE type, platform type and FMSPC.
+	ErrTCBBundleOlderThanCached = errors.New("pcs: TCB bundle older than the cached one")
+
+	// ErrTCBBundleFMSPCMismatch is the error returned when caching a TCB bundle under an FMSPC
+	// that is different from the one contained in the bundle.
+	ErrTCBBundleFMSPCMismatch = errors.New("pcs: TCB bundle FMSPC mismatch")
+)
+
+// TCBCacheConfig is the TCB cache configuration.
+type TCBCacheConfig struct {
+	// RefreshThreshold is the duration before the expected expiry of a cached TCB bundle at which
+	// the cache starts periodically refreshing the bundle.
+	//
+	// If zero, a default of 14 days is used.
+	RefreshThreshold time.Duration
+
+	// SlowRefreshInterval is the interval at which cached TCB bundles within the refresh threshold
+	// and cached TCB evaluation data numbers are refreshed.
+	//
+	// If zero, a default of 24 hours is used.
+	SlowRefreshInterval time.Duration
+
+	// MaxBundles is the maximum number of cached TCB bundles (across all TEE types, platform
+	// types and FMSPCs). When exceeded, the least recently used bundles are evicted.
+	//
+	// If zero, a default of 16 is used. Negative values are invalid.
+	MaxBundles int
+
+	// NegativeTTL is the duration for which a TCB bundle that the PCS reported as not found is
+	// remembered as absent, so that repeated lookups do not result in repeated fetches.
+	//
+	// If zero, a default of 5 minutes is used. If negative, not found results are not remembered.
+	NegativeTTL time.Duration
+
+	// MaxRefreshJitter is the maximum duration by which the start of periodic refreshes of each
+	// cached TCB bundle is delayed, so that caches seeded at the same time do not all refresh
+	// their bundles at once. The jitter is chosen pseudo-randomly per cache instance and bundle
+	// and remains stable across checks. It is capped at the refresh threshold.
+	//
+	// If zero, no jitter is applied.
+	MaxRefreshJitter time.Duration
+
+	// Clock returns the current time and is used for all expiry and refresh decisions. It can be
+	// used to inject a skew-corrected clock in case the wall clock is not reliable.
+	//
+	// The function must be safe for concurrent use. If nil, time.Now is used.
+	Clock func() time.Time
+}
+
+// Validate validates the TCB cache configuration.
+func (cfg TCBCacheConfig) Validate() error {
+	if cfg.MaxBundles < 0 {
+		return fmt.Errorf("pcs: invalid maximum number of cached TCB bundles: %d", cfg.MaxBundles)
+	}
+	return nil
+}
+
+func (cfg TCBCacheConfig) withDefaults() TCBCacheConfig {
+	if cfg.RefreshThreshold == 0 {
+		cfg.RefreshThreshold = defaultTCBCacheRefreshThreshold
+	}
+	if cfg.SlowRefreshInterval == 0 {
+		cfg.SlowRefreshInterval = defaultTCBCacheSlowRefreshInterval
+	}
+	if cfg.MaxBundles == 0 {
+		cfg.MaxBundles = defaultTCBCacheMaxBundles
+	}
+	if cfg.NegativeTTL == 0 {
+		cfg.NegativeTTL = defaultTCBCacheNegativeTTL
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+	return cfg
+}
+
+func tcbBundleCacheKey(teeType TeeType, platformType PlatformType, fmspc []byte) []byte {
+	return []byte(fmt.Sprintf("%s.%d.%d.%x", tcbBundleCacheKeyPrefix, teeType, platformType, fmspc))
+}
+
+// legacyTCBBundleCacheKey returns the key used by caches that stored a single FMSPC per TEE type
+// and platform type.
+func legacyTCBBundleCacheKey(teeType TeeType, platformType PlatformType) []byte {
+	if platformType == PlatformTypeStandard {
+		return []byte(fmt.Sprintf("%s.%d", tcbBundleCacheKeyPrefix, teeType))
+	}
+	return []byte(fmt.Sprintf("%s.%d.%d", tcbBundleCacheKeyPrefix, teeType, platformType))
 }
 
 func tcbEvaluationDataNumbersCacheKey(teeType TeeType) []byte {
@@ -57,6 +158,40 @@ type tcbBundleCache struct {
 	FMSPC          []byte     `json:"fmspc"`
 	ExpectedExpiry time.Time  `json:"expected_expiry"`
 	LastUpdate     time.Time  `json:"last_update"`
+	ForceRefresh   bool       `json:"force_refresh,omitempty"`
+
+	// Checksum is the hash of the serialized bundle, used to detect corruption of the persistent
+	// store. Entries cached before checksums were introduced have no checksum.
+	Checksum *hash.Hash `json:"checksum,omitempty"`
+}
+
+// bundleChecksum computes the checksum of the given TCB bundle.
+func bundleChecksum(tcbBundle *TCBBundle) *hash.Hash {
+	h := hash.NewFrom(tcbBundle)
+	return &h
+}
+
+// isCorrupted returns true iff the cached bundle does not match its checksum.
+func (c *tcbBundleCache) isCorrupted() bool {
+	if c.Checksum == nil {
+		return false
+	}
+	return c.Bundle == nil || !bundleChecksum(c.Bundle).Equal(c.Checksum)
+}
+
+// tcbBundleCacheIndexEntry identifies a cached TCB bundle.
+type tcbBundleCacheIndexEntry struct {
+	TeeType      TeeType      `json:"tee_type"`
+	PlatformType PlatformType `json:"platform_type"`
+	FMSPC        []byte       `json:"fmspc"`
+}
+
+func (e *tcbBundleCacheIndexEntry) key() []byte {
+	return tcbBundleCacheKey(e.TeeType, e.PlatformType, e.FMSPC)
+}
+
+func (e *tcbBundleCacheIndexEntry) matches(teeType TeeType, platformType PlatformType, fmspc []byte) bool {
+	return e.TeeType == teeType && e.PlatformType == platformType && bytes.Equal(e.FMSPC, fmspc)
 }
 
 type tcbEvaluationDataNumbersCache struct {
@@ -64,10 +199,74 @@ type tcbEvaluationDataNumbersCache struct {
 	LastUpdate time.Time `json:"last_update"`
 }
 
+// TCBCacheStats is a snapshot of TCB cache statistics.
+type TCBCacheStats struct {
+	// Hits is the number of lookups that returned a cached entry not needing a refresh.
+	Hits uint64 `json:"hits"`
+	// Misses is the number of lookups that did not return a cached entry.
+	Misses uint64 `json:"misses"`
+	// Refreshes is the number of lookups that returned a cached entry needing a refresh.
+	Refreshes uint64 `json:"refreshes"`
+	// Evictions is the number of cached TCB bundles evicted due to the cache being full.
+	Evictions uint64 `json:"evictions"`
+}
+
+type tcbCacheStats struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	refreshes atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func (s *tcbCacheStats) recordLookup(found, refresh bool) {
+	switch {
+	case !found:
+		s.misses.Add(1)
+	case refresh:
+		s.refreshes.Add(1)
+	default:
+		s.hits.Add(1)
+	}
+}
+
 type tcbCache struct {
 	serviceStore *persistent.ServiceStore
 	logger       *logging.Logger
+	cfg          TCBCacheConfig
 	now          func() time.Time
+
+	// bundleLock serializes read-modify-write updates of cached bundles.
+	bundleLock sync.Mutex
+
+	// indexLock protects the bundle index.
+	indexLock sync.Mutex
+	// index contains all cached bundles, ordered from least to most recently used.
+	index []tcbBundleCacheIndexEntry
+
+	// absentLock protects the absent bundles.
+	absentLock sync.Mutex
+	// absent maps the cache keys of bundles that the PCS reported as not found to the time until
+	// which they are considered absent.
+	absent map[string]time.Time
+
+	// jitterSeed is the seed used to derive the refresh jitter of each bundle.
+	jitterSeed [32]byte
+
+	// verifySignatures verifies the signatures of TCB bundles that are not obtained as part of
+	// quote verification before they are cached.
+	verifySignatures func(tcbBundle *TCBBundle, now time.Time) error
+
+	stats tcbCacheStats
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (tc *tcbCache) Stats() TCBCacheStats {
+	return TCBCacheStats{
+		Hits:      tc.stats.hits.Load(),
+		Misses:    tc.stats.misses.Load(),
+		Refreshes: tc.stats.refreshes.Load(),
+		Evictions: tc.stats.evictions.Load(),
+	}
 }
 
 func (tc *tcbCache) checkEvaluationDataNumbers(teeType TeeType) ([]uint32, bool) {
@@ -77,81 +276,187 @@ func (tc *tcbCache) checkEvaluationDataNumbers(teeType TeeType) ([]uint32, bool)
 		// No error, continues below.
 	case persistent.ErrNotFound:
 		// Not cached yet. Not an error, but needs refresh.
+		tc.stats.recordLookup(false, true)
 		return nil, true
 	default:
 		// Can't get it... an error, but we can still try downloading it.
 		tc.logger.Warn("error checking common store for cached TCB evaluation data numbers",
 			"err", err,
 		)
+		tc.stats.recordLookup(false, true)
 		return nil, true
 	}
 
 	now := tc.now()
 	delta := now.Sub(stored.LastUpdate)
-	refresh := delta > tcbCacheSlowRefreshInterval
+	refresh := delta > tc.cfg.SlowRefreshInterval
+	tc.stats.recordLookup(true, refresh)
 	return stored.Numbers, refresh
 }
 
 func (tc *tcbCache) cacheEvaluationDataNumbers(teeType TeeType, numbers []uint32) {
+	if err := tc.storeEvaluationDataNumbers(teeType, numbers); err != nil {
+		tc.logger.Error("could not store new TCB evaluation data numbers to cache, ignoring",
+			"err", err,
+		)
+	}
+}
+
+func (tc *tcbCache) storeEvaluationDataNumbers(teeType TeeType, numbers []uint32) error {
 	cached := tcbEvaluationDataNumbersCache{
 		Numbers:    numbers,
 		LastUpdate: tc.now(),
 	}
-	if err := tc.serviceStore.PutCBOR(tcbEvaluationDataNumbersCacheKey(teeType), cached); err != nil {
-		tc.logger.Error("could not store new TCB evaluation data numbers to cache, ignoring",
-			"err", err,
-		)
+	return tc.serviceStore.PutCBOR(tcbEvaluationDataNumbersCacheKey(teeType), cached)
+}
+
+// LoadEvaluationDataNumbers stores the given TCB evaluation data numbers obtained out of band
+// into the cache.
+func (tc *tcbCache) LoadEvaluationDataNumbers(teeType TeeType, numbers []uint32) error {
+	if len(numbers) == 0 {
+		return fmt.Errorf("pcs: no TCB evaluation data numbers")
 	}
+	if err := tc.storeEvaluationDataNumbers(teeType, numbers); err != nil {
+		return fmt.Errorf("pcs: failed to store TCB evaluation data numbers: %w", err)
+	}
+	return nil
 }
 
-func (tc *tcbCache) checkBundle(teeType TeeType, fmspc []byte) (*TCBBundle, bool) {
+// checkBundle returns the cached TCB bundle and whether it needs to be refreshed.
+//
+// In case no bundle is cached, a refresh is requested unless the bundle has recently been
+// reported as not found (see markBundleAbsent), in which case (nil, false) is returned.
+func (tc *tcbCache) checkBundle(teeType TeeType, platformType PlatformType, fmspc []byte) (*TCBBundle, bool) {
 	var err error
 
 	// Check if we have a copy in the local store.
 	var stored tcbBundleCache
-	switch err = tc.serviceStore.GetCBOR(tcbBundleCacheKey(teeType), &stored); err {
+	switch err = tc.serviceStore.GetCBOR(tcbBundleCacheKey(teeType, platformType, fmspc), &stored); err {
 	case nil:
 		// No error, continues below.
 	case persistent.ErrNotFound:
-		// Not cached yet. Not an error, but needs refresh.
-		return nil, true
+		// Not cached yet. Not an error, but needs refresh unless known to be absent.
+		refresh := !tc.isBundleAbsent(teeType, platformType, fmspc)
+		tc.stats.recordLookup(false, refresh)
+		return nil, refresh
 	default:
 		// Can't get it... an error, but we can still try downloading it.
 		tc.logger.Warn("error checking common store for cached TCB bundle",
 			"err", err,
 		)
+		tc.stats.recordLookup(false, true)
 		return nil, true
 	}
-
-	// Check if the needed and cached FMSPC are the same.
-	// If they aren't, the bundle shouldn't be used, but leave
-	// overriding to the caller.
-	if !bytes.Equal(stored.FMSPC, fmspc) {
+	if stored.isCorrupted() {
+		// Treat corrupted entries as a miss, they are replaced by the next refresh.
+		tc.logger.Warn("cached TCB bundle is corrupted, ignoring",
+			"tee_type", teeType,
+			"platform_type", platformType,
+			"fmspc", hex.EncodeToString(fmspc),
+		)
+		tc.stats.recordLookup(false, true)
 		return nil, true
 	}
 
+	tc.touchBundle(teeType, platformType, fmspc)
+
 	refresh := func() bool {
+		// Bundles explicitly marked as stale always need a refresh.
+		if stored.ForceRefresh {
+			return true
+		}
+
 		now := tc.now()
 
-		// Wait for the first two weeks, then check once daily.
+		// Wait for the first two weeks (minus jitter), then check once daily.
 		// After expected expiration, check every time.
-		if delta := stored.ExpectedExpiry.Sub(now); delta < tcbCacheRefreshThreshold {
-			if delta < 0 || now.Sub(stored.LastUpdate) > tcbCacheSlowRefreshInterval {
+		threshold := tc.cfg.RefreshThreshold - tc.refreshJitter(teeType, platformType, fmspc)
+		if delta := stored.ExpectedExpiry.Sub(now); delta < threshold {
+			if delta < 0 || now.Sub(stored.LastUpdate) > tc.cfg.SlowRefreshInterval {
 				return true
 			}
 		}
 		return false
 	}()
+	tc.stats.recordLookup(true, refresh)
 	return stored.Bundle, refresh
 }
 
-func (tc *tcbCache) cacheBundle(teeType TeeType, tcbBundle *TCBBundle, fmspc []byte) {
+// refreshJitter returns the duration by which the start of periodic refreshes of the TCB bundle
+// for the given TEE type, platform type and FMSPC is delayed.
+func (tc *tcbCache) refreshJitter(teeType TeeType, platformType PlatformType, fmspc []byte) time.Duration {
+	maxJitter := min(tc.cfg.MaxRefreshJitter, tc.cfg.RefreshThreshold)
+	if maxJitter <= 0 {
+		return 0
+	}
+
+	h := hash.NewFromBytes(tc.jitterSeed[:], tcbBundleCacheKey(teeType, platformType, fmspc))
+	return time.Duration(binary.LittleEndian.Uint64(h[:]) % uint64(maxJitter))
+}
+
+// cacheBundle stores the given TCB bundle into the cache unless a newer bundle is already cached
+// and returns whether the bundle has been stored.
+func (tc *tcbCache) cacheBundle(teeType TeeType, platformType PlatformType, tcbBundle *TCBBundle, fmspc []byte) bool {
 	expectedExpiry, err := readBundleMinTimestamp(tcbBundle)
 	if err != nil {
 		tc.logger.Error("could not determine next update timestamp from TCB bundle",
 			"err", err,
 		)
-		return
+		return false
+	}
+
+	replaced, err := tc.storeBundle(teeType, platformType, tcbBundle, fmspc, expectedExpiry)
+	switch {
+	case err != nil:
+		tc.logger.Error("could not store new TCB bundle to cache, ignoring",
+			"err", err,
+		)
+	case !replaced:
+		tc.logger.Warn("not replacing cached TCB bundle with an older one",
+			"expected_expiry", expectedExpiry,
+		)
+	}
+	return replaced
+}
+
+// storeBundle stores the given TCB bundle into the cache. In case a bundle with a later expected
+// expiry is already cached, the cached bundle is kept and false is returned.
+//
+// Bundles whose FMSPC does not match the given FMSPC are rejected so that a bundle can never be
+// cached under the wrong key.
+func (tc *tcbCache) storeBundle(
+	teeType TeeType,
+	platformType PlatformType,
+	tcbBundle *TCBBundle,
+	fmspc []byte,
+	expectedExpiry time.Time,
+) (bool, error) {
+	bundleFMSPC, err := tcbBundle.FMSPC()
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(bundleFMSPC, fmspc) {
+		return false, fmt.Errorf("%w (expected: %X got: %X)", ErrTCBBundleFMSPCMismatch, fmspc, bundleFMSPC)
+	}
+
+	tc.bundleLock.Lock()
+	defer tc.bundleLock.Unlock()
+
+	key := tcbBundleCacheKey(teeType, platformType, fmspc)
+
+	var stored tcbBundleCache
+	switch err := tc.serviceStore.GetCBOR(key, &stored); err {
+	case nil:
+		// Prevent downgrades, e.g., due to a lagging mirror.
+		if !stored.isCorrupted() && expectedExpiry.Before(stored.ExpectedExpiry) {
+			return false, nil
+		}
+	case persistent.ErrNotFound:
+	default:
+		// Corrupted entries are simply replaced as this is a cache.
+		tc.logger.Warn("error checking common store for cached TCB bundle",
+			"err", err,
+		)
 	}
 
 	cached := tcbBundleCache{
@@ -159,9 +464,223 @@ func (tc *tcbCache) cacheBundle(teeType TeeType, tcbBundle *TCBBundle, fmspc []b
 		FMSPC:          fmspc,
 		ExpectedExpiry: expectedExpiry,
 		LastUpdate:     tc.now(),
+		Checksum:       bundleChecksum(tcbBundle),
 	}
-	if err = tc.serviceStore.PutCBOR(tcbBundleCacheKey(teeType), cached); err != nil {
-		tc.logger.Error("could not store new TCB bundle to cache, ignoring",
+	if err := tc.serviceStore.PutCBOR(key, cached); err != nil {
+		return false, err
+	}
+
+	tc.touchBundle(teeType, platformType, fmspc)
+	tc.clearBundleAbsent(teeType, platformType, fmspc)
+	return true, nil
+}
+
+// markBundleAbsent remembers that the PCS reported the TCB bundle for the given TEE type,
+// platform type and FMSPC as not found, so that checkBundle does not request a refresh until
+// the negative TTL elapses.
+func (tc *tcbCache) markBundleAbsent(teeType TeeType, platformType PlatformType, fmspc []byte) {
+	if tc.cfg.NegativeTTL < 0 {
+		return
+	}
+
+	tc.absentLock.Lock()
+	defer tc.absentLock.Unlock()
+
+	now := tc.now()
+	for key, until := range tc.absent {
+		if !now.Before(until) {
+			delete(tc.absent, key)
+		}
+	}
+	tc.absent[string(tcbBundleCacheKey(teeType, platformType, fmspc))] = now.Add(tc.cfg.NegativeTTL)
+}
+
+// isBundleAbsent returns true iff the TCB bundle for the given TEE type, platform type and FMSPC
+// has been reported as not found within the negative TTL.
+func (tc *tcbCache) isBundleAbsent(teeType TeeType, platformType PlatformType, fmspc []byte) bool {
+	tc.absentLock.Lock()
+	defer tc.absentLock.Unlock()
+
+	until, ok := tc.absent[string(tcbBundleCacheKey(teeType, platformType, fmspc))]
+	return ok && tc.now().Before(until)
+}
+
+// clearBundleAbsent forgets that the TCB bundle for the given TEE type, platform type and FMSPC
+// has been reported as not found.
+func (tc *tcbCache) clearBundleAbsent(teeType TeeType, platformType PlatformType, fmspc []byte) {
+	tc.absentLock.Lock()
+	defer tc.absentLock.Unlock()
+
+	delete(tc.absent, string(tcbBundleCacheKey(teeType, platformType, fmspc)))
+}
+
+// LoadBundle validates the given TCB bundle obtained out of band and stores it into the cache.
+//
+// Bundles that cannot be parsed, that have already expired, whose signatures are invalid, that
+// are older than the cached bundle or whose FMSPC does not match the given FMSPC are rejected.
+func (tc *tcbCache) LoadBundle(teeType TeeType, platformType PlatformType, tcbBundle *TCBBundle, fmspc []byte) error {
+	if tcbBundle == nil {
+		return fmt.Errorf("pcs: nil TCB bundle")
+	}
+	expectedExpiry, err := readBundleMinTimestamp(tcbBundle)
+	if err != nil {
+		return fmt.Errorf("pcs: invalid TCB bundle: %w", err)
+	}
+	now := tc.now()
+	if expectedExpiry.Before(now) {
+		return fmt.Errorf("pcs: TCB bundle expired at %s", expectedExpiry)
+	}
+	if err = tc.verifySignatures(tcbBundle, now); err != nil {
+		return fmt.Errorf("pcs: invalid TCB bundle: %w", err)
+	}
+
+	replaced, err := tc.storeBundle(teeType, platformType, tcbBundle, fmspc, expectedExpiry)
+	if err != nil {
+		return fmt.Errorf("pcs: failed to store TCB bundle: %w", err)
+	}
+	if !replaced {
+		return ErrTCBBundleOlderThanCached
+	}
+	return nil
+}
+
+// bundleExpiry returns the expected expiry time of the cached TCB bundle for the given TEE type,
+// platform type and FMSPC. The boolean flag is false in case no bundle is cached.
+func (tc *tcbCache) bundleExpiry(teeType TeeType, platformType PlatformType, fmspc []byte) (time.Time, bool) {
+	var stored tcbBundleCache
+	switch err := tc.serviceStore.GetCBOR(tcbBundleCacheKey(teeType, platformType, fmspc), &stored); err {
+	case nil:
+		if stored.isCorrupted() {
+			return time.Time{}, false
+		}
+		return stored.ExpectedExpiry, true
+	case persistent.ErrNotFound:
+		return time.Time{}, false
+	default:
+		tc.logger.Warn("error checking common store for cached TCB bundle",
+			"err", err,
+		)
+		return time.Time{}, false
+	}
+}
+
+// CachedFMSPCs returns the FMSPCs of all cached TCB bundles for the given TEE type (across all
+// platform types), sorted in ascending order.
+func (tc *tcbCache) CachedFMSPCs(teeType TeeType) ([][]byte, error) {
+	prefix := fmt.Sprintf("%s.%d.", tcbBundleCacheKeyPrefix, teeType)
+	keys, err := tc.serviceStore.Keys([]byte(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("pcs: failed to list cached TCB bundles: %w", err)
+	}
+
+	fmspcs := [][]byte{}
+	for _, key := range keys {
+		// Keys are of the form <prefix>.<tee-type>.<platform-type>.<fmspc>, legacy keys without
+		// an FMSPC are skipped.
+		_, rawFMSPC, ok := strings.Cut(strings.TrimPrefix(string(key), prefix), ".")
+		if !ok {
+			continue
+		}
+		fmspc, err := hex.DecodeString(rawFMSPC)
+		if err != nil {
+			tc.logger.Warn("malformed TCB bundle cache key, skipping",
+				"key", string(key),
+				"err", err,
+			)
+			continue
+		}
+		fmspcs = append(fmspcs, fmspc)
+	}
+
+	slices.SortFunc(fmspcs, bytes.Compare)
+	return slices.CompactFunc(fmspcs, bytes.Equal), nil
+}
+
+// touchBundle marks the given bundle as most recently used, adding it to the index if needed and
+// evicting the least recently used bundles in case the cache is full.
+//
+// The recency order is only kept in memory, the index is persisted when its set of bundles
+// changes so that touching a cached bundle does not result in a write to the store.
+func (tc *tcbCache) touchBundle(teeType TeeType, platformType PlatformType, fmspc []byte) {
+	tc.indexLock.Lock()
+	defer tc.indexLock.Unlock()
+
+	idx := slices.IndexFunc(tc.index, func(e tcbBundleCacheIndexEntry) bool {
+		return e.matches(teeType, platformType, fmspc)
+	})
+	switch {
+	case idx >= 0 && idx == len(tc.index)-1:
+		// Already the most recently used bundle.
+		return
+	case idx >= 0:
+		entry := tc.index[idx]
+		tc.index = append(slices.Delete(tc.index, idx, idx+1), entry)
+		return
+	default:
+		tc.index = append(tc.index, tcbBundleCacheIndexEntry{
+			TeeType:      teeType,
+			PlatformType: platformType,
+			FMSPC:        bytes.Clone(fmspc),
+		})
+	}
+
+	for len(tc.index) > tc.cfg.MaxBundles {
+		evicted := tc.index[0]
+		tc.index = tc.index[1:]
+		tc.stats.evictions.Add(1)
+
+		if err := tc.serviceStore.Delete(evicted.key()); err != nil {
+			tc.logger.Warn("could not evict TCB bundle from cache, ignoring",
+				"err", err,
+			)
+		}
+	}
+
+	if err := tc.serviceStore.PutCBOR([]byte(tcbBundleCacheIndexKey), tc.index); err != nil {
+		tc.logger.Error("could not store TCB bundle cache index, ignoring",
+			"err", err,
+		)
+	}
+}
+
+func (tc *tcbCache) loadIndex() {
+	switch err := tc.serviceStore.GetCBOR([]byte(tcbBundleCacheIndexKey), &tc.index); err {
+	case nil, persistent.ErrNotFound:
+	default:
+		// The index is only used for eviction, so start with an empty one.
+		tc.logger.Warn("error loading TCB bundle cache index",
+			"err", err,
+		)
+		tc.index = nil
+	}
+}
+
+// ForceRefresh marks the cached TCB bundle for the given TEE type, platform type and FMSPC as
+// stale so that the next check requests a refresh regardless of the refresh intervals. The
+// cached bundle is kept so it remains available as a fallback until the refresh succeeds.
+func (tc *tcbCache) ForceRefresh(teeType TeeType, platformType PlatformType, fmspc []byte) {
+	tc.bundleLock.Lock()
+	defer tc.bundleLock.Unlock()
+
+	key := tcbBundleCacheKey(teeType, platformType, fmspc)
+
+	var stored tcbBundleCache
+	switch err := tc.serviceStore.GetCBOR(key, &stored); err {
+	case nil:
+		// No error, continues below.
+	case persistent.ErrNotFound:
+		// Not cached yet, the next check will request a refresh anyway.
+		return
+	default:
+		tc.logger.Warn("error checking common store for cached TCB bundle",
+			"err", err,
+		)
+		return
+	}
+
+	stored.ForceRefresh = true
+	if err := tc.serviceStore.PutCBOR(key, stored); err != nil {
+		tc.logger.Error("could not mark cached TCB bundle as stale, ignoring",
 			"err", err,
 		)
 	}
@@ -173,29 +692,200 @@ func (tc *tcbCache) migrate() {
 	switch err := tc.serviceStore.GetCBOR([]byte(tcbBundleCacheKeyPrefix), &stored); err {
 	case nil:
 		// No error, migrate. Any errors during migration are ignored as this is a cache.
-		_ = tc.serviceStore.PutCBOR(tcbBundleCacheKey(TeeTypeSGX), stored)
+		_ = tc.serviceStore.PutCBOR(legacyTCBBundleCacheKey(TeeTypeSGX, PlatformTypeStandard), stored)
 		_ = tc.serviceStore.Delete([]byte(tcbBundleCacheKeyPrefix))
 	default:
 		// No migration needed.
 	}
+
+	// Migrate any old (without FMSPC) cached entries.
+	for _, teeType := range []TeeType{TeeTypeSGX, TeeTypeTDX} {
+		for _, platformType := range []PlatformType{PlatformTypeStandard, PlatformTypeMultiPackage} {
+			legacyKey := legacyTCBBundleCacheKey(teeType, platformType)
+
+			var stored tcbBundleCache
+			if err := tc.serviceStore.GetCBOR(legacyKey, &stored); err != nil {
+				// No migration needed.
+				continue
+			}
+			// Any errors during migration are ignored as this is a cache.
+			if err := tc.serviceStore.PutCBOR(tcbBundleCacheKey(teeType, platformType, stored.FMSPC), stored); err == nil {
+				tc.touchBundle(teeType, platformType, stored.FMSPC)
+			}
+			_ = tc.serviceStore.Delete(legacyKey)
+		}
+	}
 }
 
-func newTcbCache(serviceStore *persistent.ServiceStore, logger *logging.Logger) *tcbCache {
-	tc := &tcbCache{
-		serviceStore: serviceStore,
-		logger:       logger,
-		now:          time.Now,
+// PCSFetcher fetches TCB bundles from the Intel PCS (or a compatible service).
+type PCSFetcher interface {
+	// FetchTCBBundle fetches the latest TCB bundle for the given TEE type and FMSPC.
+	FetchTCBBundle(ctx context.Context, teeType TeeType, fmspc []byte) (*TCBBundle, error)
+}
+
+type clientFetcher struct {
+	client Client
+}
+
+// NewClientFetcher creates a new PCS fetcher backed by the given PCS client.
+//
+// The fetcher retrieves the TCB bundle for the highest TCB evaluation data number for which the
+// PCS has a bundle.
+func NewClientFetcher(client Client) PCSFetcher {
+	return &clientFetcher{client: client}
+}
+
+// Implements PCSFetcher.
+func (cf *clientFetcher) FetchTCBBundle(ctx context.Context, teeType TeeType, fmspc []byte) (*TCBBundle, error) {
+	numbers, err := cf.client.GetTCBEvaluationDataNumbers(ctx, teeType)
+	if err != nil {
+		return nil, fmt.Errorf("pcs: failed to fetch TCB evaluation data numbers: %w", err)
 	}
-	tc.migrate()
-	return tc
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("pcs: no TCB evaluation data numbers available")
+	}
+	slices.SortFunc(numbers, func(a, b uint32) int {
+		return -cmp.Compare(a, b)
+	})
+
+	for _, number := range numbers {
+		var tcbBundle *TCBBundle
+		if tcbBundle, err = cf.client.GetTCBBundle(ctx, teeType, fmspc, number); err == nil {
+			return tcbBundle, nil
+		}
+	}
+	return nil, err
 }
 
-func newMockTcbCache(serviceStore *persistent.ServiceStore, logger *logging.Logger, now func() time.Time) *tcbCache {
+// TCBCache is a persistent cache of TCB bundles that fetches missing or stale bundles using the
+// configured PCS fetcher.
+type TCBCache struct {
+	cache   *tcbCache
+	fetcher PCSFetcher
+}
+
+// NewTCBCache creates a new TCB cache persisted in the given service store, fetching missing or
+// stale bundles using the given fetcher.
+func NewTCBCache(store *persistent.ServiceStore, logger *logging.Logger, fetcher PCSFetcher, cfg TCBCacheConfig) (*TCBCache, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &TCBCache{
+		cache:   newTcbCache(store, logger, cfg),
+		fetcher: fetcher,
+	}, nil
+}
+
+// GetOrRefresh returns the TCB bundle for the given TEE type, platform type and FMSPC. The
+// platform type of a quote is available in the PCKInfo obtained when verifying its PCK
+// certificate.
+//
+// In case the bundle is not cached or needs a refresh, it is fetched using the configured fetcher,
+// passing the given context to the fetcher, verified and stored into the cache. If fetching,
+// verifying or storing the bundle fails, the stale cached bundle (if any) is returned.
+func (c *TCBCache) GetOrRefresh(ctx context.Context, teeType TeeType, platformType PlatformType, fmspc []byte) (*TCBBundle, error) {
+	cached, refresh := c.cache.checkBundle(teeType, platformType, fmspc)
+	if !refresh {
+		if cached == nil {
+			// The PCS recently reported the bundle as not found, avoid fetching it again.
+			return nil, fmt.Errorf("pcs: TCB bundle recently reported as absent: %w", ErrNotFound)
+		}
+		return cached, nil
+	}
+
+	err := ctx.Err()
+	var (
+		fresh    *TCBBundle
+		replaced bool
+	)
+	if err == nil {
+		fresh, replaced, err = c.cache.fetchBundle(ctx, c.fetcher, teeType, platformType, fmspc)
+	}
+	switch {
+	case err != nil && cached != nil:
+		c.cache.logger.Warn("error refreshing TCB bundle, using stale cached bundle",
+			"err", err,
+		)
+		return cached, nil
+	case err != nil:
+		return nil, err
+	case !replaced && cached != nil:
+		// The fetched bundle is older than the cached one, e.g., due to a lagging mirror.
+		return cached, nil
+	default:
+		return fresh, nil
+	}
+}
+
+// LoadBundle validates the given TCB bundle obtained out of band and stores it into the cache.
+//
+// See TCBCacheLoader.LoadTCBBundle for details.
+func (c *TCBCache) LoadBundle(teeType TeeType, platformType PlatformType, tcbBundle *TCBBundle, fmspc []byte) error {
+	return c.cache.LoadBundle(teeType, platformType, tcbBundle, fmspc)
+}
+
+// ForceRefresh marks the cached TCB bundle for the given TEE type, platform type and FMSPC as
+// stale so that the next GetOrRefresh fetches it regardless of the refresh intervals.
+//
+// See TCBCacheLoader.ForceTCBBundleRefresh for details.
+func (c *TCBCache) ForceRefresh(teeType TeeType, platformType PlatformType, fmspc []byte) {
+	c.cache.ForceRefresh(teeType, platformType, fmspc)
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *TCBCache) Stats() TCBCacheStats {
+	return c.cache.Stats()
+}
+
+// fetchBundle fetches the TCB bundle for the given TEE type, platform type and FMSPC using the
+// given fetcher, verifies its signatures and stores it into the cache unless a newer bundle is
+// already cached. It returns the fetched bundle and whether it has been stored.
+func (tc *tcbCache) fetchBundle(ctx context.Context, fetcher PCSFetcher, teeType TeeType, platformType PlatformType, fmspc []byte) (*TCBBundle, bool, error) {
+	tcbBundle, err := fetcher.FetchTCBBundle(ctx, teeType, fmspc)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			tc.markBundleAbsent(teeType, platformType, fmspc)
+		}
+		return nil, false, fmt.Errorf("pcs: failed to fetch TCB bundle: %w", err)
+	}
+	if tcbBundle == nil {
+		return nil, false, fmt.Errorf("pcs: fetcher returned a nil TCB bundle")
+	}
+
+	expectedExpiry, err := readBundleMinTimestamp(tcbBundle)
+	if err != nil {
+		return nil, false, fmt.Errorf("pcs: invalid TCB bundle: %w", err)
+	}
+	if err = tc.verifySignatures(tcbBundle, tc.now()); err != nil {
+		return nil, false, fmt.Errorf("pcs: invalid TCB bundle: %w", err)
+	}
+	replaced, err := tc.storeBundle(teeType, platformType, tcbBundle, fmspc, expectedExpiry)
+	switch {
+	case err == nil:
+		return tcbBundle, replaced, nil
+	case errors.Is(err, ErrTCBBundleFMSPCMismatch):
+		return nil, false, fmt.Errorf("pcs: invalid TCB bundle: %w", err)
+	default:
+		return nil, false, fmt.Errorf("pcs: failed to store TCB bundle: %w", err)
+	}
+}
+
+func newTcbCache(serviceStore *persistent.ServiceStore, logger *logging.Logger, cfg TCBCacheConfig) *tcbCache {
+	cfg = cfg.withDefaults()
 	tc := &tcbCache{
-		serviceStore: serviceStore,
-		logger:       logger,
-		now:          now,
+		serviceStore:     serviceStore,
+		logger:           logger,
+		cfg:              cfg,
+		now:              cfg.Clock,
+		absent:           make(map[string]time.Time),
+		verifySignatures: (*TCBBundle).VerifySignatures,
 	}
+	_, _ = rand.Read(tc.jitterSeed[:])
+	tc.loadIndex()
 	tc.migrate()
 	return tc
 }
+
+func newMockTcbCache(serviceStore *persistent.ServiceStore, logger *logging.Logger, now func() time.Time) *tcbCache {
+	return newTcbCache(serviceStore, logger, TCBCacheConfig{Clock: now})
+}
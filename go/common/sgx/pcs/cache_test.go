@@ -1,7 +1,10 @@
 package pcs
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -22,23 +25,130 @@ func (ft *fakeTime) get() time.Time {
 	return ft.now
 }
 
-func testStorageRoundtrip(t *testing.T, store *persistent.ServiceStore, bundle *TCBBundle) {
+func testStorageRoundtrip(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
 	require := require.New(t)
 	fmspc := []byte("fmspc")
 	numbers := []uint32{17, 18, 19}
 
 	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), time.Now)
-	tcbCache.cacheBundle(TeeTypeSGX, bundle, fmspc)
-	tcbCache.cacheEvaluationDataNumbers(TeeTypeSGX, numbers)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	tcbCache.cacheEvaluationDataNumbers(teeType, numbers)
 
-	cachedBundle, _ := tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cachedBundle, _ := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.EqualValues(cachedBundle, bundle, "tcbCache.checkBundle")
 
-	cachedNumbers, _ := tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	cachedNumbers, _ := tcbCache.checkEvaluationDataNumbers(teeType)
 	require.EqualValues(cachedNumbers, numbers, "tcbCache.checkEvaluationDataNumbers")
 }
 
-func testFMSPCInvalidation(t *testing.T, store *persistent.ServiceStore, bundle *TCBBundle) {
+func testBundleExpiry(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), time.Now)
+	expiry, ok := tcbCache.bundleExpiry(teeType, PlatformTypeStandard, fmspc)
+	require.False(ok, "tcbCache.bundleExpiry pre-cache")
+	require.True(expiry.IsZero(), "tcbCache.bundleExpiry pre-cache")
+
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	expiry, ok = tcbCache.bundleExpiry(teeType, PlatformTypeStandard, fmspc)
+	require.True(ok, "tcbCache.bundleExpiry")
+	require.True(expiryTime.Equal(expiry), "tcbCache.bundleExpiry")
+
+	_, ok = tcbCache.bundleExpiry(teeType, PlatformTypeStandard, []byte("different"))
+	require.False(ok, "tcbCache.bundleExpiry different fmspc")
+
+	qs := &cachingQuoteService{cache: tcbCache}
+	expiry, ok = qs.TCBBundleExpiry(teeType, PlatformTypeStandard, fmspc)
+	require.True(ok, "cachingQuoteService.TCBBundleExpiry")
+	require.True(expiryTime.Equal(expiry), "cachingQuoteService.TCBBundleExpiry")
+}
+
+func testStats(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-(defaultTCBCacheRefreshThreshold + 24*time.Hour)),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{
+		MaxBundles: 1,
+		Clock:      timer.get,
+	})
+	require.Equal(TCBCacheStats{}, tcbCache.Stats())
+
+	// Misses.
+	tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	tcbCache.checkEvaluationDataNumbers(teeType)
+	require.Equal(TCBCacheStats{Misses: 2}, tcbCache.Stats())
+
+	// Hits.
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	tcbCache.cacheEvaluationDataNumbers(teeType, []uint32{17})
+	tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	tcbCache.checkEvaluationDataNumbers(teeType)
+	require.Equal(TCBCacheStats{Hits: 2, Misses: 2}, tcbCache.Stats())
+
+	// Refreshes.
+	timer.now = timer.now.Add(defaultTCBCacheRefreshThreshold)
+	tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	tcbCache.checkEvaluationDataNumbers(teeType)
+	require.Equal(TCBCacheStats{Hits: 2, Misses: 2, Refreshes: 2}, tcbCache.Stats())
+
+	// Evictions.
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, withFMSPC(t, bundle, []byte("different")), []byte("different"))
+	require.Equal(TCBCacheStats{Hits: 2, Misses: 2, Refreshes: 2, Evictions: 1}, tcbCache.Stats())
+}
+
+func testLoadBundle(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(time.Hour),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{Clock: timer.get})
+	skipSignatureVerification(tcbCache)
+
+	// Expired bundles should be rejected.
+	err = tcbCache.LoadBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	require.Error(err, "LoadBundle should reject expired bundles")
+	cached, _ := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "rejected bundle should not be cached")
+
+	// Unparseable bundles should be rejected.
+	invalidBundle := *bundle
+	invalidBundle.TCBInfo.TCBInfo = []byte("invalid")
+	timer.now = expiryTime.Add(-(defaultTCBCacheRefreshThreshold + 24*time.Hour))
+	err = tcbCache.LoadBundle(teeType, PlatformTypeStandard, &invalidBundle, fmspc)
+	require.Error(err, "LoadBundle should reject invalid bundles")
+	err = tcbCache.LoadBundle(teeType, PlatformTypeStandard, nil, fmspc)
+	require.Error(err, "LoadBundle should reject nil bundles")
+
+	// Valid bundles should be loaded.
+	err = tcbCache.LoadBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	require.NoError(err, "LoadBundle")
+	cached, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "loaded bundle should be cached")
+	require.False(refresh, "loaded bundle should not need a refresh")
+
+	// Evaluation data numbers.
+	err = tcbCache.LoadEvaluationDataNumbers(teeType, nil)
+	require.Error(err, "LoadEvaluationDataNumbers should reject empty numbers")
+	err = tcbCache.LoadEvaluationDataNumbers(teeType, []uint32{17, 18})
+	require.NoError(err, "LoadEvaluationDataNumbers")
+	numbers, refresh := tcbCache.checkEvaluationDataNumbers(teeType)
+	require.EqualValues([]uint32{17, 18}, numbers, "loaded numbers should be cached")
+	require.False(refresh, "loaded numbers should not need a refresh")
+}
+
+func testMultipleFMSPCs(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
 	require := require.New(t)
 	fmspc := []byte("fmspc")
 	expiryTime, err := readBundleMinTimestamp(bundle)
@@ -52,23 +162,637 @@ func testFMSPCInvalidation(t *testing.T, store *persistent.ServiceStore, bundle
 	var refresh bool
 
 	// Cache initial and check.
-	tcbCache.cacheBundle(TeeTypeSGX, bundle, fmspc)
-	cached, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.NotNil(cached, "tcbCache.check 1")
 	require.False(refresh, "tcbCache.check 1")
 
-	// Check again with bogus fmspc; shouldn't return anything
-	// but should still be available.
-	cached, refresh = tcbCache.checkBundle(TeeTypeSGX, []byte("different"))
+	// Check again with a different fmspc; shouldn't return anything
+	// but the original should still be available.
+	otherFmspc := []byte("different")
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, otherFmspc)
 	require.Nil(cached, "tcbCache.check 2")
 	require.True(refresh, "tcbCache.check 2")
 
-	cached, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.NotNil(cached, "tcbCache.check 3")
 	require.False(refresh, "tcbCache.check 3")
+
+	// Cache a bundle for the different fmspc; both should coexist.
+	otherBundle := withFMSPC(t, bundle, otherFmspc)
+	otherBundle.Certificates = []byte("different certificates")
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, otherBundle, otherFmspc)
+
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, otherFmspc)
+	require.EqualValues(otherBundle, cached, "tcbCache.check 4")
+	require.False(refresh, "tcbCache.check 4")
+
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "tcbCache.check 5")
+	require.False(refresh, "tcbCache.check 5")
 }
 
-func testCheckIntervals(t *testing.T, store *persistent.ServiceStore, bundle *TCBBundle) {
+func testEviction(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	cfg := TCBCacheConfig{
+		MaxBundles: 2,
+	}
+	fmspcA, fmspcB, fmspcC, fmspcD := []byte("A"), []byte("B"), []byte("C"), []byte("D")
+
+	isCached := func(tcbCache *tcbCache, fmspc []byte) bool {
+		cached, _ := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+		return cached != nil
+	}
+
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), cfg)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, withFMSPC(t, bundle, fmspcA), fmspcA)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, withFMSPC(t, bundle, fmspcB), fmspcB)
+
+	// Use A so that B becomes the least recently used bundle.
+	require.True(isCached(tcbCache, fmspcA), "A should be cached")
+
+	// Using a bundle should only reorder the index in memory.
+	var index []tcbBundleCacheIndexEntry
+	err := store.GetCBOR([]byte(tcbBundleCacheIndexKey), &index)
+	require.NoError(err, "GetCBOR")
+	require.Len(index, 2)
+	require.EqualValues(fmspcB, index[1].FMSPC, "persisted index should not be reordered")
+
+	// Caching C should evict B.
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, withFMSPC(t, bundle, fmspcC), fmspcC)
+	require.False(isCached(tcbCache, fmspcB), "B should be evicted")
+	require.True(isCached(tcbCache, fmspcA), "A should be cached")
+	require.True(isCached(tcbCache, fmspcC), "C should be cached")
+
+	// The index should persist across cache instances, so caching D should evict A.
+	tcbCache = newTcbCache(store, logging.GetLogger(loggerModule), cfg)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, withFMSPC(t, bundle, fmspcD), fmspcD)
+	require.False(isCached(tcbCache, fmspcA), "A should be evicted")
+	require.True(isCached(tcbCache, fmspcC), "C should be cached")
+	require.True(isCached(tcbCache, fmspcD), "D should be cached")
+}
+
+func testLegacyMigration(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+
+	// Store a bundle using the legacy (without FMSPC) key.
+	stored := tcbBundleCache{
+		Bundle:         bundle,
+		FMSPC:          fmspc,
+		ExpectedExpiry: time.Now().Add(365 * 24 * time.Hour),
+		LastUpdate:     time.Now(),
+	}
+	err := store.PutCBOR(legacyTCBBundleCacheKey(teeType, PlatformTypeStandard), stored)
+	require.NoError(err, "PutCBOR")
+
+	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), time.Now)
+	cached, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "tcbCache.checkBundle")
+	require.False(refresh, "tcbCache.checkBundle")
+
+	err = store.GetCBOR(legacyTCBBundleCacheKey(teeType, PlatformTypeStandard), &stored)
+	require.ErrorIs(err, persistent.ErrNotFound, "legacy entry should be removed")
+}
+
+func testCorruptedBundle(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	key := tcbBundleCacheKey(teeType, PlatformTypeStandard, fmspc)
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-(defaultTCBCacheRefreshThreshold + 24*time.Hour)),
+	}
+	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), timer.get)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	cached, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "tcbCache.checkBundle")
+	require.False(refresh, "tcbCache.checkBundle")
+
+	// Tamper with the stored bundle without updating the checksum.
+	var stored tcbBundleCache
+	err = store.GetCBOR(key, &stored)
+	require.NoError(err, "GetCBOR")
+	require.NotNil(stored.Checksum, "stored bundle should have a checksum")
+	stored.Bundle.Certificates = append(stored.Bundle.Certificates, 0xff)
+	err = store.PutCBOR(key, stored)
+	require.NoError(err, "PutCBOR")
+
+	require.NotPanics(func() {
+		cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	})
+	require.Nil(cached, "tcbCache.checkBundle should miss on checksum mismatch")
+	require.True(refresh, "tcbCache.checkBundle should request a refresh on checksum mismatch")
+	_, ok := tcbCache.bundleExpiry(teeType, PlatformTypeStandard, fmspc)
+	require.False(ok, "tcbCache.bundleExpiry should ignore corrupted bundle")
+
+	// Corrupted bundles should be replaced.
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "tcbCache.checkBundle after replace")
+	require.False(refresh, "tcbCache.checkBundle after replace")
+
+	// Overwrite the stored entry with garbage.
+	err = store.PutCBOR(key, []byte("garbage"))
+	require.NoError(err, "PutCBOR")
+
+	require.NotPanics(func() {
+		cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	})
+	require.Nil(cached, "tcbCache.checkBundle should miss on undecodable entry")
+	require.True(refresh, "tcbCache.checkBundle should request a refresh on undecodable entry")
+}
+
+type fakeFetcher struct {
+	bundle *TCBBundle
+	err    error
+	calls  int
+}
+
+func (ff *fakeFetcher) FetchTCBBundle(context.Context, TeeType, []byte) (*TCBBundle, error) {
+	ff.calls++
+	return ff.bundle, ff.err
+}
+
+func testFetcher(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-30 * 24 * time.Hour),
+	}
+	fetcher := &fakeFetcher{err: ErrNotFound}
+	_, err = NewTCBCache(store, logging.GetLogger(loggerModule), fetcher, TCBCacheConfig{
+		MaxBundles: -1,
+	})
+	require.Error(err, "NewTCBCache should reject a negative maximum number of bundles")
+
+	tcbCache, err := NewTCBCache(store, logging.GetLogger(loggerModule), fetcher, TCBCacheConfig{
+		Clock: timer.get,
+	})
+	require.NoError(err, "NewTCBCache")
+	skipSignatureVerification(tcbCache.cache)
+
+	// Fetch errors should be propagated and not found results remembered.
+	_, err = tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.ErrorIs(err, ErrNotFound, "TCBCache.GetOrRefresh 1")
+	_, err = tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.ErrorIs(err, ErrNotFound, "TCBCache.GetOrRefresh 2")
+	require.Equal(1, fetcher.calls, "absent bundles should not be fetched again")
+
+	// A miss should fetch and cache the bundle.
+	timer.now = timer.now.Add(time.Hour)
+	fetcher.bundle, fetcher.err = bundle, nil
+	fetched, err := tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.NoError(err, "TCBCache.GetOrRefresh 3")
+	require.EqualValues(bundle, fetched, "TCBCache.GetOrRefresh 3")
+	require.Equal(2, fetcher.calls)
+
+	// A hit should not fetch again.
+	cached, err := tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.NoError(err, "TCBCache.GetOrRefresh 4")
+	require.EqualValues(bundle, cached, "TCBCache.GetOrRefresh 4")
+	require.Equal(2, fetcher.calls)
+
+	// A refresh should fetch again.
+	timer.now = expiryTime.Add(time.Hour)
+	cached, err = tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.NoError(err, "TCBCache.GetOrRefresh 5")
+	require.EqualValues(bundle, cached, "TCBCache.GetOrRefresh 5")
+	require.Equal(3, fetcher.calls)
+
+	// Bundles with a different FMSPC should be rejected.
+	fetcher.bundle = withFMSPC(t, bundle, []byte("different"))
+	_, err = tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, []byte("other"))
+	require.ErrorIs(err, ErrTCBBundleFMSPCMismatch, "TCBCache.GetOrRefresh 6")
+	require.Equal(4, fetcher.calls)
+
+	// Once cached, failed refreshes should fall back to the stale cached bundle.
+	fetcher.bundle = bundle
+	_, err = tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.NoError(err, "TCBCache.GetOrRefresh 7")
+	require.Equal(5, fetcher.calls)
+
+	fetcher.bundle, fetcher.err = nil, errors.New("fetch failed")
+	cached, err = tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.NoError(err, "TCBCache.GetOrRefresh 8")
+	require.EqualValues(bundle, cached, "TCBCache.GetOrRefresh 8")
+	require.Equal(6, fetcher.calls)
+
+	fetcher.bundle, fetcher.err = withFMSPC(t, bundle, []byte("different")), nil
+	cached, err = tcbCache.GetOrRefresh(context.Background(), teeType, PlatformTypeStandard, fmspc)
+	require.NoError(err, "TCBCache.GetOrRefresh 9")
+	require.EqualValues(bundle, cached, "TCBCache.GetOrRefresh 9")
+	require.Equal(7, fetcher.calls)
+
+	// Canceled contexts should not fetch but still fall back to the stale cached bundle.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cached, err = tcbCache.GetOrRefresh(ctx, teeType, PlatformTypeStandard, fmspc)
+	require.NoError(err, "TCBCache.GetOrRefresh 10")
+	require.EqualValues(bundle, cached, "TCBCache.GetOrRefresh 10")
+	require.Equal(7, fetcher.calls)
+
+	_, err = tcbCache.GetOrRefresh(ctx, teeType, PlatformTypeStandard, []byte("different"))
+	require.ErrorIs(err, context.Canceled, "TCBCache.GetOrRefresh 11")
+	require.Equal(7, fetcher.calls)
+	require.Equal(TCBCacheStats{Hits: 1, Misses: 5, Refreshes: 5}, tcbCache.Stats())
+}
+
+func testRefreshJitter(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspcs := [][]byte{[]byte("fmspc"), []byte("different")}
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-30 * 24 * time.Hour),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{
+		MaxRefreshJitter: 7 * 24 * time.Hour,
+		Clock:            timer.get,
+	})
+
+	// Both bundles have the same expected expiry.
+	for _, fmspc := range fmspcs {
+		tcbCache.cacheBundle(teeType, PlatformTypeStandard, withFMSPC(t, bundle, fmspc), fmspc)
+	}
+
+	jitters := make([]time.Duration, len(fmspcs))
+	for i, fmspc := range fmspcs {
+		jitters[i] = tcbCache.refreshJitter(teeType, PlatformTypeStandard, fmspc)
+		require.Less(jitters[i], 7*24*time.Hour, "jitter should be capped")
+		require.Equal(jitters[i], tcbCache.refreshJitter(teeType, PlatformTypeStandard, fmspc), "jitter should be stable")
+	}
+	require.NotEqual(jitters[0], jitters[1], "jitter should differ between bundles")
+	early, late := 0, 1
+	if jitters[early] > jitters[late] {
+		early, late = late, early
+	}
+
+	// Between the two jittered thresholds, only the bundle with the smaller jitter is refreshed.
+	thresholdTime := expiryTime.Add(-defaultTCBCacheRefreshThreshold)
+	timer.now = thresholdTime.Add((jitters[early] + jitters[late]) / 2)
+	for range 2 {
+		_, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspcs[early])
+		require.True(refresh, "tcbCache.checkBundle early")
+		_, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspcs[late])
+		require.False(refresh, "tcbCache.checkBundle late")
+	}
+
+	// After both jittered thresholds, both bundles are refreshed.
+	timer.now = thresholdTime.Add(jitters[late] + time.Second)
+	for _, fmspc := range fmspcs {
+		_, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+		require.True(refresh, "tcbCache.checkBundle")
+	}
+}
+
+func testForceRefresh(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-(defaultTCBCacheRefreshThreshold + 24*time.Hour)),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{Clock: timer.get})
+
+	// Forcing a refresh of a bundle that is not cached should be a no-op.
+	tcbCache.ForceRefresh(teeType, PlatformTypeStandard, fmspc)
+	cached, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "tcbCache.checkBundle 1")
+	require.True(refresh, "tcbCache.checkBundle 1")
+
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.NotNil(cached, "tcbCache.checkBundle 2")
+	require.False(refresh, "tcbCache.checkBundle 2")
+
+	// Forcing a refresh for a different FMSPC should not affect the cached bundle.
+	tcbCache.ForceRefresh(teeType, PlatformTypeStandard, []byte("different"))
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.NotNil(cached, "tcbCache.checkBundle 3")
+	require.False(refresh, "tcbCache.checkBundle 3")
+
+	// Forcing a refresh should keep the cached bundle but request a refresh.
+	tcbCache.ForceRefresh(teeType, PlatformTypeStandard, fmspc)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "tcbCache.checkBundle 4")
+	require.True(refresh, "tcbCache.checkBundle 4")
+
+	// Caching a fresh bundle should clear the stale marker.
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.NotNil(cached, "tcbCache.checkBundle 5")
+	require.False(refresh, "tcbCache.checkBundle 5")
+}
+
+func testPlatformTypes(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+
+	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), time.Now)
+
+	// Make the multi-package bundle distinguishable from the standard one.
+	mpBundle := *bundle
+	mpBundle.Certificates = append([]byte{}, bundle.Certificates...)
+	mpBundle.Certificates = append(mpBundle.Certificates, '\n')
+
+	// Only the standard variant is cached, multi-package should need a refresh.
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	cached, refresh := tcbCache.checkBundle(teeType, PlatformTypeMultiPackage, fmspc)
+	require.Nil(cached, "tcbCache.checkBundle multi-package pre-cache")
+	require.True(refresh, "tcbCache.checkBundle multi-package pre-cache")
+
+	qs := &cachingQuoteService{cache: tcbCache}
+	_, ok := qs.TCBBundleExpiry(teeType, PlatformTypeStandard, fmspc)
+	require.True(ok, "cachingQuoteService.TCBBundleExpiry standard")
+	_, ok = qs.TCBBundleExpiry(teeType, PlatformTypeMultiPackage, fmspc)
+	require.False(ok, "cachingQuoteService.TCBBundleExpiry multi-package pre-cache")
+
+	// Cache the multi-package variant, it should not overwrite the standard one.
+	tcbCache.cacheBundle(teeType, PlatformTypeMultiPackage, &mpBundle, fmspc)
+
+	cached, _ = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "tcbCache.checkBundle standard")
+
+	cached, _ = tcbCache.checkBundle(teeType, PlatformTypeMultiPackage, fmspc)
+	require.EqualValues(&mpBundle, cached, "tcbCache.checkBundle multi-package")
+
+	_, ok = qs.TCBBundleExpiry(teeType, PlatformTypeMultiPackage, fmspc)
+	require.True(ok, "cachingQuoteService.TCBBundleExpiry multi-package")
+}
+
+func testCachedFMSPCs(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+
+	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), time.Now)
+
+	fmspcs, err := tcbCache.CachedFMSPCs(teeType)
+	require.NoError(err, "CachedFMSPCs")
+	require.NotNil(fmspcs, "CachedFMSPCs should return an empty slice")
+	require.Empty(fmspcs, "CachedFMSPCs")
+
+	otherTeeType := TeeTypeTDX
+	if teeType == TeeTypeTDX {
+		otherTeeType = TeeTypeSGX
+	}
+
+	cacheBundle := func(teeType TeeType, platformType PlatformType, fmspc []byte) {
+		tcbCache.cacheBundle(teeType, platformType, withFMSPC(t, bundle, fmspc), fmspc)
+	}
+	cacheBundle(teeType, PlatformTypeStandard, []byte{0x02, 0x01})
+	cacheBundle(teeType, PlatformTypeStandard, []byte{0x01, 0x02})
+	cacheBundle(teeType, PlatformTypeMultiPackage, []byte{0x02, 0x01})
+	cacheBundle(teeType, PlatformTypeMultiPackage, []byte{0x00, 0xff})
+	cacheBundle(otherTeeType, PlatformTypeStandard, []byte{0x03})
+
+	fmspcs, err = tcbCache.CachedFMSPCs(teeType)
+	require.NoError(err, "CachedFMSPCs")
+	require.EqualValues([][]byte{{0x00, 0xff}, {0x01, 0x02}, {0x02, 0x01}}, fmspcs, "CachedFMSPCs")
+
+	fmspcs, err = tcbCache.CachedFMSPCs(otherTeeType)
+	require.NoError(err, "CachedFMSPCs")
+	require.EqualValues([][]byte{{0x03}}, fmspcs, "CachedFMSPCs other TEE type")
+}
+
+// withNextUpdateShifted returns a copy of the given bundle with both next update timestamps
+// shifted by the given duration. Signatures are not updated.
+func withNextUpdateShifted(t *testing.T, bundle *TCBBundle, d time.Duration) *TCBBundle {
+	require := require.New(t)
+
+	shift := func(raw json.RawMessage) json.RawMessage {
+		var body map[string]any
+		err := json.Unmarshal(raw, &body)
+		require.NoError(err, "json.Unmarshal")
+		nextUpdate, err := time.Parse(TimestampFormat, body["nextUpdate"].(string))
+		require.NoError(err, "time.Parse")
+		body["nextUpdate"] = nextUpdate.Add(d).Format(TimestampFormat)
+		shifted, err := json.Marshal(body)
+		require.NoError(err, "json.Marshal")
+		return shifted
+	}
+
+	shifted := *bundle
+	shifted.TCBInfo.TCBInfo = shift(bundle.TCBInfo.TCBInfo)
+	shifted.QEIdentity.EnclaveIdentity = shift(bundle.QEIdentity.EnclaveIdentity)
+	return &shifted
+}
+
+// skipSignatureVerification disables the verification of TCB bundle signatures by the given
+// cache, which is needed to load test bundles modified after they have been signed.
+func skipSignatureVerification(tc *tcbCache) {
+	tc.verifySignatures = func(*TCBBundle, time.Time) error {
+		return nil
+	}
+}
+
+// withFMSPC returns a copy of the given bundle with the FMSPC in its TCB info replaced by the
+// given FMSPC. Signatures are not updated.
+func withFMSPC(t *testing.T, bundle *TCBBundle, fmspc []byte) *TCBBundle {
+	require := require.New(t)
+
+	var body map[string]any
+	err := json.Unmarshal(bundle.TCBInfo.TCBInfo, &body)
+	require.NoError(err, "json.Unmarshal")
+	body["fmspc"] = hex.EncodeToString(fmspc)
+	raw, err := json.Marshal(body)
+	require.NoError(err, "json.Marshal")
+
+	updated := *bundle
+	updated.TCBInfo.TCBInfo = raw
+	return &updated
+}
+
+func testFMSPCMismatch(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	otherFmspc := []byte("other")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-24 * time.Hour),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{Clock: timer.get})
+	skipSignatureVerification(tcbCache)
+
+	bundleFMSPC, err := bundle.FMSPC()
+	require.NoError(err, "FMSPC")
+	require.Equal(fmspc, bundleFMSPC, "FMSPC")
+
+	// Bundles should not be cached under a different FMSPC.
+	replaced := tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, otherFmspc)
+	require.False(replaced, "cacheBundle should reject a mismatched FMSPC")
+	cached, _ := tcbCache.checkBundle(teeType, PlatformTypeStandard, otherFmspc)
+	require.Nil(cached, "mismatched bundle should not be cached")
+
+	err = tcbCache.LoadBundle(teeType, PlatformTypeStandard, bundle, otherFmspc)
+	require.ErrorIs(err, ErrTCBBundleFMSPCMismatch, "LoadBundle should reject a mismatched FMSPC")
+	cached, _ = tcbCache.checkBundle(teeType, PlatformTypeStandard, otherFmspc)
+	require.Nil(cached, "mismatched bundle should not be cached")
+
+	// Bundles with a malformed FMSPC should be rejected.
+	malformed := *bundle
+	malformed.TCBInfo.TCBInfo = []byte(`{"fmspc":"not hex"}`)
+	_, err = malformed.FMSPC()
+	require.Error(err, "FMSPC should fail on a malformed FMSPC")
+	replaced = tcbCache.cacheBundle(teeType, PlatformTypeStandard, &malformed, fmspc)
+	require.False(replaced, "cacheBundle should reject a malformed FMSPC")
+
+	// Bundles should be cached under a matching FMSPC.
+	replaced = tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	require.True(replaced, "cacheBundle should accept a matching FMSPC")
+}
+
+func testRejectOlderBundle(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-24 * time.Hour),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{Clock: timer.get})
+	skipSignatureVerification(tcbCache)
+	newerBundle := withNextUpdateShifted(t, bundle, 30*24*time.Hour)
+
+	replaced := tcbCache.cacheBundle(teeType, PlatformTypeStandard, newerBundle, fmspc)
+	require.True(replaced, "cacheBundle should store the newer bundle")
+
+	// Older bundles should not replace the newer cached one.
+	replaced = tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	require.False(replaced, "cacheBundle should not replace a newer bundle")
+	cached, _ := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(newerBundle, cached, "newer bundle should remain cached")
+
+	err = tcbCache.LoadBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	require.ErrorIs(err, ErrTCBBundleOlderThanCached, "LoadBundle should reject older bundles")
+	cached, _ = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(newerBundle, cached, "newer bundle should remain cached")
+
+	// Refreshes with the same timestamp should still be stored.
+	replaced = tcbCache.cacheBundle(teeType, PlatformTypeStandard, newerBundle, fmspc)
+	require.True(replaced, "cacheBundle should store a bundle with the same timestamp")
+
+	// Bundles for other FMSPCs are not affected.
+	replaced = tcbCache.cacheBundle(teeType, PlatformTypeStandard, withFMSPC(t, bundle, []byte("other")), []byte("other"))
+	require.True(replaced, "cacheBundle should store the bundle for a different FMSPC")
+}
+
+func testConfiguredIntervals(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-2 * time.Hour),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{
+		RefreshThreshold:    time.Hour,
+		SlowRefreshInterval: 10 * time.Minute,
+		Clock:               timer.get,
+	})
+
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	tcbCache.cacheEvaluationDataNumbers(teeType, []uint32{17, 18, 19})
+
+	// Outside the refresh threshold, no refresh is needed even after the slow refresh interval.
+	timer.now = timer.now.Add(30 * time.Minute)
+	cache, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.NotNil(cache, "tcbCache.checkBundle 1")
+	require.False(refresh, "tcbCache.checkBundle 1")
+
+	cachedNumbers, refresh := tcbCache.checkEvaluationDataNumbers(teeType)
+	require.NotNil(cachedNumbers, "tcbCache.checkEvaluationDataNumbers 1")
+	require.True(refresh, "tcbCache.checkEvaluationDataNumbers 1")
+
+	// Within the refresh threshold, refresh after the slow refresh interval.
+	timer.now = expiryTime.Add(-50 * time.Minute)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.NotNil(cache, "tcbCache.checkBundle 2")
+	require.True(refresh, "tcbCache.checkBundle 2")
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+
+	timer.now = timer.now.Add(5 * time.Minute)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.NotNil(cache, "tcbCache.checkBundle 3")
+	require.False(refresh, "tcbCache.checkBundle 3")
+
+	timer.now = timer.now.Add(6 * time.Minute)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.NotNil(cache, "tcbCache.checkBundle 4")
+	require.True(refresh, "tcbCache.checkBundle 4")
+}
+
+func testNegativeCaching(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+	fmspc := []byte("fmspc")
+	expiryTime, err := readBundleMinTimestamp(bundle)
+	require.NoError(err, "readBundleMinTimestamp")
+
+	timer := fakeTime{
+		now: expiryTime.Add(-30 * 24 * time.Hour),
+	}
+	tcbCache := newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{
+		NegativeTTL: 10 * time.Minute,
+		Clock:       timer.get,
+	})
+
+	// Within the negative TTL, an absent bundle should not need a refresh.
+	tcbCache.markBundleAbsent(teeType, PlatformTypeStandard, fmspc)
+	cached, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "tcbCache.checkBundle 1")
+	require.False(refresh, "tcbCache.checkBundle 1")
+
+	// Other FMSPCs and platform types should not be affected.
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, []byte("different"))
+	require.Nil(cached, "tcbCache.checkBundle 2")
+	require.True(refresh, "tcbCache.checkBundle 2")
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeMultiPackage, fmspc)
+	require.Nil(cached, "tcbCache.checkBundle 3")
+	require.True(refresh, "tcbCache.checkBundle 3")
+
+	timer.now = timer.now.Add(9 * time.Minute)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "tcbCache.checkBundle 4")
+	require.False(refresh, "tcbCache.checkBundle 4")
+
+	// After the negative TTL, a refresh should be requested again.
+	timer.now = timer.now.Add(time.Minute)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "tcbCache.checkBundle 5")
+	require.True(refresh, "tcbCache.checkBundle 5")
+
+	// A successful fetch should clear the absent marker.
+	tcbCache.markBundleAbsent(teeType, PlatformTypeStandard, fmspc)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	require.False(tcbCache.isBundleAbsent(teeType, PlatformTypeStandard, fmspc), "absent marker should be cleared")
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "tcbCache.checkBundle 6")
+	require.False(refresh, "tcbCache.checkBundle 6")
+
+	// A negative TTL should disable negative caching.
+	otherFMSPC := []byte("other")
+	tcbCache = newTcbCache(store, logging.GetLogger(loggerModule), TCBCacheConfig{
+		NegativeTTL: -1,
+		Clock:       timer.get,
+	})
+	tcbCache.markBundleAbsent(teeType, PlatformTypeStandard, otherFMSPC)
+	cached, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, otherFMSPC)
+	require.Nil(cached, "tcbCache.checkBundle 7")
+	require.True(refresh, "tcbCache.checkBundle 7")
+}
+
+func testCheckIntervals(t *testing.T, store *persistent.ServiceStore, teeType TeeType, bundle *TCBBundle) {
 	require := require.New(t)
 	fmspc := []byte("fmspc")
 	expiryTime, err := readBundleMinTimestamp(bundle)
@@ -80,104 +804,93 @@ func testCheckIntervals(t *testing.T, store *persistent.ServiceStore, bundle *TC
 	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), timer.get)
 
 	// Initially, always needs to be refreshed.
-	cache, refresh := tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cache, refresh := tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.Nil(cache, "tcbCache.checkBundle pre-cache")
 	require.True(refresh, "tcbCache.checkBundle pre-cache")
 
-	cachedNumbers, refresh := tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	cachedNumbers, refresh := tcbCache.checkEvaluationDataNumbers(teeType)
 	require.Nil(cachedNumbers, "tcbCache.checkEvaluationDataNumbers pre-cache")
 	require.True(refresh, "tcbCache.checkEvaluationDataNumbers pre-cache")
 
 	// Cache it, pretend it's a day before the first check will need to be performed.
-	timer.now = expiryTime.Add(-(tcbCacheRefreshThreshold + 24*time.Hour))
-	tcbCache.cacheBundle(TeeTypeSGX, bundle, fmspc)
-	tcbCache.cacheEvaluationDataNumbers(TeeTypeSGX, []uint32{17, 18, 19})
+	timer.now = expiryTime.Add(-(defaultTCBCacheRefreshThreshold + 24*time.Hour))
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
+	tcbCache.cacheEvaluationDataNumbers(teeType, []uint32{17, 18, 19})
 
 	// An hour after the initial cache, shouldn't be refreshed.
 	timer.now = timer.now.Add(time.Hour)
-	cache, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.NotNil(cache, "tcbCache.checkBundle 1")
 	require.False(refresh, "tcbCache.checkBundle 1")
 
-	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(teeType)
 	require.NotNil(cachedNumbers, "tcbCache.checkEvaluationDataNumbers 1")
 	require.False(refresh, "tcbCache.checkEvaluationDataNumbers 1")
 
 	// Another day later, we're in the slow refresh cycle. First check should refresh.
 	// Advance by 25 hours, because 24 would still be within the slow refresh interval.
 	timer.now = timer.now.Add(25 * time.Hour)
-	cache, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.NotNil(cache, "tcbCache.checkBundle 2")
 	require.True(refresh, "tcbCache.checkBundle 2")
-	tcbCache.cacheBundle(TeeTypeSGX, bundle, fmspc)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
 
-	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(teeType)
 	require.NotNil(cachedNumbers, "tcbCache.checkEvaluationDataNumbers 2")
 	require.True(refresh, "tcbCache.checkEvaluationDataNumbers 2")
-	tcbCache.cacheEvaluationDataNumbers(TeeTypeSGX, []uint32{17, 18, 19})
+	tcbCache.cacheEvaluationDataNumbers(teeType, []uint32{17, 18, 19})
 
 	// An hour later, don't check again.
 	timer.now = timer.now.Add(time.Hour)
-	cache, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.NotNil(cache, "tcbCache.checkBundle 3")
 	require.False(refresh, "tcbCache.checkBundle 3")
 
-	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(teeType)
 	require.NotNil(cachedNumbers, "tcbCache.checkEvaluationDataNumbers 3")
 	require.False(refresh, "tcbCache.checkEvaluationDataNumbers 3")
 
 	// 22 hours later, still don't check (within slow refresh interval).
 	// Two hours after that, do check.
 	timer.now = timer.now.Add(22 * time.Hour)
-	cache, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.NotNil(cache, "tcbCache.checkBundle 4")
 	require.False(refresh, "tcbCache.checkBundle 4")
 
-	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(teeType)
 	require.NotNil(cachedNumbers, "tcbCache.checkEvaluationDataNumbers 4")
 	require.False(refresh, "tcbCache.checkEvaluationDataNumbers 4")
 
 	timer.now = timer.now.Add(2 * time.Hour)
-	cache, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+	cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 	require.NotNil(cache, "tcbCache.checkBundle 5")
 	require.True(refresh, "tcbCache.checkBundle 5")
-	tcbCache.cacheBundle(TeeTypeSGX, bundle, fmspc)
+	tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
 
-	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	cachedNumbers, refresh = tcbCache.checkEvaluationDataNumbers(teeType)
 	require.NotNil(cachedNumbers, "tcbCache.checkEvaluationDataNumbers 5")
 	require.True(refresh, "tcbCache.checkEvaluationDataNumbers 5")
-	tcbCache.cacheEvaluationDataNumbers(TeeTypeSGX, []uint32{17, 18, 19})
+	tcbCache.cacheEvaluationDataNumbers(teeType, []uint32{17, 18, 19})
 
 	// After the bundle expires, check all the time.
 	timer.now = expiryTime
 	for i := 0; i < 4; i++ {
-		cache, refresh = tcbCache.checkBundle(TeeTypeSGX, fmspc)
+		cache, refresh = tcbCache.checkBundle(teeType, PlatformTypeStandard, fmspc)
 		require.NotNil(cache, "tcbCache.checkBundle loop")
 		require.True(refresh, "tcbCache.checkBundle loop")
-		tcbCache.cacheBundle(TeeTypeSGX, bundle, fmspc)
+		tcbCache.cacheBundle(teeType, PlatformTypeStandard, bundle, fmspc)
 		timer.now = timer.now.Add(time.Hour)
 	}
 }
 
-func TestTCBCache(t *testing.T) {
+func loadTestTCBBundle(t *testing.T, tcbInfoPath, qeIdentityPath string) *TCBBundle {
 	require := require.New(t)
 
-	// Set up the service store.
-	dir, err := os.MkdirTemp("", "oasis-core-unittests")
-	require.NoError(err, "os.MkdirTemp")
-	defer os.RemoveAll(dir)
-
-	common, err := persistent.NewCommonStore(dir)
-	require.NoError(err, "NewCommonStore")
-
-	store := common.GetServiceStore("persistent_test")
-
-	// Read a sample tcb bundle.
-	rawTCBInfo, err := os.ReadFile("testdata/tcb_info_v3_fmspc_00606A000000.json") // From PCS V4 response.
+	rawTCBInfo, err := os.ReadFile(tcbInfoPath)
 	require.NoError(err, "Read test vector")
 	rawCerts, err := os.ReadFile("testdata/tcb_info_v3_fmspc_00606A000000_certs.pem") // From PCS V4 response (TCB-Info-Issuer-Chain header).
 	require.NoError(err, "Read test vector")
-	rawQEIdentity, err := os.ReadFile("testdata/qe_identity_v2.json") // From PCS V4 response.
+	rawQEIdentity, err := os.ReadFile(qeIdentityPath)
 	require.NoError(err, "Read test vector")
 
 	var tcbInfo SignedTCBInfo
@@ -188,21 +901,233 @@ func TestTCBCache(t *testing.T) {
 	err = json.Unmarshal(rawQEIdentity, &qeIdentity)
 	require.NoError(err, "Parse QE identity")
 
-	tcbBundle := TCBBundle{
+	return &TCBBundle{
 		TCBInfo:      tcbInfo,
 		QEIdentity:   qeIdentity,
 		Certificates: rawCerts,
 	}
+}
 
-	for name, fun := range map[string]func(*testing.T, *persistent.ServiceStore, *TCBBundle){
-		"StorageRoundtrip":  testStorageRoundtrip,
-		"CheckIntervals":    testCheckIntervals,
-		"FMSPCInvalidation": testFMSPCInvalidation,
+func testTCBCache(t *testing.T, teeType TeeType, bundle *TCBBundle) {
+	require := require.New(t)
+
+	// Bundles are only cached under their own FMSPC, so use the FMSPC used by the tests.
+	bundle = withFMSPC(t, bundle, []byte("fmspc"))
+
+	// Set up the service store.
+	dir, err := os.MkdirTemp("", "oasis-core-unittests")
+	require.NoError(err, "os.MkdirTemp")
+	defer os.RemoveAll(dir)
+
+	common, err := persistent.NewCommonStore(dir)
+	require.NoError(err, "NewCommonStore")
+	defer common.Close()
+
+	for name, fun := range map[string]func(*testing.T, *persistent.ServiceStore, TeeType, *TCBBundle){
+		"StorageRoundtrip":    testStorageRoundtrip,
+		"CheckIntervals":      testCheckIntervals,
+		"ConfiguredIntervals": testConfiguredIntervals,
+		"ForceRefresh":        testForceRefresh,
+		"MultipleFMSPCs":      testMultipleFMSPCs,
+		"BundleExpiry":        testBundleExpiry,
+		"Stats":               testStats,
+		"LoadBundle":          testLoadBundle,
+		"Eviction":            testEviction,
+		"LegacyMigration":     testLegacyMigration,
+		"PlatformTypes":       testPlatformTypes,
+		"CachedFMSPCs":        testCachedFMSPCs,
+		"RejectOlderBundle":   testRejectOlderBundle,
+		"NegativeCaching":     testNegativeCaching,
+		"FMSPCMismatch":       testFMSPCMismatch,
+		"CorruptedBundle":     testCorruptedBundle,
+		"Fetcher":             testFetcher,
+		"RefreshJitter":       testRefreshJitter,
 	} {
 		t.Run(name, func(t *testing.T) {
-			fun(t, store, &tcbBundle)
-			_ = store.Delete(tcbBundleCacheKey(TeeTypeSGX))
-			_ = store.Delete(tcbEvaluationDataNumbersCacheKey(TeeTypeSGX))
+			// Use a separate service store for each test to start with an empty cache.
+			fun(t, common.GetServiceStore("persistent_test_"+name), teeType, bundle)
 		})
 	}
 }
+
+func TestTCBCache(t *testing.T) {
+	bundle := loadTestTCBBundle(t,
+		"testdata/tcb_info_v3_fmspc_00606A000000.json", // From PCS V4 response.
+		"testdata/qe_identity_v2.json",                 // From PCS V4 response.
+	)
+	testTCBCache(t, TeeTypeSGX, bundle)
+}
+
+func TestTCBCacheTDX(t *testing.T) {
+	bundle := loadTestTCBBundle(t,
+		"testdata/tcb_info_v3_tdx_fmspc_50806F000000.json", // From PCS V4 response.
+		"testdata/qe_identity_v2_tdx.json",                 // From PCS V4 response.
+	)
+	testTCBCache(t, TeeTypeTDX, bundle)
+}
+
+func TestTCBCacheLoadBundleSignatures(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "oasis-core-unittests")
+	require.NoError(err, "os.MkdirTemp")
+	defer os.RemoveAll(dir)
+
+	common, err := persistent.NewCommonStore(dir)
+	require.NoError(err, "NewCommonStore")
+	defer common.Close()
+
+	bundle := loadTestTCBBundle(t,
+		"testdata/tcb_info_v3_fmspc_00606A000000.json",
+		"testdata/qe_identity_v2.json",
+	)
+	fmspc, err := bundle.FMSPC()
+	require.NoError(err, "FMSPC")
+
+	timer := fakeTime{
+		now: time.Unix(1671497404, 0),
+	}
+	tcbCache := newTcbCache(common.GetServiceStore("persistent_test"), logging.GetLogger(loggerModule), TCBCacheConfig{Clock: timer.get})
+
+	// Tampered bundles should be rejected.
+	tampered := withNextUpdateShifted(t, bundle, 30*24*time.Hour)
+	err = tcbCache.LoadBundle(TeeTypeSGX, PlatformTypeStandard, tampered, fmspc)
+	require.ErrorIs(err, ErrTCBBundleBadSignature, "LoadBundle should reject tampered bundles")
+	cached, _ := tcbCache.checkBundle(TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "tampered bundle should not be cached")
+
+	// Bundles with a broken certificate chain should be rejected.
+	noCerts := *bundle
+	noCerts.Certificates = nil
+	err = tcbCache.LoadBundle(TeeTypeSGX, PlatformTypeStandard, &noCerts, fmspc)
+	require.ErrorIs(err, ErrTCBBundleBadChain, "LoadBundle should reject bundles with a broken chain")
+
+	// Genuine bundles should be loaded.
+	err = tcbCache.LoadBundle(TeeTypeSGX, PlatformTypeStandard, bundle, fmspc)
+	require.NoError(err, "LoadBundle")
+	cached, _ = tcbCache.checkBundle(TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "genuine bundle should be cached")
+}
+
+func TestTCBCacheFetcherSignatures(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "oasis-core-unittests")
+	require.NoError(err, "os.MkdirTemp")
+	defer os.RemoveAll(dir)
+
+	common, err := persistent.NewCommonStore(dir)
+	require.NoError(err, "NewCommonStore")
+	defer common.Close()
+
+	bundle := loadTestTCBBundle(t,
+		"testdata/tcb_info_v3_fmspc_00606A000000.json",
+		"testdata/qe_identity_v2.json",
+	)
+	fmspc, err := bundle.FMSPC()
+	require.NoError(err, "FMSPC")
+
+	timer := fakeTime{
+		now: time.Unix(1671497404, 0),
+	}
+	fetcher := &fakeFetcher{bundle: withNextUpdateShifted(t, bundle, 30*24*time.Hour)}
+	tcbCache, err := NewTCBCache(common.GetServiceStore("persistent_test"), logging.GetLogger(loggerModule), fetcher, TCBCacheConfig{
+		Clock: timer.get,
+	})
+	require.NoError(err, "NewTCBCache")
+
+	// Tampered bundles should be neither returned nor cached.
+	_, err = tcbCache.GetOrRefresh(context.Background(), TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.ErrorIs(err, ErrTCBBundleBadSignature, "GetOrRefresh should reject tampered bundles")
+	cached, _ := tcbCache.cache.checkBundle(TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "tampered bundle should not be cached")
+
+	// Genuine bundles should be returned and cached.
+	fetcher.bundle = bundle
+	fetched, err := tcbCache.GetOrRefresh(context.Background(), TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.NoError(err, "GetOrRefresh")
+	require.EqualValues(bundle, fetched, "GetOrRefresh")
+	cached, _ = tcbCache.cache.checkBundle(TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.EqualValues(bundle, cached, "genuine bundle should be cached")
+
+	// Once cached, tampered refreshes should fall back to the cached bundle.
+	tcbCache.ForceRefresh(TeeTypeSGX, PlatformTypeStandard, fmspc)
+	fetcher.bundle = withNextUpdateShifted(t, bundle, 30*24*time.Hour)
+	fetched, err = tcbCache.GetOrRefresh(context.Background(), TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.NoError(err, "GetOrRefresh")
+	require.EqualValues(bundle, fetched, "GetOrRefresh should fall back to the cached bundle")
+	require.Equal(3, fetcher.calls)
+}
+
+func TestCachingQuoteServiceClock(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "oasis-core-unittests")
+	require.NoError(err, "os.MkdirTemp")
+	defer os.RemoveAll(dir)
+
+	common, err := persistent.NewCommonStore(dir)
+	require.NoError(err, "NewCommonStore")
+	defer common.Close()
+
+	timer := fakeTime{
+		now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	cqs, err := NewCachingQuoteServiceWithConfig(nil, common, TCBCacheConfig{Clock: timer.get})
+	require.NoError(err, "NewCachingQuoteServiceWithConfig")
+	qs := cqs.(*cachingQuoteService)
+	require.Equal(timer.now, qs.cache.now(), "configured clock should be used")
+
+	qs = NewCachingQuoteService(nil, common).(*cachingQuoteService)
+	require.WithinDuration(time.Now(), qs.cache.now(), time.Minute, "wall clock should be used by default")
+}
+
+func TestTCBCacheTeeTypeIsolation(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "oasis-core-unittests")
+	require.NoError(err, "os.MkdirTemp")
+	defer os.RemoveAll(dir)
+
+	common, err := persistent.NewCommonStore(dir)
+	require.NoError(err, "NewCommonStore")
+
+	store := common.GetServiceStore("persistent_test")
+
+	sgxBundle := loadTestTCBBundle(t,
+		"testdata/tcb_info_v3_fmspc_00606A000000.json",
+		"testdata/qe_identity_v2.json",
+	)
+	tdxBundle := loadTestTCBBundle(t,
+		"testdata/tcb_info_v3_tdx_fmspc_50806F000000.json",
+		"testdata/qe_identity_v2_tdx.json",
+	)
+	fmspc := []byte("fmspc")
+	sgxBundle = withFMSPC(t, sgxBundle, fmspc)
+	tdxBundle = withFMSPC(t, tdxBundle, fmspc)
+
+	tcbCache := newMockTcbCache(store, logging.GetLogger(loggerModule), time.Now)
+	tcbCache.cacheBundle(TeeTypeSGX, PlatformTypeStandard, sgxBundle, fmspc)
+	tcbCache.cacheEvaluationDataNumbers(TeeTypeSGX, []uint32{17, 18})
+
+	// Nothing should be cached for TDX yet.
+	cached, refresh := tcbCache.checkBundle(TeeTypeTDX, PlatformTypeStandard, fmspc)
+	require.Nil(cached, "tcbCache.checkBundle TDX pre-cache")
+	require.True(refresh, "tcbCache.checkBundle TDX pre-cache")
+	numbers, refresh := tcbCache.checkEvaluationDataNumbers(TeeTypeTDX)
+	require.Nil(numbers, "tcbCache.checkEvaluationDataNumbers TDX pre-cache")
+	require.True(refresh, "tcbCache.checkEvaluationDataNumbers TDX pre-cache")
+
+	tcbCache.cacheBundle(TeeTypeTDX, PlatformTypeStandard, tdxBundle, fmspc)
+	tcbCache.cacheEvaluationDataNumbers(TeeTypeTDX, []uint32{19})
+
+	cached, _ = tcbCache.checkBundle(TeeTypeSGX, PlatformTypeStandard, fmspc)
+	require.EqualValues(sgxBundle, cached, "tcbCache.checkBundle SGX")
+	cached, _ = tcbCache.checkBundle(TeeTypeTDX, PlatformTypeStandard, fmspc)
+	require.EqualValues(tdxBundle, cached, "tcbCache.checkBundle TDX")
+
+	numbers, _ = tcbCache.checkEvaluationDataNumbers(TeeTypeSGX)
+	require.EqualValues([]uint32{17, 18}, numbers, "tcbCache.checkEvaluationDataNumbers SGX")
+	numbers, _ = tcbCache.checkEvaluationDataNumbers(TeeTypeTDX)
+	require.EqualValues([]uint32{19}, numbers, "tcbCache.checkEvaluationDataNumbers TDX")
+}
@@ -81,6 +81,9 @@ func (hc *httpClient) doPCSRequest(ctx context.Context, u *url.URL, method, body
 			"url", u,
 		)
 		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
 		return nil, fmt.Errorf("pcs: response status error: %s", http.StatusText(resp.StatusCode))
 	}
 
@@ -3,6 +3,7 @@ package pcs
 import (
 	"context"
 	"crypto/x509"
+	"errors"
 	"time"
 
 	"github.com/oasisprotocol/oasis-core/go/common/sgx"
@@ -15,6 +16,10 @@ var (
 	mrSignerBlacklist = make(map[sgx.MrSigner]bool)
 )
 
+// ErrNotFound is the error returned by a PCS client when the requested resource (e.g., the TCB
+// info for an unknown FMSPC) does not exist.
+var ErrNotFound = errors.New("pcs: not found")
+
 // Client is an Intel SGX PCS client interface.
 type Client interface {
 	// GetTCBBundle retrieves the signed TCB artifacts needed to verify a quote.
@@ -422,6 +422,10 @@ var (
 
 	// PCK_SGX_Extensions_TCB is the ASN1 Object Identifier for the TCB SGX Extension.
 	PCK_SGX_Extensions_TCB = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 2} // nolint: revive
+
+	// PCK_SGX_Extensions_PlatformInstanceID is the ASN1 Object Identifier for the Platform
+	// Instance ID SGX Extension. It is only present in PCK certificates of multi-package platforms.
+	PCK_SGX_Extensions_PlatformInstanceID = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 6} // nolint: revive
 )
 
 // AttestationKeyType is the attestation key type.
@@ -566,6 +570,9 @@ type PCKInfo struct {
 	TCBCompSVN [16]int32
 	PCESVN     uint16
 	CPUSVN     [16]byte
+
+	// PlatformType is the platform type, derived from the presence of the platform instance ID.
+	PlatformType PlatformType
 }
 
 // verifyPCK verifies the PCK certificate and returns the extracted information.
@@ -605,6 +612,9 @@ func (qe *CertificationData_QEReport) verifyPCK(ts time.Time) (*PCKInfo, error)
 				if len(pckInfo.FMSPC) != 6 {
 					return nil, fmt.Errorf("pcs/quote: bad FMSPC length: %d", len(pckInfo.FMSPC))
 				}
+			case sgxExt.Id.Equal(PCK_SGX_Extensions_PlatformInstanceID):
+				// Platform Instance ID (multi-package platforms only)
+				pckInfo.PlatformType = PlatformTypeMultiPackage
 			case sgxExt.Id.Equal(PCK_SGX_Extensions_TCB):
 				// TCB
 				var tcbExts []SGXExtension
@@ -22,6 +22,47 @@ type QuoteService interface {
 	ResolveQuote(ctx context.Context, rawQuote []byte, quotePolicy *QuotePolicy) (*QuoteBundle, error)
 }
 
+// TCBCacheInspector is implemented by quote services that cache TCB bundles and can report their
+// validity.
+type TCBCacheInspector interface {
+	// TCBBundleExpiry returns the time at which the cached TCB bundle for the given TEE type,
+	// platform type and FMSPC is expected to expire, i.e. the earliest of the TCB info and QE
+	// identity next update timestamps. The boolean flag is false in case no bundle is cached.
+	TCBBundleExpiry(teeType TeeType, platformType PlatformType, fmspc []byte) (time.Time, bool)
+
+	// TCBCacheStats returns a snapshot of the TCB cache statistics.
+	TCBCacheStats() TCBCacheStats
+
+	// CachedFMSPCs returns the FMSPCs of all cached TCB bundles for the given TEE type, sorted in
+	// ascending order. An empty slice is returned in case no bundles are cached.
+	CachedFMSPCs(teeType TeeType) ([][]byte, error)
+}
+
+// TCBCacheLoader is implemented by quote services that cache TCB bundles and support loading
+// them from an external source (e.g., in air-gapped deployments).
+type TCBCacheLoader interface {
+	// LoadTCBBundle validates the given TCB bundle for the given TEE type, platform type and FMSPC
+	// and stores it into the cache. Bundles that cannot be parsed, that have already expired, whose
+	// signatures are invalid, that are older than the cached bundle or whose FMSPC does not match
+	// the given FMSPC are rejected.
+	LoadTCBBundle(teeType TeeType, platformType PlatformType, bundle *TCBBundle, fmspc []byte) error
+
+	// LoadTCBEvaluationDataNumbers stores the given TCB evaluation data numbers for the given
+	// TEE type into the cache.
+	LoadTCBEvaluationDataNumbers(teeType TeeType, numbers []uint32) error
+
+	// ForceTCBBundleRefresh marks the cached TCB bundle for the given TEE type, platform type and
+	// FMSPC as stale so that it is refreshed on next use regardless of the refresh intervals
+	// (e.g., when the operator knows that a new TCB has been published). The cached bundle is
+	// kept and remains available as a fallback until the refresh succeeds.
+	ForceTCBBundleRefresh(teeType TeeType, platformType PlatformType, fmspc []byte)
+}
+
+var (
+	_ TCBCacheInspector = (*cachingQuoteService)(nil)
+	_ TCBCacheLoader    = (*cachingQuoteService)(nil)
+)
+
 type cachingQuoteService struct {
 	client Client
 	cache  *tcbCache
@@ -33,21 +74,72 @@ func NewCachingQuoteService(
 	client Client,
 	store *persistent.CommonStore,
 ) QuoteService {
+	return newCachingQuoteService(client, store, TCBCacheConfig{})
+}
+
+// NewCachingQuoteServiceWithConfig creates a new caching quote service with the given TCB cache
+// configuration.
+func NewCachingQuoteServiceWithConfig(
+	client Client,
+	store *persistent.CommonStore,
+	cfg TCBCacheConfig,
+) (QuoteService, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newCachingQuoteService(client, store, cfg), nil
+}
+
+func newCachingQuoteService(
+	client Client,
+	store *persistent.CommonStore,
+	cfg TCBCacheConfig,
+) *cachingQuoteService {
 	serviceStore := store.GetServiceStore(serviceStoreName)
 	logger := logging.GetLogger("common/sgx/pcs/cqs")
 
 	return &cachingQuoteService{
 		client: client,
-		cache:  newTcbCache(serviceStore, logger),
+		cache:  newTcbCache(serviceStore, logger, cfg),
 		logger: logger,
 	}
 }
 
+// Implements TCBCacheInspector.
+func (qs *cachingQuoteService) TCBBundleExpiry(teeType TeeType, platformType PlatformType, fmspc []byte) (time.Time, bool) {
+	return qs.cache.bundleExpiry(teeType, platformType, fmspc)
+}
+
+// Implements TCBCacheInspector.
+func (qs *cachingQuoteService) TCBCacheStats() TCBCacheStats {
+	return qs.cache.Stats()
+}
+
+// Implements TCBCacheInspector.
+func (qs *cachingQuoteService) CachedFMSPCs(teeType TeeType) ([][]byte, error) {
+	return qs.cache.CachedFMSPCs(teeType)
+}
+
+// Implements TCBCacheLoader.
+func (qs *cachingQuoteService) LoadTCBBundle(teeType TeeType, platformType PlatformType, bundle *TCBBundle, fmspc []byte) error {
+	return qs.cache.LoadBundle(teeType, platformType, bundle, fmspc)
+}
+
+// Implements TCBCacheLoader.
+func (qs *cachingQuoteService) LoadTCBEvaluationDataNumbers(teeType TeeType, numbers []uint32) error {
+	return qs.cache.LoadEvaluationDataNumbers(teeType, numbers)
+}
+
+// Implements TCBCacheLoader.
+func (qs *cachingQuoteService) ForceTCBBundleRefresh(teeType TeeType, platformType PlatformType, fmspc []byte) {
+	qs.cache.ForceRefresh(teeType, platformType, fmspc)
+}
+
 func (qs *cachingQuoteService) verifyBundle(quote Quote, quotePolicy *QuotePolicy, tcbBundle *TCBBundle, which string) error {
 	if tcbBundle == nil {
 		return fmt.Errorf("nil bundle is not valid")
 	}
-	_, err := quote.Verify(quotePolicy, time.Now(), tcbBundle)
+	_, err := quote.Verify(quotePolicy, qs.cache.now(), tcbBundle)
 	var tcbErr *TCBOutOfDateError
 	switch {
 	case err == nil:
@@ -95,28 +187,40 @@ func (qs *cachingQuoteService) ResolveQuote(ctx context.Context, rawQuote []byte
 	}
 
 	// Verify PCK certificate and extract the information required to get the TCB bundle.
-	pckInfo, err := sig.VerifyPCK(time.Now())
+	pckInfo, err := sig.VerifyPCK(qs.cache.now())
 	if err != nil {
 		return nil, fmt.Errorf("PCK verification failed: %w", err)
 	}
 
 	teeType := quote.Header().TeeType()
+	platformType := pckInfo.PlatformType
 
 	// Verify the quote so we can catch errors early (the runtime and later consensus layer will
 	// also do their own verification).
+	//
+	// The number of TCB evaluation data numbers for which the PCS reported the bundle as not found
+	// is tracked, so the bundle is only remembered as absent when all of them are missing.
+	var notFound int
 	getTcbBundle := func(tcbEvaluationDataNumber uint32) (*TCBBundle, error) {
 		var fresh *TCBBundle
 
-		cached, refresh := qs.cache.checkBundle(teeType, pckInfo.FMSPC)
+		cached, refresh := qs.cache.checkBundle(teeType, platformType, pckInfo.FMSPC)
+		if cached == nil && !refresh {
+			// The PCS recently reported the bundle as not found, avoid fetching it again.
+			return nil, fmt.Errorf("TCB bundle recently reported as absent: %w", ErrNotFound)
+		}
 		if refresh {
 			if fresh, err = qs.client.GetTCBBundle(ctx, teeType, pckInfo.FMSPC, tcbEvaluationDataNumber); err != nil {
 				qs.logger.Warn("error downloading TCB refresh",
 					"err", err,
 					"tcb_evaluation_data_number", tcbEvaluationDataNumber,
 				)
+				if errors.Is(err, ErrNotFound) {
+					notFound++
+				}
 			}
 			if err = qs.verifyBundle(quote, quotePolicy, fresh, "fresh"); err == nil {
-				qs.cache.cacheBundle(teeType, fresh, pckInfo.FMSPC)
+				qs.cache.cacheBundle(teeType, platformType, fresh, pckInfo.FMSPC)
 				return fresh, nil
 			}
 			qs.logger.Warn("error verifying downloaded TCB refresh",
@@ -144,12 +248,15 @@ func (qs *cachingQuoteService) ResolveQuote(ctx context.Context, rawQuote []byte
 				"err", err,
 				"tcb_evaluation_data_number", tcbEvaluationDataNumber,
 			)
+			if errors.Is(err, ErrNotFound) {
+				notFound++
+			}
 			return nil, err
 		}
 		if err = qs.verifyBundle(quote, quotePolicy, fresh, "downloaded"); err != nil {
 			return nil, err
 		}
-		qs.cache.cacheBundle(teeType, fresh, pckInfo.FMSPC)
+		qs.cache.cacheBundle(teeType, platformType, fresh, pckInfo.FMSPC)
 		return fresh, nil
 	}
 
@@ -177,6 +284,9 @@ func (qs *cachingQuoteService) ResolveQuote(ctx context.Context, rawQuote []byte
 			break
 		}
 	}
+	if tcbBundle == nil && notFound > 0 && notFound == len(tcbEvaluationDataNumbers) {
+		qs.cache.markBundleAbsent(teeType, platformType, pckInfo.FMSPC)
+	}
 	if err != nil {
 		return nil, err
 	}
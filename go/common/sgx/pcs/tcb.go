@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
 	"time"
@@ -23,6 +24,18 @@ const (
 	requiredQEIdentityVersion = 2
 )
 
+var (
+	// ErrTCBBundleExpired is the error returned when a TCB bundle or its signing certificate is
+	// expired or not yet valid.
+	ErrTCBBundleExpired = errors.New("pcs/tcb: TCB bundle expired or not yet valid")
+
+	// ErrTCBBundleBadSignature is the error returned when a TCB bundle signature is invalid.
+	ErrTCBBundleBadSignature = errors.New("pcs/tcb: bad TCB bundle signature")
+
+	// ErrTCBBundleBadChain is the error returned when a TCB bundle certificate chain is invalid.
+	ErrTCBBundleBadChain = errors.New("pcs/tcb: bad TCB bundle certificate chain")
+)
+
 // If set, the TCB verification will be done in a more lax manner.
 var unsafeLaxVerify bool
 
@@ -71,6 +84,100 @@ func (bnd *TCBBundle) Verify(
 	return nil
 }
 
+// VerifySignatures verifies the TCB bundle's certificate chain, the signatures over the TCB info
+// and the QE identity, and that both are within their validity windows (between the issue date
+// and the next update) at the given time.
+//
+// Different from Verify, no quote policy or platform information is needed, which makes it
+// suitable for validating bundles obtained out of band before loading them into the TCB cache.
+// Failures wrap ErrTCBBundleBadChain, ErrTCBBundleBadSignature or ErrTCBBundleExpired.
+func (bnd *TCBBundle) VerifySignatures(now time.Time) error {
+	pk, err := bnd.getPublicKey(now)
+	if err != nil {
+		var certErr x509.CertificateInvalidError
+		if errors.As(err, &certErr) && certErr.Reason == x509.Expired {
+			return fmt.Errorf("%w: %w", ErrTCBBundleExpired, err)
+		}
+		return fmt.Errorf("%w: %w", ErrTCBBundleBadChain, err)
+	}
+
+	if err = verifyTCBSignature(bnd.TCBInfo.TCBInfo, bnd.TCBInfo.Signature, pk); err != nil {
+		return fmt.Errorf("%w: invalid TCB info: %w", ErrTCBBundleBadSignature, err)
+	}
+	if err = verifyTCBSignature(bnd.QEIdentity.EnclaveIdentity, bnd.QEIdentity.Signature, pk); err != nil {
+		return fmt.Errorf("%w: invalid QE identity: %w", ErrTCBBundleBadSignature, err)
+	}
+
+	var tcbInfo TCBInfo
+	if err = json.Unmarshal(bnd.TCBInfo.TCBInfo, &tcbInfo); err != nil {
+		return fmt.Errorf("pcs/tcb: malformed TCB info body: %w", err)
+	}
+	if err = verifyValidityWindow(tcbInfo.IssueDate, tcbInfo.NextUpdate, now); err != nil {
+		return fmt.Errorf("pcs/tcb: invalid TCB info: %w", err)
+	}
+
+	var qeIdentity QEIdentity
+	if err = json.Unmarshal(bnd.QEIdentity.EnclaveIdentity, &qeIdentity); err != nil {
+		return fmt.Errorf("pcs/tcb: malformed QE identity body: %w", err)
+	}
+	if err = verifyValidityWindow(qeIdentity.IssueDate, qeIdentity.NextUpdate, now); err != nil {
+		return fmt.Errorf("pcs/tcb: invalid QE identity: %w", err)
+	}
+
+	return nil
+}
+
+// FMSPC returns the FMSPC of the platforms that the TCB bundle applies to, as contained in its
+// TCB info. The signature over the TCB info is not verified.
+func (bnd *TCBBundle) FMSPC() ([]byte, error) {
+	var tcbInfo TCBInfo
+	if err := json.Unmarshal(bnd.TCBInfo.TCBInfo, &tcbInfo); err != nil {
+		return nil, fmt.Errorf("pcs/tcb: malformed TCB info body: %w", err)
+	}
+	fmspc, err := hex.DecodeString(tcbInfo.FMSPC)
+	if err != nil {
+		return nil, fmt.Errorf("pcs/tcb: malformed FMSPC: %w", err)
+	}
+	return fmspc, nil
+}
+
+// EvaluateTCBLevel verifies the TCB info and returns the TCB level matching the passed platform
+// SVN information together with its status.
+//
+// TCB levels are matched following Intel's DCAP algorithm, selecting the first level (levels are
+// sorted in descending order) where all of the platform's SVNs are greater or equal. Different
+// from Verify, the level is returned even in case its status is not acceptable.
+//
+// The only exception is the TDX module status for TDX TCB info, which is not part of the returned
+// level. In case the matching TDX module TCB level is not up to date, a TCBOutOfDateError is
+// returned instead.
+func (bnd *TCBBundle) EvaluateTCBLevel(
+	teeType TeeType,
+	ts time.Time,
+	policy *QuotePolicy,
+	fmspc []byte,
+	sgxCompSvn [16]int32,
+	tdxCompSvn *[16]byte,
+	pcesvn uint16,
+) (*TCBLevel, string, error) {
+	pk, err := bnd.getPublicKey(ts)
+	if err != nil {
+		return nil, "", err
+	}
+	tcbInfo, err := bnd.TCBInfo.open(teeType, ts, policy, pk)
+	if err != nil {
+		return nil, "", fmt.Errorf("pcs/tcb: invalid TCB info: %w", err)
+	}
+	if err = tcbInfo.validateFMSPC(fmspc); err != nil {
+		return nil, "", fmt.Errorf("pcs/tcb: failed to validate FMSPC: %w", err)
+	}
+	tcbLevel, err := tcbInfo.getTCBLevel(sgxCompSvn, tdxCompSvn, pcesvn)
+	if err != nil {
+		return nil, "", fmt.Errorf("pcs/tcb: failed to get TCB level: %w", err)
+	}
+	return tcbLevel, tcbLevel.Status.String(), nil
+}
+
 // verifyQEIdentity verifies the QE identity.
 func (bnd *TCBBundle) verifyQEIdentity(
 	teeType TeeType,
@@ -178,6 +285,28 @@ type SignedTCBInfo struct {
 	Signature string          `cbor:"signature" json:"signature"`
 }
 
+// verifyValidityWindow checks that the given time is between the issue date (inclusive) and the
+// next update (exclusive).
+func verifyValidityWindow(issueDate, nextUpdate string, now time.Time) error {
+	issued, err := time.Parse(TimestampFormat, issueDate)
+	if err != nil {
+		return fmt.Errorf("pcs/tcb: invalid issue date: %w", err)
+	}
+	next, err := time.Parse(TimestampFormat, nextUpdate)
+	if err != nil {
+		return fmt.Errorf("pcs/tcb: invalid next update date: %w", err)
+	}
+
+	switch {
+	case now.Before(issued):
+		return fmt.Errorf("%w: issued at %s", ErrTCBBundleExpired, issueDate)
+	case !now.Before(next):
+		return fmt.Errorf("%w: next update was due at %s", ErrTCBBundleExpired, nextUpdate)
+	default:
+		return nil
+	}
+}
+
 // Open verifies the signature and unmarshals the inner TCB info.
 func (st *SignedTCBInfo) open(teeType TeeType, ts time.Time, policy *QuotePolicy, pk *ecdsa.PublicKey) (*TCBInfo, error) {
 	if err := verifyTCBSignature(st.TCBInfo, st.Signature, pk); err != nil {
@@ -191,9 +320,57 @@ func (st *SignedTCBInfo) open(teeType TeeType, ts time.Time, policy *QuotePolicy
 	if err := tcbInfo.validate(teeType, ts, policy); err != nil {
 		return nil, err
 	}
+	if err := validateTCBComponentCounts(st.TCBInfo, teeType, tcbInfo.Version); err != nil {
+		return nil, err
+	}
 	return &tcbInfo, nil
 }
 
+// tcbComponentCounts maps TCB info versions to the number of SVN components in each TCB level.
+var tcbComponentCounts = map[int]int{
+	3: 16,
+}
+
+// validateTCBComponentCounts checks that all TCB levels in the given raw TCB info body contain the
+// number of SVN components expected for the given TCB info version. This is needed as component
+// vectors of a different length would otherwise be silently truncated or zero-padded when
+// decoding them into fixed-size arrays.
+func validateTCBComponentCounts(rawTCBInfo json.RawMessage, teeType TeeType, version int) error {
+	expected, ok := tcbComponentCounts[version]
+	if !ok {
+		return fmt.Errorf("pcs/tcb: unexpected TCB info version: %d", version)
+	}
+
+	var body struct {
+		TCBLevels []struct {
+			TCB struct {
+				SGXComponents []json.RawMessage `json:"sgxtcbcomponents"`
+				TDXComponents []json.RawMessage `json:"tdxtcbcomponents"`
+			} `json:"tcb"`
+		} `json:"tcbLevels"`
+	}
+	if err := json.Unmarshal(rawTCBInfo, &body); err != nil {
+		return fmt.Errorf("pcs/tcb: malformed TCB info body: %w", err)
+	}
+
+	for i, level := range body.TCBLevels {
+		if n := len(level.TCB.SGXComponents); n != expected {
+			return fmt.Errorf("pcs/tcb: TCB level %d has %d SGX TCB components (expected %d for TCB info version %d)",
+				i, n, expected, version,
+			)
+		}
+		if teeType != TeeTypeTDX {
+			continue
+		}
+		if n := len(level.TCB.TDXComponents); n != expected {
+			return fmt.Errorf("pcs/tcb: TCB level %d has %d TDX TCB components (expected %d for TCB info version %d)",
+				i, n, expected, version,
+			)
+		}
+	}
+	return nil
+}
+
 // TDXModule is a representation of the properties of Intel's TDX SEAM module.
 type TDXModule struct {
 	MRSIGNER       string `json:"mrsigner"`
@@ -415,6 +592,28 @@ func (tk TCBKind) String() string {
 	}
 }
 
+// PlatformType is the type of the platform the TCB info applies to.
+type PlatformType uint8
+
+const (
+	// PlatformTypeStandard is a standard (single-package) platform.
+	PlatformTypeStandard PlatformType = 0
+	// PlatformTypeMultiPackage is a multi-package (scalable) platform.
+	PlatformTypeMultiPackage PlatformType = 1
+)
+
+// String returns a string representation of the platform type.
+func (pt PlatformType) String() string {
+	switch pt {
+	case PlatformTypeStandard:
+		return "standard"
+	case PlatformTypeMultiPackage:
+		return "multi-package"
+	default:
+		return fmt.Sprintf("[unknown: %d]", pt)
+	}
+}
+
 // TCBOutOfDateError is an error saying that the TCB of the platform or enclave is out of date.
 type TCBOutOfDateError struct {
 	Kind        TCBKind
@@ -463,7 +662,7 @@ func (tl *TCBLevel) matches(sgxCompSvn [16]int32, tdxCompSvn *[16]byte, pcesvn u
 	//    in the TCB Level. If it is greater or equal to the value in TCB Level, read status
 	//    assigned to this TCB level (in case of SGX) or go to c (in case of TDX). Otherwise, move
 	//    to the next item on TCB Levels list.
-	if tl.TCB.PCESVN < pcesvn {
+	if pcesvn < tl.TCB.PCESVN {
 		return false
 	}
 
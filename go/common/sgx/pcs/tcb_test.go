@@ -0,0 +1,183 @@
+package pcs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateTCBLevelSGX(t *testing.T) {
+	require := require.New(t)
+
+	tcbBundle := loadTestTCBBundle(t, "testdata/tcb_info_v3_fmspc_00606A000000.json", "testdata/qe_identity_v2.json")
+	fmspc, _ := hex.DecodeString("00606A000000")
+	policy := &QuotePolicy{TCBValidityPeriod: 30}
+	now := time.Unix(1671497404, 0)
+
+	for _, tc := range []struct {
+		name       string
+		sgxCompSvn [16]int32
+		pcesvn     uint16
+		status     string
+		date       string
+	}{
+		{"Latest", [16]int32{7, 9, 3, 3, 255, 255, 1}, 13, "SWHardeningNeeded", "2022-08-10T00:00:00Z"},
+		{"Higher", [16]int32{8, 10, 3, 3, 255, 255, 2}, 14, "SWHardeningNeeded", "2022-08-10T00:00:00Z"},
+		{"Configuration", [16]int32{7, 9, 3, 3, 255, 255, 0}, 13, "ConfigurationAndSWHardeningNeeded", "2022-08-10T00:00:00Z"},
+		{"OlderPCESVN", [16]int32{7, 9, 3, 3, 255, 255, 1}, 11, "OutOfDate", "2021-11-10T00:00:00Z"},
+		{"OlderCPUSVN", [16]int32{4, 4, 3, 3, 255, 255}, 12, "OutOfDate", "2021-11-10T00:00:00Z"},
+		{"Oldest", [16]int32{4, 4, 3, 3, 255, 255}, 5, "OutOfDate", "2018-01-04T00:00:00Z"},
+	} {
+		tcbLevel, status, err := tcbBundle.EvaluateTCBLevel(TeeTypeSGX, now, policy, fmspc, tc.sgxCompSvn, nil, tc.pcesvn)
+		require.NoError(err, "EvaluateTCBLevel(%s)", tc.name)
+		require.Equal(tc.status, status, "EvaluateTCBLevel(%s)", tc.name)
+		require.Equal(tc.status, tcbLevel.Status.String(), "EvaluateTCBLevel(%s)", tc.name)
+		require.Equal(tc.date, tcbLevel.Date, "EvaluateTCBLevel(%s)", tc.name)
+	}
+
+	// No level matches.
+	_, _, err := tcbBundle.EvaluateTCBLevel(TeeTypeSGX, now, policy, fmspc, [16]int32{3, 9, 3, 3, 255, 255, 1}, nil, 13)
+	require.ErrorContains(err, "TCB level not supported", "EvaluateTCBLevel should fail for an unsupported CPU SVN")
+	_, _, err = tcbBundle.EvaluateTCBLevel(TeeTypeSGX, now, policy, fmspc, [16]int32{7, 9, 3, 3, 255, 255, 1}, nil, 4)
+	require.ErrorContains(err, "TCB level not supported", "EvaluateTCBLevel should fail for an unsupported PCE SVN")
+
+	// FMSPC mismatch.
+	otherFmspc, _ := hex.DecodeString("00906ED50000")
+	_, _, err = tcbBundle.EvaluateTCBLevel(TeeTypeSGX, now, policy, otherFmspc, [16]int32{7, 9, 3, 3, 255, 255, 1}, nil, 13)
+	require.ErrorContains(err, "FMSPC: mismatch", "EvaluateTCBLevel should fail for a different FMSPC")
+
+	// Expired TCB info.
+	_, _, err = tcbBundle.EvaluateTCBLevel(TeeTypeSGX, now.AddDate(0, 2, 0), policy, fmspc, [16]int32{7, 9, 3, 3, 255, 255, 1}, nil, 13)
+	require.ErrorContains(err, "TCB info expired", "EvaluateTCBLevel should fail for expired TCB info")
+}
+
+func TestEvaluateTCBLevelTDX(t *testing.T) {
+	require := require.New(t)
+
+	tcbBundle := loadTestTCBBundle(t, "testdata/tcb_info_v3_tdx_fmspc_C0806F000000.json", "testdata/qe_identity_v2_tdx.json")
+	fmspc, _ := hex.DecodeString("C0806F000000")
+	policy := &QuotePolicy{
+		TCBValidityPeriod:          30,
+		MinTCBEvaluationDataNumber: 12,
+		TDX:                        &TdxQuotePolicy{},
+	}
+	now := time.Unix(1725263032, 0)
+	sgxCompSvn := [16]int32{7, 7, 2, 2, 3, 1, 0, 3}
+
+	// Without a TDX module version, all TDX components are compared.
+	_, status, err := tcbBundle.EvaluateTCBLevel(TeeTypeTDX, now, policy, fmspc, sgxCompSvn, &[16]byte{5, 0, 7}, 11)
+	require.NoError(err, "EvaluateTCBLevel")
+	require.Equal("UpToDate", status)
+
+	_, status, err = tcbBundle.EvaluateTCBLevel(TeeTypeTDX, now, policy, fmspc, sgxCompSvn, &[16]byte{4, 0, 7}, 11)
+	require.NoError(err, "EvaluateTCBLevel")
+	require.Equal("OutOfDate", status)
+
+	_, status, err = tcbBundle.EvaluateTCBLevel(TeeTypeTDX, now, policy, fmspc, [16]int32{6, 6, 2, 2, 3, 1, 0, 3}, &[16]byte{5, 0, 7}, 11)
+	require.NoError(err, "EvaluateTCBLevel")
+	require.Equal("OutOfDate", status)
+
+	// With a TDX module version, the TDX module identity is also evaluated.
+	_, status, err = tcbBundle.EvaluateTCBLevel(TeeTypeTDX, now, policy, fmspc, sgxCompSvn, &[16]byte{4, 1, 7}, 11)
+	require.NoError(err, "EvaluateTCBLevel")
+	require.Equal("UpToDate", status)
+
+	_, _, err = tcbBundle.EvaluateTCBLevel(TeeTypeTDX, now, policy, fmspc, sgxCompSvn, &[16]byte{2, 1, 7}, 11)
+	var tcbErr *TCBOutOfDateError
+	require.ErrorAs(err, &tcbErr, "EvaluateTCBLevel should fail for an out of date TDX module")
+	require.EqualValues(TCBKindEnclave, tcbErr.Kind)
+	require.Equal(StatusOutOfDate, tcbErr.Status)
+
+	_, _, err = tcbBundle.EvaluateTCBLevel(TeeTypeTDX, now, policy, fmspc, sgxCompSvn, &[16]byte{4, 2, 7}, 11)
+	require.ErrorContains(err, "TDX module not supported", "EvaluateTCBLevel should fail for an unknown TDX module")
+}
+
+func TestTCBBundleVerifySignatures(t *testing.T) {
+	require := require.New(t)
+
+	tcbBundle := loadTestTCBBundle(t, "testdata/tcb_info_v3_fmspc_00606A000000.json", "testdata/qe_identity_v2.json")
+	now := time.Unix(1671497404, 0)
+
+	err := tcbBundle.VerifySignatures(now)
+	require.NoError(err, "VerifySignatures")
+
+	// Before the TCB info issue date.
+	err = tcbBundle.VerifySignatures(time.Date(2022, 12, 18, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(err, ErrTCBBundleExpired, "VerifySignatures should fail for a not yet valid bundle")
+
+	// After the QE identity next update.
+	err = tcbBundle.VerifySignatures(time.Date(2023, 1, 16, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(err, ErrTCBBundleExpired, "VerifySignatures should fail for an expired bundle")
+
+	// After the signing certificate expiry.
+	err = tcbBundle.VerifySignatures(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.ErrorIs(err, ErrTCBBundleExpired, "VerifySignatures should fail for an expired certificate")
+
+	// Bad signatures.
+	badTCBInfo := *tcbBundle
+	badTCBInfo.TCBInfo.TCBInfo = append([]byte{}, tcbBundle.TCBInfo.TCBInfo...)
+	badTCBInfo.TCBInfo.TCBInfo[16] = 'x'
+	err = badTCBInfo.VerifySignatures(now)
+	require.ErrorIs(err, ErrTCBBundleBadSignature, "VerifySignatures should fail for a bad TCB info signature")
+
+	badQEIdentity := *tcbBundle
+	badQEIdentity.QEIdentity.EnclaveIdentity = append([]byte{}, tcbBundle.QEIdentity.EnclaveIdentity...)
+	badQEIdentity.QEIdentity.EnclaveIdentity[16] = 'x'
+	err = badQEIdentity.VerifySignatures(now)
+	require.ErrorIs(err, ErrTCBBundleBadSignature, "VerifySignatures should fail for a bad QE identity signature")
+
+	// Bad certificate chain.
+	noCerts := *tcbBundle
+	noCerts.Certificates = nil
+	err = noCerts.VerifySignatures(now)
+	require.ErrorIs(err, ErrTCBBundleBadChain, "VerifySignatures should fail for a missing certificate chain")
+}
+
+func TestValidateTCBComponentCounts(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		name    string
+		teeType TeeType
+		path    string
+	}{
+		{"SGX", TeeTypeSGX, "testdata/tcb_info_v3_fmspc_00606A000000.json"},
+		{"TDX", TeeTypeTDX, "testdata/tcb_info_v3_tdx_fmspc_50806F000000.json"},
+	} {
+		qeIdentityPath := "testdata/qe_identity_v2.json"
+		if tc.teeType == TeeTypeTDX {
+			qeIdentityPath = "testdata/qe_identity_v2_tdx.json"
+		}
+		tcbBundle := loadTestTCBBundle(t, tc.path, qeIdentityPath)
+
+		err := validateTCBComponentCounts(tcbBundle.TCBInfo.TCBInfo, tc.teeType, requiredTCBInfoVersion)
+		require.NoError(err, "validateTCBComponentCounts(%s)", tc.name)
+
+		err = validateTCBComponentCounts(tcbBundle.TCBInfo.TCBInfo, tc.teeType, requiredTCBInfoVersion+1)
+		require.ErrorContains(err, "unexpected TCB info version", "validateTCBComponentCounts(%s) should fail for an unknown version", tc.name)
+
+		// Truncate the component vectors of the last TCB level.
+		truncate := func(field string) json.RawMessage {
+			var body map[string]any
+			err = json.Unmarshal(tcbBundle.TCBInfo.TCBInfo, &body)
+			require.NoError(err, "json.Unmarshal")
+			levels := body["tcbLevels"].([]any)
+			tcb := levels[len(levels)-1].(map[string]any)["tcb"].(map[string]any)
+			tcb[field] = tcb[field].([]any)[:15]
+			raw, err := json.Marshal(body)
+			require.NoError(err, "json.Marshal")
+			return raw
+		}
+
+		err = validateTCBComponentCounts(truncate("sgxtcbcomponents"), tc.teeType, requiredTCBInfoVersion)
+		require.ErrorContains(err, "has 15 SGX TCB components (expected 16", "validateTCBComponentCounts(%s) should fail for truncated SGX components", tc.name)
+
+		if tc.teeType == TeeTypeTDX {
+			err = validateTCBComponentCounts(truncate("tdxtcbcomponents"), tc.teeType, requiredTCBInfoVersion)
+			require.ErrorContains(err, "has 15 TDX TCB components (expected 16", "validateTCBComponentCounts(%s) should fail for truncated TDX components", tc.name)
+		}
+	}
+}
@@ -310,7 +310,7 @@ func getLatestVersionAndStateRoot(db mkvsAPI.NodeDB) (uint64, *hash.Hash, error)
 	if !exists {
 		return 0, nil, fmt.Errorf("no version found in runtime state db: %v", dbVersion)
 	}
-	rts, err := db.GetRootsForVersion(dbVersion)
+	rts, err := db.GetRootsForVersionByType(dbVersion, storage.RootTypeState)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -318,9 +318,7 @@ func getLatestVersionAndStateRoot(db mkvsAPI.NodeDB) (uint64, *hash.Hash, error)
 	// Get latest state root.
 	var stateRootHash hash.Hash
 	for _, r := range rts {
-		if r.Type == storage.RootTypeState {
-			stateRootHash = r.Hash
-		}
+		stateRootHash = r.Hash
 	}
 
 	return dbVersion, &stateRootHash, nil
@@ -167,16 +167,8 @@ func doCheckRoots(cmd *cobra.Command, args []string) {
 	)
 
 	// Go through every block up to latestBlock and try getting write logs for each of them.
-	oldStateRoot := node.Root{
-		Namespace: id,
-		Type:      node.RootTypeState,
-	}
-	oldStateRoot.Hash.Empty()
-	emptyRoot := node.Root{
-		Namespace: id,
-		Type:      node.RootTypeIO,
-	}
-	emptyRoot.Hash.Empty()
+	oldStateRoot := node.EmptyRoot(id, 0, node.RootTypeState)
+	emptyRoot := node.EmptyRoot(id, 0, node.RootTypeIO)
 	for i := uint64(0); i <= latestBlock.Header.Round; i++ {
 		var blk *block.Block
 		blk, err = client.GetBlock(ctx, &runtimeClient.GetBlockRequest{RuntimeID: id, Round: i})
@@ -67,12 +67,7 @@ func (h *mockHost) Call(ctx context.Context, body *protocol.Body) (*protocol.Bod
 			&transaction.Tag{Key: []byte("txn_foo"), Value: []byte("txn_bar")},
 		}
 
-		emptyRoot := mkvsNode.Root{
-			Namespace: rq.Block.Header.Namespace,
-			Version:   rq.Block.Header.Round + 1,
-			Type:      mkvsNode.RootTypeIO,
-		}
-		emptyRoot.Hash.Empty()
+		emptyRoot := mkvsNode.EmptyRoot(rq.Block.Header.Namespace, rq.Block.Header.Round+1, mkvsNode.RootTypeIO)
 
 		tree := transaction.NewTree(nil, emptyRoot)
 		defer tree.Close()
@@ -41,8 +41,8 @@ func TestTransaction(t *testing.T) {
 	store := mkvs.New(nil, nil, node.RootTypeState)
 
 	var emptyRoot node.Root
-	emptyRoot.Type = node.RootTypeState
 	emptyRoot.Empty()
+	emptyRoot.Type = node.RootTypeState
 
 	tree := NewTree(store, emptyRoot)
 
@@ -237,8 +237,8 @@ func TestTransactionLocalBackend(t *testing.T) {
 	defer db.Cleanup()
 
 	var root node.Root
-	root.Type = node.RootTypeIO
 	root.Empty()
+	root.Type = node.RootTypeIO
 
 	// Prepare transaction tree.
 	tree := NewTree(db, root)
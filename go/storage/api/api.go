@@ -80,7 +80,8 @@ type Config struct { // nolint: maligned
 	// Namespace is the namespace contained within the database.
 	Namespace common.Namespace
 
-	// MaxCacheSize is the maximum in-memory cache size for the database.
+	// MaxCacheSize is the maximum in-memory cache size for the database. It also bounds the
+	// combined in-memory caches of the trees created by the database backed storage backend.
 	MaxCacheSize int64
 
 	// DiscardWriteLogs will cause all write logs to be discarded.
@@ -11,13 +11,14 @@ import (
 
 // RootCache is a LRU based tree cache.
 type RootCache struct {
-	localDB nodedb.NodeDB
+	localDB     nodedb.NodeDB
+	sharedCache *mkvs.SharedCache
 }
 
 // GetTree gets a tree entry from the cache by the root iff present, or creates
 // a new tree with the specified root in the node database.
 func (rc *RootCache) GetTree(root Root) (mkvs.Tree, error) {
-	return mkvs.NewWithRoot(nil, rc.localDB, root), nil
+	return rc.newTree(root), nil
 }
 
 // Apply applies the write log, bypassing the apply operation iff the new root
@@ -38,7 +39,7 @@ func (rc *RootCache) Apply(
 	// Check if we already have the expected new root in our local DB.
 	if !rc.localDB.HasRoot(expectedNewRoot) {
 		// We don't, apply operations.
-		tree := mkvs.NewWithRoot(nil, rc.localDB, root)
+		tree := rc.newTree(root)
 		defer tree.Close()
 
 		if err := tree.ApplyWriteLog(ctx, writelog.NewStaticIterator(writeLog)); err != nil {
@@ -62,8 +63,18 @@ func (rc *RootCache) HasRoot(root Root) bool {
 	return rc.localDB.HasRoot(root)
 }
 
-func NewRootCache(localDB nodedb.NodeDB) (*RootCache, error) {
+func (rc *RootCache) newTree(root Root) mkvs.Tree {
+	if rc.sharedCache == nil {
+		return mkvs.NewWithRoot(nil, rc.localDB, root)
+	}
+	return mkvs.NewWithRoot(nil, rc.localDB, root, mkvs.WithSharedCache(rc.sharedCache))
+}
+
+// NewRootCache creates a new root cache. In case sharedCache is not nil, the in-memory caches of
+// all trees created by the root cache count towards its capacity.
+func NewRootCache(localDB nodedb.NodeDB, sharedCache *mkvs.SharedCache) (*RootCache, error) {
 	return &RootCache{
-		localDB: localDB,
+		localDB:     localDB,
+		sharedCache: sharedCache,
 	}, nil
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/oasisprotocol/oasis-core/go/storage/api"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/checkpoint"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db"
 	dbApi "github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
@@ -57,7 +58,9 @@ func New(cfg *api.Config) (api.LocalBackend, error) {
 		return nil, fmt.Errorf("storage/database: failed to create node database: %w", err)
 	}
 
-	rootCache, err := api.NewRootCache(ndb)
+	// Bound the combined in-memory caches of all trees by the configured node database cache size.
+	sharedCache := mkvs.NewSharedCache(uint64(cfg.MaxCacheSize))
+	rootCache, err := api.NewRootCache(ndb, sharedCache)
 	if err != nil {
 		ndb.Close()
 		return nil, fmt.Errorf("storage/database: failed to create root cache: %w", err)
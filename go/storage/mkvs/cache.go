@@ -45,6 +45,13 @@ type cache struct {
 	lruInternalPos *list.Element
 	lruLeaf        *list.List
 	lruLeafPos     *list.Element
+
+	// shared is the shared cache that this cache counts towards (if any).
+	shared *SharedCache
+	// sharedElem is the position of this cache in the shared cache.
+	sharedElem *list.Element
+	// sharedBytes is the size of the nodes accounted by the shared cache.
+	sharedBytes uint64
 }
 
 // MaxPrefetchDepth is the maximum depth of the prefeteched tree.
@@ -76,6 +83,15 @@ func (c *cache) close() {
 	c.lruLeaf = nil
 	c.lruLeafPos = nil
 
+	// Release the share of the shared cache.
+	if c.shared != nil {
+		c.shared.unregister(c.sharedElem)
+		c.shared.release(c.sharedBytes)
+		c.shared = nil
+		c.sharedElem = nil
+		c.sharedBytes = 0
+	}
+
 	// Reset sync root.
 	c.syncRoot = node.Root{}
 
@@ -166,6 +182,9 @@ func (c *cache) tryCommitNode(ptr, lockedPtr *node.Pointer) error {
 	}
 
 	// Evict nodes till there is enough capacity.
+	if c.shared != nil {
+		c.shared.makeRoom(c, sharedCacheSize(ptr.Node), lockedPtr)
+	}
 	switch n := ptr.Node.(type) {
 	case *node.InternalNode:
 		if c.nodeCapacity > 0 && c.internalNodeCount+1 > c.nodeCapacity {
@@ -196,6 +215,7 @@ func (c *cache) tryCommitNode(ptr, lockedPtr *node.Pointer) error {
 		}
 		c.valueSize += valueSize
 	}
+	c.chargeShared(ptr.Node)
 	return nil
 }
 
@@ -226,6 +246,7 @@ func (c *cache) rollbackNode(ptr *node.Pointer) {
 		c.lruLeaf.Remove(ptr.LRU)
 		c.valueSize -= n.Size()
 	}
+	c.releaseShared(ptr.Node)
 
 	ptr.LRU = nil
 }
@@ -273,6 +294,7 @@ func (c *cache) tryRemoveNode(ptr, lockedPtr *node.Pointer) error {
 		c.lruLeaf.Remove(ptr.LRU)
 		c.valueSize -= n.Size()
 	}
+	c.releaseShared(ptr.Node)
 
 	ptr.Node = nil
 	ptr.LRU = nil
@@ -350,12 +372,12 @@ func (c *cache) derefNodePtr(
 		}
 	}
 
-	if !ptr.Clean || ptr.Hash.IsEmpty() {
+	if !ptr.Clean || node.IsEmptyTreeHash(ptr.Hash) {
 		return nil, nil
 	}
 
 	// First, attempt to fetch from the local node database.
-	n, err := c.db.GetNode(c.syncRoot, ptr)
+	n, err := db.GetNodeContext(ctx, c.db, c.syncRoot, ptr)
 	switch err {
 	case nil:
 		ptr.Node = n
@@ -497,6 +497,139 @@ func testPruneGapAfterCheckpointRestore(t *testing.T, factory dbApi.Factory) {
 // TestCreateRegression is regression test for checkpoint creation.
 //
 // If change is intentional re-run the fuzzers below.
+func TestExportChunks(t *testing.T) {
+	dbTesting.TestMultipleBackends(t, db.Backends, testExportChunks)
+}
+
+func testExportChunks(t *testing.T, factory dbApi.Factory) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	dir, err := os.MkdirTemp("", "mkvs.checkpoint.ExportChunks")
+	require.NoError(err, "TempDir")
+	defer os.RemoveAll(dir)
+
+	ndb1, err := factory.New(&dbApi.Config{
+		DB:           filepath.Join(dir, "db1"),
+		Namespace:    testNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+	})
+	require.NoError(err, "New")
+	defer ndb1.Close()
+
+	root, err := populateDB(ctx, ndb1, testNs, 500, rand.New(rand.NewSource(42)))
+	require.NoError(err, "populateDB")
+
+	_, err = ExportChunks(ctx, ndb1, root, 0)
+	require.Error(err, "ExportChunks should fail with an invalid target chunk size")
+
+	chunks, err := ExportChunks(ctx, ndb1, root, 1024)
+	require.NoError(err, "ExportChunks")
+	require.Greater(len(chunks), 1, "there should be multiple chunks")
+
+	// Chunks should cover contiguous key ranges.
+	require.Nil(chunks[0].StartKey, "first chunk should start at the beginning")
+	require.Nil(chunks[len(chunks)-1].EndKey, "last chunk should end at the end")
+	for i, chunk := range chunks {
+		require.EqualValues(i, chunk.Index, "chunk index should be correct")
+		require.Equal(root, chunk.Root, "chunk root should be correct")
+		require.Equal(hash.NewFromBytes(chunk.Data), chunk.Digest, "chunk digest should be correct")
+		if i > 0 {
+			require.Equal(chunks[i-1].EndKey, chunk.StartKey, "chunks should be contiguous")
+		}
+	}
+
+	// Exporting the same root again should result in the same chunks.
+	again, err := ExportChunks(ctx, ndb1, root, 1024)
+	require.NoError(err, "ExportChunks")
+	require.Equal(chunks, again, "exported chunks should be deterministic")
+
+	// Chunks should be independently importable in any order.
+	ndb2, err := factory.New(&dbApi.Config{
+		DB:           filepath.Join(dir, "db2"),
+		Namespace:    testNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+	})
+	require.NoError(err, "New")
+	defer ndb2.Close()
+
+	err = ndb2.StartMultipartInsert(root.Version)
+	require.NoError(err, "StartMultipartInsert")
+	for i := len(chunks) - 1; i >= 0; i-- {
+		err = chunks[i].Import(ctx, ndb2)
+		require.NoError(err, "Import")
+	}
+	err = ndb2.Finalize([]node.Root{root})
+	require.NoError(err, "Finalize")
+
+	err = ensureEqualEntries(ctx, ndb1, ndb2, root)
+	require.NoError(err, "imported entries should be equal")
+}
+
+func TestStrictChunkCommit(t *testing.T) {
+	dbTesting.TestMultipleBackends(t, db.Backends, testStrictChunkCommit)
+}
+
+func testStrictChunkCommit(t *testing.T, factory dbApi.Factory) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	dir, err := os.MkdirTemp("", "mkvs.checkpoint.StrictChunkCommit")
+	require.NoError(err, "TempDir")
+	defer os.RemoveAll(dir)
+
+	ndb1, err := factory.New(&dbApi.Config{
+		DB:           filepath.Join(dir, "db1"),
+		Namespace:    testNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+	})
+	require.NoError(err, "New")
+	defer ndb1.Close()
+
+	root, err := populateDB(ctx, ndb1, testNs, 100, rand.New(rand.NewSource(42)))
+	require.NoError(err, "populateDB")
+
+	newStrictDB := func(name string) dbApi.NodeDB {
+		ndb, nErr := factory.New(&dbApi.Config{
+			DB:                filepath.Join(dir, name),
+			Namespace:         testNs,
+			MaxCacheSize:      16 * 1024 * 1024,
+			StrictChunkCommit: true,
+		})
+		require.NoError(nErr, "New")
+		nErr = ndb.StartMultipartInsert(root.Version)
+		require.NoError(nErr, "StartMultipartInsert")
+		return ndb
+	}
+
+	// A chunk containing the whole tree should be accepted.
+	chunks, err := ExportChunks(ctx, ndb1, root, 1024*1024)
+	require.NoError(err, "ExportChunks")
+	require.Len(chunks, 1, "there should be a single chunk")
+
+	ndb2 := newStrictDB("db2")
+	defer ndb2.Close()
+	err = chunks[0].Import(ctx, ndb2)
+	require.NoError(err, "Import")
+	err = ndb2.Finalize([]node.Root{root})
+	require.NoError(err, "Finalize")
+	err = ensureEqualEntries(ctx, ndb1, ndb2, root)
+	require.NoError(err, "imported entries should be equal")
+
+	// A chunk referencing subtrees that have not been imported should be rejected.
+	chunks, err = ExportChunks(ctx, ndb1, root, 256)
+	require.NoError(err, "ExportChunks")
+	require.Greater(len(chunks), 1, "there should be multiple chunks")
+
+	ndb3 := newStrictDB("db3")
+	defer ndb3.Close()
+	err = chunks[0].Import(ctx, ndb3)
+	require.ErrorIs(err, dbApi.ErrMissingChildNode, "Import should fail with missing children")
+	var missingErr *dbApi.MissingChildNodeError
+	require.ErrorAs(err, &missingErr)
+	require.False(missingErr.Hash.IsEmpty(), "missing child hash should be reported")
+}
+
 func TestCreateRegression(t *testing.T) {
 	tests := []struct {
 		threads uint16
@@ -316,12 +316,7 @@ func restoreChunk(ctx context.Context, ndb db.NodeDB, chunk *ChunkMetadata, r io
 	}
 
 	// Import chunk into the node database.
-	emptyRoot := node.Root{
-		Namespace: chunk.Root.Namespace,
-		Version:   chunk.Root.Version,
-		Type:      chunk.Root.Type,
-	}
-	emptyRoot.Hash.Empty()
+	emptyRoot := node.EmptyRoot(chunk.Root.Namespace, chunk.Root.Version, chunk.Root.Type)
 
 	batch, err := ndb.NewBatch(emptyRoot, chunk.Root.Version, true)
 	if err != nil {
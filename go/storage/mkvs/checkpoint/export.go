@@ -0,0 +1,89 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs"
+	db "github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// ChunkDescriptor describes a chunk created by ExportChunks.
+type ChunkDescriptor struct {
+	// Index is the index of the chunk.
+	Index uint64 `json:"index"`
+	// Root is the root the chunk proof is verified against.
+	Root node.Root `json:"root"`
+	// StartKey is the first key covered by the chunk. It is nil for the first chunk.
+	StartKey node.Key `json:"start_key,omitempty"`
+	// EndKey is the first key not covered by the chunk (and the start key of the next chunk). It is
+	// nil for the last chunk.
+	EndKey node.Key `json:"end_key,omitempty"`
+	// Digest is the digest of the chunk data.
+	Digest hash.Hash `json:"digest"`
+	// Data is the chunk data, encoded in the same way as checkpoint chunks.
+	Data []byte `json:"data"`
+}
+
+// Metadata returns the chunk metadata for the chunk.
+func (cd *ChunkDescriptor) Metadata() *ChunkMetadata {
+	return &ChunkMetadata{
+		Version: v1,
+		Root:    cd.Root,
+		Index:   cd.Index,
+		Digest:  cd.Digest,
+	}
+}
+
+// Import verifies the chunk and imports its nodes into the given node database using a chunk
+// batch.
+//
+// Chunks can be imported independently of each other and in any order. Multipart management in
+// the underlying database is the responsibility of the caller.
+func (cd *ChunkDescriptor) Import(ctx context.Context, ndb db.NodeDB) error {
+	return restoreChunk(ctx, ndb, cd.Metadata(), bytes.NewReader(cd.Data))
+}
+
+// ExportChunks partitions the tree with the given root into chunks of contiguous key ranges,
+// each with a proof close to targetChunkBytes in size.
+//
+// Chunk boundaries only depend on the structure of the tree, so exporting the same root always
+// results in the same chunks.
+func ExportChunks(ctx context.Context, ndb db.NodeDB, root node.Root, targetChunkBytes int) ([]ChunkDescriptor, error) {
+	if targetChunkBytes <= 0 {
+		return nil, fmt.Errorf("checkpoint: invalid target chunk size: %d", targetChunkBytes)
+	}
+
+	sc := &seqChunker{ndb: ndb, root: root, chunkSize: uint64(targetChunkBytes)}
+	tree := mkvs.NewWithRoot(nil, ndb, root)
+	defer tree.Close()
+
+	var (
+		chunks []ChunkDescriptor
+		offset node.Key
+	)
+	for idx := uint64(0); ; idx++ {
+		var buf bytes.Buffer
+		digest, nextOffset, err := sc.createChunk(ctx, tree, offset, &buf)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint: failed to create chunk %d: %w", idx, err)
+		}
+
+		chunks = append(chunks, ChunkDescriptor{
+			Index:    idx,
+			Root:     root,
+			StartKey: offset,
+			EndKey:   nextOffset,
+			Digest:   digest,
+			Data:     buf.Bytes(),
+		})
+
+		if nextOffset == nil {
+			return chunks, nil
+		}
+		offset = nextOffset
+	}
+}
@@ -80,7 +80,7 @@ func (s *subtree) visitNext(ptr *node.Pointer) error {
 		return nil
 	}
 
-	if ptr.Hash.IsEmpty() {
+	if node.IsEmptyTreeHash(ptr.Hash) {
 		s.pending = append(s.pending, pathAtom{nil, visitBefore})
 		return nil
 	}
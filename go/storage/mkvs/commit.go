@@ -72,7 +72,7 @@ func (t *tree) commitWithHooks(
 	var err error
 	switch opts.noPersist {
 	case false:
-		batch, err = t.cache.db.NewBatch(oldRoot, version, false)
+		batch, err = db.NewBatchContext(ctx, t.cache.db, oldRoot, version, false)
 	case true:
 		// Do not persist anything -- use a dummy batch.
 		nopDb, _ := db.NewNopNodeDB()
@@ -155,7 +155,7 @@ func doCommit(
 	parent *node.Pointer,
 ) (h hash.Hash, err error) {
 	if ptr == nil {
-		h.Empty()
+		h = node.EmptyTreeHash()
 		return
 	} else if ptr.Clean {
 		if err = batch.VisitCleanNode(ptr, parent); err != nil {
@@ -175,7 +175,7 @@ func doCommit(
 	switch n := ptr.Node.(type) {
 	case nil:
 		// Dead node.
-		ptr.Hash.Empty()
+		ptr.Hash = node.EmptyTreeHash()
 	case *node.InternalNode:
 		// Internal node.
 		if n.Clean {
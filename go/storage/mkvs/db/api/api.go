@@ -3,8 +3,10 @@ package api
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/oasisprotocol/oasis-core/go/common"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 	"github.com/oasisprotocol/oasis-core/go/common/errors"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/writelog"
@@ -17,6 +19,11 @@ const (
 	// MaxPendingVersions is the maximum number of allowed non-finalized versions.
 	// Increasing this too much can result in the metadata growing too much.
 	MaxPendingVersions = 5000
+
+	// WriteLogFormatV1 is the initial write log format version.
+	WriteLogFormatV1 uint64 = 1
+	// DefaultWriteLogFormatVersion is the write log format version used for new databases.
+	DefaultWriteLogFormatVersion = WriteLogFormatV1
 )
 
 var (
@@ -62,8 +69,80 @@ var (
 	// ErrCannotPruneLatestVersion indicates that the caller attempted to prune the latest finalized
 	// version which would leave the database without any finalized versions.
 	ErrCannotPruneLatestVersion = errors.New(ModuleName, 16, "mkvs: cannot prune latest version")
+	// ErrQuiesced indicates that the database is quiesced and does not accept any writes.
+	ErrQuiesced = errors.New(ModuleName, 17, "mkvs: database is quiesced")
+	// ErrUnsupportedWriteLogFormat indicates that the database uses a write log format version
+	// that is not supported by this implementation.
+	ErrUnsupportedWriteLogFormat = errors.New(ModuleName, 18, "mkvs: unsupported write log format version")
+	// ErrSecondaryHashesDisabled indicates that secondary node hashes are not enabled for
+	// the database.
+	ErrSecondaryHashesDisabled = errors.New(ModuleName, 19, "mkvs: secondary hashes are disabled")
+	// ErrSecondaryHashMismatch indicates that a stored secondary node hash does not match
+	// the recomputed one.
+	ErrSecondaryHashMismatch = errors.New(ModuleName, 20, "mkvs: secondary hash mismatch")
+	// ErrRootMismatch indicates that the root computed by a batch does not match the expected root.
+	ErrRootMismatch = errors.New(ModuleName, 21, "mkvs: root mismatch")
+	// ErrBatchTooLarge indicates that a batch has reached its configured size limits.
+	ErrBatchTooLarge = errors.New(ModuleName, 22, "mkvs: batch too large")
+	// ErrUnsupportedDBVersion indicates that the on-disk database schema version is not supported
+	// by this implementation, either because it is newer or because it is older than the minimum
+	// supported version.
+	ErrUnsupportedDBVersion = errors.New(ModuleName, 23, "mkvs: unsupported database version")
+	// ErrBatchDiscarded indicates that a batch has been discarded and can no longer be used.
+	ErrBatchDiscarded = errors.New(ModuleName, 24, "mkvs: batch has been discarded")
+	// ErrInvalidRootType indicates that the given root type is invalid or not registered.
+	ErrInvalidRootType = errors.New(ModuleName, 25, "mkvs: invalid root type")
+	// ErrRootsNotChained indicates that a root cannot be shown to have been derived from another
+	// root.
+	ErrRootsNotChained = errors.New(ModuleName, 26, "mkvs: roots are not chained")
+	// ErrDuplicateKey indicates that a leaf key has already been imported during a multipart
+	// restore.
+	ErrDuplicateKey = errors.New(ModuleName, 27, "mkvs: duplicate key")
+	// ErrMissingChildNode indicates that a chunk references a node that exists neither in the
+	// chunk nor in the database.
+	ErrMissingChildNode = errors.New(ModuleName, 28, "mkvs: missing child node")
+	// ErrUnknownDatabase indicates that a database does not exist or that it does not use the
+	// format of any of the available backends.
+	ErrUnknownDatabase = errors.New(ModuleName, 29, "mkvs: unknown database")
 )
 
+// BatchTooLargeError is the error returned by Batch.PutNode in case the batch has reached the
+// configured MaxBatchNodes or MaxBatchBytes limits. It matches ErrBatchTooLarge.
+type BatchTooLargeError struct {
+	// Nodes is the number of nodes stored by the batch.
+	Nodes uint64
+	// Bytes is the total serialized size of the nodes stored by the batch.
+	Bytes uint64
+}
+
+// Error implements the error interface.
+func (e *BatchTooLargeError) Error() string {
+	return fmt.Sprintf("%s (nodes: %d bytes: %d)", ErrBatchTooLarge, e.Nodes, e.Bytes)
+}
+
+// Unwrap returns ErrBatchTooLarge.
+func (e *BatchTooLargeError) Unwrap() error {
+	return ErrBatchTooLarge
+}
+
+// MissingChildNodeError is the error returned by Batch.Commit in case StrictChunkCommit is
+// enabled and the committed chunk references a node that exists neither in the chunk nor in the
+// database. It matches ErrMissingChildNode.
+type MissingChildNodeError struct {
+	// Hash is the hash of the missing node.
+	Hash hash.Hash
+}
+
+// Error implements the error interface.
+func (e *MissingChildNodeError) Error() string {
+	return fmt.Sprintf("%s (hash: %s)", ErrMissingChildNode, e.Hash)
+}
+
+// Unwrap returns ErrMissingChildNode.
+func (e *MissingChildNodeError) Unwrap() error {
+	return ErrMissingChildNode
+}
+
 // Config is the node database backend configuration.
 type Config struct { // nolint: maligned
 	// DB is the path to the database.
@@ -82,10 +161,171 @@ type Config struct { // nolint: maligned
 	Namespace common.Namespace
 
 	// MaxCacheSize is the maximum in-memory cache size for the database.
+	//
+	// This only bounds the caches of the database itself. The in-memory caches of trees backed
+	// by the database can be bounded in combination by using a shared cache (see the
+	// mkvs.SharedCache type), typically sized to the same value.
 	MaxCacheSize int64
 
 	// DiscardWriteLogs will cause all write logs to be discarded.
 	DiscardWriteLogs bool
+
+	// WriteLogFormatVersion is the write log format version to use when creating a new database.
+	// If zero, DefaultWriteLogFormatVersion is used. Existing databases always use the version
+	// they were created with.
+	WriteLogFormatVersion uint64
+
+	// SecondaryHasher is an optional hasher used to compute and store a secondary hash for
+	// each persisted node alongside its primary hash, e.g. when migrating to a different hash
+	// function. If nil, secondary hashes are not maintained.
+	SecondaryHasher node.Hasher
+
+	// WALPath is an optional path to an append-only write-ahead log. When set, committed batches
+	// are appended to the log and fsynced before being applied to the database, which itself is
+	// then no longer synced on each commit. The database is synced and the log is truncated on
+	// Sync, Finalize, Prune, Close and whenever the log grows too large, so after a crash only
+	// the batches committed since then are replayed by RecoverFromWAL when the database is opened.
+	//
+	// Chunk batches used during multipart restores are not logged. NoFsync also disables syncing
+	// of the log, so setting both provides no durability guarantees.
+	WALPath string
+
+	// MultipartProgress is an optional callback invoked with the number of nodes and bytes
+	// written so far during a multipart restore. It is invoked once every
+	// MultipartProgressInterval nodes and a final time when the restore is finalized or aborted.
+	//
+	// The callback must not call into the node database.
+	MultipartProgress MultipartProgressFunc
+
+	// ValueCompression is the compression algorithm used for persisted leaf values. Node hashes
+	// are always computed over uncompressed values so roots do not depend on it, and nodes that
+	// were persisted using a different setting remain readable.
+	ValueCompression ValueCompression
+
+	// ValueCompressionThreshold is the minimum size in bytes of a leaf value for it to be
+	// compressed. If zero, DefaultValueCompressionThreshold is used.
+	ValueCompressionThreshold int
+
+	// MaxBatchNodes is the maximum number of nodes a batch can store. Once a batch holds this
+	// many nodes, PutNode fails with a BatchTooLargeError so the caller can commit the batch and
+	// continue with a new one. If zero, the number of nodes is not limited.
+	MaxBatchNodes uint64
+
+	// MaxBatchBytes is the maximum total serialized size in bytes of the nodes a batch can store.
+	// In case storing a node would exceed this size, PutNode fails with a BatchTooLargeError. If
+	// zero, the size is not limited.
+	MaxBatchBytes uint64
+
+	// GCFilter is an optional callback consulted before a node is removed during finalization
+	// or pruning. Returning false vetoes the removal and keeps the node, e.g. because it is
+	// referenced by an external snapshot. Vetoed nodes are reconsidered on every subsequent
+	// prune and removed once the filter allows it.
+	//
+	// The filter is called once for each node that would be removed, so it should be cheap as it
+	// directly affects finalization and pruning performance. It is always given the node hash,
+	// but the node itself may not be resolved. The filter must not call into the node database.
+	GCFilter func(ptr *node.Pointer) bool
+
+	// BestEffortOpen enables a salvage mode for opening a partially corrupted database. Versions
+	// whose roots cannot be read are logged and skipped, and only the longest contiguous range of
+	// readable versions ending with the latest readable version is reported by
+	// GetEarliestVersion and GetLatestVersion.
+	//
+	// This requires ReadOnly to be set as it is only meant for recovering data. It should never
+	// be used during normal operation.
+	BestEffortOpen bool
+
+	// DetectDuplicateKeys enables detection of duplicate leaf keys during multipart restores,
+	// e.g. when restoring from an untrusted checkpoint. Once a leaf with a given key has been
+	// imported at the restored version, importing another leaf with the same key fails with a
+	// DuplicateKeyError.
+	//
+	// By default an exact set of all imported keys is kept in memory for the duration of the
+	// restore. See DuplicateKeysBloomFilter for a more memory efficient alternative.
+	DetectDuplicateKeys bool
+
+	// DuplicateKeysBloomFilter makes duplicate key detection use a bloom filter instead of an
+	// exact set of keys, which requires about 6 bytes per imported key. The filter never misses
+	// a duplicate key, but it may falsely report a key as a duplicate with a probability of at
+	// most DuplicateKeysFalsePositiveRate, which aborts the restore.
+	DuplicateKeysBloomFilter bool
+
+	// StrictChunkCommit makes committing a chunk batch fail with a MissingChildNodeError in case
+	// any node in the chunk references a node that exists neither in the chunk nor in the
+	// database, instead of leaving it to be discovered at finalization. This catches corrupted
+	// chunk streams at ingest time.
+	//
+	// Note that this requires all subtrees referenced by a chunk to be present by the time it is
+	// committed. As checkpoint chunks reference subtrees contained in other chunks, checkpoints
+	// consisting of multiple chunks cannot be restored in strict mode.
+	StrictChunkCommit bool
+}
+
+// ValueCompression is a compression algorithm for persisted leaf values.
+type ValueCompression uint8
+
+const (
+	// ValueCompressionNone disables compression of persisted leaf values.
+	ValueCompressionNone ValueCompression = 0
+	// ValueCompressionZstd compresses persisted leaf values using zstd.
+	ValueCompressionZstd ValueCompression = 1
+)
+
+// DefaultValueCompressionThreshold is the default minimum size in bytes of a leaf value for it
+// to be compressed. Smaller values rarely compress well enough to be worth the overhead.
+const DefaultValueCompressionThreshold = 256
+
+// String returns a string representation of the value compression algorithm.
+func (c ValueCompression) String() string {
+	switch c {
+	case ValueCompressionNone:
+		return "none"
+	case ValueCompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("[unknown value compression: %d]", uint8(c))
+	}
+}
+
+// CheckWriteLogFormatVersion returns an error in case the given write log format version is not
+// supported.
+func CheckWriteLogFormatVersion(version uint64) error {
+	switch version {
+	case WriteLogFormatV1:
+		return nil
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedWriteLogFormat, version)
+	}
+}
+
+// CheckDBVersion returns an error in case the given on-disk database schema version is outside of
+// the range of versions supported by the implementation.
+func CheckDBVersion(version, minVersion, maxVersion uint64) error {
+	switch {
+	case version > maxVersion:
+		return fmt.Errorf("%w: on-disk version %d is newer than the maximum supported version %d",
+			ErrUnsupportedDBVersion,
+			version,
+			maxVersion,
+		)
+	case version < minVersion:
+		return fmt.Errorf("%w: on-disk version %d is older than the minimum supported version %d",
+			ErrUnsupportedDBVersion,
+			version,
+			minVersion,
+		)
+	default:
+		return nil
+	}
+}
+
+// PruneEstimate is an estimate of the data removed by pruning a version.
+type PruneEstimate struct {
+	// Nodes is the number of nodes that would be removed.
+	Nodes uint64
+	// Size is the total size of the nodes that would be removed in bytes, as reported by
+	// node.Node.Size.
+	Size uint64
 }
 
 // Factory is a node database factory interface that can create new databases.
@@ -102,6 +342,13 @@ type RootPolicy struct {
 	// NoChildRoots means that roots of this type cannot have any children, each version must create
 	// a root from scratch and the root is not available in the next version.
 	NoChildRoots bool
+
+	// AutoPruneAfter is the number of versions after which roots of this type are automatically
+	// pruned as part of finalization. E.g. when set to one, finalizing version N removes all roots
+	// of this type in version N-1. Zero disables automatic pruning.
+	//
+	// Only lone roots are pruned, so this should only be used for types with NoChildRoots set.
+	AutoPruneAfter uint64
 }
 
 var rootPolicies = map[node.RootType]*RootPolicy{
@@ -118,6 +365,15 @@ func PolicyForRoot(root node.Root) *RootPolicy {
 	return rootPolicies[root.Type]
 }
 
+// CheckRootType returns ErrInvalidRootType in case the given root type is not one of the
+// registered root types (see RootTypes).
+func CheckRootType(rootType node.RootType) error {
+	if _, ok := rootPolicies[rootType]; !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidRootType, rootType)
+	}
+	return nil
+}
+
 // RootTypesWithPolicy returns all root types where the given policy predicate evaluates to true.
 func RootTypesWithPolicy(policyFn func(*RootPolicy) bool) (types []node.RootType) {
 	for rootType, policy := range rootPolicies {
@@ -133,14 +389,31 @@ func RootTypes() []node.RootType {
 	return RootTypesWithPolicy(func(*RootPolicy) bool { return true })
 }
 
+var _ node.NodeGetter = (NodeDB)(nil)
+
 // NodeDB is the persistence layer used for persisting the in-memory tree.
 type NodeDB interface {
 	// GetNode looks up a node in the database.
 	GetNode(root node.Root, ptr *node.Pointer) (node.Node, error)
 
+	// HasNode checks whether a node exists in the database without materializing it.
+	//
+	// Nodes of pruned versions are reported as missing.
+	HasNode(root node.Root, ptr *node.Pointer) (bool, error)
+
 	// GetWriteLog retrieves a write log between two storage instances from the database.
 	GetWriteLog(ctx context.Context, startRoot, endRoot node.Root) (writelog.Iterator, error)
 
+	// GetWriteLogForPrefix retrieves a write log between two storage instances from the database,
+	// only including entries whose key has the given prefix.
+	GetWriteLogForPrefix(ctx context.Context, startRoot, endRoot node.Root, prefix node.Key) (writelog.Iterator, error)
+
+	// GetWriteLogAnnotations retrieves the annotations of the write log between two storage
+	// instances from the database. The annotations correspond to the entries returned by
+	// GetWriteLog at their respective indexes. Inserted nodes are referenced by hash-only
+	// pointers that can be resolved via GetNode.
+	GetWriteLogAnnotations(ctx context.Context, startRoot, endRoot node.Root) (writelog.Annotations, error)
+
 	// GetLatestVersion returns the most recent version in the node database.
 	//
 	// The boolean flag signifies whether any version exists to disambiguate version zero.
@@ -152,6 +425,37 @@ type NodeDB interface {
 	// GetRootsForVersion returns a list of roots stored under the given version.
 	GetRootsForVersion(version uint64) ([]node.Root, error)
 
+	// GetRootsForVersionByType returns a list of roots of the given type stored under the given
+	// version. ErrInvalidRootType is returned in case the root type is not a registered type.
+	GetRootsForVersionByType(version uint64, rootType node.RootType) ([]node.Root, error)
+
+	// GetPendingVersions returns the versions that have roots committed but are not yet
+	// finalized, in ascending order.
+	GetPendingVersions() ([]uint64, error)
+
+	// NearestVersionAtOrBefore returns the highest finalized and non-pruned version that is less
+	// than or equal to the given target version.
+	//
+	// The boolean flag signifies whether any such version exists.
+	NearestVersionAtOrBefore(target uint64) (uint64, bool)
+
+	// Fingerprint returns a hash summarizing all versions and their roots in the database,
+	// including ones that have not yet been finalized, so that databases containing the same
+	// roots produce identical fingerprints.
+	Fingerprint(ctx context.Context) (hash.Hash, error)
+
+	// ScanPrefix calls fn for each key/value pair stored in the tree with the given root whose
+	// key starts with the given prefix, in lexicographic key order, stopping early in case fn
+	// returns false. Only subtrees that may contain keys with the prefix are traversed.
+	ScanPrefix(ctx context.Context, root node.Root, prefix node.Key, fn func(key node.Key, value []byte) bool) error
+
+	// IterateNodes calls fn for each node stored in the database exactly once, regardless of the
+	// roots it belongs to and in no particular order, stopping at the first error returned by fn.
+	// The passed pointer is clean and refers to the passed node.
+	//
+	// This is meant for offline analysis as it reads the whole database.
+	IterateNodes(ctx context.Context, fn func(ptr *node.Pointer, n node.Node) error) error
+
 	// StartMultipartInsert prepares the database for a batch insert job from multiple chunks.
 	// Batches from this call onwards will keep track of inserted nodes so that they can be
 	// deleted if the job fails for any reason.
@@ -164,6 +468,12 @@ type NodeDB interface {
 	// It is not an error to call this method more than once.
 	AbortMultipartInsert() error
 
+	// MultipartState returns whether a multipart insert is in progress and, if so, its version.
+	//
+	// This can be used to detect a multipart insert that has not been completed (e.g., after a
+	// restart) and decide whether to abort it via AbortMultipartInsert.
+	MultipartState() (inProgress bool, version uint64, err error)
+
 	// NewBatch starts a new batch.
 	//
 	// The chunk argument specifies whether the given batch is being used to import a chunk of an
@@ -179,18 +489,96 @@ type NodeDB interface {
 	// All non-finalized roots can be discarded.
 	Finalize(roots []node.Root) error
 
+	// FinalizeVersions finalizes multiple contiguous versions, each comprising the passed list of
+	// finalized roots, as if Finalize was called for each version in order. The metadata of all
+	// versions is committed at once, so in case finalization fails, none of the versions is
+	// finalized.
+	//
+	// See OrderVersionRoots for the requirements on the passed roots.
+	FinalizeVersions(versionRoots map[uint64][]node.Root) error
+
 	// Prune removes all roots recorded under the given version.
 	//
 	// Only the earliest version can be pruned, passing any other version will result in an error.
 	Prune(version uint64) error
 
+	// PruneWriteLogs removes the write logs of all versions earlier than the given version
+	// without touching node data or roots metadata, so write logs can be retained for a shorter
+	// period than the state itself. Afterwards, GetWriteLog returns ErrWriteLogNotFound for any
+	// pruned write logs.
+	//
+	// Only write logs of finalized versions can be pruned.
+	PruneWriteLogs(beforeVersion uint64) error
+
+	// PruneDryRun estimates the amount of data that pruning the given version would remove,
+	// without modifying the database.
+	//
+	// The same checks as in Prune are performed, so an error is returned in case pruning the given
+	// version would fail.
+	PruneDryRun(version uint64) (*PruneEstimate, error)
+
+	// CommitFinalizeAndPrune commits the given batch under the first of the passed roots,
+	// finalizes the version comprising the passed list of finalized roots and prunes the given
+	// version while holding the metadata lock, so no other operation can be interleaved.
+	//
+	// The batch must have been created by this database and must not be a chunk batch. In case
+	// the batch is nil, all of the roots must have already been committed.
+	//
+	// The finalization and pruning metadata updates are committed in a single transaction, so
+	// either both take effect or neither does. The pruning preconditions are checked before
+	// anything is changed, so in case pruning would fail, nothing is committed. In case of a crash
+	// before the metadata is committed, the batch may have been committed and the operation can
+	// be retried without it.
+	CommitFinalizeAndPrune(batch Batch, roots []node.Root, pruneVersion uint64) error
+
+	// VerifyAgainstManifest checks that, for each version in the manifest, the roots present in
+	// the database match the expected roots and returns any mismatches.
+	VerifyAgainstManifest(ctx context.Context, manifest map[uint64][]node.Root) ([]ManifestMismatch, error)
+
+	// CheckComplete returns the hashes of all nodes referenced by the tree with the given root
+	// that are not present in the database, or an empty list in case the tree is complete.
+	//
+	// The root does not need to be finalized or even committed, so this can be used to check
+	// whether a multipart restore is complete before finalizing it. Nothing is modified.
+	CheckComplete(ctx context.Context, root node.Root) ([]hash.Hash, error)
+
+	// Quiesce waits for any in-progress writes to complete, syncs the database to disk and
+	// rejects any further writes with ErrQuiesced until the returned resume function is called.
+	// Reads are not affected.
+	//
+	// In case in-progress writes do not complete before the context is done, the database is
+	// not quiesced and the context error is returned.
+	Quiesce(ctx context.Context) (resume func(), err error)
+
+	// VerifySecondaryHashes recomputes the secondary hashes of all nodes reachable from the
+	// given root and checks that they match the stored ones.
+	//
+	// Returns ErrSecondaryHashesDisabled in case no secondary hasher is configured.
+	VerifySecondaryHashes(root node.Root) error
+
+	// Compact triggers compaction of the underlying storage, reclaiming space left behind by
+	// pruned data and improving read latency. Backends that do not need compaction do nothing.
+	//
+	// This may be long-running and I/O heavy. Reads may proceed concurrently. In case the
+	// context is done before compaction completes, the context error is returned.
+	Compact(ctx context.Context) error
+
 	// Size returns the size of the database in bytes.
 	Size() (int64, error)
 
+	// CacheUsage returns the current size in bytes and the number of entries of the in-memory
+	// cache whose capacity is bounded by MaxCacheSize.
+	CacheUsage() (bytes int64, entries int, err error)
+
 	// Sync syncs the database to disk. This is useful if the NoFsync option is used to explicitly
 	// perform a sync.
 	Sync() error
 
+	// RecoverFromWAL replays any batches in the write-ahead log that have not yet been applied to
+	// the database and truncates the log. It is called automatically when opening a database with
+	// a configured WALPath and is a no-op in case no write-ahead log is configured.
+	RecoverFromWAL() error
+
 	// Close closes the database.
 	Close()
 }
@@ -200,15 +588,40 @@ type Batch interface {
 	// PutNode persists a node in the NodeDB.
 	PutNode(ptr *node.Pointer) error
 
+	// PutNodeBatch persists multiple nodes in the NodeDB. It is equivalent to calling PutNode for
+	// each of the nodes in order, but allows backends to amortize per-node overhead.
+	//
+	// In case of an error, some of the nodes may have already been added to the batch.
+	PutNodeBatch(ptrs []*node.Pointer) error
+
 	// PutWriteLog stores the specified write log into the batch.
 	PutWriteLog(writeLog writelog.WriteLog, logAnnotations writelog.Annotations) error
 
 	// RemoveNodes marks nodes for eventual garbage collection.
 	RemoveNodes(nodes []*node.Pointer) error
 
+	// PendingSize returns the number and the total in-memory size (as reported by
+	// node.Pointer.Size) of the nodes put into or removed by the batch since it was last
+	// committed or reset.
+	//
+	// The size is maintained incrementally, so this is cheap to call (e.g., in order to decide
+	// when to flush).
+	PendingSize() (nodes int, bytes uint64)
+
+	// PrefetchKeys resolves the nodes on the lookup paths of the given keys in the old root so
+	// that any reads during subsequent mutations of those keys are served from the caches.
+	//
+	// This is only a performance hint and is a no-op in case the old root is empty.
+	PrefetchKeys(keys []node.Key) error
+
 	// Commit commits the batch.
 	Commit(root node.Root) error
 
+	// CommitExpecting commits the batch only in case the hash of the root computed from the
+	// nodes in the batch matches the hash of the expected root. Otherwise ErrRootMismatch is
+	// returned and nothing is committed.
+	CommitExpecting(expectedRoot node.Root) error
+
 	// OnCommit registers a hook to run after a successful commit.
 	OnCommit(hook func())
 
@@ -226,12 +639,30 @@ type Batch interface {
 
 	// Reset resets the batch for another use.
 	Reset()
+
+	// Discard releases any resources held by the batch without committing it. Different from
+	// Reset, the batch cannot be used afterwards and any further operations on it fail with
+	// ErrBatchDiscarded. Discarding an already discarded batch is a no-op.
+	Discard()
 }
 
 // BaseBatch encapsulates basic functionality of a batch so it doesn't need
 // to be reimplemented by each concrete batch implementation.
 type BaseBatch struct {
 	onCommitHooks []func()
+
+	// rootPtr is the pointer to the root node, as visited by the batch.
+	rootPtr *node.Pointer
+
+	maxNodes uint64
+	maxBytes uint64
+	nodes    uint64
+	bytes    uint64
+
+	pendingNodes int
+	pendingBytes uint64
+
+	discarded bool
 }
 
 func (b *BaseBatch) OnCommit(hook func()) {
@@ -239,10 +670,158 @@ func (b *BaseBatch) OnCommit(hook func()) {
 }
 
 func (b *BaseBatch) Commit(node.Root) error {
+	if err := b.CheckDiscarded(); err != nil {
+		return err
+	}
 	for _, hook := range b.onCommitHooks {
 		hook()
 	}
 	b.onCommitHooks = nil
+	b.Reset()
+	return nil
+}
+
+// Reset resets the state tracked by the base batch, including the size counted towards the
+// batch size limits.
+//
+// Implementations should call this from Reset.
+func (b *BaseBatch) Reset() {
+	b.rootPtr = nil
+	b.nodes = 0
+	b.bytes = 0
+	b.ResetPendingSize()
+}
+
+// Discard marks the batch as discarded.
+//
+// Implementations that hold resources should release them and then call this from Discard.
+func (b *BaseBatch) Discard() {
+	b.discarded = true
+	b.onCommitHooks = nil
+	b.Reset()
+}
+
+// Discarded returns true iff the batch has been discarded.
+func (b *BaseBatch) Discarded() bool {
+	return b.discarded
+}
+
+// CheckDiscarded returns ErrBatchDiscarded in case the batch has been discarded.
+//
+// Implementations should call this at the start of all batch operations.
+func (b *BaseBatch) CheckDiscarded() error {
+	if b.discarded {
+		return ErrBatchDiscarded
+	}
+	return nil
+}
+
+// SetSizeLimits sets the maximum number of nodes and the maximum total serialized size in bytes
+// of the nodes stored by the batch. Zero means unlimited.
+func (b *BaseBatch) SetSizeLimits(maxNodes, maxBytes uint64) {
+	b.maxNodes = maxNodes
+	b.maxBytes = maxBytes
+}
+
+// TrackNodeSize records a node of the given serialized size stored by the batch in order to
+// enforce the batch size limits. In case storing the node would exceed any of the limits, a
+// BatchTooLargeError is returned and the node is not recorded.
+//
+// Implementations should call this from PutNode before storing the node.
+func (b *BaseBatch) TrackNodeSize(size int) error {
+	if (b.maxNodes > 0 && b.nodes+1 > b.maxNodes) || (b.maxBytes > 0 && b.bytes+uint64(size) > b.maxBytes) {
+		return &BatchTooLargeError{
+			Nodes: b.nodes,
+			Bytes: b.bytes,
+		}
+	}
+	b.nodes++
+	b.bytes += uint64(size)
+	return nil
+}
+
+// TrackPendingNodes records nodes put into or removed by the batch in order to maintain the
+// pending size.
+//
+// Implementations should call this from PutNode and RemoveNodes.
+func (b *BaseBatch) TrackPendingNodes(ptrs ...*node.Pointer) {
+	for _, ptr := range ptrs {
+		b.pendingNodes++
+		b.pendingBytes += ptr.Size()
+	}
+}
+
+// PendingSize returns the number and the total in-memory size of the nodes put into or removed
+// by the batch since it was last committed or reset.
+func (b *BaseBatch) PendingSize() (int, uint64) {
+	return b.pendingNodes, b.pendingBytes
+}
+
+// ResetPendingSize resets the pending size.
+func (b *BaseBatch) ResetPendingSize() {
+	b.pendingNodes = 0
+	b.pendingBytes = 0
+}
+
+// TrackCleanNode records a clean node visited by the batch in order to determine the
+// prospective root.
+//
+// Implementations should call this from VisitCleanNode.
+func (b *BaseBatch) TrackCleanNode(ptr *node.Pointer, parent *node.Pointer) {
+	b.trackVisitedNode(ptr, parent)
+}
+
+// TrackDirtyNode records a dirty node visited by the batch in order to determine the
+// prospective root.
+//
+// Implementations should call this from VisitDirtyNode.
+func (b *BaseBatch) TrackDirtyNode(ptr *node.Pointer, parent *node.Pointer) {
+	b.trackVisitedNode(ptr, parent)
+}
+
+func (b *BaseBatch) trackVisitedNode(ptr *node.Pointer, parent *node.Pointer) {
+	// The root is the only node visited without a parent.
+	if parent != nil {
+		return
+	}
+	b.rootPtr = ptr
+}
+
+// ProspectiveRootHash returns the hash of the root computed from the nodes visited by the batch.
+//
+// As the hash of a dirty root is only known once all of its children have been visited, this
+// should only be called once all nodes have been visited. In case no root has been visited, the
+// root is empty.
+func (b *BaseBatch) ProspectiveRootHash() hash.Hash {
+	switch {
+	case b.rootPtr == nil:
+		return node.EmptyTreeHash()
+	case b.rootPtr.Node != nil:
+		return b.rootPtr.Node.GetHash()
+	default:
+		return b.rootPtr.Hash
+	}
+}
+
+// CheckExpectedRoot returns ErrRootMismatch in case the prospective root hash does not match
+// the hash of the expected root.
+func (b *BaseBatch) CheckExpectedRoot(expectedRoot node.Root) error {
+	if h := b.ProspectiveRootHash(); !h.Equal(&expectedRoot.Hash) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrRootMismatch, expectedRoot.Hash, h)
+	}
+	return nil
+}
+
+// PutNodeBatch persists multiple nodes by calling PutNode on the given batch for each of them.
+//
+// Batch implementations without a more efficient bulk insert path may use this to implement
+// Batch.PutNodeBatch.
+func PutNodeBatch(b Batch, ptrs []*node.Pointer) error {
+	for _, ptr := range ptrs {
+		if err := b.PutNode(ptr); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -258,10 +837,22 @@ func (d *nopNodeDB) GetNode(node.Root, *node.Pointer) (node.Node, error) {
 	return nil, ErrNodeNotFound
 }
 
+func (d *nopNodeDB) HasNode(node.Root, *node.Pointer) (bool, error) {
+	return false, nil
+}
+
 func (d *nopNodeDB) GetWriteLog(context.Context, node.Root, node.Root) (writelog.Iterator, error) {
 	return nil, ErrWriteLogNotFound
 }
 
+func (d *nopNodeDB) GetWriteLogForPrefix(context.Context, node.Root, node.Root, node.Key) (writelog.Iterator, error) {
+	return nil, ErrWriteLogNotFound
+}
+
+func (d *nopNodeDB) GetWriteLogAnnotations(context.Context, node.Root, node.Root) (writelog.Annotations, error) {
+	return nil, ErrWriteLogNotFound
+}
+
 func (d *nopNodeDB) GetLatestVersion() (uint64, bool) {
 	return 0, false
 }
@@ -274,6 +865,33 @@ func (d *nopNodeDB) GetRootsForVersion(uint64) ([]node.Root, error) {
 	return nil, nil
 }
 
+func (d *nopNodeDB) GetRootsForVersionByType(_ uint64, rootType node.RootType) ([]node.Root, error) {
+	if err := CheckRootType(rootType); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (d *nopNodeDB) GetPendingVersions() ([]uint64, error) {
+	return nil, nil
+}
+
+func (d *nopNodeDB) NearestVersionAtOrBefore(uint64) (uint64, bool) {
+	return 0, false
+}
+
+func (d *nopNodeDB) Fingerprint(ctx context.Context) (hash.Hash, error) {
+	return Fingerprint(ctx, d)
+}
+
+func (d *nopNodeDB) ScanPrefix(context.Context, node.Root, node.Key, func(node.Key, []byte) bool) error {
+	return nil
+}
+
+func (d *nopNodeDB) IterateNodes(context.Context, func(*node.Pointer, node.Node) error) error {
+	return nil
+}
+
 func (d *nopNodeDB) HasRoot(node.Root) bool {
 	return false
 }
@@ -286,22 +904,72 @@ func (d *nopNodeDB) AbortMultipartInsert() error {
 	return nil
 }
 
+func (d *nopNodeDB) MultipartState() (bool, uint64, error) {
+	return false, 0, nil
+}
+
 func (d *nopNodeDB) Finalize([]node.Root) error {
 	return nil
 }
 
+func (d *nopNodeDB) FinalizeVersions(map[uint64][]node.Root) error {
+	return nil
+}
+
 func (d *nopNodeDB) Prune(uint64) error {
 	return nil
 }
 
+func (d *nopNodeDB) PruneWriteLogs(uint64) error {
+	return nil
+}
+
+func (d *nopNodeDB) PruneDryRun(uint64) (*PruneEstimate, error) {
+	return &PruneEstimate{}, nil
+}
+
+func (d *nopNodeDB) CommitFinalizeAndPrune(Batch, []node.Root, uint64) error {
+	return nil
+}
+
+func (d *nopNodeDB) Quiesce(context.Context) (func(), error) {
+	return func() {}, nil
+}
+
+func (d *nopNodeDB) VerifyAgainstManifest(ctx context.Context, manifest map[uint64][]node.Root) ([]ManifestMismatch, error) {
+	return VerifyAgainstManifest(ctx, d, manifest)
+}
+
+func (d *nopNodeDB) CheckComplete(ctx context.Context, root node.Root) ([]hash.Hash, error) {
+	return CheckComplete(ctx, root, func(*node.Pointer) (node.Node, error) {
+		return nil, ErrNodeNotFound
+	})
+}
+
+func (d *nopNodeDB) VerifySecondaryHashes(node.Root) error {
+	return ErrSecondaryHashesDisabled
+}
+
+func (d *nopNodeDB) Compact(context.Context) error {
+	return nil
+}
+
 func (d *nopNodeDB) Size() (int64, error) {
 	return 0, nil
 }
 
+func (d *nopNodeDB) CacheUsage() (int64, int, error) {
+	return 0, 0, nil
+}
+
 func (d *nopNodeDB) Sync() error {
 	return nil
 }
 
+func (d *nopNodeDB) RecoverFromWAL() error {
+	return nil
+}
+
 func (d *nopNodeDB) Close() {
 }
 
@@ -314,25 +982,60 @@ func (d *nopNodeDB) NewBatch(node.Root, uint64, bool) (Batch, error) {
 	return &nopBatch{}, nil
 }
 
-func (b *nopBatch) PutNode(*node.Pointer) error {
+func (b *nopBatch) PutNode(ptr *node.Pointer) error {
+	if err := b.CheckDiscarded(); err != nil {
+		return err
+	}
+	b.TrackPendingNodes(ptr)
 	return nil
 }
 
+func (b *nopBatch) PutNodeBatch(ptrs []*node.Pointer) error {
+	return PutNodeBatch(b, ptrs)
+}
+
+func (b *nopBatch) CommitExpecting(expectedRoot node.Root) error {
+	if err := b.CheckDiscarded(); err != nil {
+		return err
+	}
+	if err := b.CheckExpectedRoot(expectedRoot); err != nil {
+		return err
+	}
+	return b.Commit(expectedRoot)
+}
+
 func (b *nopBatch) PutWriteLog(writelog.WriteLog, writelog.Annotations) error {
-	return nil
+	return b.CheckDiscarded()
 }
 
-func (b *nopBatch) RemoveNodes([]*node.Pointer) error {
+func (b *nopBatch) RemoveNodes(nodes []*node.Pointer) error {
+	if err := b.CheckDiscarded(); err != nil {
+		return err
+	}
+	b.TrackPendingNodes(nodes...)
 	return nil
 }
 
-func (b *nopBatch) VisitCleanNode(*node.Pointer, *node.Pointer) error {
+func (b *nopBatch) PrefetchKeys([]node.Key) error {
+	return b.CheckDiscarded()
+}
+
+func (b *nopBatch) VisitCleanNode(ptr *node.Pointer, parent *node.Pointer) error {
+	if err := b.CheckDiscarded(); err != nil {
+		return err
+	}
+	b.TrackCleanNode(ptr, parent)
 	return nil
 }
 
-func (b *nopBatch) VisitDirtyNode(*node.Pointer, *node.Pointer) error {
+func (b *nopBatch) VisitDirtyNode(ptr *node.Pointer, parent *node.Pointer) error {
+	if err := b.CheckDiscarded(); err != nil {
+		return err
+	}
+	b.TrackDirtyNode(ptr, parent)
 	return nil
 }
 
 func (b *nopBatch) Reset() {
+	b.BaseBatch.Reset()
 }
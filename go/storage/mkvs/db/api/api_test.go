@@ -0,0 +1,77 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+func TestBaseBatchProspectiveRoot(t *testing.T) {
+	require := require.New(t)
+
+	var ns common.Namespace
+	emptyRoot := node.EmptyRoot(ns, 0, node.RootTypeState)
+
+	ndb, err := NewNopNodeDB()
+	require.NoError(err, "NewNopNodeDB")
+	batch, err := ndb.NewBatch(emptyRoot, 0, false)
+	require.NoError(err, "NewBatch")
+
+	visitRoot := func() node.Root {
+		leaf := &node.LeafNode{Key: []byte("key"), Value: []byte("value")}
+		leaf.UpdateHash()
+		ptr := &node.Pointer{Node: leaf}
+		err = batch.VisitDirtyNode(ptr, nil)
+		require.NoError(err, "VisitDirtyNode")
+		err = batch.PutNode(ptr)
+		require.NoError(err, "PutNode")
+
+		root := emptyRoot
+		root.Hash = leaf.Hash
+		return root
+	}
+
+	// The prospective root is the visited root node.
+	root := visitRoot()
+	err = batch.CommitExpecting(emptyRoot)
+	require.ErrorIs(err, ErrRootMismatch, "CommitExpecting should fail with a wrong root")
+	err = batch.CommitExpecting(root)
+	require.NoError(err, "CommitExpecting")
+
+	// Resetting the batch should forget the visited root.
+	_ = visitRoot()
+	batch.Reset()
+	err = batch.CommitExpecting(emptyRoot)
+	require.NoError(err, "CommitExpecting after Reset")
+}
+
+func TestBaseBatchSizeLimits(t *testing.T) {
+	require := require.New(t)
+
+	var b BaseBatch
+	b.SetSizeLimits(2, 100)
+
+	err := b.TrackNodeSize(60)
+	require.NoError(err, "TrackNodeSize")
+
+	// Nodes that would exceed the byte limit should be refused without being recorded.
+	err = b.TrackNodeSize(60)
+	require.ErrorIs(err, ErrBatchTooLarge, "TrackNodeSize should fail when exceeding the byte limit")
+	var tooLarge *BatchTooLargeError
+	require.ErrorAs(err, &tooLarge, "error should carry the batch size")
+	require.EqualValues(1, tooLarge.Nodes)
+	require.EqualValues(60, tooLarge.Bytes)
+
+	err = b.TrackNodeSize(40)
+	require.NoError(err, "TrackNodeSize should allow reaching the byte limit")
+	err = b.TrackNodeSize(0)
+	require.ErrorIs(err, ErrBatchTooLarge, "TrackNodeSize should fail when exceeding the node limit")
+
+	// Resetting the batch should reset the size.
+	b.Reset()
+	err = b.TrackNodeSize(60)
+	require.NoError(err, "TrackNodeSize after Reset")
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cache/lru"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// maxCachedRoots is the maximum number of roots remembered as existing by the caching node
+// database before the set is reset.
+const maxCachedRoots = 1024
+
+// cachedNode is a node cached by the caching node database.
+type cachedNode struct {
+	node node.Node
+
+	// root is the root with the latest version under which the node has been accessed.
+	root atomic.Pointer[node.Root]
+}
+
+// Size implements lru.Sizeable.
+func (cn *cachedNode) Size() uint64 {
+	return cn.node.Size()
+}
+
+func (cn *cachedNode) touch(root node.Root) {
+	for {
+		current := cn.root.Load()
+		if root.Version < current.Version || current.Equal(&root) {
+			return
+		}
+		updated := root
+		if cn.root.CompareAndSwap(current, &updated) {
+			return
+		}
+	}
+}
+
+// cachingNodeDB is a node database wrapper that caches resolved nodes.
+type cachingNodeDB struct {
+	NodeDB
+
+	cache *lru.Cache
+
+	// rootsLock protects roots and rootsGeneration.
+	rootsLock sync.Mutex
+	// roots is the set of roots known to exist in the inner node database.
+	roots map[node.Root]struct{}
+	// rootsGeneration is incremented each time roots may have been removed from the inner node
+	// database.
+	rootsGeneration uint64
+}
+
+// NewCachingNodeDB creates a node database wrapper that caches nodes resolved from or committed
+// to the inner node database by their hash in an LRU cache holding up to maxBytes worth of nodes.
+//
+// Cached nodes are only served for roots that exist in the inner node database. Cached nodes
+// which were last accessed under a pruned version or under a root discarded during finalization
+// are evicted. In case maxBytes is not positive, the inner node database is returned unchanged.
+func NewCachingNodeDB(inner NodeDB, maxBytes int64) NodeDB {
+	if maxBytes <= 0 {
+		return inner
+	}
+
+	return &cachingNodeDB{
+		NodeDB: inner,
+		cache:  lru.New(lru.Capacity(uint64(maxBytes), true)),
+		roots:  make(map[node.Root]struct{}),
+	}
+}
+
+func (d *cachingNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, error) {
+	// Let the inner database handle invalid pointers.
+	if ptr == nil || !ptr.IsClean() {
+		return d.NodeDB.GetNode(root, ptr)
+	}
+
+	// Nodes are cached by hash only, so make sure that the inner database would not refuse the
+	// lookup due to the root being invalid, unknown or pruned.
+	if v, ok := d.cache.Get(ptr.Hash); ok && d.hasRoot(root) {
+		cn := v.(*cachedNode)
+		cn.touch(root)
+		return copyNode(cn.node), nil
+	}
+
+	n, err := d.NodeDB.GetNode(root, ptr)
+	if err != nil {
+		return nil, err
+	}
+	d.put(ptr.Hash, copyNode(n), root)
+	return n, nil
+}
+
+func (d *cachingNodeDB) HasNode(root node.Root, ptr *node.Pointer) (bool, error) {
+	if ptr != nil && ptr.IsClean() {
+		if _, ok := d.cache.Peek(ptr.Hash); ok && d.hasRoot(root) {
+			return true, nil
+		}
+	}
+	return d.NodeDB.HasNode(root, ptr)
+}
+
+func (d *cachingNodeDB) AbortMultipartInsert() error {
+	inProgress, version, err := d.NodeDB.MultipartState()
+	if err != nil {
+		return err
+	}
+	if err = d.NodeDB.AbortMultipartInsert(); err != nil {
+		return err
+	}
+	d.resetRoots()
+	if inProgress {
+		d.evictDiscarded([]uint64{version})
+	}
+	return nil
+}
+
+func (d *cachingNodeDB) Finalize(roots []node.Root) error {
+	if err := d.NodeDB.Finalize(roots); err != nil {
+		return err
+	}
+	d.resetRoots()
+	if len(roots) > 0 {
+		d.evictDiscarded([]uint64{roots[0].Version})
+	}
+	return nil
+}
+
+func (d *cachingNodeDB) FinalizeVersions(versionRoots map[uint64][]node.Root) error {
+	if err := d.NodeDB.FinalizeVersions(versionRoots); err != nil {
+		return err
+	}
+	d.resetRoots()
+	d.evictDiscarded(slices.Collect(maps.Keys(versionRoots)))
+	return nil
+}
+
+func (d *cachingNodeDB) Prune(version uint64) error {
+	if err := d.NodeDB.Prune(version); err != nil {
+		return err
+	}
+	d.resetRoots()
+	d.evictPruned(version)
+	return nil
+}
+
+func (d *cachingNodeDB) CommitFinalizeAndPrune(batch Batch, roots []node.Root, pruneVersion uint64) error {
+	// Pass the inner batch through, so the inner database can commit it.
+	batch = UnwrapBatch(batch)
+	cb, isCaching := batch.(*cachingBatch)
+	if isCaching {
+		batch = cb.Batch
+	}
+	if err := d.NodeDB.CommitFinalizeAndPrune(batch, roots, pruneVersion); err != nil {
+		return err
+	}
+	if isCaching {
+		cb.populate(roots[0])
+	}
+	d.resetRoots()
+	d.evictDiscarded([]uint64{roots[0].Version})
+	d.evictPruned(pruneVersion)
+	return nil
+}
+
+func (d *cachingNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (Batch, error) {
+	batch, err := d.NodeDB.NewBatch(oldRoot, version, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachingBatch{
+		Batch: batch,
+		db:    d,
+	}, nil
+}
+
+func (d *cachingNodeDB) put(h hash.Hash, n node.Node, root node.Root) {
+	cn := &cachedNode{node: n}
+	cn.root.Store(&root)
+
+	// Nodes that are too large to be cached are simply not cached.
+	_ = d.cache.Put(h, cn)
+}
+
+// hasRoot returns true iff the given root exists in the inner node database.
+//
+// Existing roots are remembered until roots may have been removed, so that serving a cached node
+// does not need to consult the inner database each time.
+func (d *cachingNodeDB) hasRoot(root node.Root) bool {
+	d.rootsLock.Lock()
+	_, known := d.roots[root]
+	generation := d.rootsGeneration
+	d.rootsLock.Unlock()
+	if known {
+		return true
+	}
+
+	if !d.NodeDB.HasRoot(root) {
+		return false
+	}
+
+	d.rootsLock.Lock()
+	defer d.rootsLock.Unlock()
+
+	// Do not remember the root in case roots may have been removed in the meantime.
+	if d.rootsGeneration == generation {
+		if len(d.roots) >= maxCachedRoots {
+			clear(d.roots)
+		}
+		d.roots[root] = struct{}{}
+	}
+	return true
+}
+
+// resetRoots forgets all roots known to exist, as some of them may have been removed.
+func (d *cachingNodeDB) resetRoots() {
+	d.rootsLock.Lock()
+	defer d.rootsLock.Unlock()
+
+	d.rootsGeneration++
+	clear(d.roots)
+}
+
+// evictPruned evicts all cached nodes that were last accessed at or before the given version.
+func (d *cachingNodeDB) evictPruned(version uint64) {
+	for _, key := range d.cache.Keys() {
+		v, ok := d.cache.Peek(key)
+		if !ok {
+			continue
+		}
+		if v.(*cachedNode).root.Load().Version <= version {
+			d.cache.Remove(key)
+		}
+	}
+}
+
+// evictDiscarded evicts all cached nodes that were last accessed under a root which no longer
+// exists after the given versions have been finalized. This includes roots discarded due to not
+// being finalized and roots automatically pruned as configured by their root policy.
+func (d *cachingNodeDB) evictDiscarded(versions []uint64) {
+	affected := make(map[uint64]bool)
+	autoPruned := RootTypesWithPolicy(func(p *RootPolicy) bool { return p.AutoPruneAfter > 0 })
+	for _, version := range versions {
+		affected[version] = true
+		for _, rootType := range autoPruned {
+			policy := PolicyForRoot(node.Root{Type: rootType})
+			if version >= policy.AutoPruneAfter {
+				affected[version-policy.AutoPruneAfter] = true
+			}
+		}
+	}
+
+	exists := make(map[node.Root]bool)
+	for _, key := range d.cache.Keys() {
+		v, ok := d.cache.Peek(key)
+		if !ok {
+			continue
+		}
+		root := *v.(*cachedNode).root.Load()
+		if !affected[root.Version] {
+			continue
+		}
+		ok, checked := exists[root]
+		if !checked {
+			ok = d.NodeDB.HasRoot(root)
+			exists[root] = ok
+		}
+		if !ok {
+			d.cache.Remove(key)
+		}
+	}
+}
+
+// cachingBatch is a batch that populates the cache of the caching node database on commit.
+type cachingBatch struct {
+	Batch
+
+	db    *cachingNodeDB
+	nodes []node.Node
+}
+
+func (ba *cachingBatch) PutNode(ptr *node.Pointer) error {
+	if err := ba.Batch.PutNode(ptr); err != nil {
+		return err
+	}
+	ba.nodes = append(ba.nodes, copyNode(ptr.Node))
+	return nil
+}
+
+func (ba *cachingBatch) PutNodeBatch(ptrs []*node.Pointer) error {
+	if err := ba.Batch.PutNodeBatch(ptrs); err != nil {
+		return err
+	}
+	ba.nodes = slices.Grow(ba.nodes, len(ptrs))
+	for _, ptr := range ptrs {
+		ba.nodes = append(ba.nodes, copyNode(ptr.Node))
+	}
+	return nil
+}
+
+func (ba *cachingBatch) Commit(root node.Root) error {
+	if err := ba.Batch.Commit(root); err != nil {
+		return err
+	}
+	ba.populate(root)
+	return nil
+}
+
+func (ba *cachingBatch) CommitExpecting(expectedRoot node.Root) error {
+	if err := ba.Batch.CommitExpecting(expectedRoot); err != nil {
+		return err
+	}
+	ba.populate(expectedRoot)
+	return nil
+}
+
+func (ba *cachingBatch) Reset() {
+	ba.Batch.Reset()
+	ba.nodes = nil
+}
+
+func (ba *cachingBatch) Discard() {
+	ba.Batch.Discard()
+	ba.nodes = nil
+}
+
+func (ba *cachingBatch) populate(root node.Root) {
+	for _, n := range ba.nodes {
+		ba.db.put(n.GetHash(), n, root)
+	}
+	ba.nodes = nil
+}
+
+// copyNode makes a copy of the given node that is safe to share, as nodes returned by GetNode
+// may be modified by the caller.
+func copyNode(n node.Node) node.Node {
+	cp := n.ExtractUnchecked()
+	if in, ok := n.(*node.InternalNode); ok && in.LeafNode != nil && in.LeafNode.Node != nil {
+		cp.(*node.InternalNode).LeafNode.Node = in.LeafNode.Node.ExtractUnchecked()
+	}
+	return cp
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/writelog"
+)
+
+// RootsChain checks whether the newer root is a valid successor of the older root, i.e. that the
+// newer root follows the older root (see node.Root.Follows) and that the newer tree has actually
+// been derived from the older tree, as witnessed by the write log between them.
+//
+// In case the roots are not chained, false is returned together with an error wrapping
+// ErrRootsNotChained that describes the reason. Since the check relies on write logs, roots for
+// which the write logs have been discarded or pruned are reported as not chained.
+func RootsChain(ctx context.Context, ndb NodeDB, older, newer node.Root) (bool, error) {
+	if !newer.Follows(&older) {
+		return false, fmt.Errorf("%w: root %s does not follow root %s", ErrRootsNotChained, newer, older)
+	}
+	for _, root := range []node.Root{older, newer} {
+		if !ndb.HasRoot(root) {
+			return false, fmt.Errorf("%w: %s", ErrRootNotFound, root)
+		}
+	}
+	if older.Hash.Equal(&newer.Hash) {
+		// Nothing has changed between the roots.
+		return true, nil
+	}
+
+	// Only the existence of the write log matters, so there is no need to consume it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	_, err := ndb.GetWriteLog(ctx, older, newer)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrWriteLogNotFound):
+		return false, fmt.Errorf("%w: no write log from root %s to root %s", ErrRootsNotChained, older, newer)
+	default:
+		return false, err
+	}
+}
+
+// GetWriteLogReverse returns the write logs between the start and the end root, walking versions
+// backward. The write log ending at the end root is returned first, followed by the write logs
+// of the preceding versions down to the one starting at the start root. Entries within the write
+// log of a single version are returned in the same order as by GetWriteLog, so each entry holds
+// the value of the key after the changes of that version.
+//
+// Different from GetWriteLog, the roots may be any number of versions apart. The intermediate
+// roots are the roots of the same type connected by write logs (see RootsChain). In case no such
+// chain connects the roots, ErrWriteLogNotFound is returned.
+func GetWriteLogReverse(ctx context.Context, ndb NodeDB, startRoot, endRoot node.Root) (writelog.Iterator, error) {
+	if startRoot.Type != endRoot.Type || !startRoot.Namespace.Equal(&endRoot.Namespace) || endRoot.Version < startRoot.Version {
+		return nil, ErrRootMustFollowOld
+	}
+	if startRoot.Equal(&endRoot) {
+		return writelog.NewStaticIterator(nil), nil
+	}
+
+	chain, err := findRootsChain(ctx, ndb, startRoot, endRoot)
+	if err != nil {
+		return nil, err
+	}
+	return &reverseWriteLogIterator{
+		ctx:   ctx,
+		ndb:   ndb,
+		chain: chain,
+	}, nil
+}
+
+// findRootsChain returns the roots from the start root to the end root where each root is
+// chained to the previous one.
+func findRootsChain(ctx context.Context, ndb NodeDB, startRoot, endRoot node.Root) ([]node.Root, error) {
+	candidates := []node.Root{startRoot}
+	if endRoot.Version > startRoot.Version {
+		var err error
+		if candidates, err = GetRootsForVersionByType(ndb, endRoot.Version-1, endRoot.Type); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, root := range candidates {
+		chained, err := RootsChain(ctx, ndb, root, endRoot)
+		switch {
+		case chained:
+		case errors.Is(err, ErrRootsNotChained):
+			continue
+		default:
+			return nil, err
+		}
+
+		if root.Equal(&startRoot) {
+			return []node.Root{startRoot, endRoot}, nil
+		}
+		chain, err := findRootsChain(ctx, ndb, startRoot, root)
+		switch {
+		case err == nil:
+			return append(chain, endRoot), nil
+		case errors.Is(err, ErrWriteLogNotFound):
+			continue
+		default:
+			return nil, err
+		}
+	}
+	return nil, ErrWriteLogNotFound
+}
+
+// reverseWriteLogIterator iterates over the write logs between consecutive roots of a chain,
+// starting with the newest one.
+type reverseWriteLogIterator struct {
+	ctx context.Context
+	ndb NodeDB
+
+	// chain are the roots whose write logs have not yet been iterated over.
+	chain []node.Root
+	// current is the iterator over the write log currently being iterated over.
+	current writelog.Iterator
+}
+
+func (it *reverseWriteLogIterator) Next() (bool, error) {
+	for {
+		if it.current != nil {
+			more, err := it.current.Next()
+			if err != nil || more {
+				return more, err
+			}
+			it.current = nil
+		}
+		if len(it.chain) < 2 {
+			return false, nil
+		}
+
+		older, newer := it.chain[len(it.chain)-2], it.chain[len(it.chain)-1]
+		it.chain = it.chain[:len(it.chain)-1]
+		if older.Hash.Equal(&newer.Hash) {
+			// Nothing has changed between the roots.
+			continue
+		}
+
+		wl, err := it.ndb.GetWriteLog(it.ctx, older, newer)
+		if err != nil {
+			return false, err
+		}
+		it.current = wl
+	}
+}
+
+func (it *reverseWriteLogIterator) Value() (writelog.LogEntry, error) {
+	if it.current == nil {
+		return writelog.LogEntry{}, writelog.ErrIteratorInvalid
+	}
+	return it.current.Value()
+}
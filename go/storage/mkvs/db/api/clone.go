@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// Clone copies all finalized roots of the source node database from the given version up to its
+// latest version into a fresh node database created by the given factory, dropping any earlier
+// history. This can be used to make a compacted copy of a node database.
+//
+// The first version is copied node by node, while each later root is derived from the root of
+// the same type in the previous version so that only changed nodes are copied and any replaced
+// nodes are removed, as in a regular commit. Write logs are not copied. The hashes of all copied
+// nodes and the resulting roots are verified against the source. The destination database is
+// closed before returning.
+func Clone(ctx context.Context, src NodeDB, factory Factory, dstCfg *Config, fromVersion uint64) error {
+	latestVersion, exists := src.GetLatestVersion()
+	if !exists {
+		return fmt.Errorf("mkvs: source database has no finalized versions")
+	}
+	if fromVersion < src.GetEarliestVersion() || fromVersion > latestVersion {
+		return fmt.Errorf("%w: version %d is not available in the source database", ErrVersionNotFound, fromVersion)
+	}
+
+	dst, err := factory.New(dstCfg)
+	if err != nil {
+		return fmt.Errorf("mkvs: failed to create destination database: %w", err)
+	}
+	defer dst.Close()
+
+	if _, exists = dst.GetLatestVersion(); exists {
+		return fmt.Errorf("mkvs: destination database is not empty")
+	}
+
+	for version := fromVersion; version <= latestVersion; version++ {
+		roots, err := src.GetRootsForVersion(version)
+		if err != nil {
+			return fmt.Errorf("mkvs: failed to get source roots for version %d: %w", version, err)
+		}
+		if len(roots) == 0 {
+			return fmt.Errorf("mkvs: source version %d has no roots", version)
+		}
+
+		for _, root := range roots {
+			if err = cloneRoot(ctx, src, dst, root, fromVersion); err != nil {
+				return fmt.Errorf("mkvs: failed to clone root %s: %w", root, err)
+			}
+		}
+		if err = dst.Finalize(roots); err != nil {
+			return fmt.Errorf("mkvs: failed to finalize version %d: %w", version, err)
+		}
+
+		// Make sure that the destination contains exactly the source roots.
+		dstRoots, err := dst.GetRootsForVersion(version)
+		if err != nil {
+			return fmt.Errorf("mkvs: failed to get destination roots for version %d: %w", version, err)
+		}
+		if len(dstRoots) != len(roots) {
+			return fmt.Errorf("%w: version %d has %d roots instead of %d", ErrRootMismatch, version, len(dstRoots), len(roots))
+		}
+		for _, root := range roots {
+			if !dst.HasRoot(root) {
+				return fmt.Errorf("%w: root %s is missing", ErrRootMismatch, root)
+			}
+		}
+	}
+	return nil
+}
+
+// cloneRoot copies the given root from the source into the destination database. When the
+// destination already contains a root of the same type from the previous version, the new root
+// is derived from it so that only the nodes that changed since then are copied.
+func cloneRoot(ctx context.Context, src, dst NodeDB, root node.Root, fromVersion uint64) error {
+	base := node.EmptyRoot(root.Namespace, root.Version, root.Type)
+	if root.Version > fromVersion {
+		baseRoots, err := dst.GetRootsForVersionByType(root.Version-1, root.Type)
+		if err != nil {
+			return fmt.Errorf("failed to get base roots: %w", err)
+		}
+		if len(baseRoots) > 0 {
+			base = baseRoots[0]
+		}
+	}
+
+	batch, err := dst.NewBatch(base, root.Version, false)
+	if err != nil {
+		return err
+	}
+	defer batch.Reset()
+
+	c := &cloner{
+		src:   src,
+		dst:   dst,
+		root:  root,
+		base:  base,
+		batch: batch,
+	}
+
+	var srcPtr, basePtr, ptr *node.Pointer
+	if !root.IsEmptyTree() {
+		srcPtr = &node.Pointer{Clean: true, Hash: root.Hash}
+	}
+	if !base.IsEmptyTree() {
+		basePtr = &node.Pointer{Clean: true, Hash: base.Hash}
+	}
+	if err = c.cloneSubtree(ctx, srcPtr, basePtr, nil, &ptr); err != nil {
+		return err
+	}
+	if err = batch.RemoveNodes(c.removed); err != nil {
+		return err
+	}
+
+	// The batch verifies that the root computed from the copied nodes matches.
+	return batch.CommitExpecting(root)
+}
+
+// cloner copies a single root from the source database into a batch derived from the base root
+// in the destination database.
+type cloner struct {
+	src   NodeDB
+	dst   NodeDB
+	root  node.Root
+	base  node.Root
+	batch Batch
+
+	// removed are the base nodes that are not part of the copied root.
+	removed []*node.Pointer
+}
+
+// cloneSubtree copies the subtree rooted at the given source pointer into the batch and stores
+// the pointer to the copied subtree into slot. The base pointer is the pointer at the same
+// position in the base tree, subtrees with the same hash are reused from the base tree instead
+// of being copied.
+func (c *cloner) cloneSubtree(
+	ctx context.Context,
+	srcPtr *node.Pointer,
+	basePtr *node.Pointer,
+	parent *node.Pointer,
+	slot **node.Pointer,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if srcPtr == nil {
+		*slot = nil
+		return c.removeSubtree(ctx, basePtr)
+	}
+	if basePtr != nil && basePtr.Hash.Equal(&srcPtr.Hash) {
+		// The slot must be set before visiting as the batch may inspect the parent.
+		*slot = basePtr
+		return c.batch.VisitCleanNode(basePtr, parent)
+	}
+
+	n := srcPtr.Node
+	if n == nil {
+		var err error
+		if n, err = c.src.GetNode(c.root, srcPtr); err != nil {
+			return err
+		}
+	}
+
+	// The replaced base node is removed, its children are either reused or removed below.
+	var baseNode node.Node
+	if basePtr != nil {
+		var err error
+		if baseNode, err = c.getBaseNode(basePtr); err != nil {
+			return err
+		}
+		c.removed = append(c.removed, basePtr)
+	}
+
+	// Only hashes are copied from the source pointers as any database internals are specific to
+	// the source database.
+	ptr := &node.Pointer{Hash: srcPtr.Hash}
+	*slot = ptr
+	switch n := n.(type) {
+	case *node.InternalNode:
+		cn := &node.InternalNode{
+			Label:          n.Label,
+			LabelBitLength: n.LabelBitLength,
+		}
+		ptr.Node = cn
+		if err := c.batch.VisitDirtyNode(ptr, parent); err != nil {
+			return err
+		}
+
+		var baseLeafNode, baseLeft, baseRight *node.Pointer
+		if bn, ok := baseNode.(*node.InternalNode); ok {
+			baseLeafNode, baseLeft, baseRight = bn.LeafNode, bn.Left, bn.Right
+		}
+
+		if err := c.cloneSubtree(ctx, n.LeafNode, baseLeafNode, ptr, &cn.LeafNode); err != nil {
+			return err
+		}
+		if err := c.cloneSubtree(ctx, n.Left, baseLeft, ptr, &cn.Left); err != nil {
+			return err
+		}
+		if err := c.cloneSubtree(ctx, n.Right, baseRight, ptr, &cn.Right); err != nil {
+			return err
+		}
+		cn.UpdateHash()
+	case *node.LeafNode:
+		if err := c.removeChildren(ctx, baseNode); err != nil {
+			return err
+		}
+
+		cn := &node.LeafNode{
+			Key:   n.Key,
+			Value: n.Value,
+		}
+		ptr.Node = cn
+		if err := c.batch.VisitDirtyNode(ptr, parent); err != nil {
+			return err
+		}
+		cn.UpdateHash()
+	default:
+		return fmt.Errorf("unsupported node kind '%T'", n)
+	}
+
+	if h := ptr.Node.GetHash(); !h.Equal(&srcPtr.Hash) {
+		return fmt.Errorf("node hash mismatch (expected: %s got: %s)", srcPtr.Hash, h)
+	}
+	if err := c.batch.PutNode(ptr); err != nil {
+		return err
+	}
+
+	// Subtrees are no longer needed once their parent is stored.
+	if cn, ok := ptr.Node.(*node.InternalNode); ok {
+		for _, child := range []*node.Pointer{cn.Left, cn.Right} {
+			if child != nil {
+				child.Node = nil
+			}
+		}
+	}
+	return nil
+}
+
+// removeSubtree marks all nodes of the base subtree rooted at the given pointer as removed.
+func (c *cloner) removeSubtree(ctx context.Context, basePtr *node.Pointer) error {
+	if basePtr == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	n, err := c.getBaseNode(basePtr)
+	if err != nil {
+		return err
+	}
+	c.removed = append(c.removed, basePtr)
+
+	return c.removeChildren(ctx, n)
+}
+
+// getBaseNode returns the base node referenced by the given pointer.
+func (c *cloner) getBaseNode(basePtr *node.Pointer) (node.Node, error) {
+	// Some pointers (e.g., leaf nodes embedded in internal nodes) are already resolved.
+	if basePtr.Node != nil {
+		return basePtr.Node, nil
+	}
+	return c.dst.GetNode(c.base, basePtr)
+}
+
+// removeChildren marks all nodes below the given base node as removed.
+func (c *cloner) removeChildren(ctx context.Context, n node.Node) error {
+	in, ok := n.(*node.InternalNode)
+	if !ok {
+		return nil
+	}
+	for _, child := range []*node.Pointer{in.LeafNode, in.Left, in.Right} {
+		if err := c.removeSubtree(ctx, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
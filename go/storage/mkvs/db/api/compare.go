@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// EqualAtVersion compares the roots stored under the given version in two node databases.
+//
+// The databases are equal when they have the same set of roots and the subtrees of all roots are
+// identical. Roots with matching hashes are considered identical without being traversed. In case
+// the databases differ, the returned roots describe the differences. For each type that has a
+// single mismatching root in both databases, both trees are traversed and the subtree roots of the
+// first differing nodes in a and b are returned. Any other root only present in one of the
+// databases is returned as is.
+//
+// This is meant as a test and diagnostic helper.
+func EqualAtVersion(a, b NodeDB, version uint64) (bool, []node.Root, error) {
+	ctx := context.Background()
+
+	rootsA, err := a.GetRootsForVersion(version)
+	if err != nil {
+		return false, nil, fmt.Errorf("mkvs: failed to get roots for version %d: %w", version, err)
+	}
+	rootsB, err := b.GetRootsForVersion(version)
+	if err != nil {
+		return false, nil, fmt.Errorf("mkvs: failed to get roots for version %d: %w", version, err)
+	}
+
+	// Fast path: roots with the same hash are identical.
+	inA := make(map[TypedHash]bool, len(rootsA))
+	for _, root := range rootsA {
+		inA[TypedHashFromRoot(root)] = true
+	}
+	inB := make(map[TypedHash]bool, len(rootsB))
+	for _, root := range rootsB {
+		inB[TypedHashFromRoot(root)] = true
+	}
+
+	var (
+		types      []node.RootType
+		onlyA      = make(map[node.RootType][]node.Root)
+		onlyB      = make(map[node.RootType][]node.Root)
+		seenTypes  = make(map[node.RootType]bool)
+		addMissing = func(missing map[node.RootType][]node.Root, root node.Root) {
+			if !seenTypes[root.Type] {
+				seenTypes[root.Type] = true
+				types = append(types, root.Type)
+			}
+			missing[root.Type] = append(missing[root.Type], root)
+		}
+	)
+	for _, root := range rootsA {
+		if !inB[TypedHashFromRoot(root)] {
+			addMissing(onlyA, root)
+		}
+	}
+	for _, root := range rootsB {
+		if !inA[TypedHashFromRoot(root)] {
+			addMissing(onlyB, root)
+		}
+	}
+	if len(types) == 0 {
+		return true, nil, nil
+	}
+
+	var diffs []node.Root
+	for _, rootType := range types {
+		if len(onlyA[rootType]) != 1 || len(onlyB[rootType]) != 1 {
+			// Roots cannot be paired, so report them as is.
+			diffs = append(diffs, onlyA[rootType]...)
+			diffs = append(diffs, onlyB[rootType]...)
+			continue
+		}
+
+		rootA, rootB := onlyA[rootType][0], onlyB[rootType][0]
+		diffA, diffB, err := firstDifference(ctx, a, b, rootA, rootB, rootPointer(rootA), rootPointer(rootB))
+		if err != nil {
+			return false, nil, err
+		}
+		diffs = append(diffs, diffA, diffB)
+	}
+	return false, diffs, nil
+}
+
+func rootPointer(root node.Root) *node.Pointer {
+	if root.IsEmptyTree() {
+		return nil
+	}
+	return &node.Pointer{
+		Clean: true,
+		Hash:  root.Hash,
+	}
+}
+
+// firstDifference traverses two subtrees with different hashes in parallel and returns the
+// subtree roots of the first differing nodes.
+func firstDifference(
+	ctx context.Context,
+	a, b NodeDB,
+	rootA, rootB node.Root,
+	ptrA, ptrB *node.Pointer,
+) (node.Root, node.Root, error) {
+	select {
+	case <-ctx.Done():
+		return node.Root{}, node.Root{}, ctx.Err()
+	default:
+	}
+
+	subtreeA, subtreeB := rootA, rootB
+	subtreeA.Hash = ptrA.GetHash()
+	subtreeB.Hash = ptrB.GetHash()
+
+	// In case one of the subtrees is empty, there is nothing more to compare.
+	if subtreeA.IsEmptyTree() || subtreeB.IsEmptyTree() {
+		return subtreeA, subtreeB, nil
+	}
+
+	nodeA, err := resolveNode(a, rootA, ptrA)
+	if err != nil {
+		return node.Root{}, node.Root{}, err
+	}
+	nodeB, err := resolveNode(b, rootB, ptrB)
+	if err != nil {
+		return node.Root{}, node.Root{}, err
+	}
+
+	intA, okA := nodeA.(*node.InternalNode)
+	intB, okB := nodeB.(*node.InternalNode)
+	if !okA || !okB {
+		// Differing leaves or different node kinds.
+		return subtreeA, subtreeB, nil
+	}
+
+	for _, children := range [][2]*node.Pointer{
+		{intA.LeafNode, intB.LeafNode},
+		{intA.Left, intB.Left},
+		{intA.Right, intB.Right},
+	} {
+		hashA, hashB := children[0].GetHash(), children[1].GetHash()
+		if hashA.Equal(&hashB) {
+			continue
+		}
+		return firstDifference(ctx, a, b, rootA, rootB, children[0], children[1])
+	}
+
+	// All children are the same, so the internal nodes themselves differ (e.g., in their label).
+	return subtreeA, subtreeB, nil
+}
+
+func resolveNode(ndb NodeDB, root node.Root, ptr *node.Pointer) (node.Node, error) {
+	if ptr.Node != nil {
+		return ptr.Node, nil
+	}
+	n, err := ndb.GetNode(root, ptr)
+	if err != nil {
+		return nil, fmt.Errorf("mkvs: failed to get node %s: %w", ptr.Hash, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// NodeGetter is a function that fetches the node referenced by the given pointer, returning
+// ErrNodeNotFound in case the node is not available.
+type NodeGetter func(ptr *node.Pointer) (node.Node, error)
+
+// CheckComplete traverses the tree with the given root using the given node getter and returns
+// the hashes of all referenced nodes that are not available. It can be used by node database
+// implementations to implement NodeDB.CheckComplete.
+//
+// Subtrees of missing nodes are not traversed and an empty root is always complete.
+func CheckComplete(ctx context.Context, root node.Root, getNode NodeGetter) ([]hash.Hash, error) {
+	if root.IsEmptyTree() {
+		return nil, nil
+	}
+
+	var missing []hash.Hash
+	visited := make(map[hash.Hash]struct{})
+	stack := []*node.Pointer{{Clean: true, Hash: root.Hash}}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ptr := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[ptr.Hash]; ok {
+			continue
+		}
+		visited[ptr.Hash] = struct{}{}
+
+		n, err := getNode(ptr)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrNodeNotFound):
+			missing = append(missing, ptr.Hash)
+			continue
+		default:
+			return nil, err
+		}
+
+		// Leaf nodes of internal nodes are stored together with them, so only the children need
+		// to be checked.
+		if in, ok := n.(*node.InternalNode); ok {
+			for _, child := range []*node.Pointer{in.Right, in.Left} {
+				if child != nil && !node.IsEmptyTreeHash(child.Hash) {
+					stack = append(stack, child)
+				}
+			}
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// DuplicateKeysFalsePositiveRate is the upper bound on the probability that a key is falsely
+// reported as a duplicate when duplicate key detection uses a bloom filter.
+const DuplicateKeysFalsePositiveRate = 1e-9
+
+// duplicateKeysBloomFilterInitialCapacity is the number of keys the first bloom filter layer is
+// sized for. Each additional layer is sized for twice as many keys as the previous one.
+const duplicateKeysBloomFilterInitialCapacity = 1 << 16
+
+// DuplicateKeyError is the error returned during a multipart restore in case a leaf key has
+// already been imported at the same version. It matches ErrDuplicateKey.
+type DuplicateKeyError struct {
+	// Key is the duplicate key.
+	Key node.Key
+}
+
+// Error implements the error interface.
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("%s (key: %X)", ErrDuplicateKey, e.Key)
+}
+
+// Unwrap returns ErrDuplicateKey.
+func (e *DuplicateKeyError) Unwrap() error {
+	return ErrDuplicateKey
+}
+
+// DuplicateKeyDetector tracks the leaf keys imported during a multipart restore in order to
+// detect duplicate keys as configured via Config.DetectDuplicateKeys. It can be used by node
+// database implementations to implement duplicate key detection.
+type DuplicateKeyDetector struct {
+	mu sync.Mutex
+
+	enabled bool
+	bloom   bool
+
+	active bool
+	exact  map[string]struct{}
+	filter *scalableBloomFilter
+}
+
+// Configure configures duplicate key detection. In case bloom is true, a bloom filter is used
+// instead of an exact set of keys.
+func (d *DuplicateKeyDetector) Configure(enabled, bloom bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.enabled = enabled
+	d.bloom = bloom
+}
+
+// Start starts tracking keys at the start of a multipart restore, forgetting any previously
+// tracked keys.
+func (d *DuplicateKeyDetector) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.reset()
+	if !d.enabled {
+		return
+	}
+	d.active = true
+	if d.bloom {
+		d.filter = newScalableBloomFilter(DuplicateKeysFalsePositiveRate)
+	} else {
+		d.exact = make(map[string]struct{})
+	}
+}
+
+// Finish stops tracking keys at the end of a multipart restore, either when it completes or when
+// it is aborted.
+func (d *DuplicateKeyDetector) Finish() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.reset()
+}
+
+func (d *DuplicateKeyDetector) reset() {
+	d.active = false
+	d.exact = nil
+	d.filter = nil
+}
+
+// NewBatch creates a new tracker for the keys put into a batch. In case no keys are being tracked,
+// nil is returned, which is a valid tracker that does not track anything.
+func (d *DuplicateKeyDetector) NewBatch() *DuplicateKeyBatch {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.active {
+		return nil
+	}
+	return &DuplicateKeyBatch{
+		detector: d,
+		keys:     make(map[string]struct{}),
+	}
+}
+
+func (d *DuplicateKeyDetector) containsLocked(key []byte) bool {
+	switch {
+	case d.exact != nil:
+		_, ok := d.exact[string(key)]
+		return ok
+	case d.filter != nil:
+		return d.filter.contains(key)
+	default:
+		return false
+	}
+}
+
+func (d *DuplicateKeyDetector) addLocked(key []byte) {
+	switch {
+	case d.exact != nil:
+		d.exact[string(key)] = struct{}{}
+	case d.filter != nil:
+		d.filter.add(key)
+	}
+}
+
+// DuplicateKeyBatch tracks the leaf keys put into a single batch during a multipart restore.
+//
+// Keys are only recorded by the detector once the batch is committed, so that keys of batches
+// which are reset (e.g., in order to retry a chunk) are not reported as duplicates.
+type DuplicateKeyBatch struct {
+	detector *DuplicateKeyDetector
+	keys     map[string]struct{}
+}
+
+// PutNode checks whether the given node is a leaf node whose key has already been put into the
+// batch or committed by another batch and returns a DuplicateKeyError in that case.
+func (b *DuplicateKeyBatch) PutNode(n node.Node) error {
+	if b == nil {
+		return nil
+	}
+	leaf, ok := n.(*node.LeafNode)
+	if !ok {
+		return nil
+	}
+
+	if _, ok = b.keys[string(leaf.Key)]; ok {
+		return &DuplicateKeyError{Key: leaf.Key}
+	}
+
+	b.detector.mu.Lock()
+	defer b.detector.mu.Unlock()
+
+	if b.detector.containsLocked(leaf.Key) {
+		return &DuplicateKeyError{Key: leaf.Key}
+	}
+	b.keys[string(leaf.Key)] = struct{}{}
+	return nil
+}
+
+// Check checks whether any of the keys put into the batch has been committed by another batch in
+// the meantime and returns a DuplicateKeyError in that case.
+//
+// Implementations should call this before committing the batch while holding a lock that
+// serializes commits, followed by Commit once the batch has been committed.
+func (b *DuplicateKeyBatch) Check() error {
+	if b == nil {
+		return nil
+	}
+
+	b.detector.mu.Lock()
+	defer b.detector.mu.Unlock()
+
+	for key := range b.keys {
+		if b.detector.containsLocked([]byte(key)) {
+			return &DuplicateKeyError{Key: node.Key(key)}
+		}
+	}
+	return nil
+}
+
+// Commit records the keys put into the batch with the detector.
+func (b *DuplicateKeyBatch) Commit() {
+	if b == nil {
+		return
+	}
+
+	b.detector.mu.Lock()
+	defer b.detector.mu.Unlock()
+
+	for key := range b.keys {
+		b.detector.addLocked([]byte(key))
+	}
+	b.keys = make(map[string]struct{})
+}
+
+// Reset forgets the keys put into the batch.
+func (b *DuplicateKeyBatch) Reset() {
+	if b == nil {
+		return
+	}
+	b.keys = make(map[string]struct{})
+}
+
+// scalableBloomFilter is a bloom filter that grows by adding layers as keys are added, so that
+// the number of keys does not need to be known in advance.
+//
+// The false positive rates of the layers form a geometric series, so the overall false positive
+// rate stays below the configured rate regardless of the number of layers.
+type scalableBloomFilter struct {
+	fpRate float64
+	layers []*bloomFilter
+}
+
+func newScalableBloomFilter(fpRate float64) *scalableBloomFilter {
+	return &scalableBloomFilter{fpRate: fpRate}
+}
+
+func (f *scalableBloomFilter) contains(key []byte) bool {
+	h1, h2 := bloomHashes(key)
+	for _, layer := range f.layers {
+		if layer.contains(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *scalableBloomFilter) add(key []byte) {
+	if len(f.layers) == 0 || f.layers[len(f.layers)-1].full() {
+		n := len(f.layers)
+		capacity := uint64(duplicateKeysBloomFilterInitialCapacity) << n
+		// Layer i gets a false positive rate of fpRate / 2^(i+1).
+		f.layers = append(f.layers, newBloomFilter(capacity, math.Ldexp(f.fpRate, -(n+1))))
+	}
+
+	h1, h2 := bloomHashes(key)
+	f.layers[len(f.layers)-1].add(h1, h2)
+}
+
+// bloomHashes returns the two hashes of the given key used to derive bloom filter bit indexes.
+func bloomHashes(key []byte) (uint64, uint64) {
+	h := hash.NewFromBytes(key)
+	return binary.LittleEndian.Uint64(h[0:8]), binary.LittleEndian.Uint64(h[8:16]) | 1
+}
+
+type bloomFilter struct {
+	bits     []uint64
+	numBits  uint64
+	numHash  uint64
+	count    uint64
+	capacity uint64
+}
+
+func newBloomFilter(capacity uint64, fpRate float64) *bloomFilter {
+	numBits := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	numHash := uint64(math.Ceil(float64(numBits) / float64(capacity) * math.Ln2))
+	return &bloomFilter{
+		bits:     make([]uint64, (numBits+63)/64),
+		numBits:  numBits,
+		numHash:  numHash,
+		capacity: capacity,
+	}
+}
+
+func (f *bloomFilter) full() bool {
+	return f.count >= f.capacity
+}
+
+func (f *bloomFilter) contains(h1, h2 uint64) bool {
+	for i := uint64(0); i < f.numHash; i++ {
+		bit := (h1 + i*h2) % f.numBits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := uint64(0); i < f.numHash; i++ {
+		bit := (h1 + i*h2) % f.numBits
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+func TestDuplicateKeyDetector(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		bloom bool
+	}{
+		{"Exact", false},
+		{"BloomFilter", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require := require.New(t)
+
+			leaf := func(key string) node.Node {
+				return &node.LeafNode{Key: node.Key(key), Value: []byte("value")}
+			}
+
+			var d DuplicateKeyDetector
+			require.Nil(d.NewBatch(), "NewBatch should return nil when not started")
+
+			d.Configure(true, tc.bloom)
+			d.Start()
+
+			// Clean import across multiple batches.
+			for i := 0; i < 2; i++ {
+				b := d.NewBatch()
+				require.NotNil(b, "NewBatch")
+				for j := 0; j < 100; j++ {
+					err := b.PutNode(leaf(fmt.Sprintf("key %d %d", i, j)))
+					require.NoError(err, "PutNode")
+				}
+				require.NoError(b.PutNode(&node.InternalNode{}), "PutNode(internal)")
+				require.NoError(b.Check(), "Check")
+				b.Commit()
+			}
+
+			// Duplicate within the same batch.
+			b := d.NewBatch()
+			require.NoError(b.PutNode(leaf("foo")), "PutNode")
+			err := b.PutNode(leaf("foo"))
+			require.ErrorIs(err, ErrDuplicateKey, "PutNode should fail for duplicate key")
+			var dupErr *DuplicateKeyError
+			require.ErrorAs(err, &dupErr)
+			require.EqualValues("foo", dupErr.Key)
+
+			// Reset batches (e.g., retried chunks) should not report duplicates.
+			b.Reset()
+			require.NoError(b.PutNode(leaf("foo")), "PutNode after Reset")
+
+			// Duplicate of a key committed by an earlier batch.
+			err = b.PutNode(leaf("key 1 42"))
+			require.ErrorIs(err, ErrDuplicateKey, "PutNode should fail for committed key")
+
+			// Duplicate committed by a concurrent batch.
+			other := d.NewBatch()
+			require.NoError(other.PutNode(leaf("foo")), "PutNode")
+			require.NoError(other.Check(), "Check")
+			other.Commit()
+			err = b.Check()
+			require.ErrorIs(err, ErrDuplicateKey, "Check should fail for concurrently committed key")
+
+			// Keys should be forgotten once the restore finishes.
+			d.Finish()
+			require.Nil(d.NewBatch(), "NewBatch should return nil after Finish")
+			d.Start()
+			b = d.NewBatch()
+			require.NoError(b.PutNode(leaf("foo")), "PutNode after restart")
+			d.Finish()
+		})
+	}
+}
+
+func TestDuplicateKeyDetectorDisabled(t *testing.T) {
+	require := require.New(t)
+
+	var d DuplicateKeyDetector
+	d.Start()
+	b := d.NewBatch()
+	require.Nil(b, "NewBatch should return nil when disabled")
+
+	// A nil batch should not track anything.
+	leaf := &node.LeafNode{Key: node.Key("foo")}
+	require.NoError(b.PutNode(leaf))
+	require.NoError(b.PutNode(leaf))
+	require.NoError(b.Check())
+	b.Commit()
+	b.Reset()
+}
+
+func TestScalableBloomFilter(t *testing.T) {
+	require := require.New(t)
+
+	f := newScalableBloomFilter(DuplicateKeysFalsePositiveRate)
+	n := duplicateKeysBloomFilterInitialCapacity + 1000
+	for i := 0; i < n; i++ {
+		f.add([]byte(fmt.Sprintf("key %d", i)))
+	}
+	require.Len(f.layers, 2, "filter should grow once the first layer is full")
+
+	for i := 0; i < n; i++ {
+		require.True(f.contains([]byte(fmt.Sprintf("key %d", i))), "added keys should be found")
+	}
+	var falsePositives int
+	for i := 0; i < n; i++ {
+		if f.contains([]byte(fmt.Sprintf("other %d", i))) {
+			falsePositives++
+		}
+	}
+	require.Zero(falsePositives, "there should be no false positives")
+}
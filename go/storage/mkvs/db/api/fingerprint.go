@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"slices"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// Fingerprint computes a hash summarizing all versions and their roots in the given node
+// database, including versions and roots that have not yet been finalized.
+//
+// Databases containing the same roots under the same versions produce identical fingerprints
+// regardless of the order in which the roots are returned by the database.
+func Fingerprint(ctx context.Context, ndb NodeDB) (hash.Hash, error) {
+	var versions []uint64
+	latest, ok := ndb.GetLatestVersion()
+	if ok {
+		for version := ndb.GetEarliestVersion(); version <= latest; version++ {
+			versions = append(versions, version)
+		}
+	}
+	pending, err := ndb.GetPendingVersions()
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	for _, version := range pending {
+		if !ok || version > latest {
+			versions = append(versions, version)
+		}
+	}
+
+	b := hash.NewBuilder()
+	for _, version := range versions {
+		if err = ctx.Err(); err != nil {
+			return hash.Hash{}, err
+		}
+
+		roots, err := ndb.GetRootsForVersion(version)
+		if err != nil {
+			return hash.Hash{}, err
+		}
+		if len(roots) == 0 {
+			continue
+		}
+		slices.SortFunc(roots, func(a, b node.Root) int {
+			if c := cmp.Compare(a.Type, b.Type); c != 0 {
+				return c
+			}
+			return bytes.Compare(a.Hash[:], b.Hash[:])
+		})
+
+		var hdr [16]byte
+		binary.BigEndian.PutUint64(hdr[:8], version)
+		binary.BigEndian.PutUint64(hdr[8:], uint64(len(roots)))
+		_, _ = b.Write(hdr[:])
+		for _, root := range roots {
+			h := root.EncodedHash()
+			_, _ = b.Write(h[:])
+		}
+	}
+
+	return b.Build(), nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
@@ -98,6 +99,45 @@ func ReviveHashedDBWriteLogs(
 	return &pipe, nil
 }
 
+// prefixIterator is a write log iterator that only yields entries whose key has a given prefix.
+type prefixIterator struct {
+	it     writelog.Iterator
+	prefix node.Key
+}
+
+func (i *prefixIterator) Next() (bool, error) {
+	for {
+		more, err := i.it.Next()
+		if err != nil || !more {
+			return more, err
+		}
+		entry, err := i.it.Value()
+		if err != nil {
+			return false, err
+		}
+		if bytes.HasPrefix(entry.Key, i.prefix) {
+			return true, nil
+		}
+	}
+}
+
+func (i *prefixIterator) Value() (writelog.LogEntry, error) {
+	return i.it.Value()
+}
+
+// NewPrefixWriteLogIterator wraps the given write log iterator so that it only yields entries
+// whose key has the given prefix. It can be used by node database implementations which cannot
+// filter write logs by prefix more efficiently.
+func NewPrefixWriteLogIterator(it writelog.Iterator, prefix node.Key) writelog.Iterator {
+	if len(prefix) == 0 {
+		return it
+	}
+	return &prefixIterator{
+		it:     it,
+		prefix: prefix,
+	}
+}
+
 // NodeVisitor is a function that visits a given node and returns true to continue
 // traversal of child nodes or false to stop.
 type NodeVisitor func(context.Context, node.Node) bool
@@ -163,3 +203,156 @@ func doVisit(ctx context.Context, ndb NodeDB, root node.Root, visitor NodeVisito
 
 	return nil
 }
+
+// PrefetchKeys resolves all nodes on the lookup paths of the given keys in the tree with the
+// given root, which warms any caches of the node database. Nodes shared between paths are only
+// resolved once.
+//
+// Nothing is resolved in case the root is empty.
+func PrefetchKeys(ctx context.Context, ndb NodeDB, root node.Root, keys []node.Key) error {
+	if root.IsEmptyTree() {
+		return nil
+	}
+
+	rootPtr := &node.Pointer{
+		Clean: true,
+		Hash:  root.Hash,
+	}
+	for _, key := range keys {
+		if err := doPrefetchKey(ctx, ndb, root, rootPtr, 0, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func doPrefetchKey(ctx context.Context, ndb NodeDB, root node.Root, ptr *node.Pointer, bitDepth node.Depth, key node.Key) error {
+	for ptr != nil && !node.IsEmptyTreeHash(ptr.Hash) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if ptr.Node == nil {
+			nd, err := ndb.GetNode(root, ptr)
+			if err != nil {
+				return err
+			}
+			ptr.Node = nd
+		}
+
+		n, ok := ptr.Node.(*node.InternalNode)
+		if !ok {
+			// Reached a leaf node.
+			return nil
+		}
+
+		bitLength := bitDepth + n.LabelBitLength
+		switch {
+		case key.BitLength() == bitLength:
+			// Lookup key ends here, continue with the leaf node.
+			ptr = n.LeafNode
+		case key.BitLength() < bitLength:
+			// Lookup key is too short for the current label.
+			return nil
+		case key.GetBit(bitLength):
+			ptr = n.Right
+		default:
+			ptr = n.Left
+		}
+		bitDepth = bitLength
+	}
+	return nil
+}
+
+// ScanPrefix calls fn for each key/value pair stored in the tree with the given root whose key
+// starts with the given prefix, in lexicographic key order. The scan stops early in case fn
+// returns false.
+//
+// Only subtrees whose path is compatible with the prefix are resolved, so the rest of the tree is
+// never loaded from the node database. Resolved nodes are not retained.
+func ScanPrefix(
+	ctx context.Context,
+	ndb NodeDB,
+	root node.Root,
+	prefix node.Key,
+	fn func(key node.Key, value []byte) bool,
+) error {
+	ptr := &node.Pointer{
+		Clean: true,
+		Hash:  root.Hash,
+	}
+	_, err := doScanPrefix(ctx, ndb, root, ptr, 0, nil, prefix, fn)
+	return err
+}
+
+// doScanPrefix scans the subtree rooted at the given pointer and returns false in case the scan
+// has been stopped by fn.
+//
+// The path contains the first bitDepth bits of all keys in the subtree. A nil prefix means that
+// all keys in the subtree are known to match.
+func doScanPrefix(
+	ctx context.Context,
+	ndb NodeDB,
+	root node.Root,
+	ptr *node.Pointer,
+	bitDepth node.Depth,
+	path node.Key,
+	prefix node.Key,
+	fn func(key node.Key, value []byte) bool,
+) (bool, error) {
+	if ptr == nil || node.IsEmptyTreeHash(ptr.Hash) {
+		return true, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	nd := ptr.Node
+	if nd == nil {
+		var err error
+		if nd, err = ndb.GetNode(root, ptr); err != nil {
+			return false, err
+		}
+	}
+
+	switch n := nd.(type) {
+	case *node.InternalNode:
+		bitLength := bitDepth + n.LabelBitLength
+
+		if prefix != nil {
+			// Skip the subtree in case its path diverges from the prefix.
+			path = path.Merge(bitDepth, n.Label, n.LabelBitLength)
+			prefixBitLength := prefix.BitLength()
+			for bit := bitDepth; bit < bitLength && bit < prefixBitLength; bit++ {
+				if path.GetBit(bit) != prefix.GetBit(bit) {
+					return true, nil
+				}
+			}
+
+			if prefixBitLength > bitLength {
+				// The prefix is longer than the path, so the leaf node (whose key is the path) cannot
+				// match and only one of the children may contain matching keys.
+				next := n.Left
+				if prefix.GetBit(bitLength) {
+					next = n.Right
+				}
+				return doScanPrefix(ctx, ndb, root, next, bitLength, path, prefix, fn)
+			}
+		}
+
+		// All keys in the subtree match the prefix.
+		for _, next := range []*node.Pointer{n.LeafNode, n.Left, n.Right} {
+			if cont, err := doScanPrefix(ctx, ndb, root, next, bitLength, path, nil, fn); !cont || err != nil {
+				return cont, err
+			}
+		}
+		return true, nil
+	case *node.LeafNode:
+		if !bytes.HasPrefix(n.Key, prefix) {
+			return true, nil
+		}
+		return fn(n.Key, n.Value), nil
+	default:
+		return true, nil
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/writelog"
+)
+
+func TestPrefixWriteLogIterator(t *testing.T) {
+	wl := writelog.WriteLog{
+		{Key: []byte("acc/a/1"), Value: []byte("1")},
+		{Key: []byte("acc/ab/1"), Value: []byte("2")},
+		{Key: []byte("acc/b/1")},
+		{Key: []byte("other"), Value: []byte("3")},
+	}
+
+	for _, tc := range []struct {
+		prefix   string
+		expected writelog.WriteLog
+	}{
+		{"", wl},
+		{"acc/", wl[:3]},
+		{"acc/a", wl[:2]},
+		{"acc/b", wl[2:3]},
+		{"other", wl[3:]},
+		{"missing", writelog.WriteLog{}},
+	} {
+		it := NewPrefixWriteLogIterator(writelog.NewStaticIterator(wl), node.Key(tc.prefix))
+
+		filtered := writelog.WriteLog{}
+		for {
+			more, err := it.Next()
+			require.NoError(t, err, "Next")
+			if !more {
+				break
+			}
+			entry, err := it.Value()
+			require.NoError(t, err, "Value")
+			filtered = append(filtered, entry)
+		}
+		require.Equal(t, tc.expected, filtered, "write log for prefix %s", tc.prefix)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"slices"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// ManifestMismatch is a difference between the roots listed in a manifest and the roots
+// present in the node database.
+type ManifestMismatch struct {
+	// Version is the version of the mismatched root.
+	Version uint64 `json:"version"`
+	// Expected is the root listed in the manifest or nil in case the root present in the
+	// database is not listed in the manifest.
+	Expected *node.Root `json:"expected,omitempty"`
+	// Actual is the root present in the database or nil in case the root listed in the
+	// manifest is missing from the database.
+	Actual *node.Root `json:"actual,omitempty"`
+}
+
+// VerifyAgainstManifest checks that, for each version in the manifest, the roots present in the
+// node database match the expected roots and returns any mismatches.
+//
+// In case there is both an unexpected and a missing root of the same type in a version, they are
+// reported as a single mismatch.
+func VerifyAgainstManifest(ctx context.Context, ndb NodeDB, manifest map[uint64][]node.Root) ([]ManifestMismatch, error) {
+	versions := make([]uint64, 0, len(manifest))
+	for version := range manifest {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+
+	var mismatches []ManifestMismatch
+	for _, version := range versions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		actualRoots, err := ndb.GetRootsForVersion(version)
+		if err != nil {
+			return nil, err
+		}
+
+		actual := make(map[TypedHash]node.Root)
+		for _, root := range actualRoots {
+			actual[TypedHashFromRoot(root)] = root
+		}
+
+		// Determine expected roots that are missing.
+		var missing []node.Root
+		for _, root := range manifest[version] {
+			th := TypedHashFromRoot(root)
+			if _, ok := actual[th]; ok {
+				delete(actual, th)
+				continue
+			}
+			missing = append(missing, root)
+		}
+
+		// Remaining roots are unexpected.
+		unexpected := make([]node.Root, 0, len(actual))
+		for _, root := range actual {
+			unexpected = append(unexpected, root)
+		}
+		slices.SortFunc(unexpected, func(a, b node.Root) int {
+			tha, thb := TypedHashFromRoot(a), TypedHashFromRoot(b)
+			return slices.Compare(tha[:], thb[:])
+		})
+
+		for _, expected := range missing {
+			m := ManifestMismatch{
+				Version:  version,
+				Expected: &expected,
+			}
+			if idx := slices.IndexFunc(unexpected, func(r node.Root) bool { return r.Type == expected.Type }); idx >= 0 {
+				actualRoot := unexpected[idx]
+				m.Actual = &actualRoot
+				unexpected = slices.Delete(unexpected, idx, idx+1)
+			}
+			mismatches = append(mismatches, m)
+		}
+		for _, root := range unexpected {
+			mismatches = append(mismatches, ManifestMismatch{
+				Version: version,
+				Actual:  &root,
+			})
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/oasisprotocol/oasis-core/go/common"
+)
+
+// NamespaceRewriteFunc rewrites the namespace of an existing database without requiring the
+// configured namespace to match the one stored in the database. It returns false in case the
+// database does not exist or does not use the format of the backend that registered the function.
+// As opening a database may create it, the function must make sure that it exists first.
+type NamespaceRewriteFunc func(cfg *Config, newNs common.Namespace) (bool, error)
+
+var (
+	namespaceRewritersLock sync.RWMutex
+	namespaceRewriters     = make(map[string]NamespaceRewriteFunc)
+)
+
+// RegisterNamespaceRewriter registers a namespace rewriter for the named backend.
+//
+// This method will panic in case a rewriter for the given backend is already registered.
+func RegisterNamespaceRewriter(name string, fn NamespaceRewriteFunc) {
+	namespaceRewritersLock.Lock()
+	defer namespaceRewritersLock.Unlock()
+
+	if _, exists := namespaceRewriters[name]; exists {
+		panic(fmt.Errorf("mkvs: namespace rewriter for backend '%s' already registered", name))
+	}
+	namespaceRewriters[name] = fn
+}
+
+// RewriteNamespace rewrites the namespace stored in the database described by the configuration,
+// regardless of the configured namespace, so that it can afterwards be opened under newNs.
+//
+// Roots are keyed by their type and hash, neither of which depends on the namespace, so besides
+// the database metadata only persisted roots (e.g., write-ahead log records) need to be rewritten.
+// Encoded root hashes are derived from the rewritten roots on demand and thus reflect the new
+// namespace. Rewriting is a no-op in case the namespace already matches. In case the database
+// does not exist or none of the available backends recognizes it, ErrUnknownDatabase is returned.
+func RewriteNamespace(cfg *Config, newNs common.Namespace) error {
+	if cfg.ReadOnly {
+		return ErrReadOnly
+	}
+
+	namespaceRewritersLock.RLock()
+	rewriters := make(map[string]NamespaceRewriteFunc, len(namespaceRewriters))
+	names := make([]string, 0, len(namespaceRewriters))
+	for name, fn := range namespaceRewriters {
+		rewriters[name] = fn
+		names = append(names, name)
+	}
+	namespaceRewritersLock.RUnlock()
+	slices.Sort(names)
+
+	for _, name := range names {
+		ok, err := rewriters[name](cfg, newNs)
+		if err != nil {
+			return fmt.Errorf("mkvs: failed to rewrite namespace (backend: %s): %w", name, err)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrUnknownDatabase
+}
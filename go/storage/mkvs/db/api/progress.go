@@ -0,0 +1,73 @@
+package api
+
+import "sync"
+
+// MultipartProgressInterval is the number of nodes written during a multipart restore between
+// successive progress reports.
+const MultipartProgressInterval = 10_000
+
+// MultipartProgressFunc is the multipart restore progress callback.
+type MultipartProgressFunc func(nodesWritten, bytesWritten uint64)
+
+// MultipartProgressReporter tracks the number of nodes and bytes written during a multipart
+// restore and periodically reports them via Config.MultipartProgress. It can be used by node database
+// implementations to implement progress reporting.
+type MultipartProgressReporter struct {
+	mu sync.Mutex
+
+	fn MultipartProgressFunc
+
+	active       bool
+	nodes        uint64
+	bytes        uint64
+	lastReported uint64
+}
+
+// SetCallback sets the progress callback. A nil callback disables progress reporting.
+func (r *MultipartProgressReporter) SetCallback(fn MultipartProgressFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fn = fn
+}
+
+// Start resets the progress counters at the start of a multipart restore.
+func (r *MultipartProgressReporter) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.active = true
+	r.nodes = 0
+	r.bytes = 0
+	r.lastReported = 0
+}
+
+// NodeWritten records a node of the given serialized size being written and reports progress
+// once every MultipartProgressInterval nodes.
+func (r *MultipartProgressReporter) NodeWritten(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nodes++
+	r.bytes += uint64(size)
+	if r.fn == nil || r.nodes-r.lastReported < MultipartProgressInterval {
+		return
+	}
+	r.lastReported = r.nodes
+	r.fn(r.nodes, r.bytes)
+}
+
+// Finish reports the final progress at the end of a multipart restore, either when it completes
+// or when it is aborted. It is a no-op in case no multipart restore was started.
+func (r *MultipartProgressReporter) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.active {
+		return
+	}
+	r.active = false
+	if r.fn != nil {
+		r.fn(r.nodes, r.bytes)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipartProgressReporter(t *testing.T) {
+	require := require.New(t)
+
+	type report struct {
+		nodes, bytes uint64
+	}
+	var reports []report
+
+	var r MultipartProgressReporter
+	r.SetCallback(func(nodesWritten, bytesWritten uint64) {
+		reports = append(reports, report{nodesWritten, bytesWritten})
+	})
+
+	// Finishing without starting should not report anything.
+	r.Finish()
+	require.Empty(reports)
+
+	r.Start()
+	for i := 0; i < 2*MultipartProgressInterval+5; i++ {
+		r.NodeWritten(2)
+	}
+	require.Equal([]report{
+		{MultipartProgressInterval, 2 * MultipartProgressInterval},
+		{2 * MultipartProgressInterval, 4 * MultipartProgressInterval},
+	}, reports, "progress should be reported periodically")
+
+	r.Finish()
+	require.Len(reports, 3)
+	require.Equal(report{2*MultipartProgressInterval + 5, 4*MultipartProgressInterval + 10}, reports[2], "final progress should be reported")
+
+	r.Finish()
+	require.Len(reports, 3, "progress should only be finished once")
+
+	// Counters should be reset when a new restore is started.
+	r.Start()
+	r.NodeWritten(7)
+	r.Finish()
+	require.Equal(report{1, 7}, reports[3])
+}
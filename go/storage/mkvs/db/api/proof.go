@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/syncer"
+)
+
+// ProofOverheadRatio computes the overhead of a compact proof for all leaves under the given key
+// prefix, relative to the total size of the values stored in those leaves. The overhead is the
+// part of the proof size that is not taken up by the values themselves, so a ratio below one means
+// that the proof is dominated by the values.
+//
+// A high ratio means that serving the subtree via proofs is inefficient compared to a bulk
+// transfer. If there are no values under the prefix, the ratio is zero.
+func ProofOverheadRatio(ctx context.Context, ndb NodeDB, root node.Root, prefix node.Key, prefixBitLen node.Depth) (float64, error) {
+	if root.IsEmptyTree() {
+		return 0, nil
+	}
+
+	pb := syncer.NewProofBuilder(root.Hash, root.Hash)
+	ptr := &node.Pointer{
+		Clean: true,
+		Hash:  root.Hash,
+	}
+	valueSize, err := doProofOverhead(ctx, ndb, root, pb, ptr, 0, node.Key{}, prefix, prefixBitLen)
+	if err != nil {
+		return 0, err
+	}
+	if valueSize == 0 {
+		return 0, nil
+	}
+
+	proof, err := pb.Build(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var proofSize uint64
+	for _, entry := range proof.Entries {
+		proofSize += uint64(len(entry))
+	}
+	// Proof entries include the full leaf values.
+	return float64(proofSize-valueSize) / float64(valueSize), nil
+}
+
+func doProofOverhead(
+	ctx context.Context,
+	ndb NodeDB,
+	root node.Root,
+	pb *syncer.ProofBuilder,
+	ptr *node.Pointer,
+	bitDepth node.Depth,
+	path node.Key,
+	prefix node.Key,
+	prefixBitLen node.Depth,
+) (uint64, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if ptr == nil || node.IsEmptyTreeHash(ptr.Hash) {
+		return 0, nil
+	}
+
+	nd := ptr.Node
+	if nd == nil {
+		var err error
+		if nd, err = ndb.GetNode(root, ptr); err != nil {
+			return 0, err
+		}
+	}
+
+	switch n := nd.(type) {
+	case *node.InternalNode:
+		bitLength := bitDepth + n.LabelBitLength
+		newPath := path.Merge(bitDepth, n.Label, n.LabelBitLength)
+
+		// Skip subtrees that are disjoint with the prefix.
+		if newPath.CommonPrefixLen(bitLength, prefix, prefixBitLen) < min(bitLength, prefixBitLen) {
+			return 0, nil
+		}
+		pb.Include(n)
+
+		var valueSize uint64
+		for _, child := range []*node.Pointer{n.LeafNode, n.Left, n.Right} {
+			size, err := doProofOverhead(ctx, ndb, root, pb, child, bitLength, newPath, prefix, prefixBitLen)
+			if err != nil {
+				return 0, err
+			}
+			valueSize += size
+		}
+		return valueSize, nil
+	case *node.LeafNode:
+		if n.Key.CommonPrefixLen(n.Key.BitLength(), prefix, prefixBitLen) < prefixBitLen {
+			return 0, nil
+		}
+		pb.Include(n)
+		return uint64(len(n.Value)), nil
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// Quiescer tracks in-progress writes so that they can be quiesced. It can be embedded by node
+// database implementations to implement NodeDB.Quiesce.
+type Quiescer struct {
+	mu sync.Mutex
+
+	quiesced bool
+	active   int
+	idleCh   chan struct{}
+}
+
+// EnterWrite marks the start of a write operation. It returns ErrQuiesced in case the database
+// is quiesced. Each successful call must be followed by a call to ExitWrite.
+func (q *Quiescer) EnterWrite() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.quiesced {
+		return ErrQuiesced
+	}
+	q.active++
+	return nil
+}
+
+// ExitWrite marks the end of a write operation.
+func (q *Quiescer) ExitWrite() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.active--
+	if q.active == 0 && q.idleCh != nil {
+		close(q.idleCh)
+		q.idleCh = nil
+	}
+}
+
+// IsQuiesced returns true iff the database is quiesced.
+func (q *Quiescer) IsQuiesced() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.quiesced
+}
+
+// Quiesce rejects any new write operations, waits for in-progress write operations to complete
+// and then calls flush. Write operations are allowed again after the returned resume function is
+// called.
+func (q *Quiescer) Quiesce(ctx context.Context, flush func() error) (func(), error) {
+	q.mu.Lock()
+	if q.quiesced {
+		q.mu.Unlock()
+		return nil, ErrQuiesced
+	}
+	q.quiesced = true
+	var idleCh chan struct{}
+	if q.active > 0 {
+		idleCh = make(chan struct{})
+		q.idleCh = idleCh
+	}
+	q.mu.Unlock()
+
+	if idleCh != nil {
+		select {
+		case <-idleCh:
+		case <-ctx.Done():
+			q.resume()
+			return nil, ctx.Err()
+		}
+	}
+
+	if err := flush(); err != nil {
+		q.resume()
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() { once.Do(q.resume) }, nil
+}
+
+func (q *Quiescer) resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.quiesced = false
+	q.idleCh = nil
+}
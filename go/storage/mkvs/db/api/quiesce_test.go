@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuiescer(t *testing.T) {
+	require := require.New(t)
+
+	var q Quiescer
+	var flushed int
+	flush := func() error {
+		flushed++
+		return nil
+	}
+
+	// Quiescing should wait for in-progress writes.
+	err := q.EnterWrite()
+	require.NoError(err, "EnterWrite")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = q.Quiesce(ctx, flush)
+	require.ErrorIs(err, context.DeadlineExceeded, "Quiesce should time out while a write is in progress")
+	require.False(q.IsQuiesced(), "timed out Quiesce should not leave the database quiesced")
+	require.Equal(0, flushed)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		q.ExitWrite()
+	}()
+	resume, err := q.Quiesce(context.Background(), flush)
+	require.NoError(err, "Quiesce")
+	require.True(q.IsQuiesced())
+	require.Equal(1, flushed)
+
+	// Writes should be rejected while quiesced.
+	err = q.EnterWrite()
+	require.ErrorIs(err, ErrQuiesced, "EnterWrite should fail while quiesced")
+	_, err = q.Quiesce(context.Background(), flush)
+	require.ErrorIs(err, ErrQuiesced, "Quiesce should fail while already quiesced")
+
+	// Writes should be allowed after resume.
+	resume()
+	resume() // Resuming multiple times should be safe.
+	require.False(q.IsQuiesced())
+	err = q.EnterWrite()
+	require.NoError(err, "EnterWrite after resume")
+	q.ExitWrite()
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// SecondaryHash computes the secondary hash of the given node using the given hasher.
+//
+// The secondary hashes of any non-empty children are obtained by calling childHash while the
+// empty children use the empty hash.
+func SecondaryHash(hasher node.Hasher, n node.Node, childHash func(ptr *node.Pointer) (hash.Hash, error)) (hash.Hash, error) {
+	switch n := n.(type) {
+	case *node.LeafNode:
+		return n.HashWith(hasher), nil
+	case *node.InternalNode:
+		var hashes [3]hash.Hash
+		for i, child := range []*node.Pointer{n.LeafNode, n.Left, n.Right} {
+			if child == nil || node.IsEmptyTreeHash(child.Hash) {
+				hashes[i].Empty()
+				continue
+			}
+
+			h, err := childHash(child)
+			if err != nil {
+				return hash.Hash{}, err
+			}
+			hashes[i] = h
+		}
+		return n.HashWith(hasher, hashes[0], hashes[1], hashes[2]), nil
+	default:
+		return hash.Hash{}, fmt.Errorf("mkvs: unsupported node type: %T", n)
+	}
+}
+
+// VerifySecondaryHashes recomputes the secondary hashes of all nodes reachable from the given
+// root using the given hasher and checks that they match the stored secondary hashes as returned
+// by getSecondaryHash, which is given the primary hash of a node.
+func VerifySecondaryHashes(
+	ctx context.Context,
+	ndb NodeDB,
+	root node.Root,
+	hasher node.Hasher,
+	getSecondaryHash func(h hash.Hash) (hash.Hash, error),
+) error {
+	if root.IsEmptyTree() {
+		return nil
+	}
+
+	var verifyErr error
+	err := Visit(ctx, ndb, root, func(_ context.Context, n node.Node) bool {
+		if verifyErr != nil {
+			// Sibling subtrees are still visited after the traversal is stopped.
+			return false
+		}
+
+		expected, err := SecondaryHash(hasher, n, func(ptr *node.Pointer) (hash.Hash, error) {
+			return getSecondaryHash(ptr.Hash)
+		})
+		if err != nil {
+			verifyErr = err
+			return false
+		}
+
+		h := n.GetHash()
+		stored, err := getSecondaryHash(h)
+		if err != nil {
+			verifyErr = err
+			return false
+		}
+		if !stored.Equal(&expected) {
+			verifyErr = fmt.Errorf("%w: node %s", ErrSecondaryHashMismatch, h)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
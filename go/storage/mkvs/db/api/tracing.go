@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// Span attribute keys set on node database spans.
+const (
+	// SpanAttrRootType is the type of the root the operation is performed on.
+	SpanAttrRootType = "mkvs.root_type"
+	// SpanAttrVersion is the version the operation is performed on.
+	SpanAttrVersion = "mkvs.version"
+	// SpanAttrNodeCount is the number of nodes stored by a batch.
+	SpanAttrNodeCount = "mkvs.node_count"
+	// SpanAttrRootCount is the number of roots finalized.
+	SpanAttrRootCount = "mkvs.root_count"
+)
+
+// Span names of node database operations.
+const (
+	SpanGetNode  = "mkvs.GetNode"
+	SpanCommit   = "mkvs.Commit"
+	SpanFinalize = "mkvs.Finalize"
+	SpanPrune    = "mkvs.Prune"
+)
+
+// SpanAttribute is a key-value attribute of a span.
+type SpanAttribute struct {
+	Key   string
+	Value any
+}
+
+// Span is a tracing span around a node database operation.
+type Span interface {
+	// End ends the span, recording the error the operation failed with (if any).
+	End(err error)
+}
+
+// Tracer starts tracing spans around node database operations.
+//
+// This makes it possible to plug in any tracing implementation (e.g., OpenTelemetry) without
+// the node database depending on it.
+type Tracer interface {
+	// StartSpan starts a new span with the given name and attributes as a child of any span in
+	// the given context and returns a context containing the new span.
+	StartSpan(ctx context.Context, name string, attrs ...SpanAttribute) (context.Context, Span)
+}
+
+type tracerContextKey struct{}
+
+// WithTracer returns a context that causes node database operations performed through the
+// context-accepting variants (e.g., GetNodeContext) to be traced by the given tracer.
+func WithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerContextKey{}, tracer)
+}
+
+// TracerFromContext returns the tracer configured in the given context (if any).
+func TracerFromContext(ctx context.Context) (Tracer, bool) {
+	tracer, ok := ctx.Value(tracerContextKey{}).(Tracer)
+	return tracer, ok && tracer != nil
+}
+
+// GetNodeContext is like NodeDB.GetNode but traces the lookup in case a tracer is configured in
+// the given context.
+func GetNodeContext(ctx context.Context, ndb NodeDB, root node.Root, ptr *node.Pointer) (node.Node, error) {
+	tracer, ok := TracerFromContext(ctx)
+	if !ok {
+		return ndb.GetNode(root, ptr)
+	}
+
+	_, span := tracer.StartSpan(ctx, SpanGetNode,
+		SpanAttribute{SpanAttrRootType, root.Type.String()},
+		SpanAttribute{SpanAttrVersion, root.Version},
+	)
+	n, err := ndb.GetNode(root, ptr)
+	span.End(err)
+	return n, err
+}
+
+// NewBatchContext is like NodeDB.NewBatch but the commit of the returned batch is traced in case
+// a tracer is configured in the given context.
+func NewBatchContext(ctx context.Context, ndb NodeDB, oldRoot node.Root, version uint64, chunk bool) (Batch, error) {
+	batch, err := ndb.NewBatch(oldRoot, version, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	tracer, ok := TracerFromContext(ctx)
+	if !ok {
+		return batch, nil
+	}
+	return &tracingBatch{
+		Batch:  batch,
+		ctx:    ctx,
+		tracer: tracer,
+	}, nil
+}
+
+// FinalizeContext is like NodeDB.Finalize but traces the finalization in case a tracer is
+// configured in the given context.
+func FinalizeContext(ctx context.Context, ndb NodeDB, roots []node.Root) error {
+	tracer, ok := TracerFromContext(ctx)
+	if !ok || len(roots) == 0 {
+		return ndb.Finalize(roots)
+	}
+
+	_, span := tracer.StartSpan(ctx, SpanFinalize,
+		SpanAttribute{SpanAttrVersion, roots[0].Version},
+		SpanAttribute{SpanAttrRootCount, len(roots)},
+	)
+	err := ndb.Finalize(roots)
+	span.End(err)
+	return err
+}
+
+// PruneContext is like NodeDB.Prune but traces the pruning in case a tracer is configured in the
+// given context.
+func PruneContext(ctx context.Context, ndb NodeDB, version uint64) error {
+	tracer, ok := TracerFromContext(ctx)
+	if !ok {
+		return ndb.Prune(version)
+	}
+
+	_, span := tracer.StartSpan(ctx, SpanPrune,
+		SpanAttribute{SpanAttrVersion, version},
+	)
+	err := ndb.Prune(version)
+	span.End(err)
+	return err
+}
+
+// UnwrapBatch returns the batch wrapped by any batches that only trace the operations of the
+// wrapped batch (e.g., batches returned by NewBatchContext). Node databases use this in order to
+// accept such batches in CommitFinalizeAndPrune.
+func UnwrapBatch(batch Batch) Batch {
+	for {
+		tb, ok := batch.(*tracingBatch)
+		if !ok {
+			return batch
+		}
+		batch = tb.Batch
+	}
+}
+
+// tracingBatch is a batch that traces its commit.
+type tracingBatch struct {
+	Batch
+
+	ctx    context.Context
+	tracer Tracer
+	nodes  int
+}
+
+func (ba *tracingBatch) PutNode(ptr *node.Pointer) error {
+	if err := ba.Batch.PutNode(ptr); err != nil {
+		return err
+	}
+	ba.nodes++
+	return nil
+}
+
+func (ba *tracingBatch) PutNodeBatch(ptrs []*node.Pointer) error {
+	if err := ba.Batch.PutNodeBatch(ptrs); err != nil {
+		return err
+	}
+	ba.nodes += len(ptrs)
+	return nil
+}
+
+func (ba *tracingBatch) Commit(root node.Root) error {
+	span := ba.startCommitSpan(root)
+	err := ba.Batch.Commit(root)
+	span.End(err)
+	if err == nil {
+		ba.nodes = 0
+	}
+	return err
+}
+
+func (ba *tracingBatch) CommitExpecting(expectedRoot node.Root) error {
+	span := ba.startCommitSpan(expectedRoot)
+	err := ba.Batch.CommitExpecting(expectedRoot)
+	span.End(err)
+	if err == nil {
+		ba.nodes = 0
+	}
+	return err
+}
+
+func (ba *tracingBatch) Reset() {
+	ba.Batch.Reset()
+	ba.nodes = 0
+}
+
+func (ba *tracingBatch) startCommitSpan(root node.Root) Span {
+	_, span := ba.tracer.StartSpan(ba.ctx, SpanCommit,
+		SpanAttribute{SpanAttrRootType, root.Type.String()},
+		SpanAttribute{SpanAttrVersion, root.Version},
+		SpanAttribute{SpanAttrNodeCount, ba.nodes},
+	)
+	return span
+}
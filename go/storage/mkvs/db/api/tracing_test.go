@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+type testSpan struct {
+	name  string
+	attrs map[string]any
+	ended bool
+	err   error
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (tr *testTracer) StartSpan(ctx context.Context, name string, attrs ...SpanAttribute) (context.Context, Span) {
+	span := &testSpan{
+		name:  name,
+		attrs: make(map[string]any),
+	}
+	for _, attr := range attrs {
+		span.attrs[attr.Key] = attr.Value
+	}
+	tr.spans = append(tr.spans, span)
+	return ctx, span
+}
+
+func TestTracing(t *testing.T) {
+	require := require.New(t)
+
+	ndb, err := NewNopNodeDB()
+	require.NoError(err, "NewNopNodeDB")
+
+	root := node.EmptyRoot(common.Namespace{}, 42, node.RootTypeState)
+
+	// Without a tracer, nothing should be traced.
+	ctx := context.Background()
+	batch, err := NewBatchContext(ctx, ndb, root, 42, false)
+	require.NoError(err, "NewBatchContext")
+	require.IsType(&nopBatch{}, batch, "batch should not be wrapped without a tracer")
+
+	var tracer testTracer
+	ctx = WithTracer(ctx, &tracer)
+
+	_, err = GetNodeContext(ctx, ndb, root, &node.Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("missing"))})
+	require.ErrorIs(err, ErrNodeNotFound, "GetNodeContext")
+
+	batch, err = NewBatchContext(ctx, ndb, root, 42, false)
+	require.NoError(err, "NewBatchContext")
+	err = batch.PutNodeBatch([]*node.Pointer{
+		{Node: &node.LeafNode{Key: []byte("a")}},
+		{Node: &node.LeafNode{Key: []byte("b")}},
+	})
+	require.NoError(err, "PutNodeBatch")
+	err = batch.Commit(root)
+	require.NoError(err, "Commit")
+
+	err = FinalizeContext(ctx, ndb, []node.Root{root})
+	require.NoError(err, "FinalizeContext")
+	err = PruneContext(ctx, ndb, 41)
+	require.NoError(err, "PruneContext")
+
+	require.Len(tracer.spans, 4, "all operations should be traced")
+	for _, span := range tracer.spans {
+		require.True(span.ended, "span %s should be ended", span.name)
+	}
+
+	require.Equal(SpanGetNode, tracer.spans[0].name)
+	require.ErrorIs(tracer.spans[0].err, ErrNodeNotFound, "span should record the error")
+	require.Equal("state-root", tracer.spans[0].attrs[SpanAttrRootType])
+
+	require.Equal(SpanCommit, tracer.spans[1].name)
+	require.NoError(tracer.spans[1].err)
+	require.EqualValues(42, tracer.spans[1].attrs[SpanAttrVersion])
+	require.EqualValues(2, tracer.spans[1].attrs[SpanAttrNodeCount])
+
+	require.Equal(SpanFinalize, tracer.spans[2].name)
+	require.EqualValues(1, tracer.spans[2].attrs[SpanAttrRootCount])
+
+	require.Equal(SpanPrune, tracer.spans[3].name)
+	require.EqualValues(41, tracer.spans[3].attrs[SpanAttrVersion])
+
+	// Resetting the batch should reset the node count.
+	err = batch.PutNode(&node.Pointer{Node: &node.LeafNode{Key: []byte("c")}})
+	require.NoError(err, "PutNode")
+	batch.Reset()
+	err = batch.Commit(root)
+	require.NoError(err, "Commit")
+	require.Len(tracer.spans, 5, "commit should be traced")
+	require.EqualValues(0, tracer.spans[4].attrs[SpanAttrNodeCount])
+
+	// Traced batches should be unwrapped.
+	require.IsType(&nopBatch{}, UnwrapBatch(batch), "UnwrapBatch should return the traced batch")
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// NearestVersionAtOrBefore returns the highest finalized version that has not been pruned and is
+// less than or equal to the given target version.
+//
+// The boolean flag is false in case no such version exists.
+func NearestVersionAtOrBefore(ndb NodeDB, target uint64) (uint64, bool) {
+	latest, ok := ndb.GetLatestVersion()
+	if !ok {
+		return 0, false
+	}
+	earliest := ndb.GetEarliestVersion()
+	if target < earliest {
+		return 0, false
+	}
+
+	// Versions may be missing in case of multipart restores that skipped some versions, so walk
+	// back until a version with roots is found.
+	for version := min(target, latest); ; version-- {
+		roots, err := ndb.GetRootsForVersion(version)
+		if err == nil && len(roots) > 0 {
+			return version, true
+		}
+		if version == earliest {
+			return 0, false
+		}
+	}
+}
+
+// GetRootsForVersionByType returns the roots of the given type stored under the given version by
+// filtering the roots returned by GetRootsForVersion.
+//
+// This is a helper for node database implementations that cannot filter roots by type directly.
+func GetRootsForVersionByType(ndb NodeDB, version uint64, rootType node.RootType) ([]node.Root, error) {
+	if err := CheckRootType(rootType); err != nil {
+		return nil, err
+	}
+	roots, err := ndb.GetRootsForVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(roots, func(root node.Root) bool {
+		return root.Type != rootType
+	}), nil
+}
+
+// OrderVersionRoots validates the roots of multiple versions that are to be finalized together and
+// returns them ordered by version.
+//
+// Versions must be contiguous and each must have at least one root of that version. Every root of
+// a version other than the first must follow some root of the previous version, unless roots of
+// its type cannot have children (see RootPolicy) in which case it is built from scratch.
+func OrderVersionRoots(versionRoots map[uint64][]node.Root) ([][]node.Root, error) {
+	if len(versionRoots) == 0 {
+		return nil, fmt.Errorf("mkvs: need at least one version to finalize")
+	}
+
+	versions := make([]uint64, 0, len(versionRoots))
+	for version := range versionRoots {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+
+	ordered := make([][]node.Root, 0, len(versions))
+	for i, version := range versions {
+		roots := versionRoots[version]
+		if len(roots) == 0 {
+			return nil, fmt.Errorf("mkvs: need at least one root to finalize in version %d", version)
+		}
+		for _, root := range roots {
+			if root.Version != version {
+				return nil, fmt.Errorf("mkvs: roots to finalize don't have matching versions")
+			}
+		}
+		if i == 0 {
+			ordered = append(ordered, roots)
+			continue
+		}
+
+		if version != versions[i-1]+1 {
+			return nil, fmt.Errorf("mkvs: versions to finalize are not contiguous (%d after %d)", version, versions[i-1])
+		}
+		prevRoots := ordered[i-1]
+		for _, root := range roots {
+			if PolicyForRoot(root).NoChildRoots {
+				continue
+			}
+			if !slices.ContainsFunc(prevRoots, func(prev node.Root) bool { return root.Follows(&prev) }) {
+				return nil, fmt.Errorf("%w: root %s in version %d", ErrRootMustFollowOld, root.Hash, version)
+			}
+		}
+		ordered = append(ordered, roots)
+	}
+	return ordered, nil
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// sparseNodeDB is a node database that only contains roots for the given set of versions.
+type sparseNodeDB struct {
+	nopNodeDB
+
+	versions []uint64
+}
+
+func (d *sparseNodeDB) GetLatestVersion() (uint64, bool) {
+	if len(d.versions) == 0 {
+		return 0, false
+	}
+	return d.versions[len(d.versions)-1], true
+}
+
+func (d *sparseNodeDB) GetEarliestVersion() uint64 {
+	if len(d.versions) == 0 {
+		return 0
+	}
+	return d.versions[0]
+}
+
+func (d *sparseNodeDB) GetRootsForVersion(version uint64) ([]node.Root, error) {
+	for _, v := range d.versions {
+		if v == version {
+			return []node.Root{{Version: version, Type: node.RootTypeState, Hash: hash.NewFromBytes([]byte{byte(v)})}}, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestNearestVersionAtOrBefore(t *testing.T) {
+	require := require.New(t)
+
+	ndb := &sparseNodeDB{versions: []uint64{1, 3, 5}}
+	for _, tc := range []struct {
+		target   uint64
+		expected uint64
+		ok       bool
+	}{
+		{0, 0, false},
+		{1, 1, true},
+		{2, 1, true},
+		{3, 3, true},
+		{4, 3, true},
+		{5, 5, true},
+		{100, 5, true},
+	} {
+		version, ok := NearestVersionAtOrBefore(ndb, tc.target)
+		require.Equal(tc.ok, ok, "NearestVersionAtOrBefore(%d)", tc.target)
+		require.Equal(tc.expected, version, "NearestVersionAtOrBefore(%d)", tc.target)
+	}
+
+	// Empty database.
+	_, ok := NearestVersionAtOrBefore(&sparseNodeDB{}, 10)
+	require.False(ok, "NearestVersionAtOrBefore on an empty database")
+}
+
+func TestOrderVersionRoots(t *testing.T) {
+	require := require.New(t)
+
+	newRoot := func(version uint64, typ node.RootType) node.Root {
+		return node.Root{Version: version, Type: typ, Hash: hash.NewFromBytes([]byte{byte(version), byte(typ)})}
+	}
+
+	ordered, err := OrderVersionRoots(map[uint64][]node.Root{
+		7: {newRoot(7, node.RootTypeState), newRoot(7, node.RootTypeIO)},
+		5: {newRoot(5, node.RootTypeState), newRoot(5, node.RootTypeIO)},
+		6: {newRoot(6, node.RootTypeState)},
+	})
+	require.NoError(err, "OrderVersionRoots")
+	require.Len(ordered, 3)
+	for i, roots := range ordered {
+		require.EqualValues(5+i, roots[0].Version, "versions should be ordered")
+	}
+
+	for _, tc := range []struct {
+		name         string
+		versionRoots map[uint64][]node.Root
+	}{
+		{"NoVersions", map[uint64][]node.Root{}},
+		{"NoRoots", map[uint64][]node.Root{5: {}}},
+		{"VersionMismatch", map[uint64][]node.Root{5: {newRoot(6, node.RootTypeState)}}},
+		{"NotContiguous", map[uint64][]node.Root{
+			5: {newRoot(5, node.RootTypeState)},
+			7: {newRoot(7, node.RootTypeState)},
+		}},
+	} {
+		_, err = OrderVersionRoots(tc.versionRoots)
+		require.Error(err, tc.name)
+	}
+
+	// State roots must follow a root of the previous version.
+	_, err = OrderVersionRoots(map[uint64][]node.Root{
+		5: {newRoot(5, node.RootTypeIO)},
+		6: {newRoot(6, node.RootTypeState), newRoot(6, node.RootTypeIO)},
+	})
+	require.ErrorIs(err, ErrRootMustFollowOld)
+}
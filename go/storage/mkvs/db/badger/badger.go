@@ -2,9 +2,12 @@
 package badger
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"sync"
 
 	"github.com/dgraph-io/badger/v4"
@@ -22,6 +25,9 @@ import (
 
 const (
 	dbVersion = 5
+	// minDBVersion is the minimum supported database version. Older databases need to be migrated
+	// using the upgrade tool first.
+	minDBVersion = dbVersion
 
 	// multipartVersionNone is the value used for the multipart version in metadata
 	// when no multipart restore is in progress.
@@ -66,16 +72,56 @@ var (
 	//
 	// Value is empty.
 	rootNodeKeyFmt = keyFormat.New(0x06, &api.TypedHash{})
+	// secondaryHashKeyFmt is the key format for secondary node hashes (node hash). Only used
+	// when a secondary hasher is configured.
+	//
+	// Value is the secondary node hash.
+	secondaryHashKeyFmt = keyFormat.New(0x07, &hash.Hash{})
+	// gcDeferredNodeKeyFmt is the key format for nodes whose removal was vetoed by the GC filter
+	// (version, node hash). Once the filter allows it, the node is removed at the version
+	// timestamp.
+	//
+	// Value is empty.
+	gcDeferredNodeKeyFmt = keyFormat.New(0x08, uint64(0), &hash.Hash{})
 )
 
 // New creates a new BadgerDB-backed node database.
 func New(cfg *api.Config) (api.NodeDB, error) {
+	if cfg.WALPath != "" && cfg.ReadOnly {
+		return nil, fmt.Errorf("mkvs/badger: write-ahead log is not supported in read-only mode")
+	}
+	if cfg.BestEffortOpen && !cfg.ReadOnly {
+		return nil, fmt.Errorf("mkvs/badger: best-effort open is only supported in read-only mode")
+	}
+	if err := checkValueCompression(cfg.ValueCompression); err != nil {
+		return nil, err
+	}
+
 	db := &badgerNodeDB{
 		logger:           logging.GetLogger("mkvs/db/badger"),
 		namespace:        cfg.Namespace,
 		readOnly:         cfg.ReadOnly,
 		discardWriteLogs: cfg.DiscardWriteLogs,
+
+		writeLogFormatVersion: cfg.WriteLogFormatVersion,
+		secondaryHasher:       cfg.SecondaryHasher,
+
+		valueCompression:          cfg.ValueCompression,
+		valueCompressionThreshold: cfg.ValueCompressionThreshold,
+
+		maxBatchNodes: cfg.MaxBatchNodes,
+		maxBatchBytes: cfg.MaxBatchBytes,
+
+		gcFilter: cfg.GCFilter,
+
+		strictChunkCommit: cfg.StrictChunkCommit,
+	}
+	if db.valueCompressionThreshold == 0 {
+		db.valueCompressionThreshold = api.DefaultValueCompressionThreshold
 	}
+	db.multipartProgress.SetCallback(cfg.MultipartProgress)
+	db.duplicateKeys.Configure(cfg.DetectDuplicateKeys, cfg.DuplicateKeysBloomFilter)
+
 	opts := commonConfigToBadgerOptions(cfg, db)
 
 	var err error
@@ -92,12 +138,33 @@ func New(cfg *api.Config) (api.NodeDB, error) {
 		return nil, fmt.Errorf("mkvs/badger: failed to load metadata: %w", err)
 	}
 
+	// Only expose the versions that can be read in case of a best-effort open.
+	if cfg.BestEffortOpen {
+		if err = db.salvageVersions(); err != nil {
+			_ = db.db.Close()
+			return nil, fmt.Errorf("mkvs/badger: failed to salvage versions: %w", err)
+		}
+	}
+
 	// Cleanup any multipart restore remnants, since they can't be used anymore.
 	if err = db.cleanMultipartLocked(true); err != nil {
 		_ = db.db.Close()
 		return nil, fmt.Errorf("mkvs/badger: failed to clean leftovers from multipart restore: %w", err)
 	}
 
+	// Replay any batches that were logged but not yet durably applied before a crash.
+	if cfg.WALPath != "" {
+		if db.wal, err = openWAL(cfg.WALPath, cfg.NoFsync); err != nil {
+			_ = db.db.Close()
+			return nil, err
+		}
+		if err = db.RecoverFromWAL(); err != nil {
+			_ = db.wal.close()
+			_ = db.db.Close()
+			return nil, fmt.Errorf("mkvs/badger: failed to recover from write-ahead log: %w", err)
+		}
+	}
+
 	db.gc = cmnBadger.NewGCWorker(db.logger, db.db)
 	db.gc.Start()
 
@@ -112,17 +179,50 @@ type badgerNodeDB struct { // nolint: maligned
 	readOnly         bool
 	discardWriteLogs bool
 
+	// writeLogFormatVersion is the write log format version used by the database.
+	writeLogFormatVersion uint64
+
+	// secondaryHasher is the optional hasher used to compute secondary node hashes.
+	secondaryHasher node.Hasher
+
+	// valueCompression is the compression algorithm used for persisted leaf values.
+	valueCompression api.ValueCompression
+	// valueCompressionThreshold is the minimum size of a leaf value for it to be compressed.
+	valueCompressionThreshold int
+
+	// maxBatchNodes and maxBatchBytes are the optional batch size limits.
+	maxBatchNodes uint64
+	maxBatchBytes uint64
+
+	// gcFilter is the optional filter that can veto node removal.
+	gcFilter func(ptr *node.Pointer) bool
+
+	// strictChunkCommit specifies whether committing a chunk verifies that all referenced nodes
+	// exist.
+	strictChunkCommit bool
+
 	multipartVersion uint64
 
 	db *badger.DB
 	gc *cmnBadger.GCWorker
 
+	// wal is the optional write-ahead log of committed batches.
+	wal *writeAheadLog
+
 	// metaUpdateLock must be held at any point where data at tsMetadata is read and updated. This
 	// is required because all metadata updates happen at the same timestamp and as such conflicts
 	// cannot be detected.
 	metaUpdateLock sync.Mutex
 	meta           metadata
 
+	// compactLock serializes compactions.
+	compactLock sync.Mutex
+
+	quiescer api.Quiescer
+
+	multipartProgress api.MultipartProgressReporter
+	duplicateKeys     api.DuplicateKeyDetector
+
 	closeOnce sync.Once
 }
 
@@ -149,11 +249,8 @@ func (d *badgerNodeDB) load() error {
 			return err
 		}
 
-		if d.meta.value.Version != dbVersion {
-			return fmt.Errorf("incompatible database version (expected: %d got: %d)",
-				dbVersion,
-				d.meta.value.Version,
-			)
+		if err = api.CheckDBVersion(d.meta.value.Version, minDBVersion, dbVersion); err != nil {
+			return err
 		}
 		if !d.meta.value.Namespace.Equal(&d.namespace) {
 			return fmt.Errorf("incompatible namespace (expected: %s got: %s)",
@@ -161,15 +258,29 @@ func (d *badgerNodeDB) load() error {
 				d.meta.value.Namespace,
 			)
 		}
-		return nil
+
+		// Databases without a write log format version use the initial format.
+		d.writeLogFormatVersion = d.meta.value.WriteLogFormatVersion
+		if d.writeLogFormatVersion == 0 {
+			d.writeLogFormatVersion = api.WriteLogFormatV1
+		}
+		return api.CheckWriteLogFormatVersion(d.writeLogFormatVersion)
 	case badger.ErrKeyNotFound:
 	default:
 		return err
 	}
 
 	// No metadata exists, create some.
+	if d.writeLogFormatVersion == 0 {
+		d.writeLogFormatVersion = api.DefaultWriteLogFormatVersion
+	}
+	if err = api.CheckWriteLogFormatVersion(d.writeLogFormatVersion); err != nil {
+		return err
+	}
+
 	d.meta.value.Version = dbVersion
 	d.meta.value.Namespace = d.namespace
+	d.meta.value.WriteLogFormatVersion = d.writeLogFormatVersion
 	if err = d.meta.save(tx); err != nil {
 		return err
 	}
@@ -270,6 +381,8 @@ func (d *badgerNodeDB) cleanMultipartLocked(removeNodes bool) error {
 	}
 
 	d.multipartVersion = multipartVersionNone
+	d.multipartProgress.Finish()
+	d.duplicateKeys.Finish()
 	return nil
 }
 
@@ -277,6 +390,51 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 	if ptr == nil || !ptr.IsClean() {
 		panic("mkvs/badger: attempted to get invalid pointer from node database")
 	}
+
+	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
+	defer tx.Discard()
+
+	item, err := d.getNodeItem(tx, root, ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	var n node.Node
+	if err = item.Value(func(val []byte) error {
+		var vErr error
+		n, vErr = decodeNode(val)
+		return vErr
+	}); err != nil {
+		d.logger.Error("failed to unmarshal node",
+			"err", err,
+		)
+		return nil, fmt.Errorf("mkvs/badger: failed to unmarshal node: %w", err)
+	}
+
+	return n, nil
+}
+
+func (d *badgerNodeDB) HasNode(root node.Root, ptr *node.Pointer) (bool, error) {
+	if ptr == nil || !ptr.IsClean() {
+		panic("mkvs/badger: attempted to check invalid pointer in node database")
+	}
+
+	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
+	defer tx.Discard()
+
+	// The value is only read when requested, so there is no need to load or decode the node.
+	_, err := d.getNodeItem(tx, root, ptr)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, api.ErrNodeNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (d *badgerNodeDB) getNodeItem(tx *badger.Txn, root node.Root, ptr *node.Pointer) (*badger.Item, error) {
 	if err := d.sanityCheckNamespace(root.Namespace); err != nil {
 		return nil, err
 	}
@@ -286,9 +444,6 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 		return nil, api.ErrNodeNotFound
 	}
 
-	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
-	defer tx.Discard()
-
 	// Check if the root actually exists.
 	if err := d.checkRoot(tx, root); err != nil {
 		return nil, err
@@ -297,6 +452,7 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 	item, err := tx.Get(nodeKeyFmt.Encode(&ptr.Hash))
 	switch err {
 	case nil:
+		return item, nil
 	case badger.ErrKeyNotFound:
 		return nil, api.ErrNodeNotFound
 	default:
@@ -305,50 +461,90 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 		)
 		return nil, fmt.Errorf("mkvs/badger: failed to Get node from backing store: %w", err)
 	}
+}
 
-	var n node.Node
-	if err = item.Value(func(val []byte) error {
-		var vErr error
-		n, vErr = node.UnmarshalBinary(val)
-		return vErr
-	}); err != nil {
-		d.logger.Error("failed to unmarshal node",
-			"err", err,
-		)
-		return nil, fmt.Errorf("mkvs/badger: failed to unmarshal node: %w", err)
+func (d *badgerNodeDB) GetWriteLog(ctx context.Context, startRoot, endRoot node.Root) (writelog.Iterator, error) {
+	return d.getWriteLog(ctx, startRoot, endRoot, nil)
+}
+
+func (d *badgerNodeDB) GetWriteLogForPrefix(ctx context.Context, startRoot, endRoot node.Root, prefix node.Key) (writelog.Iterator, error) {
+	return d.getWriteLog(ctx, startRoot, endRoot, prefix)
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) GetWriteLogAnnotations(ctx context.Context, startRoot, endRoot node.Root) (writelog.Annotations, error) {
+	if err := d.checkWriteLogRoots(startRoot, endRoot); err != nil {
+		return nil, err
 	}
 
-	return n, nil
+	tx := d.db.NewTransactionAt(versionToTs(endRoot.Version), false)
+	defer tx.Discard()
+
+	if err := d.checkRoot(tx, endRoot); err != nil {
+		return nil, err
+	}
+
+	logKeys, _, err := d.findWriteLogPath(ctx, tx, startRoot, endRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations writelog.Annotations
+	for _, key := range logKeys {
+		item, err := tx.Get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var log api.HashedDBWriteLog
+		if err = item.Value(func(data []byte) error {
+			return d.unmarshalWriteLog(data, &log)
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range log {
+			var ann writelog.LogEntryAnnotation
+			if entry.InsertedHash != nil {
+				ann.InsertedNode = &node.Pointer{Clean: true, Hash: *entry.InsertedHash}
+			}
+			annotations = append(annotations, ann)
+		}
+	}
+	return annotations, nil
 }
 
-func (d *badgerNodeDB) GetWriteLog(ctx context.Context, startRoot, endRoot node.Root) (writelog.Iterator, error) {
+// checkWriteLogRoots checks whether a write log between the given roots can be available.
+func (d *badgerNodeDB) checkWriteLogRoots(startRoot, endRoot node.Root) error {
 	if d.discardWriteLogs {
-		return nil, api.ErrWriteLogNotFound
+		return api.ErrWriteLogNotFound
 	}
 	if !endRoot.Follows(&startRoot) {
-		return nil, api.ErrRootMustFollowOld
+		return api.ErrRootMustFollowOld
 	}
 	if err := d.sanityCheckNamespace(startRoot.Namespace); err != nil {
-		return nil, err
+		return err
 	}
 	// If the version is earlier than the earliest version, we don't have the roots.
 	if endRoot.Version < d.meta.getEarliestVersion() {
-		return nil, api.ErrWriteLogNotFound
+		return api.ErrWriteLogNotFound
 	}
-
-	tx := d.db.NewTransactionAt(versionToTs(endRoot.Version), false)
-	discardTx := true
-	defer func() {
-		if discardTx {
-			tx.Discard()
-		}
-	}()
-
-	// Check if the root actually exists.
-	if err := d.checkRoot(tx, endRoot); err != nil {
-		return nil, err
+	// If the version is earlier than the earliest write logs version, the write logs were pruned.
+	if endRoot.Version < d.meta.getWriteLogsEarliestVersion() {
+		return api.ErrWriteLogNotFound
 	}
+	return nil
+}
 
+// findWriteLogPath finds the chain of write logs leading from the start root to the end root. It
+// returns the database keys of the write logs and the end roots of the corresponding write logs,
+// ordered from the end root towards the start root.
+func (d *badgerNodeDB) findWriteLogPath(
+	ctx context.Context,
+	tx *badger.Txn,
+	startRoot node.Root,
+	endRoot node.Root,
+) ([][]byte, []api.TypedHash, error) {
 	// Start at the end root and search towards the start root. This assumes that the
 	// chains are not long and that there is not a lot of forks as in that case performance
 	// would suffer.
@@ -372,13 +568,13 @@ func (d *badgerNodeDB) GetWriteLog(ctx context.Context, startRoot, endRoot node.
 	startRootHash := api.TypedHashFromRoot(startRoot)
 	for len(queue) > 0 {
 		if ctx.Err() != nil {
-			return nil, ctx.Err()
+			return nil, nil, ctx.Err()
 		}
 
 		curItem := queue[0]
 		queue = queue[1:]
 
-		wl, err := func() (writelog.Iterator, error) {
+		found, err := func() (*wlItem, error) {
 			// Iterate over all write logs that result in the current item.
 			prefix := writeLogKeyFmt.Encode(endRoot.Version, &curItem.endRootHash)
 			it := tx.NewIterator(badger.IteratorOptions{Prefix: prefix})
@@ -405,56 +601,11 @@ func (d *badgerNodeDB) GetWriteLog(ctx context.Context, startRoot, endRoot node.
 					endRootHash: decStartRootHash,
 					// Only store log keys to avoid keeping everything in memory while
 					// we are searching for the right path.
-					logKeys:  append(curItem.logKeys, item.KeyCopy(nil)),
-					logRoots: append(curItem.logRoots, curItem.endRootHash),
+					logKeys:  append(slices.Clone(curItem.logKeys), item.KeyCopy(nil)),
+					logRoots: append(slices.Clone(curItem.logRoots), curItem.endRootHash),
 				}
 				if nextItem.endRootHash.Equal(&startRootHash) {
-					// Path has been found, deserialize and stream write logs.
-					var index int
-					discardTx = false
-					// Close iterator now as ReviveHashedDBWriteLogs can close the txn immediately.
-					it.Close()
-					return api.ReviveHashedDBWriteLogs(ctx,
-						func() (node.Root, api.HashedDBWriteLog, error) {
-							if index >= len(nextItem.logKeys) {
-								return node.Root{}, nil, nil
-							}
-
-							key := nextItem.logKeys[index]
-							root := node.Root{
-								Namespace: endRoot.Namespace,
-								Version:   endRoot.Version,
-								Type:      nextItem.logRoots[index].Type(),
-								Hash:      nextItem.logRoots[index].Hash(),
-							}
-
-							item, err := tx.Get(key)
-							if err != nil {
-								return node.Root{}, nil, err
-							}
-
-							var log api.HashedDBWriteLog
-							err = item.Value(func(data []byte) error {
-								return cbor.UnmarshalTrusted(data, &log)
-							})
-							if err != nil {
-								return node.Root{}, nil, err
-							}
-
-							index++
-							return root, log, nil
-						},
-						func(root node.Root, h hash.Hash) (*node.LeafNode, error) {
-							leaf, err := d.GetNode(root, &node.Pointer{Hash: h, Clean: true})
-							if err != nil {
-								return nil, err
-							}
-							return leaf.(*node.LeafNode), nil
-						},
-						func() {
-							tx.Discard()
-						},
-					)
+					return &nextItem, nil
 				}
 
 				if nextItem.depth < maxAllowedHops {
@@ -464,12 +615,91 @@ func (d *badgerNodeDB) GetWriteLog(ctx context.Context, startRoot, endRoot node.
 
 			return nil, nil
 		}()
-		if wl != nil || err != nil {
-			return wl, err
+		if err != nil {
+			return nil, nil, err
+		}
+		if found != nil {
+			return found.logKeys, found.logRoots, nil
+		}
+	}
+
+	return nil, nil, api.ErrWriteLogNotFound
+}
+
+// getWriteLog retrieves a write log between two roots, only including entries whose key has the
+// given prefix. Entries are filtered before their values are resolved.
+func (d *badgerNodeDB) getWriteLog(ctx context.Context, startRoot, endRoot node.Root, prefix node.Key) (writelog.Iterator, error) {
+	if err := d.checkWriteLogRoots(startRoot, endRoot); err != nil {
+		return nil, err
+	}
+
+	tx := d.db.NewTransactionAt(versionToTs(endRoot.Version), false)
+	discardTx := true
+	defer func() {
+		if discardTx {
+			tx.Discard()
 		}
+	}()
+
+	// Check if the root actually exists.
+	if err := d.checkRoot(tx, endRoot); err != nil {
+		return nil, err
+	}
+
+	logKeys, logRoots, err := d.findWriteLogPath(ctx, tx, startRoot, endRoot)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, api.ErrWriteLogNotFound
+	// Path has been found, deserialize and stream write logs.
+	var index int
+	discardTx = false
+	return api.ReviveHashedDBWriteLogs(ctx,
+		func() (node.Root, api.HashedDBWriteLog, error) {
+			if index >= len(logKeys) {
+				return node.Root{}, nil, nil
+			}
+
+			key := logKeys[index]
+			root := node.Root{
+				Namespace: endRoot.Namespace,
+				Version:   endRoot.Version,
+				Type:      logRoots[index].Type(),
+				Hash:      logRoots[index].Hash(),
+			}
+
+			item, err := tx.Get(key)
+			if err != nil {
+				return node.Root{}, nil, err
+			}
+
+			var log api.HashedDBWriteLog
+			err = item.Value(func(data []byte) error {
+				return d.unmarshalWriteLog(data, &log)
+			})
+			if err != nil {
+				return node.Root{}, nil, err
+			}
+			if len(prefix) > 0 {
+				log = slices.DeleteFunc(log, func(entry api.HashedDBLogEntry) bool {
+					return !bytes.HasPrefix(entry.Key, prefix)
+				})
+			}
+
+			index++
+			return root, log, nil
+		},
+		func(root node.Root, h hash.Hash) (*node.LeafNode, error) {
+			leaf, err := d.GetNode(root, &node.Pointer{Hash: h, Clean: true})
+			if err != nil {
+				return nil, err
+			}
+			return leaf.(*node.LeafNode), nil
+		},
+		func() {
+			tx.Discard()
+		},
+	)
 }
 
 func (d *badgerNodeDB) GetLatestVersion() (uint64, bool) {
@@ -505,13 +735,100 @@ func (d *badgerNodeDB) GetRootsForVersion(version uint64) (roots []node.Root, er
 	return
 }
 
+func (d *badgerNodeDB) GetRootsForVersionByType(version uint64, rootType node.RootType) ([]node.Root, error) {
+	// Roots metadata is not indexed by type.
+	return api.GetRootsForVersionByType(d, version, rootType)
+}
+
+func (d *badgerNodeDB) GetPendingVersions() ([]uint64, error) {
+	startVersion := d.meta.getEarliestVersion()
+	if lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion(); exists {
+		startVersion = lastFinalizedVersion + 1
+	}
+
+	tx := d.db.NewTransactionAt(tsMetadata, false)
+	defer tx.Discard()
+
+	it := tx.NewIterator(badger.IteratorOptions{Prefix: rootsMetadataKeyFmt.Encode()})
+	defer it.Close()
+
+	var versions []uint64
+	for it.Seek(rootsMetadataKeyFmt.Encode(startVersion)); it.Valid(); it.Next() {
+		var version uint64
+		if !rootsMetadataKeyFmt.Decode(it.Item().Key(), &version) {
+			return nil, fmt.Errorf("mkvs/badger: undecodable roots metadata key: %X", it.Item().Key())
+		}
+
+		var rootsMeta rootsMetadata
+		if err := it.Item().Value(func(val []byte) error {
+			return cbor.Unmarshal(val, &rootsMeta)
+		}); err != nil {
+			return nil, fmt.Errorf("mkvs/badger: error reading roots metadata for version %d: %w", version, err)
+		}
+		if len(rootsMeta.Roots) > 0 {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+func (d *badgerNodeDB) NearestVersionAtOrBefore(target uint64) (uint64, bool) {
+	return api.NearestVersionAtOrBefore(d, target)
+}
+
+func (d *badgerNodeDB) Fingerprint(ctx context.Context) (hash.Hash, error) {
+	return api.Fingerprint(ctx, d)
+}
+
+func (d *badgerNodeDB) ScanPrefix(
+	ctx context.Context,
+	root node.Root,
+	prefix node.Key,
+	fn func(key node.Key, value []byte) bool,
+) error {
+	return api.ScanPrefix(ctx, d, root, prefix, fn)
+}
+
+func (d *badgerNodeDB) IterateNodes(ctx context.Context, fn func(ptr *node.Pointer, n node.Node) error) error {
+	tx := d.db.NewTransactionAt(math.MaxUint64, false)
+	defer tx.Discard()
+
+	it := tx.NewIterator(badger.IteratorOptions{Prefix: nodeKeyFmt.Encode()})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var h hash.Hash
+		if !nodeKeyFmt.Decode(it.Item().Key(), &h) {
+			return fmt.Errorf("mkvs/badger: undecodable node key: %X", it.Item().Key())
+		}
+
+		var n node.Node
+		if err := it.Item().Value(func(val []byte) error {
+			var vErr error
+			n, vErr = decodeNode(val)
+			return vErr
+		}); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to unmarshal node %s: %w", h, err)
+		}
+
+		if err := fn(&node.Pointer{Clean: true, Hash: h, Node: n}, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *badgerNodeDB) HasRoot(root node.Root) bool {
 	if err := d.sanityCheckNamespace(root.Namespace); err != nil {
 		return false
 	}
 
 	// An empty root is always implicitly present.
-	if root.Hash.IsEmpty() {
+	if root.IsEmptyTree() {
 		return true
 	}
 
@@ -532,67 +849,147 @@ func (d *badgerNodeDB) HasRoot(root node.Root) bool {
 	return exists
 }
 
-func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
+func (d *badgerNodeDB) Finalize(roots []node.Root) error {
 	if d.readOnly {
 		return api.ErrReadOnly
 	}
 
-	if len(roots) == 0 {
-		return fmt.Errorf("mkvs/badger: need at least one root to finalize")
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
 	}
-	version := roots[0].Version
+	defer d.quiescer.ExitWrite()
 
 	d.metaUpdateLock.Lock()
 	defer d.metaUpdateLock.Unlock()
 
-	if d.multipartVersion != multipartVersionNone && d.multipartVersion != version {
-		return api.ErrInvalidMultipartVersion
+	if err := d.finalizeLocked(roots); err != nil {
+		return err
 	}
+	return d.checkpointWALLocked()
+}
 
-	// Version batch collects removals at the version timestamp.
-	versionBatch := d.db.NewWriteBatchAt(versionToTs(version))
-	defer versionBatch.Cancel()
-	// Transaction is used to read at the version timestamp.
-	tx := d.db.NewTransactionAt(versionToTs(version), true)
-	defer tx.Discard()
-
-	// Make sure that the previous version has been finalized (if we are not restoring).
-	lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion()
-	if d.multipartVersion == multipartVersionNone && version > 0 && exists && lastFinalizedVersion < (version-1) {
-		return api.ErrNotFinalized
-	}
-	// Make sure that this version has not yet been finalized.
-	if exists && version <= lastFinalizedVersion {
-		return api.ErrAlreadyFinalized
+func (d *badgerNodeDB) FinalizeVersions(versionRoots map[uint64][]node.Root) error {
+	if d.readOnly {
+		return api.ErrReadOnly
 	}
 
-	// Determine the set of finalized roots. Finalization is transitive, so if
-	// a parent root is finalized the child should be considered finalized too.
-	finalizedRoots := make(map[api.TypedHash]bool)
-	for _, root := range roots {
-		if root.Version != version {
-			return fmt.Errorf("mkvs/badger: roots to finalize don't have matching versions")
-		}
-		finalizedRoots[api.TypedHashFromRoot(root)] = true
+	ordered, err := api.OrderVersionRoots(versionRoots)
+	if err != nil {
+		return err
 	}
 
-	var rootsChanged bool
-	rootsMeta, err := loadRootsMetadata(tx, version)
-	if err != nil {
+	if err = d.quiescer.EnterWrite(); err != nil {
 		return err
 	}
+	defer d.quiescer.ExitWrite()
 
-	for updated := true; updated; {
-		updated = false
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
 
-		for rootHash, derivedRoots := range rootsMeta.Roots {
-			if len(derivedRoots) == 0 {
-				continue
-			}
+	if err := d.finalizeVersionsLocked(ordered); err != nil {
+		return err
+	}
+	return d.checkpointWALLocked()
+}
 
-			for _, nextRoot := range derivedRoots {
-				if !finalizedRoots[rootHash] && finalizedRoots[nextRoot] {
-					finalizedRoots[rootHash] = true
+func (d *badgerNodeDB) finalizeLocked(roots []node.Root) error {
+	if len(roots) == 0 {
+		return fmt.Errorf("mkvs/badger: need at least one root to finalize")
+	}
+	return d.finalizeVersionsLocked([][]node.Root{roots})
+}
+
+// finalizeVersionsLocked finalizes the given non-empty lists of roots, one per version, in order
+// and commits the metadata of all versions in a single transaction. In case finalization fails,
+// nothing is finalized.
+//
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) finalizeVersionsLocked(versionRoots [][]node.Root) error {
+	lastVersion := versionRoots[len(versionRoots)-1][0].Version
+
+	// Transaction is used to read metadata and is committed once all versions are finalized.
+	tx := d.db.NewTransactionAt(versionToTs(lastVersion), true)
+	defer tx.Discard()
+
+	var dels pendingDeletes
+	meta := d.meta.snapshot()
+	err := func() error {
+		for _, roots := range versionRoots {
+			if err := d.finalizeVersionLocked(tx, &dels, roots); err != nil {
+				return err
+			}
+		}
+		// Only remove anything once all versions have been successfully finalized.
+		if err := dels.flush(d.db); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to flush removals: %w", err)
+		}
+		if err := tx.CommitAt(tsMetadata, nil); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to commit metadata: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		// Make sure in-memory metadata is consistent with what has been committed.
+		d.meta.restore(meta)
+		return err
+	}
+
+	// Clean multipart metadata if there is any.
+	if d.multipartVersion != multipartVersionNone {
+		if err := d.cleanMultipartLocked(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalizeVersionLocked finalizes a single version, recording metadata updates in the given
+// transaction and removals in the given pending removals which must both be committed by the
+// caller.
+func (d *badgerNodeDB) finalizeVersionLocked(tx *badger.Txn, dels *pendingDeletes, roots []node.Root) error { // nolint: gocyclo
+	version := roots[0].Version
+
+	if d.multipartVersion != multipartVersionNone && d.multipartVersion != version {
+		return api.ErrInvalidMultipartVersion
+	}
+
+	// Make sure that the previous version has been finalized (if we are not restoring).
+	lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion()
+	if d.multipartVersion == multipartVersionNone && version > 0 && exists && lastFinalizedVersion < (version-1) {
+		return api.ErrNotFinalized
+	}
+	// Make sure that this version has not yet been finalized.
+	if exists && version <= lastFinalizedVersion {
+		return api.ErrAlreadyFinalized
+	}
+
+	// Determine the set of finalized roots. Finalization is transitive, so if
+	// a parent root is finalized the child should be considered finalized too.
+	finalizedRoots := make(map[api.TypedHash]bool)
+	for _, root := range roots {
+		if root.Version != version {
+			return fmt.Errorf("mkvs/badger: roots to finalize don't have matching versions")
+		}
+		finalizedRoots[api.TypedHashFromRoot(root)] = true
+	}
+
+	var rootsChanged bool
+	rootsMeta, err := loadRootsMetadata(tx, version)
+	if err != nil {
+		return err
+	}
+
+	for updated := true; updated; {
+		updated = false
+
+		for rootHash, derivedRoots := range rootsMeta.Roots {
+			if len(derivedRoots) == 0 {
+				continue
+			}
+
+			for _, nextRoot := range derivedRoots {
+				if !finalizedRoots[rootHash] && finalizedRoots[nextRoot] {
+					finalizedRoots[rootHash] = true
 					updated = true
 				}
 			}
@@ -602,7 +999,7 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 	// Sanity check the input roots list.
 	for iroot := range finalizedRoots {
 		h := iroot.Hash()
-		if _, ok := rootsMeta.Roots[iroot]; !ok && !h.IsEmpty() {
+		if _, ok := rootsMeta.Roots[iroot]; !ok && !node.IsEmptyTreeHash(h) {
 			return api.ErrRootNotFound
 		}
 	}
@@ -654,20 +1051,15 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 
 			// Remove write logs for the non-finalized root.
 			if !d.discardWriteLogs {
-				if err = func() error {
+				func() {
 					rootWriteLogsPrefix := writeLogKeyFmt.Encode(version, &rootHash)
 					wit := tx.NewIterator(badger.IteratorOptions{Prefix: rootWriteLogsPrefix})
 					defer wit.Close()
 
 					for wit.Rewind(); wit.Valid(); wit.Next() {
-						if err = versionBatch.Delete(wit.Item().KeyCopy(nil)); err != nil {
-							return err
-						}
+						dels.delete(wit.Item().KeyCopy(nil), version)
 					}
-					return nil
-				}(); err != nil {
-					return err
-				}
+				}()
 			}
 		}
 
@@ -683,17 +1075,11 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 			continue
 		}
 
-		key := nodeKeyFmt.Encode(&h)
-		if err := versionBatch.Delete(key); err != nil {
+		if err := d.removeNode(tx, dels, version, h, nil); err != nil {
 			return err
 		}
 	}
 
-	// Commit batch.
-	if err := versionBatch.Flush(); err != nil {
-		return err
-	}
-
 	// Save roots metadata if changed.
 	if rootsChanged {
 		if err := rootsMeta.save(tx); err != nil {
@@ -706,15 +1092,78 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 		return fmt.Errorf("mkvs/badger: failed to set last finalized version: %w", err)
 	}
 
-	if err := tx.CommitAt(tsMetadata, nil); err != nil {
-		return fmt.Errorf("mkvs/badger: failed to commit metadata: %w", err)
+	// Automatically prune roots that have fallen out of their retention window.
+	if err := d.autoPruneLocked(tx, dels, version); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to auto-prune roots: %w", err)
 	}
+	return nil
+}
 
-	// Clean multipart metadata if there is any.
-	if d.multipartVersion != multipartVersionNone {
-		if err := d.cleanMultipartLocked(false); err != nil {
+// autoPruneLocked removes the lone roots of all root types with a non-zero AutoPruneAfter policy
+// that fall out of the retention window once the given version is finalized.
+func (d *badgerNodeDB) autoPruneLocked(tx *badger.Txn, dels *pendingDeletes, finalizedVersion uint64) error {
+	for _, rootType := range api.RootTypesWithPolicy(func(p *api.RootPolicy) bool { return p.AutoPruneAfter > 0 }) {
+		policy := api.PolicyForRoot(node.Root{Type: rootType})
+		if finalizedVersion < policy.AutoPruneAfter {
+			continue
+		}
+		version := finalizedVersion - policy.AutoPruneAfter
+		if version < d.meta.getEarliestVersion() {
+			continue
+		}
+
+		if err := d.pruneRootsLocked(tx, dels, version, rootType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneRootsLocked removes all lone roots of the given type in the given version, together with
+// the nodes created in that version and their write logs.
+func (d *badgerNodeDB) pruneRootsLocked(tx *badger.Txn, dels *pendingDeletes, version uint64, rootType node.RootType) error {
+	rootsMeta, err := loadRootsMetadata(tx, version)
+	if err != nil {
+		return err
+	}
+
+	var rootsChanged bool
+	for rootHash, derivedRoots := range rootsMeta.Roots {
+		if rootHash.Type() != rootType || len(derivedRoots) > 0 {
+			continue
+		}
+
+		// Traverse the root and prune all items created in this version.
+		err = d.forEachPrunableNode(rootHash, version, func(h hash.Hash, n node.Node) error {
+			return d.removeNode(tx, dels, version, h, n)
+		})
+		if err != nil {
 			return err
 		}
+
+		dels.delete(rootNodeKeyFmt.Encode(&rootHash), version)
+
+		// Remove write logs for the pruned root.
+		if !d.discardWriteLogs {
+			func() {
+				rootWriteLogsPrefix := writeLogKeyFmt.Encode(version, &rootHash)
+				wit := tx.NewIterator(badger.IteratorOptions{Prefix: rootWriteLogsPrefix})
+				defer wit.Close()
+
+				for wit.Rewind(); wit.Valid(); wit.Next() {
+					dels.delete(wit.Item().KeyCopy(nil), version)
+				}
+			}()
+		}
+
+		delete(rootsMeta.Roots, rootHash)
+		rootsChanged = true
+	}
+
+	if rootsChanged {
+		if err = rootsMeta.save(tx); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to save roots metadata: %w", err)
+		}
 	}
 	return nil
 }
@@ -724,9 +1173,228 @@ func (d *badgerNodeDB) Prune(version uint64) error {
 		return api.ErrReadOnly
 	}
 
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	if err := d.pruneLocked(version); err != nil {
+		return err
+	}
+	return d.checkpointWALLocked()
+}
+
+func (d *badgerNodeDB) PruneWriteLogs(beforeVersion uint64) error {
+	if d.readOnly {
+		return api.ErrReadOnly
+	}
+
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	startVersion := max(d.meta.getEarliestVersion(), d.meta.getWriteLogsEarliestVersion())
+	if beforeVersion <= startVersion {
+		return nil
+	}
+	// Make sure that all versions whose write logs we try to prune have been finalized.
+	lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion()
+	if !exists || lastFinalizedVersion < beforeVersion-1 {
+		return api.ErrNotFinalized
+	}
+
+	if !d.discardWriteLogs {
+		for version := startVersion; version < beforeVersion; version++ {
+			if err := d.pruneVersionWriteLogs(version); err != nil {
+				return err
+			}
+		}
+	}
+
+	tx := d.db.NewTransactionAt(tsMetadata, true)
+	defer tx.Discard()
+
+	if err := d.meta.setWriteLogsEarliestVersion(tx, beforeVersion); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to set earliest write logs version: %w", err)
+	}
+	if err := tx.CommitAt(tsMetadata, nil); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to commit: %w", err)
+	}
+	return d.checkpointWALLocked()
+}
+
+// pruneVersionWriteLogs removes all write logs in the given version.
+func (d *badgerNodeDB) pruneVersionWriteLogs(version uint64) error {
+	batch := d.db.NewWriteBatchAt(versionToTs(version))
+	defer batch.Cancel()
+	tx := d.db.NewTransactionAt(versionToTs(version), false)
+	defer tx.Discard()
+
+	it := tx.NewIterator(badger.IteratorOptions{Prefix: writeLogKeyFmt.Encode(version)})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := batch.Delete(it.Item().KeyCopy(nil)); err != nil {
+			return err
+		}
+	}
+
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to flush batch: %w", err)
+	}
+	return nil
+}
+
+func (d *badgerNodeDB) CommitFinalizeAndPrune(batch api.Batch, roots []node.Root, pruneVersion uint64) error {
+	if d.readOnly {
+		return api.ErrReadOnly
+	}
+
+	if len(roots) == 0 {
+		return fmt.Errorf("mkvs/badger: need at least one root to finalize")
+	}
+	version := roots[0].Version
+
+	var ba *badgerBatch
+	if batch != nil {
+		var ok bool
+		if ba, ok = api.UnwrapBatch(batch).(*badgerBatch); !ok || ba.db != d {
+			return fmt.Errorf("mkvs/badger: batch not created by this database")
+		}
+		if err := ba.CheckDiscarded(); err != nil {
+			return err
+		}
+		if ba.chunk {
+			return fmt.Errorf("mkvs/badger: cannot finalize a chunk batch")
+		}
+	}
+
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
 	d.metaUpdateLock.Lock()
 	defer d.metaUpdateLock.Unlock()
 
+	// Make sure that pruning will succeed before changing anything.
+	if d.multipartVersion != multipartVersionNone {
+		return api.ErrMultipartInProgress
+	}
+	if pruneVersion > version {
+		return api.ErrNotFinalized
+	}
+	if pruneVersion != d.meta.getEarliestVersion() {
+		return api.ErrNotEarliest
+	}
+	if pruneVersion == version {
+		return api.ErrCannotPruneLatestVersion
+	}
+
+	// All metadata updates are recorded in a single transaction, so either all of them are
+	// committed or none is.
+	tx := d.db.NewTransactionAt(versionToTs(version), true)
+	defer tx.Discard()
+
+	var (
+		exists bool
+		dels   pendingDeletes
+	)
+	meta := d.meta.snapshot()
+	walPos := d.walPositionLocked()
+	err := func() error {
+		var err error
+		if ba != nil {
+			if exists, err = ba.prepareCommitLocked(tx, roots[0]); err != nil {
+				return err
+			}
+		}
+		if err = d.finalizeVersionLocked(tx, &dels, roots); err != nil {
+			return err
+		}
+		if err = d.pruneVersionLocked(tx, &dels, pruneVersion); err != nil {
+			return err
+		}
+		if err = dels.flush(d.db); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to flush removals: %w", err)
+		}
+		if err = tx.CommitAt(tsMetadata, nil); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to commit metadata: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		// Make sure in-memory metadata is consistent with what has been committed.
+		d.meta.restore(meta)
+		d.abortWALLocked(walPos)
+		return err
+	}
+
+	// Discard everything invalidated at or below the pruned version.
+	d.db.SetDiscardTs(versionToTs(pruneVersion + 1))
+
+	switch {
+	case ba == nil:
+	case exists:
+		ba.Reset()
+		err = ba.BaseBatch.Commit(roots[0])
+	default:
+		err = ba.finishCommitLocked(roots[0])
+	}
+	if err != nil {
+		return err
+	}
+	return d.checkpointWALLocked()
+}
+
+func (d *badgerNodeDB) VerifyAgainstManifest(ctx context.Context, manifest map[uint64][]node.Root) ([]api.ManifestMismatch, error) {
+	return api.VerifyAgainstManifest(ctx, d, manifest)
+}
+
+func (d *badgerNodeDB) CheckComplete(ctx context.Context, root node.Root) ([]hash.Hash, error) {
+	if err := d.sanityCheckNamespace(root.Namespace); err != nil {
+		return nil, err
+	}
+
+	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
+	defer tx.Discard()
+
+	// Nodes are looked up directly by hash as the root may not have been finalized yet.
+	return api.CheckComplete(ctx, root, func(ptr *node.Pointer) (node.Node, error) {
+		if root.Version < d.meta.getEarliestVersion() {
+			return nil, api.ErrNodeNotFound
+		}
+
+		item, err := tx.Get(nodeKeyFmt.Encode(&ptr.Hash))
+		switch err {
+		case nil:
+		case badger.ErrKeyNotFound:
+			return nil, api.ErrNodeNotFound
+		default:
+			return nil, fmt.Errorf("mkvs/badger: failed to get node: %w", err)
+		}
+
+		var n node.Node
+		if err = item.Value(func(val []byte) error {
+			var vErr error
+			n, vErr = decodeNode(val)
+			return vErr
+		}); err != nil {
+			return nil, fmt.Errorf("mkvs/badger: failed to unmarshal node: %w", err)
+		}
+		return n, nil
+	})
+}
+
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) checkPruneLocked(version uint64) error {
 	if d.multipartVersion != multipartVersionNone {
 		return api.ErrMultipartInProgress
 	}
@@ -744,18 +1412,59 @@ func (d *badgerNodeDB) Prune(version uint64) error {
 	if version == lastFinalizedVersion {
 		return api.ErrCannotPruneLatestVersion
 	}
+	return nil
+}
+
+func (d *badgerNodeDB) pruneLocked(version uint64) error {
+	if err := d.checkPruneLocked(version); err != nil {
+		return err
+	}
 
-	// Remove all roots in version.
-	batch := d.db.NewWriteBatchAt(versionToTs(version))
-	defer batch.Cancel()
 	tx := d.db.NewTransactionAt(versionToTs(version), true)
 	defer tx.Discard()
 
+	var dels pendingDeletes
+	meta := d.meta.snapshot()
+	err := func() error {
+		if err := d.pruneVersionLocked(tx, &dels, version); err != nil {
+			return err
+		}
+		if err := dels.flush(d.db); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to flush removals: %w", err)
+		}
+		if err := tx.CommitAt(tsMetadata, nil); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to commit: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		// Make sure in-memory metadata is consistent with what has been committed.
+		d.meta.restore(meta)
+		return err
+	}
+
+	// Discard everything invalidated at or below given version.
+	d.db.SetDiscardTs(versionToTs(version + 1))
+
+	return nil
+}
+
+// pruneVersionLocked prunes the given version, recording metadata updates in the given
+// transaction and removals in the given pending removals which must both be committed by the
+// caller.
+//
+// Assumes metaUpdateLock is held and the pruning preconditions have been checked when called.
+func (d *badgerNodeDB) pruneVersionLocked(tx *badger.Txn, dels *pendingDeletes, version uint64) error {
 	rootsMeta, err := loadRootsMetadata(tx, version)
 	if err != nil {
 		return err
 	}
 
+	// Remove any nodes whose removal was previously vetoed but is now allowed.
+	if err = d.collectDeferredNodes(tx, dels); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to collect deferred nodes: %w", err)
+	}
+
 	for rootHash, derivedRoots := range rootsMeta.Roots {
 		if len(derivedRoots) > 0 {
 			// Not a lone root.
@@ -763,37 +1472,14 @@ func (d *badgerNodeDB) Prune(version uint64) error {
 		}
 
 		// Traverse the root and prune all items created in this version.
-		root := node.Root{
-			Namespace: d.namespace,
-			Version:   version,
-			Type:      rootHash.Type(),
-			Hash:      rootHash.Hash(),
-		}
-		var innerErr error
-		err := api.Visit(context.Background(), d, root, func(_ context.Context, n node.Node) bool {
-			h := n.GetHash()
-			var item *badger.Item
-			if item, innerErr = tx.Get(nodeKeyFmt.Encode(&h)); innerErr != nil {
-				return false
-			}
-
-			if tsToVersion(item.Version()) == version {
-				if innerErr = batch.Delete(nodeKeyFmt.Encode(&h)); innerErr != nil {
-					return false
-				}
-			}
-			return true
+		err = d.forEachPrunableNode(rootHash, version, func(h hash.Hash, n node.Node) error {
+			return d.removeNode(tx, dels, version, h, n)
 		})
-		if innerErr != nil {
-			return innerErr
-		}
 		if err != nil {
 			return err
 		}
 
-		if err = batch.Delete(rootNodeKeyFmt.Encode(&rootHash)); err != nil {
-			return err
-		}
+		dels.delete(rootNodeKeyFmt.Encode(&rootHash), version)
 	}
 
 	// Delete roots metadata.
@@ -802,7 +1488,7 @@ func (d *badgerNodeDB) Prune(version uint64) error {
 	}
 
 	// Prune all write logs in version.
-	if !d.discardWriteLogs {
+	if !d.discardWriteLogs && version >= d.meta.getWriteLogsEarliestVersion() {
 		wtx := d.db.NewTransactionAt(versionToTs(version), false)
 		defer wtx.Discard()
 
@@ -811,29 +1497,94 @@ func (d *badgerNodeDB) Prune(version uint64) error {
 		defer it.Close()
 
 		for it.Rewind(); it.Valid(); it.Next() {
-			if err := batch.Delete(it.Item().KeyCopy(nil)); err != nil {
-				return err
-			}
+			dels.delete(it.Item().KeyCopy(nil), version)
 		}
 	}
 
-	// Commit batch.
-	if err := batch.Flush(); err != nil {
-		return fmt.Errorf("mkvs/badger: failed to flush batch: %w", err)
-	}
-
 	// Update metadata.
 	if err := d.meta.setEarliestVersion(tx, version+1); err != nil {
 		return fmt.Errorf("mkvs/badger: failed to set earliest version: %w", err)
 	}
-	if err := tx.CommitAt(tsMetadata, nil); err != nil {
-		return fmt.Errorf("mkvs/badger: failed to commit: %w", err)
+	return nil
+}
+
+// forEachPrunableNode invokes fn for each node reachable from the given lone root that was
+// created in the given version and is therefore removed when the version is pruned.
+func (d *badgerNodeDB) forEachPrunableNode(rootHash api.TypedHash, version uint64, fn func(hash.Hash, node.Node) error) error {
+	// Nodes are read at the version timestamp, so nodes written again in later versions are
+	// attributed to the correct version.
+	tx := d.db.NewTransactionAt(versionToTs(version), false)
+	defer tx.Discard()
+
+	root := node.Root{
+		Namespace: d.namespace,
+		Version:   version,
+		Type:      rootHash.Type(),
+		Hash:      rootHash.Hash(),
+	}
+	var innerErr error
+	err := api.Visit(context.Background(), d, root, func(_ context.Context, n node.Node) bool {
+		h := n.GetHash()
+		var item *badger.Item
+		if item, innerErr = tx.Get(nodeKeyFmt.Encode(&h)); innerErr != nil {
+			return false
+		}
+
+		if tsToVersion(item.Version()) == version {
+			if innerErr = fn(h, n); innerErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if innerErr != nil {
+		return innerErr
 	}
+	return err
+}
 
-	// Discard everything invalidated at or below given version.
-	d.db.SetDiscardTs(versionToTs(version + 1))
+func (d *badgerNodeDB) PruneDryRun(version uint64) (*api.PruneEstimate, error) {
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
 
-	return nil
+	if err := d.checkPruneLocked(version); err != nil {
+		return nil, err
+	}
+
+	tx := d.db.NewTransactionAt(versionToTs(version), false)
+	defer tx.Discard()
+
+	rootsMeta, err := loadRootsMetadata(tx, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var estimate api.PruneEstimate
+	seen := make(map[hash.Hash]struct{})
+	for rootHash, derivedRoots := range rootsMeta.Roots {
+		if len(derivedRoots) > 0 {
+			// Not a lone root.
+			continue
+		}
+
+		err = d.forEachPrunableNode(rootHash, version, func(h hash.Hash, n node.Node) error {
+			if _, ok := seen[h]; ok {
+				return nil
+			}
+			seen[h] = struct{}{}
+
+			if d.keepNode(h, n) {
+				return nil
+			}
+			estimate.Nodes++
+			estimate.Size += n.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &estimate, nil
 }
 
 func (d *badgerNodeDB) StartMultipartInsert(version uint64) error {
@@ -863,6 +1614,8 @@ func (d *badgerNodeDB) StartMultipartInsert(version uint64) error {
 	}
 
 	d.multipartVersion = version
+	d.multipartProgress.Start()
+	d.duplicateKeys.Start()
 
 	return nil
 }
@@ -874,6 +1627,17 @@ func (d *badgerNodeDB) AbortMultipartInsert() error {
 	return d.cleanMultipartLocked(true)
 }
 
+func (d *badgerNodeDB) MultipartState() (bool, uint64, error) {
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	version := d.multipartVersion
+	if version == multipartVersionNone {
+		version = d.meta.getMultipartVersion()
+	}
+	return version != multipartVersionNone, version, nil
+}
+
 func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (api.Batch, error) {
 	// WARNING: There is a maximum batch size and maximum batch entry count.
 	// Both of these things are derived from the MaxTableSize option.
@@ -885,6 +1649,9 @@ func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (
 	if d.readOnly {
 		return nil, api.ErrReadOnly
 	}
+	if d.quiescer.IsQuiesced() {
+		return nil, api.ErrQuiesced
+	}
 
 	d.metaUpdateLock.Lock()
 	defer d.metaUpdateLock.Unlock()
@@ -898,21 +1665,61 @@ func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (
 
 	var logBatch *badger.WriteBatch
 	var readTxn *badger.Txn
+	var duplicateKeys *api.DuplicateKeyBatch
 	if d.multipartVersion != multipartVersionNone {
 		// The node log is at a different version than the nodes themselves,
 		// which is awkward.
 		logBatch = d.db.NewWriteBatchAt(tsMetadata)
 		readTxn = d.db.NewTransactionAt(versionToTs(version), false)
+		duplicateKeys = d.duplicateKeys.NewBatch()
 	}
 
-	return &badgerBatch{
+	ba := &badgerBatch{
 		db:             d,
 		bat:            d.db.NewWriteBatchAt(versionToTs(version)),
 		multipartNodes: logBatch,
 		readTxn:        readTxn,
 		oldRoot:        oldRoot,
+		version:        version,
 		chunk:          chunk,
-	}, nil
+		duplicateKeys:  duplicateKeys,
+	}
+	ba.SetSizeLimits(d.maxBatchNodes, d.maxBatchBytes)
+	return ba, nil
+}
+
+func (d *badgerNodeDB) VerifySecondaryHashes(root node.Root) error {
+	if d.secondaryHasher == nil {
+		return api.ErrSecondaryHashesDisabled
+	}
+	if err := d.sanityCheckNamespace(root.Namespace); err != nil {
+		return err
+	}
+
+	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
+	defer tx.Discard()
+
+	return api.VerifySecondaryHashes(context.Background(), d, root, d.secondaryHasher, func(h hash.Hash) (hash.Hash, error) {
+		sh, err := getSecondaryHash(tx, h)
+		if errors.Is(err, errSecondaryHashNotFound) {
+			return hash.Hash{}, fmt.Errorf("%w: missing secondary hash for node %s", api.ErrSecondaryHashMismatch, h)
+		}
+		return sh, err
+	})
+}
+
+func (d *badgerNodeDB) Compact(ctx context.Context) error {
+	if d.readOnly {
+		return api.ErrReadOnly
+	}
+
+	d.compactLock.Lock()
+	defer d.compactLock.Unlock()
+
+	if err := cmnBadger.Compact(ctx, d.db); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to compact: %w", err)
+	}
+	return nil
 }
 
 func (d *badgerNodeDB) Size() (int64, error) {
@@ -920,8 +1727,24 @@ func (d *badgerNodeDB) Size() (int64, error) {
 	return lsm + vlog, nil
 }
 
+func (d *badgerNodeDB) CacheUsage() (int64, int, error) {
+	size, entries := cmnBadger.BlockCacheUsage(d.db)
+	return size, entries, nil
+}
+
 func (d *badgerNodeDB) Sync() error {
-	return d.db.Sync()
+	if d.wal == nil {
+		return d.db.Sync()
+	}
+
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	return d.checkpointWALLocked()
+}
+
+func (d *badgerNodeDB) Quiesce(ctx context.Context) (func(), error) {
+	return d.quiescer.Quiesce(ctx, d.Sync)
 }
 
 func (d *badgerNodeDB) Close() {
@@ -930,6 +1753,19 @@ func (d *badgerNodeDB) Close() {
 			d.gc.Stop()
 		}
 
+		if d.wal != nil {
+			if err := d.Sync(); err != nil {
+				d.logger.Error("failed to checkpoint write-ahead log",
+					"err", err,
+				)
+			}
+			if err := d.wal.close(); err != nil {
+				d.logger.Error("failed to close write-ahead log",
+					"err", err,
+				)
+			}
+		}
+
 		if err := d.db.Close(); err != nil {
 			d.logger.Error("close returned error",
 				"err", err,
@@ -948,17 +1784,40 @@ type badgerBatch struct {
 	// readTx is the read transaction used to check for node existence during
 	// a multipart restore.
 	readTxn *badger.Txn
+	// duplicateKeys tracks the leaf keys put into the batch during a multipart restore.
+	duplicateKeys *api.DuplicateKeyBatch
+	// childRefs are the hashes of the nodes referenced, but not included, by the nodes put into
+	// a chunk batch in strict mode. Their existence is verified on commit.
+	childRefs []hash.Hash
 
 	oldRoot node.Root
+	version uint64
 	chunk   bool
 
 	writeLog     writelog.WriteLog
 	annotations  writelog.Annotations
 	updatedNodes []updatedNode
+
+	// secondaryHashes are the secondary hashes of the nodes put into this batch, indexed by
+	// their primary hash.
+	secondaryHashes map[hash.Hash]hash.Hash
+
+	// encodedWriteLog is the serialized write log stored on commit.
+	encodedWriteLog []byte
+	// walNodes are the serialized nodes put into this batch that are recorded in the write-ahead
+	// log on commit.
+	walNodes [][]byte
+	// replayed specifies whether the batch is being replayed from the write-ahead log, in which
+	// case it must not be logged again.
+	replayed bool
 }
 
 // Implements api.Batch.
 func (ba *badgerBatch) PutWriteLog(writeLog writelog.WriteLog, annotations writelog.Annotations) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
 	if ba.chunk {
 		return fmt.Errorf("mkvs/badger: cannot put write log in chunk mode")
 	}
@@ -971,11 +1830,25 @@ func (ba *badgerBatch) PutWriteLog(writeLog writelog.WriteLog, annotations write
 	return nil
 }
 
+// Implements api.Batch.
+func (ba *badgerBatch) PrefetchKeys(keys []node.Key) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	return api.PrefetchKeys(context.Background(), ba.db, ba.oldRoot, keys)
+}
+
 // Implements api.Batch.
 func (ba *badgerBatch) RemoveNodes(nodes []*node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
 	if ba.chunk {
 		return fmt.Errorf("mkvs/badger: cannot remove nodes in chunk mode")
 	}
+	ba.TrackPendingNodes(nodes...)
 
 	for _, ptr := range nodes {
 		ba.updatedNodes = append(ba.updatedNodes, updatedNode{
@@ -988,42 +1861,81 @@ func (ba *badgerBatch) RemoveNodes(nodes []*node.Pointer) error {
 
 // Implements api.Batch.
 func (ba *badgerBatch) Commit(root node.Root) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	if err := ba.db.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer ba.db.quiescer.ExitWrite()
+
 	ba.db.metaUpdateLock.Lock()
 	defer ba.db.metaUpdateLock.Unlock()
 
+	return ba.commitLocked(root)
+}
+
+// Assumes metaUpdateLock is held when called.
+func (ba *badgerBatch) commitLocked(root node.Root) error {
+	tx := ba.db.db.NewTransactionAt(versionToTs(root.Version), true)
+	defer tx.Discard()
+
+	walPos := ba.db.walPositionLocked()
+	exists, err := ba.prepareCommitLocked(tx, root)
+	if err != nil {
+		ba.db.abortWALLocked(walPos)
+		return err
+	}
+	if exists {
+		ba.Reset()
+		return ba.BaseBatch.Commit(root)
+	}
+
+	// Commit root metadata updates. This is done last, so in case we fail, we can still retry.
+	if err = tx.CommitAt(tsMetadata, nil); err != nil {
+		ba.db.abortWALLocked(walPos)
+		return err
+	}
+	return ba.finishCommitLocked(root)
+}
+
+// prepareCommitLocked flushes the node updates of the batch and records the root metadata
+// updates in the given transaction which must be committed by the caller before calling
+// finishCommitLocked. In case the root already exists, nothing is recorded and true is returned.
+//
+// Assumes metaUpdateLock is held when called.
+func (ba *badgerBatch) prepareCommitLocked(tx *badger.Txn, root node.Root) (bool, error) {
 	if ba.db.multipartVersion != multipartVersionNone && ba.db.multipartVersion != root.Version {
-		return api.ErrInvalidMultipartVersion
+		return false, api.ErrInvalidMultipartVersion
 	}
 
 	if err := ba.db.sanityCheckNamespace(root.Namespace); err != nil {
-		return err
+		return false, err
 	}
 	if !root.Follows(&ba.oldRoot) {
-		return api.ErrRootMustFollowOld
+		return false, api.ErrRootMustFollowOld
 	}
 
 	// Make sure that the version that we try to commit into has not yet been finalized.
 	lastFinalizedVersion, exists := ba.db.meta.getLastFinalizedVersion()
 	if exists && lastFinalizedVersion >= root.Version {
-		return api.ErrAlreadyFinalized
+		return false, api.ErrAlreadyFinalized
 	}
 
 	// Update the set of roots for this version.
-	tx := ba.db.db.NewTransactionAt(versionToTs(root.Version), true)
-	defer tx.Discard()
-
 	rootsMeta, err := loadRootsMetadata(tx, root.Version)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	rootHash := api.TypedHashFromRoot(root)
 	if err = ba.bat.Set(rootNodeKeyFmt.Encode(&rootHash), []byte{}); err != nil {
-		return err
+		return false, err
 	}
 	if ba.multipartNodes != nil {
 		if err = ba.multipartNodes.Set(multipartRestoreNodeLogKeyFmt.Encode(&rootHash), []byte{}); err != nil {
-			return err
+			return false, err
 		}
 	}
 
@@ -1033,15 +1945,14 @@ func (ba *badgerBatch) Commit(root node.Root) error {
 		//
 		// If we are importing a chunk, there can be multiple commits for the same root.
 		if !ba.chunk {
-			ba.Reset()
-			return ba.BaseBatch.Commit(root)
+			return true, nil
 		}
 	} else {
 		// Create root with no derived roots.
 		rootsMeta.Roots[rootHash] = []api.TypedHash{}
 
 		if err = rootsMeta.save(tx); err != nil {
-			return fmt.Errorf("mkvs/badger: failed to save roots metadata: %w", err)
+			return false, fmt.Errorf("mkvs/badger: failed to save roots metadata: %w", err)
 		}
 	}
 
@@ -1049,71 +1960,128 @@ func (ba *badgerBatch) Commit(root node.Root) error {
 		// Skip most of metadata updates if we are just importing chunks.
 		key := rootUpdatedNodesKeyFmt.Encode(root.Version, &rootHash)
 		if err = tx.Set(key, cbor.Marshal([]updatedNode{})); err != nil {
-			return fmt.Errorf("mkvs/badger: set returned error: %w", err)
+			return false, fmt.Errorf("mkvs/badger: set returned error: %w", err)
 		}
 	} else {
 		// Update the root link for the old root.
 		oldRootHash := api.TypedHashFromRoot(ba.oldRoot)
-		if !ba.oldRoot.Hash.IsEmpty() {
+		if !ba.oldRoot.IsEmptyTree() {
 			if ba.oldRoot.Version < ba.db.meta.getEarliestVersion() && ba.oldRoot.Version != root.Version {
-				return api.ErrPreviousVersionMismatch
+				return false, api.ErrPreviousVersionMismatch
 			}
 
 			var oldRootsMeta *rootsMetadata
 			oldRootsMeta, err = loadRootsMetadata(tx, ba.oldRoot.Version)
 			if err != nil {
-				return err
+				return false, err
 			}
 
 			if _, ok := oldRootsMeta.Roots[oldRootHash]; !ok {
-				return api.ErrRootNotFound
+				return false, api.ErrRootNotFound
 			}
 
 			oldRootsMeta.Roots[oldRootHash] = append(oldRootsMeta.Roots[oldRootHash], rootHash)
 			if err = oldRootsMeta.save(tx); err != nil {
-				return fmt.Errorf("mkvs/badger: failed to save old roots metadata: %w", err)
+				return false, fmt.Errorf("mkvs/badger: failed to save old roots metadata: %w", err)
 			}
 		}
 
 		// Store updated nodes (only needed until the version is finalized).
 		key := rootUpdatedNodesKeyFmt.Encode(root.Version, &rootHash)
 		if err = tx.Set(key, cbor.Marshal(ba.updatedNodes)); err != nil {
-			return fmt.Errorf("mkvs/badger: set returned error: %w", err)
+			return false, fmt.Errorf("mkvs/badger: set returned error: %w", err)
 		}
 
 		// Store write log.
-		if ba.writeLog != nil && ba.annotations != nil {
+		if ba.encodedWriteLog == nil && ba.writeLog != nil && ba.annotations != nil {
 			log := api.MakeHashedDBWriteLog(ba.writeLog, ba.annotations)
-			bytes := cbor.Marshal(log)
+			if ba.encodedWriteLog, err = ba.db.marshalWriteLog(log); err != nil {
+				return false, fmt.Errorf("mkvs/badger: failed to marshal write log: %w", err)
+			}
+		}
+		if ba.encodedWriteLog != nil {
 			key := writeLogKeyFmt.Encode(root.Version, &rootHash, &oldRootHash)
-			if err = ba.bat.Set(key, bytes); err != nil {
-				return fmt.Errorf("mkvs/badger: set new write log returned error: %w", err)
+			if err = ba.bat.Set(key, ba.encodedWriteLog); err != nil {
+				return false, fmt.Errorf("mkvs/badger: set new write log returned error: %w", err)
 			}
 		}
+
+	}
+
+	if err = ba.checkChildRefs(tx); err != nil {
+		return false, err
+	}
+
+	// Make sure no other batch has imported any of the same keys in the meantime.
+	if err = ba.duplicateKeys.Check(); err != nil {
+		return false, err
 	}
 
 	// Flush node updates.
 	if ba.multipartNodes != nil {
 		if err = ba.multipartNodes.Flush(); err != nil {
-			return fmt.Errorf("mkvs/badger: failed to flush node log batch: %w", err)
+			return false, fmt.Errorf("mkvs/badger: failed to flush node log batch: %w", err)
 		}
 	}
 	if err = ba.bat.Flush(); err != nil {
-		return fmt.Errorf("mkvs/badger: failed to flush batch: %w", err)
+		return false, fmt.Errorf("mkvs/badger: failed to flush batch: %w", err)
+	}
+
+	// Log the batch once it has been validated and right before the root metadata is committed,
+	// so that it can be replayed in case of a crash. In case the commit fails afterwards, the
+	// caller must abort the record (see abortWALLocked).
+	if !ba.chunk && ba.db.wal != nil && !ba.replayed {
+		err = ba.db.wal.append(&walRecord{
+			OldRoot:      ba.oldRoot,
+			Root:         root,
+			Nodes:        ba.walNodes,
+			UpdatedNodes: ba.updatedNodes,
+			WriteLog:     ba.encodedWriteLog,
+		})
+		if err != nil {
+			return false, err
+		}
 	}
 
-	// Commit root metadata updates. This is done last, so in case we fail, we can still retry.
-	if err = tx.CommitAt(tsMetadata, nil); err != nil {
-		return err
-	}
+	return false, nil
+}
+
+// finishCommitLocked finishes the commit of the batch once the root metadata updates recorded by
+// prepareCommitLocked have been committed.
+//
+// Assumes metaUpdateLock is held when called.
+func (ba *badgerBatch) finishCommitLocked(root node.Root) error {
+	ba.duplicateKeys.Commit()
 
 	ba.writeLog = nil
 	ba.annotations = nil
 	ba.updatedNodes = nil
+	ba.secondaryHashes = nil
+	ba.encodedWriteLog = nil
+	ba.walNodes = nil
+
+	// Bound the amount of data that needs to be replayed after a crash.
+	if ba.db.wal != nil && !ba.replayed && ba.db.wal.size >= walCheckpointSize {
+		if err := ba.db.checkpointWALLocked(); err != nil {
+			return err
+		}
+	}
 
 	return ba.BaseBatch.Commit(root)
 }
 
+// Implements api.Batch.
+func (ba *badgerBatch) CommitExpecting(expectedRoot node.Root) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	if err := ba.CheckExpectedRoot(expectedRoot); err != nil {
+		return err
+	}
+	return ba.Commit(expectedRoot)
+}
+
 // Implements api.Batch.
 func (ba *badgerBatch) Reset() {
 	ba.bat.Cancel()
@@ -1124,14 +2092,73 @@ func (ba *badgerBatch) Reset() {
 	ba.writeLog = nil
 	ba.annotations = nil
 	ba.updatedNodes = nil
+	ba.secondaryHashes = nil
+	ba.encodedWriteLog = nil
+	ba.walNodes = nil
+	ba.duplicateKeys.Reset()
+	ba.childRefs = nil
+	ba.BaseBatch.Reset()
+}
+
+// Implements api.Batch.
+func (ba *badgerBatch) Discard() {
+	if ba.Discarded() {
+		return
+	}
+
+	// Reset already cancels any pending writes and releases the transactions.
+	ba.Reset()
+	ba.BaseBatch.Discard()
 }
 
 // Implements api.Batch.
 func (ba *badgerBatch) PutNode(ptr *node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	return ba.putNode(ptr)
+}
+
+// Implements api.Batch.
+func (ba *badgerBatch) PutNodeBatch(ptrs []*node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	if ba.db.wal != nil && !ba.chunk && !ba.replayed {
+		ba.walNodes = slices.Grow(ba.walNodes, len(ptrs))
+	}
+	ba.updatedNodes = slices.Grow(ba.updatedNodes, len(ptrs))
+	for _, ptr := range ptrs {
+		if err := ba.putNode(ptr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ba *badgerBatch) putNode(ptr *node.Pointer) error {
 	data, err := ptr.Node.MarshalBinary()
 	if err != nil {
 		return err
 	}
+	if err = ba.TrackNodeSize(len(data)); err != nil {
+		return err
+	}
+
+	if err = ba.duplicateKeys.PutNode(ptr.Node); err != nil {
+		return err
+	}
+	if ba.chunk && ba.db.strictChunkCommit {
+		ba.trackChildRefs(ptr.Node)
+	}
+
+	ba.TrackPendingNodes(ptr)
+
+	if ba.db.wal != nil && !ba.chunk && !ba.replayed {
+		ba.walNodes = append(ba.walNodes, data)
+	}
 
 	h := ptr.Node.GetHash()
 	ba.updatedNodes = append(ba.updatedNodes, updatedNode{Hash: h})
@@ -1145,15 +2172,87 @@ func (ba *badgerBatch) PutNode(ptr *node.Pointer) error {
 		}
 	}
 
-	return ba.bat.Set(nodeKey, data)
+	// Chunks may reference nodes which are not yet available, so secondary hashes cannot be
+	// computed during a multipart restore. They are backfilled once the nodes are referenced
+	// by a later version.
+	if ba.db.secondaryHasher != nil && !ba.chunk {
+		if err = ba.putSecondaryHash(ptr.Node); err != nil {
+			return err
+		}
+	}
+
+	stored, err := ba.db.encodeNode(ptr.Node, data)
+	if err != nil {
+		return err
+	}
+	if err = ba.bat.Set(nodeKey, stored); err != nil {
+		return err
+	}
+	if ba.chunk {
+		ba.db.multipartProgress.NodeWritten(len(stored))
+	}
+	return nil
+}
+
+// trackChildRefs records the hashes of the nodes referenced, but not included, by the given node
+// so that their existence can be verified on commit.
+func (ba *badgerBatch) trackChildRefs(n node.Node) {
+	intNode, ok := n.(*node.InternalNode)
+	if !ok {
+		return
+	}
+	for _, child := range []*node.Pointer{intNode.LeafNode, intNode.Left, intNode.Right} {
+		if child == nil || child.Node != nil || node.IsEmptyTreeHash(child.Hash) {
+			continue
+		}
+		ba.childRefs = append(ba.childRefs, child.Hash)
+	}
+}
+
+// checkChildRefs makes sure that all nodes referenced by the nodes put into a chunk batch in
+// strict mode exist either in the batch or in the database.
+func (ba *badgerBatch) checkChildRefs(tx *badger.Txn) error {
+	if len(ba.childRefs) == 0 {
+		return nil
+	}
+
+	stored := make(map[hash.Hash]struct{}, len(ba.updatedNodes))
+	for _, n := range ba.updatedNodes {
+		stored[n.Hash] = struct{}{}
+	}
+	for _, h := range ba.childRefs {
+		if _, ok := stored[h]; ok {
+			continue
+		}
+
+		_, err := tx.Get(nodeKeyFmt.Encode(&h))
+		switch {
+		case err == nil:
+		case errors.Is(err, badger.ErrKeyNotFound):
+			return &api.MissingChildNodeError{Hash: h}
+		default:
+			return fmt.Errorf("mkvs/badger: failed to check child node existence: %w", err)
+		}
+	}
+	return nil
 }
 
 // Implements api.Batch.
-func (ba *badgerBatch) VisitCleanNode(*node.Pointer, *node.Pointer) error {
+func (ba *badgerBatch) VisitCleanNode(ptr *node.Pointer, parent *node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	ba.TrackCleanNode(ptr, parent)
 	return nil
 }
 
 // Implements api.Batch.
-func (ba *badgerBatch) VisitDirtyNode(*node.Pointer, *node.Pointer) error {
+func (ba *badgerBatch) VisitDirtyNode(ptr *node.Pointer, parent *node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	ba.TrackDirtyNode(ptr, parent)
 	return nil
 }
@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/stretchr/testify/require"
 
 	"github.com/oasisprotocol/oasis-core/go/common"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/checkpoint"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
@@ -223,6 +228,7 @@ func TestMultipartRestore(t *testing.T) {
 	t.Run("Abort", wrap(testAbort, testValues))
 	t.Run("Finalize", wrap(testFinalize, testValues))
 	t.Run("ExistingNodes", wrap(testExistingNodes, testValues[:1]))
+	t.Run("CheckComplete", wrap(testCheckComplete, testValues))
 }
 
 func testAbort(ctx *test) {
@@ -294,6 +300,43 @@ func testExistingNodes(ctx *test) {
 	verifyNodes(ctx.require, ctx.badgerdb, ctx.ckNodes)
 }
 
+func testCheckComplete(ctx *test) {
+	root := ctx.ckMeta.Root
+
+	// Nothing has been restored yet.
+	missing, err := ctx.badgerdb.CheckComplete(ctx.ctx, root)
+	ctx.require.NoError(err, "CheckComplete()")
+	ctx.require.Equal([]hash.Hash{root.Hash}, missing, "the root node should be missing before the restore")
+
+	// The restored root should be complete even though it is not yet finalized.
+	restoreCheckpoint(ctx, ctx.ckMeta, ctx.ckNodes)
+	missing, err = ctx.badgerdb.CheckComplete(ctx.ctx, root)
+	ctx.require.NoError(err, "CheckComplete()")
+	ctx.require.Empty(missing, "the restored root should be complete")
+
+	// Simulate a half-imported root by removing one of the child nodes.
+	tx := ctx.badgerdb.db.NewTransactionAt(versionToTs(root.Version), true)
+	defer tx.Discard()
+	item, err := tx.Get(nodeKeyFmt.Encode(&root.Hash))
+	ctx.require.NoError(err, "Get(root)")
+	var rootNode node.Node
+	err = item.Value(func(val []byte) error {
+		rootNode, err = decodeNode(val)
+		return err
+	})
+	ctx.require.NoError(err, "decodeNode(root)")
+	childHash := rootNode.(*node.InternalNode).Left.Hash
+
+	err = tx.Delete(nodeKeyFmt.Encode(&childHash))
+	ctx.require.NoError(err, "Delete(child)")
+	err = tx.CommitAt(versionToTs(root.Version), nil)
+	ctx.require.NoError(err, "CommitAt()")
+
+	missing, err = ctx.badgerdb.CheckComplete(ctx.ctx, root)
+	ctx.require.NoError(err, "CheckComplete()")
+	ctx.require.Equal([]hash.Hash{childHash}, missing, "the removed child node should be reported as missing")
+}
+
 func TestVersionChecks(t *testing.T) {
 	require := require.New(t)
 	ndb, err := New(dbCfg)
@@ -322,6 +365,74 @@ func TestVersionChecks(t *testing.T) {
 	require.Error(err, "Commit(Root{0})")
 }
 
+func TestDuplicateKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		bloom bool
+	}{
+		{"Exact", false},
+		{"BloomFilter", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			require := require.New(t)
+
+			dir, err := os.MkdirTemp("", "oasis-storage-database-test")
+			require.NoError(err, "TempDir()")
+			defer os.RemoveAll(dir)
+
+			ckMeta, ckNodes := createCheckpoint(ctx, require, dir, testValues, 1)
+
+			cfg := *dbCfg
+			cfg.DetectDuplicateKeys = true
+			cfg.DuplicateKeysBloomFilter = tc.bloom
+			ndb, err := New(&cfg)
+			require.NoError(err, "New() - 2")
+			defer ndb.Close()
+			badgerdb := ndb.(*badgerNodeDB)
+
+			// A clean import should succeed.
+			restorer := restoreCheckpoint(&test{
+				require:  require,
+				ctx:      ctx,
+				dir:      dir,
+				badgerdb: badgerdb,
+			}, ckMeta, ckNodes)
+
+			// Importing an already imported key should fail.
+			root := node.Root{
+				Namespace: testNs,
+				Version:   ckMeta.Root.Version,
+				Type:      node.RootTypeState,
+			}
+			root.Hash.Empty()
+			batch, err := badgerdb.NewBatch(root, root.Version, true)
+			require.NoError(err, "NewBatch()")
+
+			putLeaf := func(key, value []byte) error {
+				leaf := &node.LeafNode{Key: key, Value: value}
+				leaf.UpdateHash()
+				return batch.PutNode(&node.Pointer{Clean: true, Hash: leaf.Hash, Node: leaf})
+			}
+
+			err = putLeaf([]byte("new key"), []byte("value"))
+			require.NoError(err, "PutNode(new key)")
+
+			err = putLeaf([]byte("0"), []byte("other value"))
+			require.ErrorIs(err, api.ErrDuplicateKey, "PutNode(duplicate key)")
+			var dupErr *api.DuplicateKeyError
+			require.ErrorAs(err, &dupErr)
+			require.EqualValues([]byte("0"), dupErr.Key)
+
+			batch.Reset()
+			err = restorer.AbortRestore(ctx)
+			require.NoError(err, "AbortRestore()")
+			err = badgerdb.AbortMultipartInsert()
+			require.NoError(err, "AbortMultipartInsert()")
+		})
+	}
+}
+
 func TestReadOnlyBatch(t *testing.T) {
 	require := require.New(t)
 
@@ -352,6 +463,428 @@ func TestReadOnlyBatch(t *testing.T) {
 	require.Error(err, "NewBatch()")
 }
 
+func TestWriteLogFormatVersion(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "oasis-storage-database-test")
+	require.NoError(err, "TempDir()")
+	defer os.RemoveAll(dir)
+
+	cfg := *dbCfg
+	cfg.MemoryOnly = false
+	cfg.DB = dir
+
+	// Unknown versions should be refused when creating a database.
+	badCfg := cfg
+	badCfg.WriteLogFormatVersion = 42
+	_, err = New(&badCfg)
+	require.ErrorIs(err, api.ErrUnsupportedWriteLogFormat, "New() with unknown write log format")
+
+	// Create a database with the default write log format and then corrupt the stored version.
+	func() {
+		ndb, errNew := New(&cfg)
+		require.NoError(errNew, "New()")
+		defer ndb.Close()
+		badgerdb := ndb.(*badgerNodeDB)
+		require.EqualValues(api.DefaultWriteLogFormatVersion, badgerdb.writeLogFormatVersion)
+		require.EqualValues(api.DefaultWriteLogFormatVersion, badgerdb.meta.value.WriteLogFormatVersion)
+
+		tx := badgerdb.db.NewTransactionAt(tsMetadata, true)
+		defer tx.Discard()
+		badgerdb.meta.value.WriteLogFormatVersion = 42
+		require.NoError(badgerdb.meta.save(tx), "meta.save()")
+		require.NoError(tx.CommitAt(tsMetadata, nil), "CommitAt()")
+	}()
+
+	_, err = New(&cfg)
+	require.ErrorIs(err, api.ErrUnsupportedWriteLogFormat, "New() with unknown stored write log format")
+}
+
+func TestDBVersion(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "oasis-storage-database-test")
+	require.NoError(err, "TempDir()")
+	defer os.RemoveAll(dir)
+
+	cfg := *dbCfg
+	cfg.MemoryOnly = false
+	cfg.DB = dir
+
+	setVersion := func(version uint64) {
+		ndb, errNew := New(&cfg)
+		require.NoError(errNew, "New()")
+		defer ndb.Close()
+		badgerdb := ndb.(*badgerNodeDB)
+		require.EqualValues(dbVersion, badgerdb.meta.value.Version)
+
+		tx := badgerdb.db.NewTransactionAt(tsMetadata, true)
+		defer tx.Discard()
+		badgerdb.meta.value.Version = version
+		require.NoError(badgerdb.meta.save(tx), "meta.save()")
+		require.NoError(tx.CommitAt(tsMetadata, nil), "CommitAt()")
+	}
+
+	// Newer on-disk versions should be refused.
+	setVersion(dbVersion + 1)
+	_, err = New(&cfg)
+	require.ErrorIs(err, api.ErrUnsupportedDBVersion, "New() with newer stored version")
+	require.ErrorContains(err, fmt.Sprintf("on-disk version %d is newer than the maximum supported version %d", dbVersion+1, dbVersion))
+
+	// Older on-disk versions should be refused as well.
+	require.NoError(os.RemoveAll(dir), "RemoveAll()")
+	setVersion(minDBVersion - 1)
+	_, err = New(&cfg)
+	require.ErrorIs(err, api.ErrUnsupportedDBVersion, "New() with older stored version")
+	require.ErrorContains(err, fmt.Sprintf("on-disk version %d is older than the minimum supported version %d", minDBVersion-1, minDBVersion))
+}
+
+func TestCacheUsage(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	// The block cache is only used for data read from disk, so persistence is needed.
+	dir, err := os.MkdirTemp("", "oasis-storage-database-test")
+	require.NoError(err, "TempDir()")
+	defer os.RemoveAll(dir)
+
+	cfg := *dbCfg
+	cfg.MemoryOnly = false
+	cfg.DB = dir
+
+	// Populate the database and reopen it so that everything is flushed to disk.
+	func() {
+		ndb, errNew := New(&cfg)
+		require.NoError(errNew, "New() - 1")
+		defer ndb.Close()
+
+		values := make([][]byte, 0, 1000)
+		for i := 0; i < cap(values); i++ {
+			values = append(values, bytes.Repeat([]byte{byte(i)}, 128))
+		}
+		fillDB(ctx, require, values, nil, 0, 0, ndb)
+	}()
+
+	ndb, err := New(&cfg)
+	require.NoError(err, "New() - 2")
+	defer ndb.Close()
+	badgerdb := ndb.(*badgerNodeDB)
+
+	initialBytes, initialEntries, err := ndb.CacheUsage()
+	require.NoError(err, "CacheUsage()")
+
+	// Reading the data should populate the cache.
+	err = badgerdb.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if _, err := it.Item().ValueCopy(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(err, "View()")
+
+	// Cache updates are applied asynchronously.
+	var peakBytes int64
+	require.Eventually(func() bool {
+		usedBytes, entries, errUsage := ndb.CacheUsage()
+		require.NoError(errUsage, "CacheUsage()")
+		peakBytes = usedBytes
+		return usedBytes > initialBytes && entries > initialEntries
+	}, 10*time.Second, 10*time.Millisecond, "cache usage should grow after reads")
+	require.LessOrEqual(peakBytes, cfg.MaxCacheSize, "cache usage should be bounded")
+
+	// Dropping all data evicts everything from the cache.
+	err = badgerdb.db.DropAll()
+	require.NoError(err, "DropAll()")
+
+	usedBytes, entries, err := ndb.CacheUsage()
+	require.NoError(err, "CacheUsage()")
+	require.Less(usedBytes, peakBytes, "cache usage should shrink after eviction")
+	require.Zero(entries, "cache should be empty after eviction")
+}
+
+type testSecondaryHasher struct{}
+
+func (testSecondaryHasher) Hash(data ...[]byte) hash.Hash {
+	return hash.NewFromBytes(append([][]byte{[]byte("secondary")}, data...)...)
+}
+
+func TestSecondaryHashes(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	// Secondary hashes are disabled by default.
+	ndb, err := New(dbCfg)
+	require.NoError(err, "New()")
+	err = ndb.VerifySecondaryHashes(node.Root{Namespace: testNs, Type: node.RootTypeState})
+	require.ErrorIs(err, api.ErrSecondaryHashesDisabled, "VerifySecondaryHashes() should fail when disabled")
+	ndb.Close()
+
+	cfg := *dbCfg
+	cfg.SecondaryHasher = testSecondaryHasher{}
+	ndb, err = New(&cfg)
+	require.NoError(err, "New()")
+	defer ndb.Close()
+	badgerdb := ndb.(*badgerNodeDB)
+
+	tree := mkvs.New(nil, ndb, node.RootTypeState)
+	for i := 0; i < 100; i++ {
+		err = tree.Insert(ctx, []byte(strconv.Itoa(i)), []byte(fmt.Sprintf("value %d", i)))
+		require.NoError(err, "Insert()")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(err, "Commit()")
+	root0 := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	err = ndb.Finalize([]node.Root{root0})
+	require.NoError(err, "Finalize()")
+
+	// There should be a secondary hash for each node and it should differ from the primary one.
+	countKeys := func(prefix []byte) int {
+		var count int
+		err = badgerdb.db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				count++
+			}
+			return nil
+		})
+		require.NoError(err, "View()")
+		return count
+	}
+	require.NotZero(countKeys(nodePrefix), "there should be some nodes")
+	require.Equal(countKeys(nodePrefix), countKeys(secondaryHashKeyFmt.Encode()), "each node should have a secondary hash")
+
+	tx := badgerdb.db.NewTransactionAt(versionToTs(0), false)
+	secondaryRootHash, err := getSecondaryHash(tx, rootHash)
+	tx.Discard()
+	require.NoError(err, "getSecondaryHash()")
+	require.False(secondaryRootHash.Equal(&rootHash), "secondary hash should differ from the primary hash")
+
+	err = ndb.VerifySecondaryHashes(root0)
+	require.NoError(err, "VerifySecondaryHashes()")
+
+	// Secondary hashes of clean subtrees should be reused by later versions.
+	tree = mkvs.NewWithRoot(nil, ndb, root0)
+	err = tree.Insert(ctx, []byte("1"), []byte("updated value"))
+	require.NoError(err, "Insert()")
+	_, rootHash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(err, "Commit()")
+	root1 := node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}
+
+	err = ndb.VerifySecondaryHashes(root1)
+	require.NoError(err, "VerifySecondaryHashes()")
+
+	// Tampering with a secondary hash should be detected.
+	batch := badgerdb.db.NewWriteBatchAt(versionToTs(1))
+	err = batch.Set(secondaryHashKeyFmt.Encode(&rootHash), secondaryRootHash[:])
+	require.NoError(err, "Set()")
+	err = batch.Flush()
+	require.NoError(err, "Flush()")
+
+	err = ndb.VerifySecondaryHashes(root1)
+	require.ErrorIs(err, api.ErrSecondaryHashMismatch, "VerifySecondaryHashes() should detect tampering")
+	err = ndb.VerifySecondaryHashes(root0)
+	require.NoError(err, "VerifySecondaryHashes() of an untampered version")
+}
+
+func TestFingerprint(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	populate := func(ndb api.NodeDB, reverse bool) {
+		for version := uint64(0); version < 3; version++ {
+			rootTypes := []node.RootType{node.RootTypeState, node.RootTypeIO}
+			if reverse {
+				rootTypes = []node.RootType{node.RootTypeIO, node.RootTypeState}
+			}
+
+			var roots []node.Root
+			for _, rootType := range rootTypes {
+				tree := mkvs.New(nil, ndb, rootType)
+				err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", version)), []byte(rootType.String()))
+				require.NoError(err, "Insert()")
+				_, rootHash, err := tree.Commit(ctx, testNs, version)
+				require.NoError(err, "Commit()")
+				roots = append(roots, node.Root{Namespace: testNs, Version: version, Type: rootType, Hash: rootHash})
+				tree.Close()
+			}
+			err := ndb.Finalize(roots)
+			require.NoError(err, "Finalize()")
+		}
+	}
+
+	ndb1, err := New(dbCfg)
+	require.NoError(err, "New()")
+	defer ndb1.Close()
+	ndb2, err := New(dbCfg)
+	require.NoError(err, "New()")
+	defer ndb2.Close()
+
+	emptyFp, err := ndb1.Fingerprint(ctx)
+	require.NoError(err, "Fingerprint()")
+
+	populate(ndb1, false)
+	populate(ndb2, true)
+
+	fp1, err := ndb1.Fingerprint(ctx)
+	require.NoError(err, "Fingerprint()")
+	fp2, err := ndb2.Fingerprint(ctx)
+	require.NoError(err, "Fingerprint()")
+	require.Equal(fp1, fp2, "equivalent databases should have identical fingerprints")
+	require.NotEqual(emptyFp, fp1, "populated database should have a different fingerprint")
+
+	fp, err := ndb1.Fingerprint(ctx)
+	require.NoError(err, "Fingerprint()")
+	require.Equal(fp1, fp, "fingerprint should be stable")
+
+	// Adding another version changes the fingerprint.
+	tree := mkvs.New(nil, ndb2, node.RootTypeState)
+	err = tree.Insert(ctx, []byte("another key"), []byte("another value"))
+	require.NoError(err, "Insert()")
+	_, _, err = tree.Commit(ctx, testNs, 3)
+	require.NoError(err, "Commit()")
+	fp2, err = ndb2.Fingerprint(ctx)
+	require.NoError(err, "Fingerprint()")
+	require.NotEqual(fp1, fp2, "modified database should have a different fingerprint")
+
+	// Cancelled contexts are respected.
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ndb1.Fingerprint(cancelledCtx)
+	require.ErrorIs(err, context.Canceled)
+}
+
+func TestWriteAheadLog(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	dir, err := os.MkdirTemp("", "oasis-storage-database-test")
+	require.NoError(err, "TempDir()")
+	defer os.RemoveAll(dir)
+
+	cfg := *dbCfg
+	cfg.MemoryOnly = false
+	cfg.DB = filepath.Join(dir, "db")
+	cfg.WALPath = filepath.Join(dir, "wal")
+
+	// Read-only databases cannot use a write-ahead log.
+	roCfg := cfg
+	roCfg.ReadOnly = true
+	_, err = New(&roCfg)
+	require.Error(err, "New() should fail in read-only mode")
+
+	ndb, err := New(&cfg)
+	require.NoError(err, "New()")
+	badgerdb := ndb.(*badgerNodeDB)
+
+	tree := mkvs.New(nil, ndb, node.RootTypeState)
+	for i := 0; i < 50; i++ {
+		err = tree.Insert(ctx, []byte(strconv.Itoa(i)), []byte(fmt.Sprintf("value %d", i)))
+		require.NoError(err, "Insert()")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(err, "Commit()")
+	root0 := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	err = tree.Insert(ctx, []byte("1"), []byte("updated value"))
+	require.NoError(err, "Insert()")
+	_, rootHash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(err, "Commit()")
+	root1 := node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}
+	tree.Close()
+
+	recs, err := badgerdb.wal.records()
+	require.NoError(err, "records()")
+	require.Len(recs, 2, "each commit should be logged")
+	require.Equal(root0, recs[0].Root)
+	require.Equal(root1, recs[1].Root)
+
+	// A batch whose commit fails should not be logged.
+	batch, err := ndb.NewBatch(root1, 2, false)
+	require.NoError(err, "NewBatch()")
+	root2 := root1
+	root2.Version = 2
+	invalidRoot := root2
+	invalidRoot.Version = 3
+	err = ndb.CommitFinalizeAndPrune(batch, []node.Root{root2, invalidRoot}, 0)
+	require.Error(err, "CommitFinalizeAndPrune() should fail with mismatched root versions")
+	require.False(ndb.HasRoot(root2), "failed commit should not commit the root")
+	recs, err = badgerdb.wal.records()
+	require.NoError(err, "records()")
+	require.Len(recs, 2, "failed commit should not be logged")
+	batch.Discard()
+
+	// Simulate a crash by keeping a copy of the log before it is checkpointed on close.
+	walData, err := os.ReadFile(cfg.WALPath)
+	require.NoError(err, "ReadFile()")
+	ndb.Close()
+
+	fi, err := os.Stat(cfg.WALPath)
+	require.NoError(err, "Stat()")
+	require.Zero(fi.Size(), "write-ahead log should be truncated on close")
+
+	// Replaying the log into an empty database should restore all roots. A torn record at the
+	// end of the log should be ignored, even if its length is garbage.
+	cfg.DB = filepath.Join(dir, "recovered")
+	err = os.WriteFile(cfg.WALPath, append(walData, 0x00, 0x00, 0x10), 0o600)
+	require.NoError(err, "WriteFile()")
+	tornWAL, err := openWAL(cfg.WALPath, true)
+	require.NoError(err, "openWAL()")
+	recs, err = tornWAL.records()
+	require.NoError(err, "records()")
+	require.Len(recs, 2, "torn record should be ignored")
+	require.NoError(tornWAL.close(), "close()")
+
+	err = os.WriteFile(cfg.WALPath, append(walData, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x01), 0o600)
+	require.NoError(err, "WriteFile()")
+	tornWAL, err = openWAL(cfg.WALPath, true)
+	require.NoError(err, "openWAL()")
+	recs, err = tornWAL.records()
+	require.NoError(err, "records()")
+	require.Len(recs, 2, "record with a length exceeding the log should be ignored")
+	require.NoError(tornWAL.close(), "close()")
+
+	ndb, err = New(&cfg)
+	require.NoError(err, "New()")
+	defer ndb.Close()
+	badgerdb = ndb.(*badgerNodeDB)
+
+	require.True(ndb.HasRoot(root0), "root 0 should be recovered")
+	require.True(ndb.HasRoot(root1), "root 1 should be recovered")
+
+	tree = mkvs.NewWithRoot(nil, ndb, root1)
+	defer tree.Close()
+	value, err := tree.Get(ctx, []byte("1"))
+	require.NoError(err, "Get()")
+	require.Equal([]byte("updated value"), value)
+	value, err = tree.Get(ctx, []byte("2"))
+	require.NoError(err, "Get()")
+	require.Equal([]byte("value 2"), value)
+
+	it, err := ndb.GetWriteLog(ctx, root0, root1)
+	require.NoError(err, "GetWriteLog()")
+	more, err := it.Next()
+	require.NoError(err, "Next()")
+	require.True(more, "write log should be recovered")
+
+	recs, err = badgerdb.wal.records()
+	require.NoError(err, "records()")
+	require.Empty(recs, "write-ahead log should be truncated after recovery")
+
+	// Recovering again is a no-op.
+	err = ndb.RecoverFromWAL()
+	require.NoError(err, "RecoverFromWAL()")
+
+	// Finalization checkpoints the log.
+	err = ndb.Finalize([]node.Root{root0})
+	require.NoError(err, "Finalize()")
+	require.Zero(badgerdb.wal.size, "write-ahead log should be truncated after finalization")
+}
+
 func TestFinalizeBasic(t *testing.T) {
 	ctx := context.Background()
 	require := require.New(t)
@@ -379,3 +912,461 @@ func TestFinalizeBasic(t *testing.T) {
 	err = ndb.Finalize([]node.Root{root2})
 	require.Errorf(err, "mkvs: root not found", "Finalize({root2-broken})")
 }
+
+func TestBestEffortOpen(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	cfg := *dbCfg
+	cfg.MemoryOnly = false
+	cfg.DB = t.TempDir()
+	ndb, err := New(&cfg)
+	require.NoError(err, "New()")
+
+	for version := uint64(0); version < 5; version++ {
+		tree := mkvs.New(nil, ndb, node.RootTypeState)
+		err = tree.Insert(ctx, []byte("key"), []byte(fmt.Sprintf("value %d", version)))
+		require.NoError(err, "Insert()")
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(err, "Commit()")
+		tree.Close()
+
+		err = ndb.Finalize([]node.Root{{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}})
+		require.NoError(err, "Finalize()")
+	}
+
+	// Corrupt the roots metadata of one of the versions.
+	bdb := ndb.(*badgerNodeDB)
+	tx := bdb.db.NewTransactionAt(tsMetadata, true)
+	err = tx.Set(rootsMetadataKeyFmt.Encode(uint64(2)), []byte{0xff})
+	require.NoError(err, "Set()")
+	err = tx.CommitAt(tsMetadata, nil)
+	require.NoError(err, "CommitAt()")
+	ndb.Close()
+
+	// Best-effort open requires read-only mode.
+	cfg.BestEffortOpen = true
+	_, err = New(&cfg)
+	require.Error(err, "New() should fail without read-only mode")
+
+	cfg.ReadOnly = true
+	ndb, err = New(&cfg)
+	require.NoError(err, "New()")
+	defer ndb.Close()
+
+	latestVersion, exists := ndb.GetLatestVersion()
+	require.True(exists, "GetLatestVersion()")
+	require.EqualValues(4, latestVersion, "latest version should be readable")
+	require.EqualValues(3, ndb.GetEarliestVersion(), "versions before the corrupted one should be skipped")
+
+	roots, err := ndb.GetRootsForVersion(3)
+	require.NoError(err, "GetRootsForVersion()")
+	require.Len(roots, 1, "readable version should expose its roots")
+	roots, err = ndb.GetRootsForVersion(2)
+	require.NoError(err, "GetRootsForVersion()")
+	require.Empty(roots, "skipped version should not expose any roots")
+}
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	src, err := New(dbCfg)
+	require.NoError(err, "New()")
+	defer src.Close()
+
+	// Build a chain of versions where each version updates a subset of the keys.
+	var roots []node.Root
+	root := node.EmptyRoot(testNs, 0, node.RootTypeState)
+	for version := uint64(0); version < 4; version++ {
+		tree := mkvs.NewWithRoot(nil, src, root)
+		for i := 0; i < 50; i++ {
+			err = tree.Insert(ctx, []byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %d/%d", i, version*uint64(i%2))))
+			require.NoError(err, "Insert()")
+		}
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(err, "Commit()")
+		tree.Close()
+
+		root = node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}
+		err = src.Finalize([]node.Root{root})
+		require.NoError(err, "Finalize()")
+		roots = append(roots, root)
+	}
+
+	dstCfg := *dbCfg
+	dstCfg.MemoryOnly = false
+	dstCfg.DB = t.TempDir()
+
+	// Versions that are not available should be rejected.
+	err = api.Clone(ctx, src, Factory, &dstCfg, 4)
+	require.ErrorIs(err, api.ErrVersionNotFound, "Clone() should fail for unavailable versions")
+
+	err = api.Clone(ctx, src, Factory, &dstCfg, 2)
+	require.NoError(err, "Clone()")
+
+	// Cloning into a non-empty database should fail.
+	err = api.Clone(ctx, src, Factory, &dstCfg, 2)
+	require.Error(err, "Clone() should fail for a non-empty destination")
+
+	dst, err := New(&dstCfg)
+	require.NoError(err, "New()")
+	defer dst.Close()
+
+	latestVersion, exists := dst.GetLatestVersion()
+	require.True(exists, "GetLatestVersion()")
+	require.EqualValues(3, latestVersion, "latest version should be cloned")
+	require.EqualValues(2, dst.GetEarliestVersion(), "earlier versions should be dropped")
+	require.False(dst.HasRoot(roots[1]), "earlier roots should be dropped")
+
+	for _, root := range roots[2:] {
+		require.True(dst.HasRoot(root), "root should be cloned")
+
+		srcTree := mkvs.NewWithRoot(nil, src, root)
+		dstTree := mkvs.NewWithRoot(nil, dst, root)
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("key %d", i))
+			expected, err := srcTree.Get(ctx, key)
+			require.NoError(err, "Get()")
+			value, err := dstTree.Get(ctx, key)
+			require.NoError(err, "Get()")
+			require.Equal(expected, value, "cloned value should match")
+		}
+		srcTree.Close()
+		dstTree.Close()
+	}
+
+	// Later roots share unchanged nodes with earlier ones, so they must survive pruning.
+	err = dst.Prune(2)
+	require.NoError(err, "Prune()")
+
+	srcTree := mkvs.NewWithRoot(nil, src, roots[3])
+	defer srcTree.Close()
+	dstTree := mkvs.NewWithRoot(nil, dst, roots[3])
+	defer dstTree.Close()
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key %d", i))
+		expected, err := srcTree.Get(ctx, key)
+		require.NoError(err, "Get()")
+		value, err := dstTree.Get(ctx, key)
+		require.NoError(err, "Get()")
+		require.Equal(expected, value, "cloned value should match after pruning")
+	}
+}
+
+// compressibleValue returns a redundant JSON value of roughly the given size.
+func compressibleValue(i, size int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for j := 0; buf.Len() < size; j++ {
+		fmt.Fprintf(&buf, `{"account":"%08d","index":%d,"balance":"1000000000","nonce":0},`, i, j)
+	}
+	buf.WriteString("null]")
+	return buf.Bytes()
+}
+
+// storedNodeSize returns the total size of all persisted nodes.
+func storedNodeSize(require *require.Assertions, badgerdb *badgerNodeDB) int {
+	tx := badgerdb.db.NewTransactionAt(math.MaxUint64, false)
+	defer tx.Discard()
+
+	it := tx.NewIterator(badger.IteratorOptions{Prefix: nodePrefix})
+	defer it.Close()
+
+	var size int
+	for it.Rewind(); it.Valid(); it.Next() {
+		val, err := it.Item().ValueCopy(nil)
+		require.NoError(err, "ValueCopy()")
+		size += len(val)
+	}
+	return size
+}
+
+func TestValueCompression(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	invalidCfg := *dbCfg
+	invalidCfg.ValueCompression = api.ValueCompression(0xff)
+	_, err := New(&invalidCfg)
+	require.Error(err, "New() should fail with an unsupported value compression")
+
+	commit := func(compression api.ValueCompression) (*badgerNodeDB, hash.Hash) {
+		cfg := *dbCfg
+		cfg.ValueCompression = compression
+		ndb, err := New(&cfg)
+		require.NoError(err, "New()")
+
+		tree := mkvs.New(nil, ndb, node.RootTypeState)
+		for i := 0; i < 50; i++ {
+			err = tree.Insert(ctx, []byte(fmt.Sprintf("large %d", i)), compressibleValue(i, 4096))
+			require.NoError(err, "Insert()")
+			err = tree.Insert(ctx, []byte(fmt.Sprintf("small %d", i)), []byte(fmt.Sprintf("value %d", i)))
+			require.NoError(err, "Insert()")
+		}
+		_, rootHash, err := tree.Commit(ctx, testNs, 0)
+		require.NoError(err, "Commit()")
+		tree.Close()
+
+		return ndb.(*badgerNodeDB), rootHash
+	}
+
+	plainDB, plainRoot := commit(api.ValueCompressionNone)
+	defer plainDB.Close()
+	compressedDB, compressedRoot := commit(api.ValueCompressionZstd)
+	defer compressedDB.Close()
+
+	require.Equal(plainRoot, compressedRoot, "compression should not affect the root hash")
+	require.Less(storedNodeSize(require, compressedDB), storedNodeSize(require, plainDB)/2,
+		"compression should reduce the stored size")
+
+	// Large leaf values should be stored compressed while small ones should be stored as is.
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: compressedRoot}
+	tree := mkvs.NewWithRoot(nil, compressedDB, root)
+	defer tree.Close()
+	for i := 0; i < 50; i++ {
+		value, err := tree.Get(ctx, []byte(fmt.Sprintf("large %d", i)))
+		require.NoError(err, "Get()")
+		require.Equal(compressibleValue(i, 4096), value, "large values should be decompressed")
+		value, err = tree.Get(ctx, []byte(fmt.Sprintf("small %d", i)))
+		require.NoError(err, "Get()")
+		require.Equal([]byte(fmt.Sprintf("value %d", i)), value, "small values should be unchanged")
+	}
+
+	tx := compressedDB.db.NewTransactionAt(math.MaxUint64, false)
+	defer tx.Discard()
+	it := tx.NewIterator(badger.IteratorOptions{Prefix: nodePrefix})
+	defer it.Close()
+	var compressed, uncompressed int
+	for it.Rewind(); it.Valid(); it.Next() {
+		val, err := it.Item().ValueCopy(nil)
+		require.NoError(err, "ValueCopy()")
+
+		n, err := decodeNode(val)
+		require.NoError(err, "decodeNode()")
+		n.UpdateHash()
+		var h hash.Hash
+		require.True(nodeKeyFmt.Decode(it.Item().Key(), &h), "nodeKeyFmt.Decode()")
+		require.Equal(h, n.GetHash(), "decoded node hash should match")
+
+		if leafValueSize(n) >= api.DefaultValueCompressionThreshold {
+			require.Equal(compressedNodePrefix, val[0], "nodes with large values should be compressed")
+			compressed++
+		} else {
+			require.NotEqual(compressedNodePrefix, val[0], "nodes with small values should not be compressed")
+			uncompressed++
+		}
+	}
+	require.NotZero(compressed, "some nodes should be compressed")
+	require.NotZero(uncompressed, "some nodes should not be compressed")
+}
+
+func BenchmarkValueCompression(b *testing.B) {
+	ctx := context.Background()
+	require := require.New(b)
+
+	for _, compression := range []api.ValueCompression{api.ValueCompressionNone, api.ValueCompressionZstd} {
+		b.Run(compression.String(), func(b *testing.B) {
+			var storedSize int
+			for i := 0; i < b.N; i++ {
+				cfg := *dbCfg
+				cfg.ValueCompression = compression
+				ndb, err := New(&cfg)
+				require.NoError(err, "New()")
+
+				tree := mkvs.New(nil, ndb, node.RootTypeState)
+				for j := 0; j < 1000; j++ {
+					err = tree.Insert(ctx, []byte(fmt.Sprintf("key %d", j)), compressibleValue(j, 2048))
+					require.NoError(err, "Insert()")
+				}
+				_, _, err = tree.Commit(ctx, testNs, 0)
+				require.NoError(err, "Commit()")
+				tree.Close()
+
+				storedSize = storedNodeSize(require, ndb.(*badgerNodeDB))
+				ndb.Close()
+			}
+			b.ReportMetric(float64(storedSize), "stored-bytes")
+		})
+	}
+}
+
+func BenchmarkPutNodeBatch(b *testing.B) {
+	require := require.New(b)
+
+	// Prepare a set of nodes to import.
+	const numNodes = 10_000
+	ptrs := make([]*node.Pointer, 0, numNodes)
+	for i := 0; i < numNodes; i++ {
+		n := &node.LeafNode{
+			Key:   []byte(fmt.Sprintf("key %d", i)),
+			Value: []byte(fmt.Sprintf("value %d", i)),
+		}
+		n.UpdateHash()
+		ptrs = append(ptrs, &node.Pointer{Clean: true, Hash: n.GetHash(), Node: n})
+	}
+	root := node.Root{
+		Namespace: testNs,
+		Type:      node.RootTypeState,
+	}
+
+	for _, tc := range []struct {
+		name string
+		put  func(api.Batch) error
+	}{
+		{"PutNode", func(batch api.Batch) error {
+			for _, ptr := range ptrs {
+				if err := batch.PutNode(ptr); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"PutNodeBatch", func(batch api.Batch) error {
+			return batch.PutNodeBatch(ptrs)
+		}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			ndb, err := New(dbCfg)
+			require.NoError(err, "New()")
+			defer ndb.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				batch, err := ndb.NewBatch(root, 0, false)
+				require.NoError(err, "NewBatch()")
+				err = tc.put(batch)
+				require.NoError(err, "PutNode()")
+				batch.Discard()
+			}
+		})
+	}
+}
+
+func TestBatchSizeLimits(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		maxNodes uint64
+		maxBytes uint64
+	}{
+		{"Nodes", 5, 0},
+		{"Bytes", 0, 256},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := *dbCfg
+			cfg.MaxBatchNodes = tc.maxNodes
+			cfg.MaxBatchBytes = tc.maxBytes
+			ndb, err := New(&cfg)
+			require.NoError(err, "New()")
+			defer ndb.Close()
+
+			tree := mkvs.New(nil, ndb, node.RootTypeState)
+			defer tree.Close()
+			for i := 0; i < 50; i++ {
+				err = tree.Insert(ctx, []byte(strconv.Itoa(i)), []byte(fmt.Sprintf("value %d", i)))
+				require.NoError(err, "Insert()")
+			}
+			_, _, err = tree.Commit(ctx, testNs, 0)
+			require.ErrorIs(err, api.ErrBatchTooLarge, "Commit() should fail on a too large batch")
+
+			var tooLarge *api.BatchTooLargeError
+			require.ErrorAs(err, &tooLarge, "error should carry the batch size")
+			if tc.maxNodes > 0 {
+				require.Equal(tc.maxNodes, tooLarge.Nodes, "batch should be refused at the node limit")
+			}
+			if tc.maxBytes > 0 {
+				require.LessOrEqual(tooLarge.Bytes, tc.maxBytes, "batch should never exceed the byte limit")
+				require.Less(tooLarge.Nodes, uint64(50), "batch should be refused before storing all nodes")
+			}
+
+			// Small batches should not be affected.
+			small := mkvs.New(nil, ndb, node.RootTypeState)
+			defer small.Close()
+			err = small.Insert(ctx, []byte("key"), []byte("value"))
+			require.NoError(err, "Insert()")
+			_, _, err = small.Commit(ctx, testNs, 0)
+			require.NoError(err, "Commit()")
+		})
+	}
+}
+
+func TestGCFilter(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	var (
+		pinnedLock sync.Mutex
+		pinned     = make(map[hash.Hash]bool)
+	)
+	setPinned := func(h hash.Hash, pin bool) {
+		pinnedLock.Lock()
+		defer pinnedLock.Unlock()
+		pinned[h] = pin
+	}
+
+	cfg := *dbCfg
+	cfg.GCFilter = func(ptr *node.Pointer) bool {
+		pinnedLock.Lock()
+		defer pinnedLock.Unlock()
+		return !pinned[ptr.Hash]
+	}
+	ndb, err := New(&cfg)
+	require.NoError(err, "New()")
+	defer ndb.Close()
+	bdb := ndb.(*badgerNodeDB)
+
+	// Commit lone roots in each version so they are removed when the version is pruned.
+	commitVersion := func(version uint64, keys ...string) []node.Root {
+		var roots []node.Root
+		for _, key := range keys {
+			tree := mkvs.New(nil, ndb, node.RootTypeIO)
+			err := tree.Insert(ctx, []byte(key), []byte(fmt.Sprintf("value %s %d", key, version)))
+			require.NoError(err, "Insert()")
+			_, rootHash, err := tree.Commit(ctx, testNs, version)
+			require.NoError(err, "Commit()")
+			tree.Close()
+			roots = append(roots, node.Root{Namespace: testNs, Version: version, Type: node.RootTypeIO, Hash: rootHash})
+		}
+		err := ndb.Finalize(roots)
+		require.NoError(err, "Finalize()")
+		return roots
+	}
+	nodeExists := func(h hash.Hash) bool {
+		tx := bdb.db.NewTransactionAt(versionToTs(ndb.GetEarliestVersion()), false)
+		defer tx.Discard()
+		_, err := tx.Get(nodeKeyFmt.Encode(&h))
+		return err == nil
+	}
+
+	roots0 := commitVersion(0, "pinned", "unpinned")
+	commitVersion(1, "other")
+	commitVersion(2, "other")
+
+	pinnedHash := roots0[0].Hash
+	setPinned(pinnedHash, true)
+
+	estimate, err := ndb.PruneDryRun(0)
+	require.NoError(err, "PruneDryRun()")
+	require.EqualValues(1, estimate.Nodes, "pinned nodes should not be counted as removed")
+
+	err = ndb.Prune(0)
+	require.NoError(err, "Prune()")
+	require.True(nodeExists(pinnedHash), "pinned node should survive prune")
+	require.False(nodeExists(roots0[1].Hash), "unpinned node should be removed")
+
+	// Nodes should remain pinned across prunes.
+	err = ndb.Prune(1)
+	require.NoError(err, "Prune()")
+	require.True(nodeExists(pinnedHash), "pinned node should survive subsequent prunes")
+
+	// Once unpinned, the node should be removed by the next prune.
+	setPinned(pinnedHash, false)
+	commitVersion(3, "other")
+	err = ndb.Prune(2)
+	require.NoError(err, "Prune()")
+	require.False(nodeExists(pinnedHash), "unpinned node should be removed by the next prune")
+}
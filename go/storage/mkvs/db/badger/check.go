@@ -66,6 +66,21 @@ func checkSanityInternal(ctx context.Context, db *badgerNodeDB, display DisplayH
 
 	lastRootsMetadataKey := []byte{rootsMetadataKeyFmt.Prefix(), 0xff}
 
+	// Write logs of versions earlier than the earliest write logs version have been pruned.
+	var meta serializedMetadata
+	item, err := txn.Get(metadataKeyFmt.Encode())
+	switch err {
+	case nil:
+		if err = item.Value(func(val []byte) error {
+			return cbor.UnmarshalTrusted(val, &meta)
+		}); err != nil {
+			return fmt.Errorf("mkvs/badger/check: error reading metadata: %w", err)
+		}
+	case badger.ErrKeyNotFound:
+	default:
+		return fmt.Errorf("mkvs/badger/check: error reading metadata: %w", err)
+	}
+
 	// Determine last version in the db.
 	firstVersion, lastVersion, err := func() (uint64, uint64, error) {
 		itOpts := badger.DefaultIteratorOptions
@@ -146,7 +161,7 @@ func checkSanityInternal(ctx context.Context, db *badgerNodeDB, display DisplayH
 				Type:      rootHash.Type(),
 				Hash:      rootHash.Hash(),
 			}
-			if root.Hash.IsEmpty() {
+			if root.IsEmptyTree() {
 				continue
 			}
 			if err = common.checkNodes(root); err != nil {
@@ -161,7 +176,7 @@ func checkSanityInternal(ctx context.Context, db *badgerNodeDB, display DisplayH
 				if !ok {
 					return fmt.Errorf("mkvs/badger/check: missing target root (%s -> %s)", rootHash, dstRoot)
 				}
-				if !dstRoot.Equal(&rootHash) {
+				if !dstRoot.Equal(&rootHash) && dstVersion >= meta.WriteLogsEarliestVersion {
 					_, err = txn.Get(writeLogKeyFmt.Encode(dstVersion, &dstRoot, &rootHash)) //nolint: gosec
 					if err != nil {
 						return fmt.Errorf("mkvs/badger/check: missing write log (%d, %s, %s)", dstVersion, dstRoot, rootHash)
@@ -194,10 +209,10 @@ func checkSanityInternal(ctx context.Context, db *badgerNodeDB, display DisplayH
 
 		// Make sure that both roots exist.
 		srcRootHash, dstRootHash := srcRoot.Hash(), dstRoot.Hash()
-		if _, err = txn.Get(rootNodeKeyFmt.Encode(&srcRoot)); err != nil && !srcRootHash.IsEmpty() {
+		if _, err = txn.Get(rootNodeKeyFmt.Encode(&srcRoot)); err != nil && !node.IsEmptyTreeHash(srcRootHash) {
 			return fmt.Errorf("mkvs/badger/check: bad source root in write log (%d, %s, %s): %w", version, dstRoot, srcRoot, err)
 		}
-		if _, err = txn.Get(rootNodeKeyFmt.Encode(&dstRoot)); err != nil && !dstRootHash.IsEmpty() {
+		if _, err = txn.Get(rootNodeKeyFmt.Encode(&dstRoot)); err != nil && !node.IsEmptyTreeHash(dstRootHash) {
 			return fmt.Errorf("mkvs/badger/check: bad destination root in write log (%d, %s, %s): %w", version, dstRoot, srcRoot, err)
 		}
 	}
@@ -0,0 +1,93 @@
+package badger
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// compressedNodePrefix is the prefix of persisted nodes that are stored compressed. It is
+// followed by the compression algorithm and the compressed serialized node. The prefix is
+// distinct from all node prefixes so that uncompressed nodes remain readable.
+const compressedNodePrefix byte = 0x80
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil)
+	})
+)
+
+func checkValueCompression(compression api.ValueCompression) error {
+	switch compression {
+	case api.ValueCompressionNone, api.ValueCompressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("mkvs/badger: unsupported value compression: %s", compression)
+	}
+}
+
+// leafValueSize returns the size of the leaf value contained in the given node.
+func leafValueSize(n node.Node) int {
+	switch n := n.(type) {
+	case *node.LeafNode:
+		return len(n.Value)
+	case *node.InternalNode:
+		if n.LeafNode != nil {
+			if leaf, ok := n.LeafNode.Node.(*node.LeafNode); ok {
+				return len(leaf.Value)
+			}
+		}
+	}
+	return 0
+}
+
+// encodeNode returns the persisted representation of the given node with the given serialized
+// form, compressing it in case the contained leaf value is large enough and compression actually
+// reduces its size.
+func (d *badgerNodeDB) encodeNode(n node.Node, data []byte) ([]byte, error) {
+	if d.valueCompression == api.ValueCompressionNone || leafValueSize(n) < d.valueCompressionThreshold {
+		return data, nil
+	}
+
+	enc, err := zstdEncoder()
+	if err != nil {
+		return nil, fmt.Errorf("mkvs/badger: failed to create zstd encoder: %w", err)
+	}
+	compressed := enc.EncodeAll(data, []byte{compressedNodePrefix, byte(api.ValueCompressionZstd)})
+	if len(compressed) >= len(data) {
+		return data, nil
+	}
+	return compressed, nil
+}
+
+// decodeNode decodes a persisted node, decompressing it if needed.
+func decodeNode(data []byte) (node.Node, error) {
+	if len(data) == 0 || data[0] != compressedNodePrefix {
+		return node.UnmarshalBinary(data)
+	}
+	if len(data) < 2 {
+		return nil, node.ErrMalformedNode
+	}
+
+	switch api.ValueCompression(data[1]) {
+	case api.ValueCompressionZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("mkvs/badger: failed to create zstd decoder: %w", err)
+		}
+		raw, err := dec.DecodeAll(data[2:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("mkvs/badger: failed to decompress node: %w", err)
+		}
+		return node.UnmarshalBinary(raw)
+	default:
+		return nil, fmt.Errorf("mkvs/badger: unsupported value compression: %s", api.ValueCompression(data[1]))
+	}
+}
@@ -0,0 +1,105 @@
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// keepNode returns true in case the GC filter vetoes removal of the given node. The node itself
+// may be nil in case it has not been loaded.
+func (d *badgerNodeDB) keepNode(h hash.Hash, n node.Node) bool {
+	if d.gcFilter == nil {
+		return false
+	}
+	return !d.gcFilter(&node.Pointer{Clean: true, Hash: h, Node: n})
+}
+
+// pendingDelete is a key removal at a given timestamp.
+type pendingDelete struct {
+	key []byte
+	ts  uint64
+}
+
+// pendingDeletes collects key removals so that they can all be performed at once after all of the
+// checks of a metadata update have passed. Badger write batches may commit at any time when they
+// grow too large, so they cannot be used to defer removals.
+type pendingDeletes []pendingDelete
+
+// delete records a removal of the given key at the timestamp of the given version.
+func (p *pendingDeletes) delete(key []byte, version uint64) {
+	*p = append(*p, pendingDelete{key: key, ts: versionToTs(version)})
+}
+
+// flush performs all recorded removals in order.
+func (p *pendingDeletes) flush(db *badger.DB) error {
+	if len(*p) == 0 {
+		return nil
+	}
+
+	batch := db.NewManagedWriteBatch()
+	defer batch.Cancel()
+
+	for _, del := range *p {
+		if err := batch.DeleteAt(del.key, del.ts); err != nil {
+			return err
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+	*p = nil
+	return nil
+}
+
+// removeNode records the removal of the given node at the timestamp of the given version. In case the GC filter
+// vetoes the removal, the node is kept and its removal at the given version is deferred by
+// recording it in the given metadata transaction.
+func (d *badgerNodeDB) removeNode(tx *badger.Txn, dels *pendingDeletes, version uint64, h hash.Hash, n node.Node) error {
+	if d.keepNode(h, n) {
+		return tx.Set(gcDeferredNodeKeyFmt.Encode(version, &h), []byte{})
+	}
+
+	dels.delete(nodeKeyFmt.Encode(&h), version)
+	if d.secondaryHasher != nil {
+		dels.delete(secondaryHashKeyFmt.Encode(&h), version)
+	}
+	return nil
+}
+
+// collectDeferredNodes records the removal of all nodes whose removal was previously vetoed by the
+// GC filter but is now allowed, clearing their records in the given metadata transaction.
+//
+// Nodes are removed at the timestamp of the version at which they would have been removed, so
+// any nodes resurrected in later versions are not affected.
+func (d *badgerNodeDB) collectDeferredNodes(tx *badger.Txn, dels *pendingDeletes) error {
+	mtx := d.db.NewTransactionAt(tsMetadata, false)
+	defer mtx.Discard()
+
+	it := mtx.NewIterator(badger.IteratorOptions{Prefix: gcDeferredNodeKeyFmt.Encode()})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		var (
+			version uint64
+			h       hash.Hash
+		)
+		if !gcDeferredNodeKeyFmt.Decode(it.Item().Key(), &version, &h) {
+			// This should not happen as the Badger iterator should take care of it.
+			panic("mkvs/badger: bad iterator")
+		}
+		if d.keepNode(h, nil) {
+			continue
+		}
+
+		dels.delete(nodeKeyFmt.Encode(&h), version)
+		if d.secondaryHasher != nil {
+			dels.delete(secondaryHashKeyFmt.Encode(&h), version)
+		}
+		if err := tx.Delete(it.Item().KeyCopy(nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
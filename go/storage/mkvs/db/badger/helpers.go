@@ -30,7 +30,8 @@ func tsToVersion(ts uint64) uint64 {
 func commonConfigToBadgerOptions(cfg *api.Config, db *badgerNodeDB) badger.Options {
 	opts := badger.DefaultOptions(cfg.DB)
 	opts = opts.WithLogger(cmnBadger.NewLogAdapter(db.logger))
-	opts = opts.WithSyncWrites(!cfg.NoFsync)
+	// When a write-ahead log is used, durability of commits is provided by the log instead.
+	opts = opts.WithSyncWrites(!cfg.NoFsync && cfg.WALPath == "")
 	opts = opts.WithCompression(options.Snappy)
 	if cfg.MaxCacheSize == 0 {
 		opts = opts.WithBlockCacheSize(64 * 1024 * 1024)
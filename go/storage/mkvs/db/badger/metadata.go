@@ -25,6 +25,11 @@ type serializedMetadata struct {
 	LastFinalizedVersion *uint64 `json:"last_finalized_version"`
 	// MultipartVersion is the version for the in-progress multipart restore, or 0 if none was in progress.
 	MultipartVersion uint64 `json:"multipart_version"`
+	// WriteLogFormatVersion is the write log format version. Databases created before the write
+	// log format was versioned have this set to 0 and use the initial format.
+	WriteLogFormatVersion uint64 `json:"write_log_format_version,omitempty"`
+	// WriteLogsEarliestVersion is the earliest version whose write logs have not been pruned.
+	WriteLogsEarliestVersion uint64 `json:"write_logs_earliest_version,omitempty"`
 }
 
 // metadata is the database metadata.
@@ -54,6 +59,26 @@ func (m *metadata) setEarliestVersion(tx *badger.Txn, version uint64) error {
 	return m.save(tx)
 }
 
+func (m *metadata) getWriteLogsEarliestVersion() uint64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.value.WriteLogsEarliestVersion
+}
+
+func (m *metadata) setWriteLogsEarliestVersion(tx *badger.Txn, version uint64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	// The earliest version can only increase, not decrease.
+	if version < m.value.WriteLogsEarliestVersion {
+		return nil
+	}
+
+	m.value.WriteLogsEarliestVersion = version
+	return m.save(tx)
+}
+
 func (m *metadata) getLastFinalizedVersion() (uint64, bool) {
 	m.RLock()
 	defer m.RUnlock()
@@ -80,6 +105,15 @@ func (m *metadata) setLastFinalizedVersion(tx *badger.Txn, version uint64) error
 	return m.save(tx)
 }
 
+// restrictVersions sets the earliest and the last finalized version without persisting them.
+func (m *metadata) restrictVersions(earliestVersion, lastFinalizedVersion uint64) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.value.EarliestVersion = earliestVersion
+	m.value.LastFinalizedVersion = &lastFinalizedVersion
+}
+
 func (m *metadata) getMultipartVersion() uint64 {
 	m.Lock()
 	defer m.Unlock()
@@ -95,6 +129,22 @@ func (m *metadata) setMultipartVersion(tx *badger.Txn, version uint64) error {
 	return m.save(tx)
 }
 
+// snapshot returns a copy of the in-memory metadata that can later be restored.
+func (m *metadata) snapshot() serializedMetadata {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.value
+}
+
+// restore restores the in-memory metadata from a snapshot.
+func (m *metadata) restore(value serializedMetadata) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.value = value
+}
+
 func (m *metadata) save(tx *badger.Txn) error {
 	return tx.Set(metadataKeyFmt.Encode(), cbor.Marshal(m.value))
 }
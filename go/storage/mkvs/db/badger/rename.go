@@ -1,7 +1,9 @@
 package badger
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/dgraph-io/badger/v4"
 
@@ -11,8 +13,36 @@ import (
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
 )
 
+func init() {
+	api.RegisterNamespaceRewriter(Factory.Name(), func(cfg *api.Config, newNamespace common.Namespace) (bool, error) {
+		return renameNamespace(cfg, newNamespace, false)
+	})
+}
+
 // RenameNamespace changes the namespace specified in the database.
 func RenameNamespace(cfg *api.Config, newNamespace common.Namespace) error {
+	_, err := renameNamespace(cfg, newNamespace, true)
+	return err
+}
+
+// renameNamespace changes the namespace specified in the database and in any roots stored in the
+// write-ahead log, optionally verifying that the database namespace matches the configured one.
+//
+// It returns false in case the database does not exist or does not contain badger backend
+// metadata.
+func renameNamespace(cfg *api.Config, newNamespace common.Namespace, checkNamespace bool) (bool, error) {
+	if cfg.ReadOnly {
+		return false, api.ErrReadOnly
+	}
+
+	// Opening the database creates it in case it does not exist.
+	if _, err := os.Stat(cfg.DB); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat database: %w", err)
+	}
+
 	db := &badgerNodeDB{
 		logger:           logging.GetLogger("mkvs/db/badger/rename"),
 		namespace:        cfg.Namespace,
@@ -22,7 +52,7 @@ func RenameNamespace(cfg *api.Config, newNamespace common.Namespace) error {
 
 	var err error
 	if db.db, err = badger.OpenManaged(opts); err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return false, fmt.Errorf("failed to open database: %w", err)
 	}
 	defer db.Close()
 
@@ -34,9 +64,9 @@ func RenameNamespace(cfg *api.Config, newNamespace common.Namespace) error {
 	case nil:
 	case badger.ErrKeyNotFound:
 		// Nothing to rename.
-		return nil
+		return false, nil
 	default:
-		return err
+		return false, err
 	}
 
 	var meta metadata
@@ -44,31 +74,48 @@ func RenameNamespace(cfg *api.Config, newNamespace common.Namespace) error {
 		return cbor.UnmarshalTrusted(data, &meta.value)
 	})
 	if err != nil {
-		return fmt.Errorf("failed to load database metadata: %w", err)
+		return true, fmt.Errorf("failed to load database metadata: %w", err)
 	}
 
 	// Sanity checks.
 	if meta.value.Version != dbVersion {
-		return fmt.Errorf("incompatible database version (expected: %d got: %d)",
+		return true, fmt.Errorf("incompatible database version (expected: %d got: %d)",
 			dbVersion,
 			meta.value.Version,
 		)
 	}
-	if !meta.value.Namespace.Equal(&cfg.Namespace) {
-		return fmt.Errorf("incompatible namespace (expected: %s got: %s)",
+	if checkNamespace && !meta.value.Namespace.Equal(&cfg.Namespace) {
+		return true, fmt.Errorf("incompatible namespace (expected: %s got: %s)",
 			cfg.Namespace,
 			meta.value.Namespace,
 		)
 	}
+	if meta.value.Namespace.Equal(&newNamespace) {
+		return true, nil
+	}
+
+	// Rename the namespace in write-ahead log records first so that an interrupted rename can
+	// simply be retried as the database metadata still contains the old namespace.
+	if cfg.WALPath != "" {
+		if err = rewriteWALNamespace(cfg.WALPath, cfg.NoFsync, newNamespace); err != nil {
+			return true, err
+		}
+	}
 
 	// Rename the namespace in database metadata.
+	oldNamespace := meta.value.Namespace
 	meta.value.Namespace = newNamespace
 	if err = meta.save(tx); err != nil {
-		return fmt.Errorf("failed to save database metadata: %w", err)
+		return true, fmt.Errorf("failed to save database metadata: %w", err)
 	}
 	if err = tx.CommitAt(tsMetadata, nil); err != nil {
-		return fmt.Errorf("failed to commit database metadata: %w", err)
+		return true, fmt.Errorf("failed to commit database metadata: %w", err)
 	}
 
-	return nil
+	db.logger.Info("renamed database namespace",
+		"old_namespace", oldNamespace,
+		"new_namespace", newNamespace,
+	)
+
+	return true, nil
 }
@@ -1,13 +1,17 @@
 package badger
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/oasisprotocol/oasis-core/go/common"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
 )
 
 func TestRenameNamespace(t *testing.T) {
@@ -44,3 +48,65 @@ func TestRenameNamespace(t *testing.T) {
 	require.NoError(err, "New(dstNs)")
 	ndb.Close()
 }
+
+func TestRewriteNamespaceWAL(t *testing.T) {
+	ctx := context.Background()
+	require := require.New(t)
+
+	srcNs := common.NewTestNamespaceFromSeed([]byte("badger node db test ns 1"), 0)
+	dstNs := common.NewTestNamespaceFromSeed([]byte("badger node db test ns 2"), 0)
+
+	dir, err := os.MkdirTemp("", "mkvs.test.badger")
+	require.NoError(err, "TempDir")
+	defer os.RemoveAll(dir)
+
+	cfg := &api.Config{
+		DB:           filepath.Join(dir, "db"),
+		Namespace:    srcNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+		NoFsync:      true,
+		WALPath:      filepath.Join(dir, "wal"),
+	}
+
+	ndb, err := New(cfg)
+	require.NoError(err, "New(srcNs)")
+
+	tree := mkvs.New(nil, ndb, node.RootTypeState)
+	err = tree.Insert(ctx, []byte("key"), []byte("value"))
+	require.NoError(err, "Insert")
+	_, rootHash, err := tree.Commit(ctx, srcNs, 0)
+	require.NoError(err, "Commit")
+	tree.Close()
+
+	// Simulate a crash by restoring the log after it is checkpointed on close.
+	walData, err := os.ReadFile(cfg.WALPath)
+	require.NoError(err, "ReadFile")
+	ndb.Close()
+	err = os.WriteFile(cfg.WALPath, walData, 0o600)
+	require.NoError(err, "WriteFile")
+
+	err = api.RewriteNamespace(cfg, dstNs)
+	require.NoError(err, "RewriteNamespace")
+	_, err = os.Stat(cfg.WALPath + ".tmp")
+	require.ErrorIs(err, os.ErrNotExist, "temporary write-ahead log should be removed")
+
+	wal, err := openWAL(cfg.WALPath, true)
+	require.NoError(err, "openWAL")
+	recs, err := wal.records()
+	require.NoError(err, "records")
+	require.NoError(wal.close(), "close")
+	require.Len(recs, 1, "write-ahead log records should be preserved")
+	require.Equal(dstNs, recs[0].Root.Namespace, "root namespace should be rewritten")
+	require.Equal(dstNs, recs[0].OldRoot.Namespace, "old root namespace should be rewritten")
+
+	// Recovery should succeed under the new namespace.
+	cfg.Namespace = dstNs
+	ndb, err = New(cfg)
+	require.NoError(err, "New(dstNs)")
+	defer ndb.Close()
+	err = ndb.RecoverFromWAL()
+	require.NoError(err, "RecoverFromWAL")
+
+	root := node.Root{Namespace: dstNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	require.True(ndb.HasRoot(root), "root should exist after recovery")
+}
@@ -0,0 +1,78 @@
+package badger
+
+import (
+	"fmt"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// salvageVersions restricts the exposed versions to the longest contiguous range of readable
+// finalized versions ending with the latest readable version. Unreadable versions are logged.
+//
+// This is only used when opening a database in best-effort mode, which is read-only so that the
+// restricted range is never persisted.
+func (d *badgerNodeDB) salvageVersions() error {
+	lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion()
+	if !exists {
+		return nil
+	}
+	earliestVersion := d.meta.getEarliestVersion()
+
+	var (
+		earliest, latest uint64
+		found            bool
+	)
+	for version := lastFinalizedVersion; ; version-- {
+		if err := d.checkVersionReadable(version); err != nil {
+			d.logger.Warn("skipping unreadable version",
+				"version", version,
+				"err", err,
+			)
+			if found {
+				break
+			}
+		} else {
+			if !found {
+				latest = version
+				found = true
+			}
+			earliest = version
+		}
+
+		if version == earliestVersion {
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no readable versions between %d and %d", earliestVersion, lastFinalizedVersion)
+	}
+
+	if earliest != earliestVersion || latest != lastFinalizedVersion {
+		d.logger.Warn("only exposing readable versions",
+			"earliest_version", earliest,
+			"latest_version", latest,
+			"stored_earliest_version", earliestVersion,
+			"stored_latest_version", lastFinalizedVersion,
+		)
+	}
+	d.meta.restrictVersions(earliest, latest)
+
+	return nil
+}
+
+// checkVersionReadable returns an error in case the roots of the given version cannot be read.
+func (d *badgerNodeDB) checkVersionReadable(version uint64) error {
+	roots, err := d.GetRootsForVersion(version)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if root.IsEmptyTree() {
+			continue
+		}
+		if _, err = d.GetNode(root, &node.Pointer{Clean: true, Hash: root.Hash}); err != nil {
+			return fmt.Errorf("failed to read root %s: %w", root, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package badger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+var errSecondaryHashNotFound = errors.New("mkvs/badger: secondary hash not found")
+
+// getSecondaryHash returns the stored secondary hash of the node with the given primary hash.
+func getSecondaryHash(tx *badger.Txn, h hash.Hash) (hash.Hash, error) {
+	item, err := tx.Get(secondaryHashKeyFmt.Encode(&h))
+	switch err {
+	case nil:
+	case badger.ErrKeyNotFound:
+		return hash.Hash{}, errSecondaryHashNotFound
+	default:
+		return hash.Hash{}, fmt.Errorf("mkvs/badger: failed to get secondary hash: %w", err)
+	}
+
+	var sh hash.Hash
+	if err = item.Value(sh.UnmarshalBinary); err != nil {
+		return hash.Hash{}, fmt.Errorf("mkvs/badger: failed to unmarshal secondary hash: %w", err)
+	}
+	return sh, nil
+}
+
+// putSecondaryHash computes and stores the secondary hash of the given node.
+//
+// All children must either have been put into this batch before or already be stored in the
+// database.
+func (ba *badgerBatch) putSecondaryHash(n node.Node) error {
+	tx := ba.db.db.NewTransactionAt(versionToTs(ba.version), false)
+	defer tx.Discard()
+
+	sh, err := api.SecondaryHash(ba.db.secondaryHasher, n, func(ptr *node.Pointer) (hash.Hash, error) {
+		return ba.childSecondaryHash(tx, ptr.Hash)
+	})
+	if err != nil {
+		return fmt.Errorf("mkvs/badger: failed to compute secondary hash: %w", err)
+	}
+	return ba.setSecondaryHash(n.GetHash(), sh)
+}
+
+func (ba *badgerBatch) setSecondaryHash(h, sh hash.Hash) error {
+	if ba.secondaryHashes == nil {
+		ba.secondaryHashes = make(map[hash.Hash]hash.Hash)
+	}
+	ba.secondaryHashes[h] = sh
+
+	return ba.bat.Set(secondaryHashKeyFmt.Encode(&h), sh[:])
+}
+
+func (ba *badgerBatch) childSecondaryHash(tx *badger.Txn, h hash.Hash) (hash.Hash, error) {
+	if sh, ok := ba.secondaryHashes[h]; ok {
+		return sh, nil
+	}
+
+	sh, err := getSecondaryHash(tx, h)
+	if !errors.Is(err, errSecondaryHashNotFound) {
+		return sh, err
+	}
+
+	// The node was stored before secondary hashes were enabled (or during a multipart restore),
+	// so compute its secondary hash from the stored subtree and backfill it.
+	item, err := tx.Get(nodeKeyFmt.Encode(&h))
+	switch err {
+	case nil:
+	case badger.ErrKeyNotFound:
+		return hash.Hash{}, api.ErrNodeNotFound
+	default:
+		return hash.Hash{}, fmt.Errorf("mkvs/badger: failed to get node: %w", err)
+	}
+
+	var n node.Node
+	if err = item.Value(func(val []byte) error {
+		var vErr error
+		n, vErr = decodeNode(val)
+		return vErr
+	}); err != nil {
+		return hash.Hash{}, fmt.Errorf("mkvs/badger: failed to unmarshal node: %w", err)
+	}
+
+	sh, err = api.SecondaryHash(ba.db.secondaryHasher, n, func(ptr *node.Pointer) (hash.Hash, error) {
+		return ba.childSecondaryHash(tx, ptr.Hash)
+	})
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	if err = ba.setSecondaryHash(h, sh); err != nil {
+		return hash.Hash{}, err
+	}
+	return sh, nil
+}
@@ -0,0 +1,365 @@
+package badger
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/oasisprotocol/oasis-core/go/common"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+const (
+	// walRecordHeaderSize is the size of the header preceding each write-ahead log record. The
+	// header consists of the big-endian record length followed by its CRC-32C checksum.
+	walRecordHeaderSize = 8
+
+	// walMaxRecordSize is the maximum size of a single write-ahead log record.
+	walMaxRecordSize = 1024 * 1024 * 1024
+
+	// walCheckpointSize is the write-ahead log size in bytes after which the database is synced
+	// and the log is truncated in order to bound the amount of data replayed during recovery.
+	walCheckpointSize = 64 * 1024 * 1024
+)
+
+var walCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is a write-ahead log record describing a committed batch.
+type walRecord struct {
+	// OldRoot is the root the batch was created against.
+	OldRoot node.Root `json:"old_root"`
+	// Root is the committed root.
+	Root node.Root `json:"root"`
+	// Nodes are the serialized nodes put into the batch.
+	Nodes [][]byte `json:"nodes,omitempty"`
+	// UpdatedNodes are the nodes updated by the batch.
+	UpdatedNodes []updatedNode `json:"updated_nodes,omitempty"`
+	// WriteLog is the serialized write log of the batch.
+	WriteLog []byte `json:"write_log,omitempty"`
+}
+
+// writeAheadLog is an append-only log of committed batches.
+type writeAheadLog struct {
+	file    *os.File
+	size    int64
+	noFsync bool
+}
+
+func openWAL(path string, noFsync bool) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("mkvs/badger: failed to open write-ahead log: %w", err)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("mkvs/badger: failed to stat write-ahead log: %w", err)
+	}
+
+	return &writeAheadLog{
+		file:    file,
+		size:    fi.Size(),
+		noFsync: noFsync,
+	}, nil
+}
+
+// append appends the given record to the log and syncs it to disk.
+func (w *writeAheadLog) append(rec *walRecord) error {
+	data := cbor.Marshal(rec)
+	if len(data) > walMaxRecordSize {
+		return fmt.Errorf("mkvs/badger: write-ahead log record too large (%d bytes)", len(data))
+	}
+	buf := make([]byte, walRecordHeaderSize, walRecordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(data, walCrcTable))
+	buf = append(buf, data...)
+
+	n, err := w.file.Write(buf)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("mkvs/badger: failed to append to write-ahead log: %w", err)
+	}
+	if w.noFsync {
+		return nil
+	}
+	if err = w.file.Sync(); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to sync write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// records returns all complete records in the log.
+//
+// Reading stops at the first incomplete or corrupted record, as such a record can only be the
+// result of a crash while appending and the corresponding commit has therefore never succeeded.
+// This includes records whose length exceeds the remaining size of the log, so that a garbage
+// length is never used to allocate the record.
+func (w *writeAheadLog) records() ([]*walRecord, error) {
+	r := io.NewSectionReader(w.file, 0, w.size)
+
+	var (
+		recs   []*walRecord
+		header [walRecordHeaderSize]byte
+		offset int64
+	)
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return recs, nil
+			}
+			return nil, fmt.Errorf("mkvs/badger: failed to read write-ahead log: %w", err)
+		}
+		offset += walRecordHeaderSize
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		if size > walMaxRecordSize || size > w.size-offset {
+			return recs, nil
+		}
+		offset += size
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return recs, nil
+			}
+			return nil, fmt.Errorf("mkvs/badger: failed to read write-ahead log: %w", err)
+		}
+		if crc32.Checksum(data, walCrcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return recs, nil
+		}
+
+		var rec walRecord
+		if err := cbor.UnmarshalTrusted(data, &rec); err != nil {
+			return recs, nil
+		}
+		recs = append(recs, &rec)
+	}
+}
+
+// truncate removes all records from the log.
+func (w *writeAheadLog) truncate() error {
+	return w.truncateTo(0)
+}
+
+// truncateTo removes all records appended after the log had the given size.
+func (w *writeAheadLog) truncateTo(size int64) error {
+	if err := w.file.Truncate(size); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to truncate write-ahead log: %w", err)
+	}
+	w.size = size
+	if w.noFsync {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to sync write-ahead log: %w", err)
+	}
+	return nil
+}
+
+func (w *writeAheadLog) close() error {
+	return w.file.Close()
+}
+
+// checkpointWALLocked syncs the database to disk and truncates the write-ahead log, as all of the
+// logged batches are durable afterwards.
+//
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) checkpointWALLocked() error {
+	if d.wal == nil {
+		return nil
+	}
+	if err := d.db.Sync(); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to sync database: %w", err)
+	}
+	return d.wal.truncate()
+}
+
+// walPositionLocked returns the current size of the write-ahead log, to be passed to
+// abortWALLocked in case a commit fails.
+//
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) walPositionLocked() int64 {
+	if d.wal == nil {
+		return 0
+	}
+	return d.wal.size
+}
+
+// abortWALLocked removes all write-ahead log records appended after the given position, so that
+// batches whose commit failed are not replayed during recovery.
+//
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) abortWALLocked(pos int64) {
+	if d.wal == nil || d.wal.size <= pos {
+		return
+	}
+	if err := d.wal.truncateTo(pos); err != nil {
+		d.logger.Error("failed to abort write-ahead log record",
+			"err", err,
+		)
+	}
+}
+
+func (d *badgerNodeDB) RecoverFromWAL() error {
+	if d.wal == nil {
+		return nil
+	}
+
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	if d.multipartVersion != multipartVersionNone {
+		return api.ErrMultipartInProgress
+	}
+
+	recs, err := d.wal.records()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err = d.replayWALRecordLocked(rec); err != nil {
+			return fmt.Errorf("mkvs/badger: failed to replay write-ahead log record for root %s: %w", rec.Root, err)
+		}
+	}
+	if len(recs) > 0 {
+		d.logger.Info("replayed write-ahead log",
+			"records", len(recs),
+		)
+	}
+
+	return d.checkpointWALLocked()
+}
+
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) replayWALRecordLocked(rec *walRecord) error {
+	// Records for versions that have since been finalized have either been applied or their roots
+	// have been discarded during finalization.
+	lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion()
+	if exists && lastFinalizedVersion >= rec.Root.Version {
+		return nil
+	}
+
+	ba := &badgerBatch{
+		db:       d,
+		bat:      d.db.NewWriteBatchAt(versionToTs(rec.Root.Version)),
+		oldRoot:  rec.OldRoot,
+		version:  rec.Root.Version,
+		replayed: true,
+	}
+	ptrs := make([]*node.Pointer, 0, len(rec.Nodes))
+	for _, data := range rec.Nodes {
+		n, err := node.UnmarshalBinary(data)
+		if err != nil {
+			ba.Reset()
+			return fmt.Errorf("failed to unmarshal node: %w", err)
+		}
+		n.UpdateHash()
+
+		ptrs = append(ptrs, &node.Pointer{Clean: true, Hash: n.GetHash(), Node: n})
+	}
+	if err := ba.PutNodeBatch(ptrs); err != nil {
+		ba.Reset()
+		return err
+	}
+	ba.updatedNodes = rec.UpdatedNodes
+	ba.encodedWriteLog = rec.WriteLog
+
+	// Committing a root that already exists is a no-op.
+	if err := ba.commitLocked(rec.Root); err != nil {
+		ba.Reset()
+		return err
+	}
+	return nil
+}
+
+// rewriteWALNamespace rewrites the namespace of all roots in the records of the write-ahead log
+// at the given path.
+//
+// The rewritten log is written to a temporary file which then atomically replaces the original
+// log, so that the records are not lost in case the rewrite is interrupted.
+func rewriteWALNamespace(path string, noFsync bool, namespace common.Namespace) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	wal, err := openWAL(path, noFsync)
+	if err != nil {
+		return err
+	}
+	recs, err := wal.records()
+	_ = wal.close()
+	if err != nil {
+		return err
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+
+	tmpPath := path + ".tmp"
+	if err = os.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("mkvs/badger: failed to remove stale write-ahead log: %w", err)
+	}
+	if err = writeWALRecords(tmpPath, noFsync, recs, namespace); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("mkvs/badger: failed to replace write-ahead log: %w", err)
+	}
+	if noFsync {
+		return nil
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeWALRecords writes the given records with their root namespaces rewritten to a new
+// write-ahead log at the given path and syncs it to disk.
+func writeWALRecords(path string, noFsync bool, recs []*walRecord, namespace common.Namespace) error {
+	// Records are synced once after all of them have been written.
+	wal, err := openWAL(path, true)
+	if err != nil {
+		return err
+	}
+	defer wal.close()
+
+	for _, rec := range recs {
+		rec.OldRoot.Namespace = namespace
+		rec.Root.Namespace = namespace
+		if err = wal.append(rec); err != nil {
+			return err
+		}
+	}
+	if noFsync {
+		return nil
+	}
+	if err = wal.file.Sync(); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to sync write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// syncDir syncs the directory at the given path, making any renames within it durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("mkvs/badger: failed to open directory: %w", err)
+	}
+	defer dir.Close()
+
+	if err = dir.Sync(); err != nil {
+		return fmt.Errorf("mkvs/badger: failed to sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package badger
+
+import (
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+)
+
+// marshalWriteLog serializes the given write log using the database's write log format.
+func (d *badgerNodeDB) marshalWriteLog(log api.HashedDBWriteLog) ([]byte, error) {
+	switch d.writeLogFormatVersion {
+	case api.WriteLogFormatV1:
+		return cbor.Marshal(log), nil
+	default:
+		return nil, api.CheckWriteLogFormatVersion(d.writeLogFormatVersion)
+	}
+}
+
+// unmarshalWriteLog deserializes the given write log using the database's write log format.
+func (d *badgerNodeDB) unmarshalWriteLog(data []byte, log *api.HashedDBWriteLog) error {
+	switch d.writeLogFormatVersion {
+	case api.WriteLogFormatV1:
+		return cbor.UnmarshalTrusted(data, log)
+	default:
+		return api.CheckWriteLogFormatVersion(d.writeLogFormatVersion)
+	}
+}
@@ -3,10 +3,13 @@ package db
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/oasisprotocol/oasis-core/go/common"
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
@@ -85,3 +88,64 @@ func TestHashedWriteLog(t *testing.T) {
 	}
 	require.Equal(t, i, len(wl))
 }
+
+func TestRewriteNamespace(t *testing.T) {
+	srcNs := common.NewTestNamespaceFromSeed([]byte("node db rewrite test ns 1"), 0)
+	dstNs := common.NewTestNamespaceFromSeed([]byte("node db rewrite test ns 2"), 0)
+	otherNs := common.NewTestNamespaceFromSeed([]byte("node db rewrite test ns 3"), 0)
+
+	for _, factory := range Backends {
+		t.Run(factory.Name(), func(t *testing.T) {
+			require := require.New(t)
+
+			dir, err := os.MkdirTemp("", "mkvs.test.rewrite")
+			require.NoError(err, "TempDir")
+			defer os.RemoveAll(dir)
+
+			cfg := &api.Config{
+				DB:           dir,
+				Namespace:    srcNs,
+				MaxCacheSize: 16 * 1024 * 1024,
+				NoFsync:      true,
+			}
+			// Rewriting a database that does not exist should fail without creating it.
+			missingCfg := *cfg
+			missingCfg.DB = filepath.Join(dir, "missing")
+			err = api.RewriteNamespace(&missingCfg, dstNs)
+			require.ErrorIs(err, api.ErrUnknownDatabase, "RewriteNamespace should fail for missing databases")
+			_, err = os.Stat(missingCfg.DB)
+			require.ErrorIs(err, os.ErrNotExist, "RewriteNamespace should not create missing databases")
+
+			ndb, err := factory.New(cfg)
+			require.NoError(err, "New(srcNs)")
+			ndb.Close()
+
+			// Rewriting a read-only database should be refused.
+			roCfg := *cfg
+			roCfg.ReadOnly = true
+			err = api.RewriteNamespace(&roCfg, dstNs)
+			require.ErrorIs(err, api.ErrReadOnly, "RewriteNamespace should fail in read-only mode")
+
+			// Rewriting to the same namespace should be a no-op.
+			err = api.RewriteNamespace(cfg, srcNs)
+			require.NoError(err, "RewriteNamespace(srcNs)")
+			ndb, err = factory.New(cfg)
+			require.NoError(err, "New(srcNs) should succeed on unchanged database")
+			ndb.Close()
+
+			// The configured namespace should not need to match the stored one.
+			otherCfg := *cfg
+			otherCfg.Namespace = otherNs
+			err = api.RewriteNamespace(&otherCfg, dstNs)
+			require.NoError(err, "RewriteNamespace(dstNs)")
+
+			_, err = factory.New(cfg)
+			require.Error(err, "New(srcNs) should fail on rewritten database")
+
+			cfg.Namespace = dstNs
+			ndb, err = factory.New(cfg)
+			require.NoError(err, "New(dstNs)")
+			ndb.Close()
+		})
+	}
+}
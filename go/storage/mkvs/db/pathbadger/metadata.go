@@ -2,7 +2,9 @@ package pathbadger
 
 import (
 	"fmt"
+	"maps"
 	"math"
+	"slices"
 	"sync"
 
 	"github.com/dgraph-io/badger/v4"
@@ -34,6 +36,12 @@ type serializedMetadata struct {
 	NextPendingRootSeq map[uint64]map[uint8]uint16 `json:"next_pending_root_seq,omitempty"`
 	// PendingRootSeqs contains the set of all non-finalized roots in the next version.
 	PendingRootSeqs map[uint64]map[api.TypedHash]uint16 `json:"pending_root_seqs,omitempty"`
+
+	// WriteLogFormatVersion is the write log format version. Databases created before the write
+	// log format was versioned have this set to 0 and use the initial format.
+	WriteLogFormatVersion uint64 `json:"write_log_format_version,omitempty"`
+	// WriteLogsEarliestVersion is the earliest version whose write logs have not been pruned.
+	WriteLogsEarliestVersion uint64 `json:"write_logs_earliest_version,omitempty"`
 }
 
 // metadata is the database metadata.
@@ -62,6 +70,25 @@ func (m *metadata) setEarliestVersion(version uint64) {
 	m.value.EarliestVersion = version
 }
 
+func (m *metadata) getWriteLogsEarliestVersion() uint64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.value.WriteLogsEarliestVersion
+}
+
+func (m *metadata) setWriteLogsEarliestVersion(version uint64) {
+	m.Lock()
+	defer m.Unlock()
+
+	// The earliest version can only increase, not decrease.
+	if version < m.value.WriteLogsEarliestVersion {
+		panic(fmt.Errorf("mkvs/pathbadger: earliest write logs version must only increase"))
+	}
+
+	m.value.WriteLogsEarliestVersion = version
+}
+
 func (m *metadata) getLastFinalizedVersion() (uint64, bool) {
 	m.RLock()
 	defer m.RUnlock()
@@ -165,6 +192,43 @@ func (m *metadata) getPendingRootSeqNo(version uint64, rootHash api.TypedHash) (
 	return seqNo, ok
 }
 
+func (m *metadata) getPendingVersions() []uint64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	var versions []uint64
+	for version, roots := range m.value.PendingRootSeqs {
+		if len(roots) == 0 {
+			continue
+		}
+		if m.value.LastFinalizedVersion != nil && version <= *m.value.LastFinalizedVersion {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// snapshot returns a copy of the in-memory metadata that can later be restored.
+func (m *metadata) snapshot() serializedMetadata {
+	m.RLock()
+	defer m.RUnlock()
+
+	value := m.value
+	value.NextPendingRootSeq = maps.Clone(m.value.NextPendingRootSeq)
+	value.PendingRootSeqs = maps.Clone(m.value.PendingRootSeqs)
+	return value
+}
+
+// restore restores the in-memory metadata from a snapshot.
+func (m *metadata) restore(value serializedMetadata) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.value = value
+}
+
 func (m *metadata) commit(tx *badger.Txn) {
 	// The only safe thing to do in case we cannot save metadata is to panic.
 	err := tx.Set(metadataKeyFmt.Encode(), cbor.Marshal(m.value))
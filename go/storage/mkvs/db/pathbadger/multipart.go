@@ -70,6 +70,8 @@ func (d *badgerNodeDB) StartMultipartInsert(version uint64) error {
 
 	d.multipartVersion = version
 	d.multipartMeta = multiMeta
+	d.multipartProgress.Start()
+	d.duplicateKeys.Start()
 
 	return nil
 }
@@ -82,6 +84,18 @@ func (d *badgerNodeDB) AbortMultipartInsert() error {
 	return d.cleanMultipartLocked(true)
 }
 
+// Implements api.NodeDB.
+func (d *badgerNodeDB) MultipartState() (bool, uint64, error) {
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	version := d.multipartVersion
+	if version == multipartVersionNone {
+		version, _ = d.meta.getMultipart()
+	}
+	return version != multipartVersionNone, version, nil
+}
+
 // Assumes metaUpdateLock is held when called.
 func (d *badgerNodeDB) cleanMultipartLocked(removeNodes bool) error {
 	var (
@@ -157,6 +171,8 @@ func (d *badgerNodeDB) cleanMultipartLocked(removeNodes bool) error {
 
 	d.multipartVersion = multipartVersionNone
 	d.multipartMeta = nil
+	d.multipartProgress.Finish()
+	d.duplicateKeys.Finish()
 	return nil
 }
 
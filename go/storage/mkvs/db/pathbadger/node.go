@@ -1,8 +1,12 @@
 package pathbadger
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 
 	"github.com/dgraph-io/badger/v4"
 
@@ -34,6 +38,52 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 	if ptr == nil || !ptr.IsClean() {
 		return nil, fmt.Errorf("mkvs/pathbadger: invalid node pointer")
 	}
+
+	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
+	defer tx.Discard()
+
+	item, err := d.getNodeItem(tx, root, ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	var n node.Node
+	if err = item.Value(func(val []byte) error {
+		var vErr error
+		n, vErr = nodeFromDb(val)
+		return vErr
+	}); err != nil {
+		d.logger.Error("failed to unmarshal node",
+			"err", err,
+		)
+		return nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal node: %w", err)
+	}
+
+	return n, nil
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) HasNode(root node.Root, ptr *node.Pointer) (bool, error) {
+	if ptr == nil || !ptr.IsClean() {
+		return false, fmt.Errorf("mkvs/pathbadger: invalid node pointer")
+	}
+
+	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
+	defer tx.Discard()
+
+	// The value is only read when requested, so there is no need to load or decode the node.
+	_, err := d.getNodeItem(tx, root, ptr)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, api.ErrNodeNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (d *badgerNodeDB) getNodeItem(tx *badger.Txn, root node.Root, ptr *node.Pointer) (*badger.Item, error) {
 	if err := d.sanityCheckNamespace(&root.Namespace); err != nil {
 		return nil, err
 	}
@@ -43,9 +93,6 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 		return nil, api.ErrNodeNotFound
 	}
 
-	tx := d.db.NewTransactionAt(versionToTs(root.Version), false)
-	defer tx.Discard()
-
 	// Check if the root actually exists.
 	if err := d.checkRootExists(tx, root); err != nil {
 		return nil, err
@@ -90,6 +137,7 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 
 	switch err {
 	case nil:
+		return item, nil
 	case badger.ErrKeyNotFound:
 		return nil, api.ErrNodeNotFound
 	default:
@@ -98,24 +146,102 @@ func (d *badgerNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, er
 		)
 		return nil, fmt.Errorf("mkvs/pathbadger: failed to Get node from backing store: %w", err)
 	}
+}
 
-	var n node.Node
-	if err = item.Value(func(val []byte) error {
-		var vErr error
-		n, vErr = nodeFromDb(val)
-		return vErr
-	}); err != nil {
-		d.logger.Error("failed to unmarshal node",
-			"err", err,
+// Implements api.NodeDB.
+func (d *badgerNodeDB) IterateNodes(ctx context.Context, fn func(ptr *node.Pointer, n node.Node) error) error {
+	tx := d.db.NewTransactionAt(math.MaxUint64, false)
+	defer tx.Discard()
+
+	// Root nodes are stored separately from the other (finalized and pending) nodes.
+	if err := d.iterateNodes(ctx, tx, rootNodeKeyFmt.Encode(), func(key []byte) (*dbPtr, bool) {
+		var (
+			version  uint64
+			rootHash api.TypedHash
 		)
-		return nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal node: %w", err)
+		if !rootNodeKeyFmt.Decode(key, &version, &rootHash) {
+			return nil, false
+		}
+		return &dbPtr{version: version, index: indexRootNode}, true
+	}, fn); err != nil {
+		return err
+	}
+	if err := d.iterateNodes(ctx, tx, finalizedNodeKeyFmt.Encode(), func(key []byte) (*dbPtr, bool) {
+		var (
+			rootType uint8
+			dbKey    []byte
+		)
+		if !finalizedNodeKeyFmt.Decode(key, &rootType, &dbKey) {
+			return nil, false
+		}
+		return dbPtrFromKey(dbKey)
+	}, fn); err != nil {
+		return err
 	}
+	return d.iterateNodes(ctx, tx, pendingNodeKeyFmt.Encode(), func(key []byte) (*dbPtr, bool) {
+		var (
+			version  uint64
+			rootType uint8
+			seqNo    uint16
+			dbKey    []byte
+		)
+		if !pendingNodeKeyFmt.Decode(key, &version, &rootType, &seqNo, &dbKey) {
+			return nil, false
+		}
+		return dbPtrFromKey(dbKey)
+	}, fn)
+}
 
-	return n, nil
+func (d *badgerNodeDB) iterateNodes(
+	ctx context.Context,
+	tx *badger.Txn,
+	prefix []byte,
+	decodeKey func(key []byte) (*dbPtr, bool),
+	fn func(ptr *node.Pointer, n node.Node) error,
+) error {
+	it := tx.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		iptr, ok := decodeKey(it.Item().Key())
+		if !ok {
+			return fmt.Errorf("mkvs/pathbadger: undecodable node key: %X", it.Item().Key())
+		}
+
+		var n node.Node
+		if err := it.Item().Value(func(val []byte) error {
+			var vErr error
+			n, vErr = nodeFromDb(val)
+			return vErr
+		}); err != nil {
+			return fmt.Errorf("mkvs/pathbadger: failed to unmarshal node: %w", err)
+		}
+
+		ptr := &node.Pointer{
+			Clean:      true,
+			Hash:       n.GetHash(),
+			Node:       n,
+			DBInternal: iptr,
+		}
+		if err := fn(ptr, n); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Implements api.Batch.
 func (ba *badgerBatch) VisitCleanNode(ptr *node.Pointer, parent *node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	ba.TrackCleanNode(ptr, parent)
+
 	var needsPutNode bool
 	if parent == nil && ptr.DBInternal == nil {
 		// If this is a clean root node, don't do anything as it seems the root has not changed.
@@ -168,6 +294,11 @@ func (ba *badgerBatch) VisitCleanNode(ptr *node.Pointer, parent *node.Pointer) e
 
 // Implements api.Batch.
 func (ba *badgerBatch) VisitDirtyNode(ptr *node.Pointer, parent *node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	ba.TrackDirtyNode(ptr, parent)
 	return ba.refreshDbPtr(ptr, parent)
 }
 
@@ -216,20 +347,65 @@ func (ba *badgerBatch) refreshDbPtr(ptr *node.Pointer, parent *node.Pointer) err
 
 // Implements api.Batch.
 func (ba *badgerBatch) PutNode(ptr *node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	return ba.putNode(ptr)
+}
+
+// Implements api.Batch.
+func (ba *badgerBatch) PutNodeBatch(ptrs []*node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	ba.updatedNodes = slices.Grow(ba.updatedNodes, len(ptrs))
+	for _, ptr := range ptrs {
+		if err := ba.putNode(ptr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ba *badgerBatch) putNode(ptr *node.Pointer) error {
 	iptr, ok := ptr.DBInternal.(*dbPtr)
 	if !ok {
 		return fmt.Errorf("mkvs/pathbadger: bad internal pointer")
 	}
 
+	// Nodes that are not stored separately do not count towards the batch size limits.
+	var (
+		key, value []byte
+		err        error
+	)
+	if !iptr.isInvalid() {
+		// Determine the correct database key based on the batch sequence number.
+		if key, value, err = nodeToDb(ptr); err != nil {
+			return err
+		}
+		if err = ba.TrackNodeSize(len(value)); err != nil {
+			return err
+		}
+	}
+
+	if err = ba.duplicateKeys.PutNode(ptr.Node); err != nil {
+		return err
+	}
+	if ba.chunk && ba.db.strictChunkCommit {
+		ba.trackChildRefs(ptr.Node)
+	}
+
+	ba.TrackPendingNodes(ptr)
+
 	// Skip nodes that should not be stored separately.
 	if iptr.isInvalid() {
 		return nil
 	}
 
-	// Determine the correct database key based on the batch sequence number.
-	key, value, err := nodeToDb(ptr)
-	if err != nil {
-		return err
+	if ba.chunk {
+		ba.db.multipartProgress.NodeWritten(len(value))
 	}
 
 	// Root node is special.
@@ -250,6 +426,53 @@ func (ba *badgerBatch) PutNode(ptr *node.Pointer) error {
 	return ba.bat.Set(dbKey, value)
 }
 
+// trackChildRefs records the pointers to the nodes referenced, but not included, by the given node
+// so that their existence can be verified on commit.
+func (ba *badgerBatch) trackChildRefs(n node.Node) {
+	intNode, ok := n.(*node.InternalNode)
+	if !ok {
+		return
+	}
+	// Leaf nodes of internal nodes are stored together with the internal node.
+	for _, child := range []*node.Pointer{intNode.Left, intNode.Right} {
+		if child == nil || child.Node != nil || node.IsEmptyTreeHash(child.Hash) {
+			continue
+		}
+		ba.childRefs = append(ba.childRefs, child)
+	}
+}
+
+// checkChildRefs makes sure that all nodes referenced by the nodes put into a chunk batch in
+// strict mode exist in the database.
+//
+// Referenced nodes cannot be part of the same batch as any node put into the batch is included by
+// its parent.
+func (ba *badgerBatch) checkChildRefs() error {
+	if len(ba.childRefs) == 0 {
+		return nil
+	}
+
+	tx := ba.db.db.NewTransactionAt(versionToTs(ba.version), false)
+	defer tx.Discard()
+
+	for _, ptr := range ba.childRefs {
+		iptr, ok := ptr.DBInternal.(*dbPtr)
+		if !ok || iptr.isInvalid() {
+			return &api.MissingChildNodeError{Hash: ptr.Hash}
+		}
+
+		_, err := tx.Get(ba.deriveNodeDbKey(iptr.dbKey()))
+		switch {
+		case err == nil:
+		case errors.Is(err, badger.ErrKeyNotFound):
+			return &api.MissingChildNodeError{Hash: ptr.Hash}
+		default:
+			return fmt.Errorf("mkvs/pathbadger: failed to check child node existence: %w", err)
+		}
+	}
+	return nil
+}
+
 func (ba *badgerBatch) deriveNodeDbKey(key []byte) []byte {
 	var dbKey []byte
 	rootType := byte(ba.oldRoot.Type)
@@ -427,7 +650,7 @@ func ptrToDb(ptr *node.Pointer) []byte {
 	if iptr.isInvalid() {
 		panic("mkvs/pathbadger: attempted to serialize invalid internal pointer")
 	}
-	if ptr.Hash.IsEmpty() {
+	if node.IsEmptyTreeHash(ptr.Hash) {
 		panic("mkvs/pathbadger: attempted to serialize an empty pointer")
 	}
 
@@ -453,7 +676,7 @@ func ptrFromDb(data []byte) (int, *node.Pointer, error) {
 	if err = h.UnmarshalBinary(data[:hash.Size]); err != nil {
 		return 0, nil, fmt.Errorf("failed to unmarshal hash: %w", err)
 	}
-	if h.IsEmpty() {
+	if node.IsEmptyTreeHash(h) {
 		// Empty hashes are not allowed in serialized form.
 		return 0, nil, fmt.Errorf("serialized empty hash encountered in pointer (db corruption?)")
 	}
@@ -493,6 +716,17 @@ func encodeNodeKey(version uint64, index uint32) []byte {
 	return data
 }
 
+// dbPtrFromKey decodes a database key produced by encodeNodeKey.
+func dbPtrFromKey(key []byte) (*dbPtr, bool) {
+	if len(key) != 8+4 {
+		return nil, false
+	}
+	return &dbPtr{
+		version: binary.BigEndian.Uint64(key[:8]),
+		index:   binary.BigEndian.Uint32(key[8:]),
+	}, true
+}
+
 // dbPtr contains internal metadata needed for pointer resolution.
 type dbPtr struct {
 	version uint64
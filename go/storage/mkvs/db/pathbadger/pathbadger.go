@@ -2,6 +2,8 @@
 package pathbadger
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -11,6 +13,7 @@ import (
 	"github.com/oasisprotocol/oasis-core/go/common"
 	cmnBadger "github.com/oasisprotocol/oasis-core/go/common/badger"
 	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
@@ -21,14 +24,43 @@ import (
 // the older badger backend which uses a database version of 5.
 const dbVersion = 6
 
+// minDBVersion is the minimum supported database version.
+const minDBVersion = dbVersion
+
 // New creates a new BadgerDB-backed node database that uses trie paths as keys.
 func New(cfg *api.Config) (api.NodeDB, error) {
+	if cfg.SecondaryHasher != nil {
+		return nil, fmt.Errorf("mkvs/pathbadger: secondary hashes are not supported")
+	}
+	if cfg.WALPath != "" {
+		return nil, fmt.Errorf("mkvs/pathbadger: write-ahead log is not supported")
+	}
+	if cfg.ValueCompression != api.ValueCompressionNone {
+		return nil, fmt.Errorf("mkvs/pathbadger: value compression is not supported")
+	}
+	if cfg.GCFilter != nil {
+		return nil, fmt.Errorf("mkvs/pathbadger: GC filters are not supported")
+	}
+	if cfg.BestEffortOpen {
+		return nil, fmt.Errorf("mkvs/pathbadger: best-effort open is not supported")
+	}
+
 	db := &badgerNodeDB{
 		logger:           logging.GetLogger("mkvs/db/pathbadger"),
 		namespace:        cfg.Namespace,
 		readOnly:         cfg.ReadOnly,
 		discardWriteLogs: cfg.DiscardWriteLogs,
+
+		writeLogFormatVersion: cfg.WriteLogFormatVersion,
+
+		maxBatchNodes: cfg.MaxBatchNodes,
+		maxBatchBytes: cfg.MaxBatchBytes,
+
+		strictChunkCommit: cfg.StrictChunkCommit,
 	}
+	db.multipartProgress.SetCallback(cfg.MultipartProgress)
+	db.duplicateKeys.Configure(cfg.DetectDuplicateKeys, cfg.DuplicateKeysBloomFilter)
+
 	opts := commonConfigToBadgerOptions(cfg, db.logger)
 
 	var err error
@@ -69,6 +101,17 @@ type badgerNodeDB struct { // nolint: maligned
 	readOnly         bool
 	discardWriteLogs bool
 
+	// writeLogFormatVersion is the write log format version used by the database.
+	writeLogFormatVersion uint64
+
+	// maxBatchNodes and maxBatchBytes are the optional batch size limits.
+	maxBatchNodes uint64
+	maxBatchBytes uint64
+
+	// strictChunkCommit specifies whether committing a chunk verifies that all referenced nodes
+	// exist.
+	strictChunkCommit bool
+
 	multipartVersion uint64
 	multipartMeta    map[uint8]*multipartMeta
 
@@ -81,6 +124,14 @@ type badgerNodeDB struct { // nolint: maligned
 	metaUpdateLock sync.Mutex
 	meta           metadata
 
+	// compactLock serializes compactions.
+	compactLock sync.Mutex
+
+	quiescer api.Quiescer
+
+	multipartProgress api.MultipartProgressReporter
+	duplicateKeys     api.DuplicateKeyDetector
+
 	closeOnce sync.Once
 }
 
@@ -91,7 +142,7 @@ func (d *badgerNodeDB) initMetadata() error {
 	// Ensure that no legacy metadata exists to prevent corrupting a database created using the old
 	// badger backend.
 	if _, err := tx.Get([]byte{0x04}); err != badger.ErrKeyNotFound {
-		return fmt.Errorf("incompatible database version")
+		return fmt.Errorf("%w: legacy badger database", api.ErrUnsupportedDBVersion)
 	}
 
 	// Load metadata.
@@ -107,11 +158,8 @@ func (d *badgerNodeDB) initMetadata() error {
 			return err
 		}
 
-		if d.meta.value.Version != dbVersion {
-			return fmt.Errorf("incompatible database version (expected: %d got: %d)",
-				dbVersion,
-				d.meta.value.Version,
-			)
+		if err = api.CheckDBVersion(d.meta.value.Version, minDBVersion, dbVersion); err != nil {
+			return err
 		}
 		if !d.meta.value.Namespace.Equal(&d.namespace) {
 			return fmt.Errorf("incompatible namespace (expected: %s got: %s)",
@@ -119,15 +167,29 @@ func (d *badgerNodeDB) initMetadata() error {
 				d.meta.value.Namespace,
 			)
 		}
-		return nil
+
+		// Databases without a write log format version use the initial format.
+		d.writeLogFormatVersion = d.meta.value.WriteLogFormatVersion
+		if d.writeLogFormatVersion == 0 {
+			d.writeLogFormatVersion = api.WriteLogFormatV1
+		}
+		return api.CheckWriteLogFormatVersion(d.writeLogFormatVersion)
 	case badger.ErrKeyNotFound:
 	default:
 		return err
 	}
 
 	// No metadata exists, create some.
+	if d.writeLogFormatVersion == 0 {
+		d.writeLogFormatVersion = api.DefaultWriteLogFormatVersion
+	}
+	if err = api.CheckWriteLogFormatVersion(d.writeLogFormatVersion); err != nil {
+		return err
+	}
+
 	d.meta.value.Version = dbVersion
 	d.meta.value.Namespace = d.namespace
+	d.meta.value.WriteLogFormatVersion = d.writeLogFormatVersion
 	d.meta.commit(tx)
 
 	return nil
@@ -167,7 +229,22 @@ func (d *badgerNodeDB) GetEarliestVersion() uint64 {
 }
 
 // Implements api.NodeDB.
-func (d *badgerNodeDB) GetRootsForVersion(version uint64) (roots []node.Root, err error) {
+func (d *badgerNodeDB) GetRootsForVersion(version uint64) ([]node.Root, error) {
+	return d.getRootsForVersion(version, rootNodeKeyFmt.Encode(version))
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) GetRootsForVersionByType(version uint64, rootType node.RootType) ([]node.Root, error) {
+	if err := api.CheckRootType(rootType); err != nil {
+		return nil, err
+	}
+
+	// Typed hashes start with the root type, so roots can be filtered by key prefix.
+	prefix := append(rootNodeKeyFmt.Encode(version), byte(rootType))
+	return d.getRootsForVersion(version, prefix)
+}
+
+func (d *badgerNodeDB) getRootsForVersion(version uint64, prefix []byte) (roots []node.Root, err error) {
 	// If the version is earlier than the earliest version, we don't have the roots.
 	if version < d.meta.getEarliestVersion() {
 		return nil, nil
@@ -176,7 +253,6 @@ func (d *badgerNodeDB) GetRootsForVersion(version uint64) (roots []node.Root, er
 	tx := d.db.NewTransactionAt(versionToTs(version), false)
 	defer tx.Discard()
 
-	prefix := rootNodeKeyFmt.Encode(version)
 	it := tx.NewIterator(badger.IteratorOptions{Prefix: prefix})
 	defer it.Close()
 
@@ -199,6 +275,31 @@ func (d *badgerNodeDB) GetRootsForVersion(version uint64) (roots []node.Root, er
 	return
 }
 
+// Implements api.NodeDB.
+func (d *badgerNodeDB) GetPendingVersions() ([]uint64, error) {
+	return d.meta.getPendingVersions(), nil
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) NearestVersionAtOrBefore(target uint64) (uint64, bool) {
+	return api.NearestVersionAtOrBefore(d, target)
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) Fingerprint(ctx context.Context) (hash.Hash, error) {
+	return api.Fingerprint(ctx, d)
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) ScanPrefix(
+	ctx context.Context,
+	root node.Root,
+	prefix node.Key,
+	fn func(key node.Key, value []byte) bool,
+) error {
+	return api.ScanPrefix(ctx, d, root, prefix, fn)
+}
+
 // Implements api.NodeDB.
 func (d *badgerNodeDB) HasRoot(root node.Root) bool {
 	if err := d.sanityCheckNamespace(&root.Namespace); err != nil {
@@ -206,7 +307,7 @@ func (d *badgerNodeDB) HasRoot(root node.Root) bool {
 	}
 
 	// An empty root is always implicitly present.
-	if root.Hash.IsEmpty() {
+	if root.IsEmptyTree() {
 		return true
 	}
 
@@ -226,19 +327,134 @@ func (d *badgerNodeDB) HasRoot(root node.Root) bool {
 }
 
 // Implements api.NodeDB.
-func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
+func (d *badgerNodeDB) Finalize(roots []node.Root) error {
 	if d.readOnly {
 		return api.ErrReadOnly
 	}
 
-	if len(roots) == 0 {
-		return fmt.Errorf("mkvs/pathbadger: need at least one root to finalize")
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
 	}
-	version := roots[0].Version
+	defer d.quiescer.ExitWrite()
 
 	d.metaUpdateLock.Lock()
 	defer d.metaUpdateLock.Unlock()
 
+	return d.finalizeLocked(roots)
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) FinalizeVersions(versionRoots map[uint64][]node.Root) error {
+	if d.readOnly {
+		return api.ErrReadOnly
+	}
+
+	ordered, err := api.OrderVersionRoots(versionRoots)
+	if err != nil {
+		return err
+	}
+
+	if err = d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	return d.finalizeVersionsLocked(ordered)
+}
+
+func (d *badgerNodeDB) finalizeLocked(roots []node.Root) error {
+	if len(roots) == 0 {
+		return fmt.Errorf("mkvs/pathbadger: need at least one root to finalize")
+	}
+	return d.finalizeVersionsLocked([][]node.Root{roots})
+}
+
+// pendingDelete is a key removal at a given timestamp.
+type pendingDelete struct {
+	key []byte
+	ts  uint64
+}
+
+// pendingDeletes collects key removals so that they can all be performed at once after all of the
+// in-memory metadata updates have succeeded. Badger write batches may commit at any time when they
+// grow too large, so they cannot be used to defer removals.
+type pendingDeletes []pendingDelete
+
+// delete records a removal of the given key at the given timestamp.
+func (p *pendingDeletes) delete(key []byte, ts uint64) {
+	*p = append(*p, pendingDelete{key: key, ts: ts})
+}
+
+// flush performs all recorded removals in order.
+func (p *pendingDeletes) flush(db *badger.DB) error {
+	if len(*p) == 0 {
+		return nil
+	}
+
+	batch := db.NewManagedWriteBatch()
+	defer batch.Cancel()
+
+	for _, del := range *p {
+		if err := batch.DeleteAt(del.key, del.ts); err != nil {
+			return err
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+	*p = nil
+	return nil
+}
+
+// finalizeVersionsLocked finalizes the given non-empty lists of roots, one per version, in order
+// and commits the metadata of all versions at once. In case finalization fails, nothing is
+// finalized and finalization can be redone.
+//
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) finalizeVersionsLocked(versionRoots [][]node.Root) error {
+	var dels pendingDeletes
+	meta := d.meta.snapshot()
+	err := func() error {
+		for _, roots := range versionRoots {
+			if err := d.finalizeVersionLocked(&dels, roots); err != nil {
+				return err
+			}
+		}
+		// Only remove anything once all versions have been successfully finalized.
+		if err := dels.flush(d.db); err != nil {
+			return fmt.Errorf("mkvs/pathbadger: failed to flush removals: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		// Make sure in-memory metadata is consistent with what has been committed.
+		d.meta.restore(meta)
+		return err
+	}
+
+	tx := d.db.NewTransactionAt(tsMetadata, true)
+	defer tx.Discard()
+	d.meta.commit(tx)
+
+	// Clean multipart metadata if there is any.
+	if d.multipartVersion != multipartVersionNone {
+		if err := d.cleanMultipartLocked(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFinalizeLocked checks that the given non-empty list of roots of a single version can be
+// finalized, without checking whether the roots exist.
+//
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) checkFinalizeLocked(roots []node.Root) error {
+	version := roots[0].Version
+
 	// Validate multipart version.
 	if d.multipartVersion != multipartVersionNone && d.multipartVersion != version {
 		return api.ErrInvalidMultipartVersion
@@ -255,36 +471,46 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 		}
 	}
 
-	// Ensure that all roots are valid and only one root per type is finalized.
+	// Ensure that only one root per type is finalized.
 	typeCheck := make(map[node.RootType]struct{})
-	finalizedRoots := make(map[api.TypedHash]struct{})
 	for _, root := range roots {
 		if root.Version != version {
 			return fmt.Errorf("mkvs/pathbadger: roots to finalize don't have matching versions")
 		}
-		h := api.TypedHashFromRoot(root)
-		finalizedRoots[h] = struct{}{}
 
 		if _, ok := typeCheck[root.Type]; ok {
 			return fmt.Errorf("mkvs/pathbadger: only one root of type '%s' may be finalized", root.Type)
 		}
 		typeCheck[root.Type] = struct{}{}
 	}
+	return nil
+}
+
+// finalizeVersionLocked finalizes a single version, only updating the in-memory metadata and
+// recording removals in the given pending removals which must both be committed by the caller.
+func (d *badgerNodeDB) finalizeVersionLocked(dels *pendingDeletes, roots []node.Root) error { // nolint: gocyclo
+	version := roots[0].Version
+
+	if err := d.checkFinalizeLocked(roots); err != nil {
+		return err
+	}
+
+	finalizedRoots := make(map[api.TypedHash]struct{})
+	for _, root := range roots {
+		finalizedRoots[api.TypedHashFromRoot(root)] = struct{}{}
+	}
 
-	// Batch collects removals and copies at the version timestamp.
+	// Batch collects copies at the version timestamp.
 	batch := d.db.NewWriteBatchAt(versionToTs(version))
 	defer batch.Cancel()
-	// Batch meta collects removals at the tsMeta timestamp.
-	batchMeta := d.db.NewWriteBatchAt(tsMetadata)
-	defer batchMeta.Cancel()
 	// Transaction is used to read at the version timestamp.
-	tx := d.db.NewTransactionAt(versionToTs(version), true)
+	tx := d.db.NewTransactionAt(versionToTs(version), false)
 	defer tx.Discard()
 
 	// Ensure that all roots are valid and only one root per type is finalized.
 	var nonEmptyFinalizedRoots, nonEmptyVisitedRoots int
 	for _, root := range roots {
-		if root.Hash.IsEmpty() {
+		if root.IsEmptyTree() {
 			continue
 		}
 		if err := d.checkRootExists(tx, root); err != nil {
@@ -360,7 +586,7 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 			}
 
 			finalizedSeqNos[rht] = seqNo
-			if h := rootHash.Hash(); !h.IsEmpty() {
+			if h := rootHash.Hash(); !node.IsEmptyTreeHash(h) {
 				nonEmptyVisitedRoots++
 			}
 		case false:
@@ -381,20 +607,15 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 
 			// Remove write logs for the non-finalized root.
 			if !d.discardWriteLogs {
-				if err = func() error {
+				func() {
 					rootWriteLogsPrefix := writeLogKeyFmt.Encode(version, &rootHash)
 					wit := tx.NewIterator(badger.IteratorOptions{Prefix: rootWriteLogsPrefix})
 					defer wit.Close()
 
 					for wit.Rewind(); wit.Valid(); wit.Next() {
-						if err = batchMeta.Delete(wit.Item().KeyCopy(nil)); err != nil {
-							return err
-						}
+						dels.delete(wit.Item().KeyCopy(nil), tsMetadata)
 					}
-					return nil
-				}(); err != nil {
-					return err
-				}
+				}()
 			}
 		}
 	}
@@ -436,18 +657,11 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 	}
 
 	// All removals should be done at the end so in case finalization is interrupted, we can recover
-	// by simply redoing finalization. Flush batches here to ensure all node copying has been
+	// by simply redoing finalization. Flush the batch here to ensure all node copying has been
 	// committed.
 	if err := batch.Flush(); err != nil {
 		return err
 	}
-	if err := batchMeta.Flush(); err != nil {
-		return err
-	}
-	batch = d.db.NewWriteBatchAt(versionToTs(version))
-	defer batch.Cancel()
-	batchMeta = d.db.NewWriteBatchAt(tsMetadata)
-	defer batchMeta.Cancel()
 
 	// Remove any lone nodes. This can be retried.
 	for rht, nodes := range maybeLoneNodes {
@@ -456,9 +670,7 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 				continue
 			}
 
-			if err := batch.Delete(finalizedNodeKeyFmt.Encode(rht, []byte(k))); err != nil {
-				return fmt.Errorf("mkvs/pathbadger: failed to delete lone node: %w", err)
-			}
+			dels.delete(finalizedNodeKeyFmt.Encode(rht, []byte(k)), versionToTs(version))
 		}
 	}
 
@@ -466,9 +678,7 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 	// the worst that can happen is that some pending nodes are left over and will be removed during
 	// next finalization.
 	for _, key := range removeMetaKeys {
-		if err := batchMeta.Delete(key); err != nil {
-			return fmt.Errorf("mkvs/pathbadger: failed to delete key: %w", err)
-		}
+		dels.delete(key, tsMetadata)
 	}
 
 	// Remove all temporary nodes for non-zero sequence numbers. Relevant ones have been copied.
@@ -477,29 +687,74 @@ func (d *badgerNodeDB) Finalize(roots []node.Root) error { // nolint: gocyclo
 	defer pendingIt.Close()
 
 	for pendingIt.Rewind(); pendingIt.Valid(); pendingIt.Next() {
-		if err := batchMeta.Delete(pendingIt.Item().KeyCopy(nil)); err != nil {
-			return fmt.Errorf("mkvs/pathbadger: failed to delete pending node: %w", err)
-		}
+		dels.delete(pendingIt.Item().KeyCopy(nil), tsMetadata)
 	}
 
 	pendingIt.Close()
 
-	// Commit batches. If this fails, deletion will be redone.
-	if err := batch.Flush(); err != nil {
-		return err
+	// Update last finalized version.
+	d.meta.setLastFinalizedVersion(version)
+
+	// Automatically prune roots that have fallen out of their retention window.
+	if err := d.autoPruneLocked(dels, version); err != nil {
+		return fmt.Errorf("mkvs/pathbadger: failed to auto-prune roots: %w", err)
+	}
+	return nil
+}
+
+// autoPruneLocked removes the roots of all root types with a non-zero AutoPruneAfter policy that
+// fall out of the retention window once the given version is finalized.
+func (d *badgerNodeDB) autoPruneLocked(dels *pendingDeletes, finalizedVersion uint64) error {
+	for _, rootType := range api.RootTypesWithPolicy(func(p *api.RootPolicy) bool { return p.AutoPruneAfter > 0 }) {
+		policy := api.PolicyForRoot(node.Root{Type: rootType})
+		if finalizedVersion < policy.AutoPruneAfter {
+			continue
+		}
+		version := finalizedVersion - policy.AutoPruneAfter
+		if version < d.meta.getEarliestVersion() {
+			continue
+		}
+
+		if err := d.pruneRootsLocked(dels, version, rootType); err != nil {
+			return err
+		}
 	}
-	if err := batchMeta.Flush(); err != nil {
+	return nil
+}
+
+// pruneRootsLocked removes all roots of the given type in the given version, together with their
+// nodes and write logs. The root type must have the NoChildRoots policy.
+func (d *badgerNodeDB) pruneRootsLocked(dels *pendingDeletes, version uint64, rootType node.RootType) error {
+	var rootHashes []api.TypedHash
+	err := d.forEachRootTypeNodeItem(version, rootType, func(item *badger.Item) error {
+		var (
+			v        uint64
+			rootHash api.TypedHash
+		)
+		if rootNodeKeyFmt.Decode(item.Key(), &v, &rootHash) {
+			rootHashes = append(rootHashes, rootHash)
+		}
+		dels.delete(item.KeyCopy(nil), versionToTs(version))
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
-	// Update last finalized version.
-	d.meta.setLastFinalizedVersion(version)
-	d.meta.commit(tx)
+	// Remove write logs for the pruned roots.
+	if !d.discardWriteLogs {
+		wtx := d.db.NewTransactionAt(tsMetadata, false)
+		defer wtx.Discard()
 
-	// Clean multipart metadata if there is any.
-	if d.multipartVersion != multipartVersionNone {
-		if err := d.cleanMultipartLocked(false); err != nil {
-			return err
+		for _, rootHash := range rootHashes {
+			func() {
+				it := wtx.NewIterator(badger.IteratorOptions{Prefix: writeLogKeyFmt.Encode(version, &rootHash)})
+				defer it.Close()
+
+				for it.Rewind(); it.Valid(); it.Next() {
+					dels.delete(it.Item().KeyCopy(nil), tsMetadata)
+				}
+			}()
 		}
 	}
 	return nil
@@ -511,81 +766,286 @@ func (d *badgerNodeDB) Prune(version uint64) error {
 		return api.ErrReadOnly
 	}
 
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
 	d.metaUpdateLock.Lock()
 	defer d.metaUpdateLock.Unlock()
 
-	if d.multipartVersion != multipartVersionNone {
-		return api.ErrMultipartInProgress
+	return d.pruneLocked(version)
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) PruneWriteLogs(beforeVersion uint64) error {
+	if d.readOnly {
+		return api.ErrReadOnly
 	}
 
-	// Make sure that the version that we try to prune has been finalized.
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	startVersion := max(d.meta.getEarliestVersion(), d.meta.getWriteLogsEarliestVersion())
+	if beforeVersion <= startVersion {
+		return nil
+	}
+	// Make sure that all versions whose write logs we try to prune have been finalized.
 	lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion()
-	if !exists || lastFinalizedVersion < version {
+	if !exists || lastFinalizedVersion < beforeVersion-1 {
 		return api.ErrNotFinalized
 	}
-	// Make sure that the version that we are trying to prune is the earliest version.
-	if version != d.meta.getEarliestVersion() {
+
+	if !d.discardWriteLogs {
+		batchMeta := d.db.NewWriteBatchAt(tsMetadata)
+		defer batchMeta.Cancel()
+		wtx := d.db.NewTransactionAt(tsMetadata, false)
+		defer wtx.Discard()
+
+		it := wtx.NewIterator(badger.IteratorOptions{Prefix: writeLogKeyFmt.Encode()})
+		defer it.Close()
+
+		for it.Seek(writeLogKeyFmt.Encode(startVersion)); it.Valid(); it.Next() {
+			var version uint64
+			if !writeLogKeyFmt.Decode(it.Item().Key(), &version) {
+				// This should not happen as the Badger iterator should take care of it.
+				panic("mkvs/pathbadger: bad iterator")
+			}
+			if version >= beforeVersion {
+				break
+			}
+			if err := batchMeta.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+
+		it.Close()
+		wtx.Discard()
+
+		if err := batchMeta.Flush(); err != nil {
+			return fmt.Errorf("mkvs/pathbadger: failed to flush batch: %w", err)
+		}
+	}
+
+	// Update metadata.
+	tx := d.db.NewTransactionAt(tsMetadata, true)
+	defer tx.Discard()
+	d.meta.setWriteLogsEarliestVersion(beforeVersion)
+	d.meta.commit(tx)
+
+	return nil
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) CommitFinalizeAndPrune(batch api.Batch, roots []node.Root, pruneVersion uint64) error {
+	if d.readOnly {
+		return api.ErrReadOnly
+	}
+
+	if len(roots) == 0 {
+		return fmt.Errorf("mkvs/pathbadger: need at least one root to finalize")
+	}
+	version := roots[0].Version
+
+	var ba *badgerBatch
+	if batch != nil {
+		var ok bool
+		if ba, ok = api.UnwrapBatch(batch).(*badgerBatch); !ok || ba.db != d {
+			return fmt.Errorf("mkvs/pathbadger: batch not created by this database")
+		}
+		if err := ba.CheckDiscarded(); err != nil {
+			return err
+		}
+		if ba.chunk {
+			return fmt.Errorf("mkvs/pathbadger: cannot finalize a chunk batch")
+		}
+	}
+
+	if err := d.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer d.quiescer.ExitWrite()
+
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	// Make sure that pruning will succeed before changing anything.
+	if d.multipartVersion != multipartVersionNone {
+		return api.ErrMultipartInProgress
+	}
+	if pruneVersion > version {
+		return api.ErrNotFinalized
+	}
+	if pruneVersion != d.meta.getEarliestVersion() {
 		return api.ErrNotEarliest
 	}
-	// Make sure that the version that we are trying to prune is not the only finalized version.
-	if version == lastFinalizedVersion {
+	if pruneVersion == version {
 		return api.ErrCannotPruneLatestVersion
 	}
 
-	// Remove all roots in version.
-	batch := d.db.NewWriteBatchAt(versionToTs(version))
-	defer batch.Cancel()
-	batchMeta := d.db.NewWriteBatchAt(tsMetadata)
-	defer batchMeta.Cancel()
+	// Make sure that finalization will succeed before changing anything.
+	if err := d.checkFinalizeLocked(roots); err != nil {
+		return err
+	}
+
 	tx := d.db.NewTransactionAt(versionToTs(version), true)
 	defer tx.Discard()
 
-	// Delete data for all root types that cannot have children.
-	for _, rootType := range api.RootTypesWithPolicy(func(p *api.RootPolicy) bool { return p.NoChildRoots }) {
-		// Delete all finalized nodes.
-		wtx := d.db.NewTransactionAt(versionToTs(version), false)
-		defer wtx.Discard()
-
-		prefix := finalizedNodeKeyFmt.Encode(byte(rootType))
-		it := wtx.NewIterator(badger.IteratorOptions{Prefix: prefix})
-		defer it.Close()
+	for i, root := range roots {
+		if (ba != nil && i == 0) || root.IsEmptyTree() {
+			// The root of the batch only exists once the batch is committed.
+			continue
+		}
+		if err := d.checkRootExists(tx, root); err != nil {
+			return err
+		}
+	}
 
-		for it.Rewind(); it.Valid(); it.Next() {
-			if err := batch.Delete(it.Item().KeyCopy(nil)); err != nil {
+	// Committing the batch, finalization and pruning only update the in-memory metadata, which is
+	// then committed at once, so either all of them take effect or none does.
+	var (
+		exists, flushed bool
+		dels            pendingDeletes
+	)
+	meta := d.meta.snapshot()
+	err := func() error {
+		var err error
+		if ba != nil {
+			if exists, err = ba.prepareCommitLocked(tx, roots[0]); err != nil {
 				return err
 			}
+			if !exists {
+				flushed = true
+				if err = ba.flushLocked(roots[0]); err != nil {
+					return err
+				}
+			}
+		}
+		if err = d.finalizeVersionLocked(&dels, roots); err != nil {
+			return err
+		}
+		if err = d.pruneVersionLocked(&dels, pruneVersion); err != nil {
+			return err
+		}
+		if err = dels.flush(d.db); err != nil {
+			return fmt.Errorf("mkvs/pathbadger: failed to flush removals: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		// Make sure in-memory metadata is consistent with what has been committed.
+		d.meta.restore(meta)
+		if flushed {
+			ba.abortCommitLocked(roots[0])
 		}
+		return err
+	}
 
-		it.Close()
+	mtx := d.db.NewTransactionAt(tsMetadata, true)
+	defer mtx.Discard()
+	d.meta.commit(mtx)
 
-		// Delete all root nodes of this type (there should be only one per type).
-		prefix = rootNodeKeyFmt.Encode(version)
-		it = wtx.NewIterator(badger.IteratorOptions{Prefix: prefix})
-		defer it.Close()
+	// Discard everything invalidated at or below the _new_ earliest version.
+	d.db.SetDiscardTs(versionToTs(pruneVersion + 1))
 
-		for it.Rewind(); it.Valid(); it.Next() {
-			var (
-				v        uint64
-				rootHash api.TypedHash
-			)
-			if !rootNodeKeyFmt.Decode(it.Item().Key(), &v, &rootHash) {
-				panic("mkvs/pathbadger: corrupted key")
-			}
-			if rootHash.Type() != rootType {
-				continue
-			}
+	if ba != nil {
+		ba.Reset()
+		return ba.BaseBatch.Commit(roots[0])
+	}
+	return nil
+}
 
-			if err := batch.Delete(it.Item().KeyCopy(nil)); err != nil {
-				return err
-			}
+// Implements api.NodeDB.
+func (d *badgerNodeDB) VerifyAgainstManifest(ctx context.Context, manifest map[uint64][]node.Root) ([]api.ManifestMismatch, error) {
+	return api.VerifyAgainstManifest(ctx, d, manifest)
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) CheckComplete(ctx context.Context, root node.Root) ([]hash.Hash, error) {
+	if err := d.sanityCheckNamespace(&root.Namespace); err != nil {
+		return nil, err
+	}
+
+	return api.CheckComplete(ctx, root, func(ptr *node.Pointer) (node.Node, error) {
+		n, err := d.GetNode(root, ptr)
+		if errors.Is(err, api.ErrRootNotFound) {
+			// The root node is stored separately and is only missing in case the root is.
+			return nil, api.ErrNodeNotFound
 		}
+		return n, err
+	})
+}
 
-		it.Close()
-		wtx.Discard()
+// Assumes metaUpdateLock is held when called.
+func (d *badgerNodeDB) checkPruneLocked(version uint64) error {
+	if d.multipartVersion != multipartVersionNone {
+		return api.ErrMultipartInProgress
+	}
+
+	// Make sure that the version that we try to prune has been finalized.
+	lastFinalizedVersion, exists := d.meta.getLastFinalizedVersion()
+	if !exists || lastFinalizedVersion < version {
+		return api.ErrNotFinalized
+	}
+	// Make sure that the version that we are trying to prune is the earliest version.
+	if version != d.meta.getEarliestVersion() {
+		return api.ErrNotEarliest
+	}
+	// Make sure that the version that we are trying to prune is not the only finalized version.
+	if version == lastFinalizedVersion {
+		return api.ErrCannotPruneLatestVersion
+	}
+	return nil
+}
+
+func (d *badgerNodeDB) pruneLocked(version uint64) error {
+	if err := d.checkPruneLocked(version); err != nil {
+		return err
+	}
+
+	var dels pendingDeletes
+	meta := d.meta.snapshot()
+	if err := d.pruneVersionLocked(&dels, version); err != nil {
+		d.meta.restore(meta)
+		return err
+	}
+	if err := dels.flush(d.db); err != nil {
+		d.meta.restore(meta)
+		return fmt.Errorf("mkvs/pathbadger: failed to flush removals: %w", err)
+	}
+
+	tx := d.db.NewTransactionAt(versionToTs(version), true)
+	defer tx.Discard()
+	d.meta.commit(tx)
+
+	// Discard everything invalidated at or below the _new_ earliest version. E.g. there is no need
+	// to keep around any keys that were removed at `version + 1`.
+	d.db.SetDiscardTs(versionToTs(version + 1))
+
+	return nil
+}
+
+// pruneVersionLocked prunes the given version, only updating the in-memory metadata and recording
+// removals in the given pending removals which must both be committed by the caller.
+//
+// Assumes metaUpdateLock is held and the pruning preconditions have been checked when called.
+func (d *badgerNodeDB) pruneVersionLocked(dels *pendingDeletes, version uint64) error {
+	// Delete data for all root types that cannot have children.
+	err := d.forEachPrunableNodeItem(version, func(item *badger.Item) error {
+		dels.delete(item.KeyCopy(nil), versionToTs(version))
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Prune all write logs in version.
-	if !d.discardWriteLogs {
+	if !d.discardWriteLogs && version >= d.meta.getWriteLogsEarliestVersion() {
 		wtx := d.db.NewTransactionAt(tsMetadata, false)
 		defer wtx.Discard()
 
@@ -594,34 +1054,91 @@ func (d *badgerNodeDB) Prune(version uint64) error {
 		defer it.Close()
 
 		for it.Rewind(); it.Valid(); it.Next() {
-			if err := batchMeta.Delete(it.Item().KeyCopy(nil)); err != nil {
-				return err
-			}
+			dels.delete(it.Item().KeyCopy(nil), tsMetadata)
 		}
 
 		it.Close()
 		wtx.Discard()
 	}
 
-	// Commit batch.
-	if err := batch.Flush(); err != nil {
-		return fmt.Errorf("mkvs/pathbadger: failed to flush batch: %w", err)
+	// Update metadata.
+	d.meta.setEarliestVersion(version + 1)
+
+	return nil
+}
+
+// forEachPrunableNodeItem invokes fn for each node item that is removed when the given version is
+// pruned.
+func (d *badgerNodeDB) forEachPrunableNodeItem(version uint64, fn func(*badger.Item) error) error {
+	for _, rootType := range api.RootTypesWithPolicy(func(p *api.RootPolicy) bool { return p.NoChildRoots }) {
+		if err := d.forEachRootTypeNodeItem(version, rootType, fn); err != nil {
+			return err
+		}
 	}
-	if err := batchMeta.Flush(); err != nil {
-		return fmt.Errorf("mkvs/pathbadger: failed to flush batch: %w", err)
+	return nil
+}
+
+// forEachRootTypeNodeItem invokes fn for each node item of the given root type that is visible in
+// the given version, including the root nodes of that version. The root type must have the
+// NoChildRoots policy.
+func (d *badgerNodeDB) forEachRootTypeNodeItem(version uint64, rootType node.RootType, fn func(*badger.Item) error) error {
+	wtx := d.db.NewTransactionAt(versionToTs(version), false)
+	defer wtx.Discard()
+
+	// All finalized nodes.
+	prefix := finalizedNodeKeyFmt.Encode(byte(rootType))
+	it := wtx.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := fn(it.Item()); err != nil {
+			return err
+		}
 	}
 
-	// Update metadata.
-	d.meta.setEarliestVersion(version + 1)
-	d.meta.commit(tx)
+	it.Close()
 
-	// Discard everything invalidated at or below the _new_ earliest version. E.g. there is no need
-	// to keep around any keys that were removed at `version + 1`.
-	d.db.SetDiscardTs(versionToTs(version + 1))
+	// All root nodes of this type (there should be only one per type).
+	prefix = append(rootNodeKeyFmt.Encode(version), byte(rootType))
+	it = wtx.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
 
+	for it.Rewind(); it.Valid(); it.Next() {
+		if err := fn(it.Item()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Implements api.NodeDB.
+func (d *badgerNodeDB) PruneDryRun(version uint64) (*api.PruneEstimate, error) {
+	d.metaUpdateLock.Lock()
+	defer d.metaUpdateLock.Unlock()
+
+	if err := d.checkPruneLocked(version); err != nil {
+		return nil, err
+	}
+
+	var estimate api.PruneEstimate
+	err := d.forEachPrunableNodeItem(version, func(item *badger.Item) error {
+		return item.Value(func(val []byte) error {
+			n, err := nodeFromDb(val)
+			if err != nil {
+				return fmt.Errorf("mkvs/pathbadger: failed to unmarshal node: %w", err)
+			}
+
+			estimate.Nodes++
+			estimate.Size += n.Size()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &estimate, nil
+}
+
 // Implements api.NodeDB.
 func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (api.Batch, error) {
 	// WARNING: There is a maximum batch size and maximum batch entry count.
@@ -634,6 +1151,9 @@ func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (
 	if d.readOnly {
 		return nil, api.ErrReadOnly
 	}
+	if d.quiescer.IsQuiesced() {
+		return nil, api.ErrQuiesced
+	}
 
 	if version != oldRoot.Version && version != oldRoot.Version+1 {
 		return nil, api.ErrRootMustFollowOld
@@ -647,7 +1167,7 @@ func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (
 		return nil, err
 	}
 
-	if !oldRoot.Hash.IsEmpty() {
+	if !oldRoot.IsEmptyTree() {
 		policy := api.PolicyForRoot(oldRoot)
 		if policy == nil {
 			return nil, fmt.Errorf("mkvs/pathbadger: unsupported root type '%s'", oldRoot.Type)
@@ -679,10 +1199,11 @@ func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (
 	}
 
 	var (
-		readTxn   *badger.Txn
-		seqNo     uint16
-		lastIndex *atomic.Uint32
-		mpLock    *sync.Mutex
+		readTxn       *badger.Txn
+		seqNo         uint16
+		lastIndex     *atomic.Uint32
+		mpLock        *sync.Mutex
+		duplicateKeys *api.DuplicateKeyBatch
 	)
 	if d.multipartVersion != multipartVersionNone {
 		readTxn = d.db.NewTransactionAt(versionToTs(version), false)
@@ -693,6 +1214,7 @@ func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (
 		lastIndex = multiMeta.lastIndex
 		// We currently only allow a single multipart batch concurrently.
 		mpLock = &multiMeta.mpLock
+		duplicateKeys = d.duplicateKeys.NewBatch()
 	} else {
 		// Reserve a sequence number for the batch.
 		var err error
@@ -712,18 +1234,36 @@ func (d *badgerNodeDB) NewBatch(oldRoot node.Root, version uint64, chunk bool) (
 		mpLock.Lock()
 	}
 
-	return &badgerBatch{
-		db:        d,
-		bat:       d.db.NewWriteBatchAt(versionToTs(version)),
-		batMeta:   d.db.NewWriteBatchAt(tsMetadata),
-		readTxn:   readTxn,
-		oldRoot:   oldRoot,
-		chunk:     chunk,
-		version:   version,
-		seqNo:     seqNo,
-		lastIndex: lastIndex,
-		mpLock:    mpLock,
-	}, nil
+	ba := &badgerBatch{
+		db:            d,
+		bat:           d.db.NewWriteBatchAt(versionToTs(version)),
+		batMeta:       d.db.NewWriteBatchAt(tsMetadata),
+		readTxn:       readTxn,
+		duplicateKeys: duplicateKeys,
+		oldRoot:       oldRoot,
+		chunk:         chunk,
+		version:       version,
+		seqNo:         seqNo,
+		lastIndex:     lastIndex,
+		mpLock:        mpLock,
+	}
+	ba.SetSizeLimits(d.maxBatchNodes, d.maxBatchBytes)
+	return ba, nil
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) Compact(ctx context.Context) error {
+	if d.readOnly {
+		return api.ErrReadOnly
+	}
+
+	d.compactLock.Lock()
+	defer d.compactLock.Unlock()
+
+	if err := cmnBadger.Compact(ctx, d.db); err != nil {
+		return fmt.Errorf("mkvs/pathbadger: failed to compact: %w", err)
+	}
+	return nil
 }
 
 // Implements api.NodeDB.
@@ -732,11 +1272,32 @@ func (d *badgerNodeDB) Size() (int64, error) {
 	return lsm + vlog, nil
 }
 
+// Implements api.NodeDB.
+func (d *badgerNodeDB) VerifySecondaryHashes(node.Root) error {
+	return api.ErrSecondaryHashesDisabled
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) CacheUsage() (int64, int, error) {
+	size, entries := cmnBadger.BlockCacheUsage(d.db)
+	return size, entries, nil
+}
+
 // Implements api.NodeDB.
 func (d *badgerNodeDB) Sync() error {
 	return d.db.Sync()
 }
 
+// Implements api.NodeDB.
+func (d *badgerNodeDB) Quiesce(ctx context.Context) (func(), error) {
+	return d.quiescer.Quiesce(ctx, d.db.Sync)
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) RecoverFromWAL() error {
+	return nil
+}
+
 // Implements api.NodeDB.
 func (d *badgerNodeDB) Close() {
 	d.closeOnce.Do(func() {
@@ -762,6 +1323,11 @@ type badgerBatch struct {
 	// readTx is the read transaction used to check for node existence during
 	// a multipart restore.
 	readTxn *badger.Txn
+	// duplicateKeys tracks the leaf keys put into the batch during a multipart restore.
+	duplicateKeys *api.DuplicateKeyBatch
+	// childRefs are the pointers to the nodes referenced, but not included, by the nodes put into
+	// a chunk batch in strict mode. Their existence is verified on commit.
+	childRefs []*node.Pointer
 
 	oldRoot   node.Root
 	chunk     bool
@@ -779,6 +1345,10 @@ type badgerBatch struct {
 
 // Implements api.Batch.
 func (ba *badgerBatch) PutWriteLog(writeLog writelog.WriteLog, annotations writelog.Annotations) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
 	if ba.chunk {
 		return fmt.Errorf("mkvs/pathbadger: cannot put write log in chunk mode")
 	}
@@ -794,11 +1364,25 @@ func (ba *badgerBatch) PutWriteLog(writeLog writelog.WriteLog, annotations write
 	return nil
 }
 
+// Implements api.Batch.
+func (ba *badgerBatch) PrefetchKeys(keys []node.Key) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	return api.PrefetchKeys(context.Background(), ba.db, ba.oldRoot, keys)
+}
+
 // Implements api.Batch.
 func (ba *badgerBatch) RemoveNodes(nodes []*node.Pointer) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
 	if ba.chunk {
 		return fmt.Errorf("mkvs/pathbadger: cannot remove nodes in chunk mode")
 	}
+	ba.TrackPendingNodes(nodes...)
 
 	for _, ptr := range nodes {
 		iptr, ok := ptr.DBInternal.(*dbPtr)
@@ -819,20 +1403,63 @@ func (ba *badgerBatch) RemoveNodes(nodes []*node.Pointer) error {
 
 // Implements api.Batch.
 func (ba *badgerBatch) Commit(root node.Root) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	if err := ba.db.quiescer.EnterWrite(); err != nil {
+		return err
+	}
+	defer ba.db.quiescer.ExitWrite()
+
 	ba.db.metaUpdateLock.Lock()
 	defer ba.db.metaUpdateLock.Unlock()
 
-	if err := ba.db.sanityCheckNamespace(&root.Namespace); err != nil {
+	return ba.commitLocked(root)
+}
+
+// Assumes metaUpdateLock is held when called.
+func (ba *badgerBatch) commitLocked(root node.Root) error {
+	tx := ba.db.db.NewTransactionAt(versionToTs(root.Version), true)
+	defer tx.Discard()
+
+	exists, err := ba.prepareCommitLocked(tx, root)
+	if err != nil {
 		return err
 	}
+	if !exists {
+		// Record sequence number for the pending (non-finalized) root. We need to commit this
+		// before storing the root to make sure we can retry in case of a crash as otherwise the
+		// root can exist but its sequence number is not known.
+		ba.db.meta.commit(tx)
+
+		if err = ba.flushLocked(root); err != nil {
+			return err
+		}
+	}
+
+	ba.Reset()
+	return ba.BaseBatch.Commit(root)
+}
+
+// prepareCommitLocked validates the commit of the batch under the given root and records the
+// sequence number of the pending root in the in-memory metadata which must be committed by the
+// caller before the batch is flushed. It returns true in case the root already exists, in which
+// case there is nothing to flush.
+//
+// Assumes metaUpdateLock is held when called.
+func (ba *badgerBatch) prepareCommitLocked(tx *badger.Txn, root node.Root) (bool, error) {
+	if err := ba.db.sanityCheckNamespace(&root.Namespace); err != nil {
+		return false, err
+	}
 	if !root.Follows(&ba.oldRoot) {
-		return api.ErrRootMustFollowOld
+		return false, api.ErrRootMustFollowOld
 	}
 
 	// Make sure that the version that we try to commit into has not yet been finalized.
 	lastFinalizedVersion, exists := ba.db.meta.getLastFinalizedVersion()
 	if exists && lastFinalizedVersion >= root.Version {
-		return api.ErrAlreadyFinalized
+		return false, api.ErrAlreadyFinalized
 	}
 
 	rootHash := api.TypedHashFromRoot(root)
@@ -840,41 +1467,36 @@ func (ba *badgerBatch) Commit(root node.Root) error {
 
 	if ba.db.multipartVersion != multipartVersionNone {
 		if ba.db.multipartVersion != root.Version {
-			return api.ErrInvalidMultipartVersion
+			return false, api.ErrInvalidMultipartVersion
 		}
 
 		multiMeta := ba.db.multipartMeta[uint8(rootHash.Type())]
 		if multiMeta.root != nil && !multiMeta.root.Equal(&rootHash) {
-			return fmt.Errorf("mkvs/pathbadger: cannot change multipart root for type '%s'", root.Type)
+			return false, fmt.Errorf("mkvs/pathbadger: cannot change multipart root for type '%s'", root.Type)
 		}
 		multiMeta.root = &rootHash
 	}
 
-	// Check if the root already exists.
-	tx := ba.db.db.NewTransactionAt(versionToTs(root.Version), true)
-	defer tx.Discard()
-
 	// If we are not importing a chunk, check if the root already exists.
 	if !ba.chunk {
 		if err := ba.db.checkRootExists(tx, root); err == nil {
 			// No need to do anything since if the hash matches, everything will be identical and we
 			// would just be duplicating work.
-			ba.Reset()
-			return ba.BaseBatch.Commit(root)
+			return true, nil
 		}
 	}
 
 	// Check if the root node was committed. In cases where the root has not actually changed, there
 	// may be no root node and so we need to actually get it from the old version.
-	if len(ba.newRootValue) == 0 && !root.Hash.IsEmpty() {
+	if len(ba.newRootValue) == 0 && !root.IsEmptyTree() {
 		if !rootHash.Equal(&oldRootHash) {
 			// Should never happen unless something is seriously wrong.
-			return fmt.Errorf("mkvs/pathbadger: no new root node, but new root hash not equal to old")
+			return false, fmt.Errorf("mkvs/pathbadger: no new root node, but new root hash not equal to old")
 		}
 
 		item, err := tx.Get(rootNodeKeyFmt.Encode(ba.oldRoot.Version, &oldRootHash))
 		if err != nil {
-			return fmt.Errorf("mkvs/pathbadger: failed to fetch old root node: %w", err)
+			return false, fmt.Errorf("mkvs/pathbadger: failed to fetch old root node: %w", err)
 		}
 
 		err = item.Value(func(data []byte) error {
@@ -882,17 +1504,25 @@ func (ba *badgerBatch) Commit(root node.Root) error {
 			return nil
 		})
 		if err != nil {
-			return fmt.Errorf("mkvs/pathbadger: failed to copy old root node: %w", err)
+			return false, fmt.Errorf("mkvs/pathbadger: failed to copy old root node: %w", err)
 		}
 	}
 
-	// Record sequence number for the pending (non-finalized) root. We need to commit this before
-	// storing the root to make sure we can retry in case of a crash as otherwise the root can exist
-	// but its sequence number is not known.
+	// Record sequence number for the pending (non-finalized) root.
 	if err := ba.db.meta.setPendingRootSeqNo(root.Version, rootHash, ba.seqNo); err != nil {
-		return fmt.Errorf("mkvs/pathbadger: failed to set pending root seqno: %w", err)
+		return false, fmt.Errorf("mkvs/pathbadger: failed to set pending root seqno: %w", err)
 	}
-	ba.db.meta.commit(tx)
+	return false, nil
+}
+
+// flushLocked stores the updated nodes index, the write log and finally the root node of the
+// batch under the given root and flushes all node updates. The root must have been prepared by
+// prepareCommitLocked.
+//
+// Assumes metaUpdateLock is held when called.
+func (ba *badgerBatch) flushLocked(root node.Root) error {
+	rootHash := api.TypedHashFromRoot(root)
+	oldRootHash := api.TypedHashFromRoot(ba.oldRoot)
 
 	if !ba.chunk {
 		// Store updated nodes (only needed until the version is finalized).
@@ -902,7 +1532,7 @@ func (ba *badgerBatch) Commit(root node.Root) error {
 		}
 
 		// Store write log.
-		if err := storeInternalWriteLog(ba.batMeta, oldRootHash, rootHash, root.Version, ba.writeLog, ba.annotations); err != nil {
+		if err := storeInternalWriteLog(ba.batMeta, ba.db.writeLogFormatVersion, oldRootHash, rootHash, root.Version, ba.writeLog, ba.annotations); err != nil {
 			return err
 		}
 	}
@@ -912,6 +1542,15 @@ func (ba *badgerBatch) Commit(root node.Root) error {
 		return err
 	}
 
+	if err := ba.checkChildRefs(); err != nil {
+		return err
+	}
+
+	// Make sure no other batch has imported any of the same keys in the meantime.
+	if err := ba.duplicateKeys.Check(); err != nil {
+		return err
+	}
+
 	// Flush node updates.
 	if err := ba.batMeta.Flush(); err != nil {
 		return fmt.Errorf("mkvs/pathbadger: failed to flush batch: %w", err)
@@ -919,9 +1558,43 @@ func (ba *badgerBatch) Commit(root node.Root) error {
 	if err := ba.bat.Flush(); err != nil {
 		return fmt.Errorf("mkvs/pathbadger: failed to flush batch: %w", err)
 	}
+	ba.duplicateKeys.Commit()
+	return nil
+}
 
-	ba.Reset()
-	return ba.BaseBatch.Commit(root)
+// abortCommitLocked removes the root node stored by flushLocked after the commit of the batch
+// under the given root has failed without its metadata being committed. Other flushed nodes are
+// unreachable without the root node.
+//
+// Assumes metaUpdateLock is held when called.
+func (ba *badgerBatch) abortCommitLocked(root node.Root) {
+	rootHash := api.TypedHashFromRoot(root)
+
+	tx := ba.db.db.NewTransactionAt(versionToTs(root.Version), true)
+	defer tx.Discard()
+
+	err := tx.Delete(rootNodeKeyFmt.Encode(root.Version, &rootHash))
+	if err == nil {
+		err = tx.CommitAt(versionToTs(root.Version), nil)
+	}
+	if err != nil {
+		ba.db.logger.Error("failed to remove root node of aborted commit",
+			"err", err,
+			"root", root,
+		)
+	}
+}
+
+// Implements api.Batch.
+func (ba *badgerBatch) CommitExpecting(expectedRoot node.Root) error {
+	if err := ba.CheckDiscarded(); err != nil {
+		return err
+	}
+
+	if err := ba.CheckExpectedRoot(expectedRoot); err != nil {
+		return err
+	}
+	return ba.Commit(expectedRoot)
 }
 
 // Implements api.Batch.
@@ -937,9 +1610,23 @@ func (ba *badgerBatch) Reset() {
 	ba.annotations = nil
 	ba.updatedNodes = nil
 	ba.newRootValue = nil
+	ba.duplicateKeys.Reset()
+	ba.childRefs = nil
+	ba.BaseBatch.Reset()
 
 	if ba.mpLock != nil {
 		ba.mpLock.Unlock()
 		ba.mpLock = nil
 	}
 }
+
+// Implements api.Batch.
+func (ba *badgerBatch) Discard() {
+	if ba.Discarded() {
+		return
+	}
+
+	// Reset already cancels any pending writes and releases the transactions.
+	ba.Reset()
+	ba.BaseBatch.Discard()
+}
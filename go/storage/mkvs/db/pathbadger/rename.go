@@ -0,0 +1,95 @@
+package pathbadger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"github.com/oasisprotocol/oasis-core/go/common"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+)
+
+func init() {
+	api.RegisterNamespaceRewriter(Factory.Name(), rewriteNamespace)
+}
+
+// rewriteNamespace changes the namespace specified in the database without verifying that it
+// matches the configured namespace.
+//
+// It returns false in case the database does not exist or does not contain pathbadger backend
+// metadata.
+func rewriteNamespace(cfg *api.Config, newNamespace common.Namespace) (bool, error) {
+	if cfg.ReadOnly {
+		return false, api.ErrReadOnly
+	}
+
+	// Opening the database creates it in case it does not exist.
+	if _, err := os.Stat(cfg.DB); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	logger := logging.GetLogger("mkvs/db/pathbadger/rename")
+	opts := commonConfigToBadgerOptions(cfg, logger)
+
+	db, err := badger.OpenManaged(opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	tx := db.NewTransactionAt(tsMetadata, true)
+	defer tx.Discard()
+
+	item, err := tx.Get(metadataKeyFmt.Encode())
+	switch err {
+	case nil:
+	case badger.ErrKeyNotFound:
+		// Nothing to rename.
+		return false, nil
+	default:
+		return false, err
+	}
+
+	var meta metadata
+	err = item.Value(func(data []byte) error {
+		return cbor.UnmarshalTrusted(data, &meta.value)
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to load database metadata: %w", err)
+	}
+
+	// Sanity checks.
+	if meta.value.Version != dbVersion {
+		return true, fmt.Errorf("incompatible database version (expected: %d got: %d)",
+			dbVersion,
+			meta.value.Version,
+		)
+	}
+	if meta.value.Namespace.Equal(&newNamespace) {
+		return true, nil
+	}
+
+	// Rename the namespace in database metadata.
+	oldNamespace := meta.value.Namespace
+	meta.value.Namespace = newNamespace
+	if err = tx.Set(metadataKeyFmt.Encode(), cbor.Marshal(meta.value)); err != nil {
+		return true, fmt.Errorf("failed to save database metadata: %w", err)
+	}
+	if err = tx.CommitAt(tsMetadata, nil); err != nil {
+		return true, fmt.Errorf("failed to commit database metadata: %w", err)
+	}
+
+	logger.Info("renamed database namespace",
+		"old_namespace", oldNamespace,
+		"new_namespace", newNamespace,
+	)
+
+	return true, nil
+}
@@ -38,9 +38,31 @@ func makeInternalWriteLog(writeLog writelog.WriteLog, annotations writelog.Annot
 	return log
 }
 
+// marshalInternalWriteLog serializes the given internal write log using the given write log format.
+func marshalInternalWriteLog(formatVersion uint64, log internalWriteLog) ([]byte, error) {
+	switch formatVersion {
+	case api.WriteLogFormatV1:
+		return cbor.Marshal(log), nil
+	default:
+		return nil, api.CheckWriteLogFormatVersion(formatVersion)
+	}
+}
+
+// unmarshalInternalWriteLog deserializes the given internal write log using the given write log
+// format.
+func unmarshalInternalWriteLog(formatVersion uint64, data []byte, log *internalWriteLog) error {
+	switch formatVersion {
+	case api.WriteLogFormatV1:
+		return cbor.UnmarshalTrusted(data, log)
+	default:
+		return api.CheckWriteLogFormatVersion(formatVersion)
+	}
+}
+
 // storeInternalWriteLog stores the given write log using an internal database representation.
 func storeInternalWriteLog(
 	batch *badger.WriteBatch,
+	formatVersion uint64,
 	startRootHash api.TypedHash,
 	endRootHash api.TypedHash,
 	endRootVersion uint64,
@@ -51,9 +73,13 @@ func storeInternalWriteLog(
 		return nil
 	}
 	intLog := makeInternalWriteLog(writeLog, annotations)
+	data, err := marshalInternalWriteLog(formatVersion, intLog)
+	if err != nil {
+		return fmt.Errorf("mkvs/pathbadger: failed to marshal write log: %w", err)
+	}
 
 	key := writeLogKeyFmt.Encode(endRootVersion, &endRootHash, &startRootHash)
-	if err := batch.Set(key, cbor.Marshal(intLog)); err != nil {
+	if err = batch.Set(key, data); err != nil {
 		return fmt.Errorf("mkvs/pathbadger: set new write log returned error: %w", err)
 	}
 	return nil
@@ -61,22 +87,56 @@ func storeInternalWriteLog(
 
 // Implements api.NodeDB.
 func (d *badgerNodeDB) GetWriteLog(_ context.Context, startRoot, endRoot node.Root) (writelog.Iterator, error) {
+	wl, _, err := d.getWriteLog(startRoot, endRoot, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return writelog.NewStaticIterator(wl), nil
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) GetWriteLogForPrefix(_ context.Context, startRoot, endRoot node.Root, prefix node.Key) (writelog.Iterator, error) {
+	wl, _, err := d.getWriteLog(startRoot, endRoot, prefix, false)
+	if err != nil {
+		return nil, err
+	}
+	return writelog.NewStaticIterator(wl), nil
+}
+
+// Implements api.NodeDB.
+func (d *badgerNodeDB) GetWriteLogAnnotations(_ context.Context, startRoot, endRoot node.Root) (writelog.Annotations, error) {
+	_, annotations, err := d.getWriteLog(startRoot, endRoot, nil, true)
+	return annotations, err
+}
+
+// getWriteLog retrieves a write log between two roots, only including entries whose key has the
+// given prefix. In case withAnnotations is true, the write log annotations are also returned.
+func (d *badgerNodeDB) getWriteLog(
+	startRoot node.Root,
+	endRoot node.Root,
+	prefix node.Key,
+	withAnnotations bool,
+) (writelog.WriteLog, writelog.Annotations, error) {
 	if d.discardWriteLogs {
-		return nil, api.ErrWriteLogNotFound
+		return nil, nil, api.ErrWriteLogNotFound
 	}
 	if !endRoot.Follows(&startRoot) {
-		return nil, api.ErrRootMustFollowOld
+		return nil, nil, api.ErrRootMustFollowOld
 	}
 	if err := d.sanityCheckNamespace(&startRoot.Namespace); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// If the version is earlier than the earliest version, we don't have the roots.
 	if endRoot.Version < d.meta.getEarliestVersion() {
-		return nil, api.ErrWriteLogNotFound
+		return nil, nil, api.ErrWriteLogNotFound
+	}
+	// If the version is earlier than the earliest write logs version, the write logs were pruned.
+	if endRoot.Version < d.meta.getWriteLogsEarliestVersion() {
+		return nil, nil, api.ErrWriteLogNotFound
 	}
 	// If difference between versions is more than 1 we can reject early.
 	if endRoot.Version-startRoot.Version > 1 {
-		return nil, api.ErrWriteLogNotFound
+		return nil, nil, api.ErrWriteLogNotFound
 	}
 
 	tx := d.db.NewTransactionAt(versionToTs(endRoot.Version), false)
@@ -84,7 +144,7 @@ func (d *badgerNodeDB) GetWriteLog(_ context.Context, startRoot, endRoot node.Ro
 
 	// Check if the root actually exists.
 	if err := d.checkRootExists(tx, endRoot); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	startRootHash := api.TypedHashFromRoot(startRoot)
@@ -94,22 +154,22 @@ func (d *badgerNodeDB) GetWriteLog(_ context.Context, startRoot, endRoot node.Ro
 	switch err {
 	case nil:
 	case badger.ErrKeyNotFound:
-		return nil, api.ErrWriteLogNotFound
+		return nil, nil, api.ErrWriteLogNotFound
 	default:
-		return nil, fmt.Errorf("mkvs/pathbadger: failed to fetch write log: %w", err)
+		return nil, nil, fmt.Errorf("mkvs/pathbadger: failed to fetch write log: %w", err)
 	}
 
 	var log internalWriteLog
 	if err = item.Value(func(data []byte) error {
-		return cbor.UnmarshalTrusted(data, &log)
+		return unmarshalInternalWriteLog(d.writeLogFormatVersion, data, &log)
 	}); err != nil {
-		return nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal write log: %w", err)
+		return nil, nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal write log: %w", err)
 	}
 
 	// Determine sequence number for the root. All finalized roots use a seqNo of zero.
 	seqNo, _ := d.meta.getPendingRootSeqNo(endRoot.Version, endRootHash)
 	if seqNo != 0 {
-		return nil, api.ErrWriteLogNotFound
+		return nil, nil, api.ErrWriteLogNotFound
 	}
 
 	// Note the root node dbKey as an entry could also end there.
@@ -117,11 +177,35 @@ func (d *badgerNodeDB) GetWriteLog(_ context.Context, startRoot, endRoot node.Ro
 
 	// Resolve the write log.
 	wl := make(writelog.WriteLog, 0, len(log))
+	var annotations writelog.Annotations
+	if withAnnotations {
+		annotations = make(writelog.Annotations, 0, len(log))
+	}
+	appendEntry := func(key, value []byte, inserted []byte) {
+		wl = append(wl, writelog.LogEntry{Key: key, Value: value})
+		if !withAnnotations {
+			return
+		}
+
+		var ann writelog.LogEntryAnnotation
+		if inserted != nil {
+			leaf := &node.LeafNode{Clean: true, Key: key, Value: value}
+			leaf.UpdateHash()
+			ann.InsertedNode = &node.Pointer{Clean: true, Hash: leaf.Hash, Node: leaf}
+			if iptr, ok := dbPtrFromKey(inserted); ok {
+				ann.InsertedNode.DBInternal = iptr
+			}
+		}
+		annotations = append(annotations, ann)
+	}
 	for _, key := range log {
 		switch key[0] {
 		case internalWriteLogKindDelete:
 			// Deletion.
-			wl = append(wl, writelog.LogEntry{Key: key[1:]})
+			if !bytes.HasPrefix(key[1:], prefix) {
+				continue
+			}
+			appendEntry(key[1:], nil, nil)
 		case internalWriteLogKindInsert:
 			// Insertion.
 			if bytes.Equal(key[1:], rootNodeDbKey) {
@@ -129,20 +213,23 @@ func (d *badgerNodeDB) GetWriteLog(_ context.Context, startRoot, endRoot node.Ro
 				var rootNodeKey, rootNodeValue []byte
 				item, err = tx.Get(rootNodeKeyFmt.Encode(endRoot.Version, &endRootHash))
 				if err != nil {
-					return nil, fmt.Errorf("mkvs/pathbadger: failed to fetch root node: %w", err)
+					return nil, nil, fmt.Errorf("mkvs/pathbadger: failed to fetch root node: %w", err)
 				}
 				if err = item.Value(func(rawValue []byte) error {
 					rootNodeKey, rootNodeValue, err = leafFromDb(rawValue)
 					return err
 				}); err != nil {
-					return nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal root node: %w", err)
+					return nil, nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal root node: %w", err)
 				}
 
-				wl = append(wl, writelog.LogEntry{Key: rootNodeKey, Value: rootNodeValue})
+				if bytes.HasPrefix(rootNodeKey, prefix) {
+					appendEntry(rootNodeKey, rootNodeValue, key[1:])
+				}
 				continue
 			}
 
-			item, err = tx.Get(finalizedNodeKeyFmt.Encode(byte(endRoot.Type), key[1:]))
+			dbKey := key[1:]
+			item, err = tx.Get(finalizedNodeKeyFmt.Encode(byte(endRoot.Type), dbKey))
 			switch err {
 			case nil:
 				// Key has been inserted, resolve value from node.
@@ -151,16 +238,18 @@ func (d *badgerNodeDB) GetWriteLog(_ context.Context, startRoot, endRoot node.Ro
 					key, value, err = leafFromDb(rawValue)
 					return err
 				}); err != nil {
-					return nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal node: %w", err)
+					return nil, nil, fmt.Errorf("mkvs/pathbadger: failed to unmarshal node: %w", err)
+				}
+				if bytes.HasPrefix(key, prefix) {
+					appendEntry(key, value, dbKey)
 				}
-				wl = append(wl, writelog.LogEntry{Key: key, Value: value})
 			default:
-				return nil, fmt.Errorf("mkvs/pathbadger: failed to fetch node: %w", err)
+				return nil, nil, fmt.Errorf("mkvs/pathbadger: failed to fetch node: %w", err)
 			}
 		default:
-			return nil, fmt.Errorf("mkvs/pathbadger: internal write log is corrupted")
+			return nil, nil, fmt.Errorf("mkvs/pathbadger: internal write log is corrupted")
 		}
 	}
 
-	return writelog.NewStaticIterator(wl), nil
+	return wl, annotations, nil
 }
@@ -135,18 +135,19 @@ func (t *tree) doInsert(
 		// Key mismatches the label at position cpLength. Split the edge and
 		// insert new leaf.
 		labelPrefix, labelSuffix := n.Label.Split(cpLength, n.LabelBitLength)
-		n.Label = labelSuffix
-		n.LabelBitLength = n.LabelBitLength - cpLength
 
 		if n.Clean {
 			// Node was clean so old node is eligible for removal.
 			t.pendingRemovedNodes = append(t.pendingRemovedNodes, ptr.ExtractUnchecked())
 		}
 
+		// No longer eligible for eviction as it is dirty. This must be done before the node is
+		// modified so that the cache accounts for its previous size.
+		t.cache.rollbackNode(ptr)
+		n.Label = labelSuffix
+		n.LabelBitLength = n.LabelBitLength - cpLength
 		n.Clean = false
 		ptr.SetDirty()
-		// No longer eligible for eviction as it is dirty.
-		t.cache.rollbackNode(ptr)
 
 		newLeaf := t.cache.newLeafNode(key, val)
 		var leafNode, left, right *node.Pointer
@@ -189,11 +190,12 @@ func (t *tree) doInsert(
 				t.pendingRemovedNodes = append(t.pendingRemovedNodes, ptr.ExtractUnchecked())
 			}
 
+			// No longer eligible for eviction as it is dirty. This must be done before the node
+			// is modified so that the cache accounts for its previous size.
+			t.cache.rollbackNode(ptr)
 			n.Value = val
 			n.Clean = false
 			ptr.SetDirty()
-			// No longer eligible for eviction as it is dirty.
-			t.cache.rollbackNode(ptr)
 			return insertResult{
 				newRoot:      ptr,
 				insertedLeaf: ptr,
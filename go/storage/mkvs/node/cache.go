@@ -0,0 +1,98 @@
+package node
+
+import "container/list"
+
+// NodeCache is a size-bounded cache of resolved nodes that evicts the least recently used nodes.
+//
+// The cache keeps track of the pointers in recency order using their LRU list elements. Evicting
+// a pointer drops its node while keeping its hash, so the node can later be resolved again.
+// Dirty pointers are never evicted as their nodes could not be resolved again.
+//
+// The cache is not safe for concurrent use.
+type NodeCache struct {
+	lru *list.List
+
+	maxBytes uint64
+	size     uint64
+}
+
+type nodeCacheEntry struct {
+	ptr  *Pointer
+	size uint64
+}
+
+// NewNodeCache creates a new node cache holding up to maxBytes worth of pointers, as determined
+// by their Size. A capacity of 0 means unlimited.
+func NewNodeCache(maxBytes uint64) *NodeCache {
+	return &NodeCache{
+		lru:      list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+// Size returns the combined size of the cached pointers in bytes.
+func (c *NodeCache) Size() uint64 {
+	return c.size
+}
+
+// Len returns the number of cached pointers.
+func (c *NodeCache) Len() int {
+	return c.lru.Len()
+}
+
+// Add adds the given pointer to the cache as the most recently used one and evicts the least
+// recently used pointers in case the cache exceeds its capacity. The added pointer itself is
+// never evicted by Add.
+//
+// Adding a pointer that is already cached is equivalent to Touch. Pointers must not be tracked
+// by another cache (i.e. their LRU must be nil).
+func (c *NodeCache) Add(ptr *Pointer) {
+	if ptr == nil {
+		return
+	}
+	if ptr.LRU != nil {
+		c.Touch(ptr)
+		return
+	}
+
+	entry := &nodeCacheEntry{ptr: ptr, size: ptr.Size()}
+	ptr.LRU = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	c.evict(ptr)
+}
+
+// Touch marks the given cached pointer as the most recently used one.
+//
+// This is a no-op for pointers that are not cached.
+func (c *NodeCache) Touch(ptr *Pointer) {
+	if ptr == nil || ptr.LRU == nil {
+		return
+	}
+	c.lru.MoveToFront(ptr.LRU)
+}
+
+// Evict evicts the least recently used clean pointers until the cache is within its capacity or
+// only dirty pointers remain.
+func (c *NodeCache) Evict() {
+	c.evict(nil)
+}
+
+func (c *NodeCache) evict(keep *Pointer) {
+	if c.maxBytes == 0 {
+		return
+	}
+
+	for elem := c.lru.Back(); elem != nil && c.size > c.maxBytes; {
+		prev := elem.Prev()
+
+		entry := elem.Value.(*nodeCacheEntry)
+		if entry.ptr.Clean && entry.ptr != keep {
+			c.lru.Remove(elem)
+			c.size -= entry.size
+			entry.ptr.LRU = nil
+			entry.ptr.Node = nil
+		}
+		elem = prev
+	}
+}
@@ -0,0 +1,80 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeCache(t *testing.T) {
+	require := require.New(t)
+
+	newLeaf := func(i int) *Pointer {
+		leafNode := &LeafNode{
+			Clean: true,
+			Key:   []byte(fmt.Sprintf("key %d", i)),
+			Value: []byte(fmt.Sprintf("value %d", i)),
+		}
+		leafNode.UpdateHash()
+		return &Pointer{Clean: true, Hash: leafNode.Hash, Node: leafNode}
+	}
+
+	var ptrs []*Pointer
+	for i := 0; i < 4; i++ {
+		ptrs = append(ptrs, newLeaf(i))
+	}
+	ptrSize := ptrs[0].Size()
+
+	cache := NewNodeCache(3 * ptrSize)
+	for _, ptr := range ptrs[:3] {
+		cache.Add(ptr)
+		require.NotNil(ptr.LRU, "added pointer should have an LRU element")
+	}
+	require.Equal(3, cache.Len())
+	require.Equal(3*ptrSize, cache.Size())
+
+	// Adding an already cached pointer should not change the size.
+	cache.Add(ptrs[0])
+	require.Equal(3, cache.Len())
+	require.Equal(3*ptrSize, cache.Size())
+
+	// The least recently used pointer (ptrs[1]) should be evicted.
+	cache.Add(ptrs[3])
+	require.Equal(3, cache.Len())
+	require.Equal(3*ptrSize, cache.Size())
+	require.Nil(ptrs[1].LRU, "evicted pointer should not have an LRU element")
+	require.Nil(ptrs[1].Node, "evicted pointer should drop its node")
+	require.False(ptrs[1].Hash.IsEmpty(), "evicted pointer should keep its hash")
+	for _, i := range []int{0, 2, 3} {
+		require.NotNil(ptrs[i].Node, "pointer %d should not be evicted", i)
+	}
+
+	// Touching a pointer should protect it from eviction.
+	cache.Touch(ptrs[2])
+	cache.Add(ptrs[1])
+	require.Nil(ptrs[0].Node, "least recently used pointer should be evicted")
+	require.NotNil(ptrs[2].Node, "touched pointer should not be evicted")
+
+	// Dirty pointers should never be evicted.
+	dirty, clean := newLeaf(4), newLeaf(5)
+	dirty.Clean = false
+	cache = NewNodeCache(ptrSize)
+	cache.Add(dirty)
+	cache.Add(clean)
+	require.NotNil(clean.Node, "added pointer should not be evicted by Add")
+	require.NotNil(dirty.Node, "dirty pointer should not be evicted")
+	cache.Evict()
+	require.Nil(clean.Node, "clean pointer should be evicted")
+	require.NotNil(dirty.Node, "dirty pointer should not be evicted")
+	require.Equal(1, cache.Len())
+	require.Equal(dirty.Size(), cache.Size())
+
+	// Unlimited caches should never evict.
+	cache = NewNodeCache(0)
+	for i := 0; i < 10; i++ {
+		cache.Add(newLeaf(i))
+	}
+	cache.Evict()
+	require.Equal(10, cache.Len())
+}
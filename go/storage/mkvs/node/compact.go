@@ -0,0 +1,181 @@
+package node
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// compactMarshalBinary dispatches to the compact encoding of the given version.
+func compactMarshalBinary(n Node, version uint16) ([]byte, error) {
+	switch version {
+	case 0:
+		return n.CompactMarshalBinaryV0()
+	case 1:
+		return n.CompactMarshalBinaryV1()
+	case 2:
+		return n.CompactMarshalBinaryV2()
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCompactVersion, version)
+	}
+}
+
+// CompactMarshalBinary encodes an internal node into binary form using the
+// compact encoding of the given version.
+func (n *InternalNode) CompactMarshalBinary(version uint16) ([]byte, error) {
+	return compactMarshalBinary(n, version)
+}
+
+// CompactMarshalBinary encodes a leaf node into binary form using the compact
+// encoding of the given version.
+func (n *LeafNode) CompactMarshalBinary(version uint16) ([]byte, error) {
+	return compactMarshalBinary(n, version)
+}
+
+// UnmarshalCompact unmarshals a node of arbitrary type encoded using the compact
+// encoding of the given version.
+func UnmarshalCompact(version uint16, data []byte) (Node, error) {
+	switch version {
+	case 0, 1:
+		// Version 0 and 1 compact encodings are compatible with the full encoding.
+		return UnmarshalBinary(data)
+	case 2:
+		return UnmarshalCompactV2(data)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedCompactVersion, version)
+	}
+}
+
+// CompactMarshalBinaryV2 encodes an internal node into binary form without
+// any hash pointers and without the leaf node, using a varint-encoded label
+// bit length.
+func (n *InternalNode) CompactMarshalBinaryV2() (data []byte, err error) {
+	data = make([]byte, 0, 1+binary.MaxVarintLen16+len(n.Label)+1)
+	data = append(data, PrefixInternalNode)
+	data = binary.AppendUvarint(data, uint64(n.LabelBitLength))
+	data = append(data, n.Label...)
+	data = append(data, PrefixNilNode)
+
+	return
+}
+
+// sizedUnmarshalCompactV2 decodes a version 2 compact marshaled internal node.
+func (n *InternalNode) sizedUnmarshalCompactV2(data []byte) (int, error) {
+	if len(data) < 3 || data[0] != PrefixInternalNode {
+		return 0, ErrMalformedNode
+	}
+	pos := 1
+
+	labelBitLength, size := binary.Uvarint(data[pos:])
+	if size <= 0 || labelBitLength > math.MaxUint16 {
+		return 0, ErrMalformedNode
+	}
+	pos += size
+
+	n.LabelBitLength = Depth(labelBitLength)
+	labelLen := n.LabelBitLength.ToBytes()
+	if pos+labelLen >= len(data) {
+		return 0, ErrMalformedNode
+	}
+	n.Label = make(Key, labelLen)
+	copy(n.Label, data[pos:pos+labelLen])
+	pos += labelLen
+
+	// Leaf nodes are never included in the compact internal node encoding.
+	if data[pos] != PrefixNilNode {
+		return 0, ErrMalformedNode
+	}
+	pos++
+
+	n.LeafNode = nil
+	n.Left = nil
+	n.Right = nil
+	n.Clean = true
+
+	return pos, nil
+}
+
+// CompactMarshalBinaryV2 encodes a leaf node into binary form using
+// varint-encoded key and value lengths.
+func (n *LeafNode) CompactMarshalBinaryV2() (data []byte, err error) {
+	data = make([]byte, 0, 1+2*binary.MaxVarintLen32+len(n.Key)+len(n.Value))
+	data = append(data, PrefixLeafNode)
+	data = binary.AppendUvarint(data, uint64(len(n.Key)))
+	data = append(data, n.Key...)
+	data = binary.AppendUvarint(data, uint64(len(n.Value)))
+	data = append(data, n.Value...)
+
+	return
+}
+
+// sizedUnmarshalCompactV2 decodes a version 2 compact marshaled leaf node.
+func (n *LeafNode) sizedUnmarshalCompactV2(data []byte) (int, error) {
+	if len(data) < 3 || data[0] != PrefixLeafNode {
+		return 0, ErrMalformedNode
+	}
+	pos := 1
+
+	keySize, size := binary.Uvarint(data[pos:])
+	if size <= 0 || keySize > math.MaxUint16 {
+		return 0, ErrMalformedKey
+	}
+	pos += size
+	if uint64(len(data)-pos) < keySize {
+		return 0, ErrMalformedKey
+	}
+	key := make(Key, keySize)
+	copy(key, data[pos:pos+int(keySize)])
+	pos += int(keySize)
+
+	valueSize, size := binary.Uvarint(data[pos:])
+	if size <= 0 || valueSize > uint64(MaxValueSize()) {
+		return 0, ErrMalformedNode
+	}
+	pos += size
+	if uint64(len(data)-pos) < valueSize {
+		return 0, ErrMalformedNode
+	}
+	value := make([]byte, valueSize)
+	copy(value, data[pos:pos+int(valueSize)])
+	pos += int(valueSize)
+
+	n.Clean = true
+	n.Key = key
+	n.Value = value
+
+	n.UpdateHash()
+
+	return pos, nil
+}
+
+// UnmarshalCompactV2 unmarshals a version 2 compact encoded node of arbitrary type.
+func UnmarshalCompactV2(data []byte) (Node, error) {
+	if len(data) < 1 {
+		return nil, ErrMalformedNode
+	}
+
+	var (
+		nd   Node
+		size int
+		err  error
+	)
+	switch data[0] {
+	case PrefixLeafNode:
+		var leaf LeafNode
+		size, err = leaf.sizedUnmarshalCompactV2(data)
+		nd = &leaf
+	case PrefixInternalNode:
+		var inode InternalNode
+		size, err = inode.sizedUnmarshalCompactV2(data)
+		nd = &inode
+	default:
+		return nil, ErrMalformedNode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size != len(data) {
+		return nil, ErrMalformedNode
+	}
+	return nd, nil
+}
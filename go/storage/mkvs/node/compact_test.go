@@ -0,0 +1,159 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializationCompactV2(t *testing.T) {
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	leafNode.UpdateHash()
+
+	rawLeafNode, err := leafNode.CompactMarshalBinaryV2()
+	require.NoError(t, err, "CompactMarshalBinaryV2")
+	rawLeafNodeV1, err := leafNode.CompactMarshalBinaryV1()
+	require.NoError(t, err, "CompactMarshalBinaryV1")
+	require.Less(t, len(rawLeafNode), len(rawLeafNodeV1), "V2 encoding should be smaller for small values")
+
+	decoded, err := UnmarshalCompactV2(rawLeafNode)
+	require.NoError(t, err, "UnmarshalCompactV2")
+	decodedLeafNode, ok := decoded.(*LeafNode)
+	require.True(t, ok, "decoded node should be a leaf node")
+	require.True(t, decodedLeafNode.Clean)
+	require.Equal(t, leafNode.Key, decodedLeafNode.Key)
+	require.Equal(t, leafNode.Value, decodedLeafNode.Value)
+	require.Equal(t, leafNode.Hash, decodedLeafNode.Hash, "hash should not depend on the encoding")
+
+	intNode := &InternalNode{
+		Label:          Key("abc"),
+		LabelBitLength: Depth(24),
+		LeafNode:       &Pointer{Clean: true, Node: leafNode, Hash: leafNode.Hash},
+	}
+	intNode.UpdateHash()
+
+	rawIntNode, err := intNode.CompactMarshalBinaryV2()
+	require.NoError(t, err, "CompactMarshalBinaryV2")
+
+	decoded, err = UnmarshalCompactV2(rawIntNode)
+	require.NoError(t, err, "UnmarshalCompactV2")
+	decodedIntNode, ok := decoded.(*InternalNode)
+	require.True(t, ok, "decoded node should be an internal node")
+	require.True(t, decodedIntNode.Clean)
+	require.Equal(t, intNode.Label, decodedIntNode.Label)
+	require.Equal(t, intNode.LabelBitLength, decodedIntNode.LabelBitLength)
+	require.Nil(t, decodedIntNode.LeafNode)
+	require.Nil(t, decodedIntNode.Left)
+	require.Nil(t, decodedIntNode.Right)
+
+	// Malformed encodings.
+	for _, data := range [][]byte{
+		nil,
+		{PrefixNilNode},
+		rawLeafNode[:len(rawLeafNode)-1],
+		append(append([]byte{}, rawLeafNode...), 0x00),
+		rawIntNode[:len(rawIntNode)-1],
+		{PrefixInternalNode, 0xff, 0xff, 0xff, 0xff, 0x0f},
+	} {
+		_, err = UnmarshalCompactV2(data)
+		require.Error(t, err, "UnmarshalCompactV2 should fail on malformed data")
+	}
+}
+
+func TestCompactMarshalBinaryVersions(t *testing.T) {
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	leafNode.UpdateHash()
+
+	intNode := &InternalNode{
+		Label:          Key("abc"),
+		LabelBitLength: Depth(24),
+		LeafNode:       &Pointer{Clean: true, Node: leafNode, Hash: leafNode.Hash},
+	}
+	intNode.UpdateHash()
+
+	for _, tc := range []struct {
+		version uint16
+		marshal func(Node) ([]byte, error)
+	}{
+		{0, Node.CompactMarshalBinaryV0},
+		{1, Node.CompactMarshalBinaryV1},
+		{2, Node.CompactMarshalBinaryV2},
+	} {
+		for _, n := range []Node{leafNode, intNode} {
+			expected, err := tc.marshal(n)
+			require.NoError(t, err)
+			data, err := n.CompactMarshalBinary(tc.version)
+			require.NoError(t, err, "CompactMarshalBinary(%d)", tc.version)
+			require.Equal(t, expected, data, "CompactMarshalBinary(%d) should dispatch to the right encoding", tc.version)
+
+			decoded, err := UnmarshalCompact(tc.version, data)
+			require.NoError(t, err, "UnmarshalCompact(%d)", tc.version)
+			require.IsType(t, n, decoded)
+		}
+
+		// Only version 0 includes the leaf in the internal node.
+		data, err := intNode.CompactMarshalBinary(tc.version)
+		require.NoError(t, err)
+		decoded, err := UnmarshalCompact(tc.version, data)
+		require.NoError(t, err)
+		if tc.version == 0 {
+			require.NotNil(t, decoded.(*InternalNode).LeafNode, "version 0 should include the leaf node")
+		} else {
+			require.Nil(t, decoded.(*InternalNode).LeafNode, "version %d should not include the leaf node", tc.version)
+		}
+	}
+
+	_, err := leafNode.CompactMarshalBinary(3)
+	require.ErrorIs(t, err, ErrUnsupportedCompactVersion)
+	_, err = intNode.CompactMarshalBinary(3)
+	require.ErrorIs(t, err, ErrUnsupportedCompactVersion)
+	_, err = UnmarshalCompact(3, []byte{PrefixLeafNode})
+	require.ErrorIs(t, err, ErrUnsupportedCompactVersion)
+}
+
+func BenchmarkCompactMarshalBinary(b *testing.B) {
+	// A typical proof consists of internal nodes with short labels and leaves with small
+	// keys and values.
+	var nodes []Node
+	for i := 0; i < 64; i++ {
+		leafNode := &LeafNode{
+			Key:   []byte(fmt.Sprintf("key %d", i)),
+			Value: []byte(fmt.Sprintf("value %d", i)),
+		}
+		nodes = append(nodes, leafNode)
+		nodes = append(nodes, &InternalNode{
+			Label:          Key{byte(i)},
+			LabelBitLength: Depth(i%8 + 1),
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		marshal func(Node) ([]byte, error)
+	}{
+		{"V1", Node.CompactMarshalBinaryV1},
+		{"V2", Node.CompactMarshalBinaryV2},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			var size int
+			for n := 0; n < b.N; n++ {
+				size = 0
+				for _, nd := range nodes {
+					data, err := tc.marshal(nd)
+					if err != nil {
+						b.Fatalf("failed to marshal node: %s", err)
+					}
+					size += len(data)
+				}
+			}
+			b.ReportMetric(float64(size), "proof-bytes")
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrUnresolvedPointer is the error returned when an operation that requires a fully resolved
+// subtree encounters a pointer whose node is not loaded.
+var ErrUnresolvedPointer = errors.New("mkvs: unresolved node pointer")
+
+// DiffOp is the kind of difference between two subtrees for a given key.
+type DiffOp uint8
+
+const (
+	// DiffAdded means that the key is only present in the second subtree.
+	DiffAdded DiffOp = iota
+	// DiffRemoved means that the key is only present in the first subtree.
+	DiffRemoved
+	// DiffChanged means that the key is present in both subtrees with different values.
+	DiffChanged
+)
+
+// String returns a string representation of the diff operation.
+func (op DiffOp) String() string {
+	switch op {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	default:
+		return fmt.Sprintf("[unknown: %d]", op)
+	}
+}
+
+// DiffEntry is a single difference between two subtrees.
+type DiffEntry struct {
+	// Key is the key that differs.
+	Key Key
+	// Op is the kind of difference.
+	Op DiffOp
+	// OldValue is the value in the first subtree (nil when added).
+	OldValue []byte
+	// NewValue is the value in the second subtree (nil when removed).
+	NewValue []byte
+}
+
+// Diff compares two subtrees rooted at the same depth and returns the keys whose values were
+// added, removed or changed going from a to b, ordered by key.
+//
+// Clean subtrees with equal hashes are assumed to be equal and are not descended into. All
+// other nodes must be loaded in memory, otherwise ErrUnresolvedPointer is returned.
+func Diff(a, b *Pointer) ([]DiffEntry, error) {
+	var entries []DiffEntry
+	if err := doDiff(a, b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func doDiff(a, b *Pointer, entries *[]DiffEntry) error {
+	if a.IsClean() && b.IsClean() {
+		ha, hb := a.GetHash(), b.GetHash()
+		if ha.Equal(&hb) {
+			return nil
+		}
+	}
+
+	na, err := diffResolve(a)
+	if err != nil {
+		return err
+	}
+	nb, err := diffResolve(b)
+	if err != nil {
+		return err
+	}
+
+	// In case both internal nodes cover the same key prefix, compare the children pairwise so
+	// that any equal subtrees below can be skipped.
+	ia, okA := na.(*InternalNode)
+	ib, okB := nb.(*InternalNode)
+	if okA && okB && ia.LabelBitLength == ib.LabelBitLength && ia.Label.Equal(ib.Label) {
+		for _, pair := range [][2]*Pointer{
+			{ia.LeafNode, ib.LeafNode},
+			{ia.Left, ib.Left},
+			{ia.Right, ib.Right},
+		} {
+			if err = doDiff(pair[0], pair[1], entries); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Structures differ, compare all leaves.
+	var leavesA, leavesB []*LeafNode
+	if err = collectLeaves(a, &leavesA); err != nil {
+		return err
+	}
+	if err = collectLeaves(b, &leavesB); err != nil {
+		return err
+	}
+	mergeLeaves(leavesA, leavesB, entries)
+	return nil
+}
+
+func diffResolve(ptr *Pointer) (Node, error) {
+	switch {
+	case ptr == nil:
+		return nil, nil
+	case ptr.Clean && ptr.Node == nil && !IsEmptyTreeHash(ptr.Hash):
+		return nil, fmt.Errorf("%w: %s", ErrUnresolvedPointer, ptr.Hash)
+	default:
+		return ptr.Node, nil
+	}
+}
+
+// collectLeaves appends all leaves in the subtree to the given slice, in key order.
+func collectLeaves(ptr *Pointer, leaves *[]*LeafNode) error {
+	nd, err := diffResolve(ptr)
+	if err != nil {
+		return err
+	}
+
+	switch n := nd.(type) {
+	case nil:
+	case *InternalNode:
+		for _, child := range []*Pointer{n.LeafNode, n.Left, n.Right} {
+			if err = collectLeaves(child, leaves); err != nil {
+				return err
+			}
+		}
+	case *LeafNode:
+		*leaves = append(*leaves, n)
+	}
+	return nil
+}
+
+// mergeLeaves compares two key-ordered lists of leaves and appends any differences.
+func mergeLeaves(leavesA, leavesB []*LeafNode, entries *[]DiffEntry) {
+	var i, j int
+	for i < len(leavesA) || j < len(leavesB) {
+		var cmp int
+		switch {
+		case i == len(leavesA):
+			cmp = 1
+		case j == len(leavesB):
+			cmp = -1
+		default:
+			cmp = leavesA[i].Key.Compare(leavesB[j].Key)
+		}
+
+		switch {
+		case cmp < 0:
+			*entries = append(*entries, DiffEntry{Key: leavesA[i].Key, Op: DiffRemoved, OldValue: leavesA[i].Value})
+			i++
+		case cmp > 0:
+			*entries = append(*entries, DiffEntry{Key: leavesB[j].Key, Op: DiffAdded, NewValue: leavesB[j].Value})
+			j++
+		default:
+			if !bytes.Equal(leavesA[i].Value, leavesB[j].Value) {
+				*entries = append(*entries, DiffEntry{
+					Key:      leavesA[i].Key,
+					Op:       DiffChanged,
+					OldValue: leavesA[i].Value,
+					NewValue: leavesB[j].Value,
+				})
+			}
+			i++
+			j++
+		}
+	}
+}
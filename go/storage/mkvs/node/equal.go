@@ -0,0 +1,70 @@
+package node
+
+import "bytes"
+
+// EqualStructural compares two nodes by their labels, keys, values and child structure.
+//
+// Unlike Node.Equal, cached node hashes are never used as a shortcut, so nodes whose hashes
+// have not been populated yet can be compared. Hashes are only compared for child pointers
+// where neither side has a resolved node, as there is nothing else to compare.
+func EqualStructural(a, b Node) bool {
+	aNil, bNil := isNilNode(a), isNilNode(b)
+	if aNil || bNil {
+		return aNil && bNil
+	}
+
+	switch a := a.(type) {
+	case *InternalNode:
+		b, ok := b.(*InternalNode)
+		if !ok {
+			return false
+		}
+		return a.LabelBitLength == b.LabelBitLength &&
+			bytes.Equal(a.Label, b.Label) &&
+			equalPointerStructural(a.LeafNode, b.LeafNode) &&
+			equalPointerStructural(a.Left, b.Left) &&
+			equalPointerStructural(a.Right, b.Right)
+	case *LeafNode:
+		b, ok := b.(*LeafNode)
+		if !ok {
+			return false
+		}
+		return a.Key.Equal(b.Key) && bytes.Equal(a.Value, b.Value)
+	default:
+		return false
+	}
+}
+
+func equalPointerStructural(p, q *Pointer) bool {
+	var pn, qn Node
+	if p != nil {
+		pn = p.Node
+	}
+	if q != nil {
+		qn = q.Node
+	}
+
+	pNil, qNil := isNilNode(pn), isNilNode(qn)
+	switch {
+	case pNil && qNil:
+		ph, qh := p.GetHash(), q.GetHash()
+		return ph.Equal(&qh)
+	case pNil || qNil:
+		return false
+	default:
+		return EqualStructural(pn, qn)
+	}
+}
+
+func isNilNode(n Node) bool {
+	switch n := n.(type) {
+	case nil:
+		return true
+	case *InternalNode:
+		return n == nil
+	case *LeafNode:
+		return n == nil
+	default:
+		return false
+	}
+}
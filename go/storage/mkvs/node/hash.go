@@ -0,0 +1,64 @@
+package node
+
+import "sync"
+
+// UpdateHashesParallel recomputes the hashes of all dirty nodes in the subtree rooted at the
+// given pointer, bottom-up, using at most concurrency goroutines.
+//
+// Clean pointers (including hash-only pointers) are left untouched. The resulting hashes are
+// identical to the ones computed by calling UpdateHash serially. Nodes are not marked clean.
+func UpdateHashesParallel(root *Pointer, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// The calling goroutine also performs work, so only concurrency-1 extra workers are needed.
+	sem := make(chan struct{}, concurrency-1)
+	updateHashes(root, sem)
+}
+
+func updateHashes(ptr *Pointer, sem chan struct{}) {
+	if ptr == nil || ptr.Clean {
+		return
+	}
+
+	switch n := ptr.Node.(type) {
+	case nil:
+		// Dead node.
+		ptr.Hash = EmptyTreeHash()
+	case *InternalNode:
+		// Sibling subtrees are independent so they can be processed in parallel. In case
+		// there are no free workers, process the subtree in the current goroutine.
+		var wg sync.WaitGroup
+		for _, child := range []*Pointer{n.Left, n.Right} {
+			if child == nil || child.Clean {
+				continue
+			}
+			if _, ok := child.Node.(*InternalNode); !ok {
+				updateHashes(child, sem)
+				continue
+			}
+
+			select {
+			case sem <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+					updateHashes(child, sem)
+				}()
+			default:
+				updateHashes(child, sem)
+			}
+		}
+		updateHashes(n.LeafNode, sem)
+		wg.Wait()
+
+		n.UpdateHash()
+		ptr.Hash = n.Hash
+	case *LeafNode:
+		n.UpdateHash()
+		ptr.Hash = n.Hash
+	}
+}
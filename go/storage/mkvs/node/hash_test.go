@@ -0,0 +1,96 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+)
+
+// generateDirtyTree generates a dirty in-memory subtree of the given depth. Some of the
+// children are clean hash-only pointers.
+func generateDirtyTree(depth int, path string) *Pointer {
+	leafNode := &LeafNode{
+		Key:   []byte(path),
+		Value: []byte("value " + path),
+	}
+	leafPtr := &Pointer{Node: leafNode}
+	if depth == 0 {
+		return leafPtr
+	}
+
+	intNode := &InternalNode{
+		Label:          Key{byte(depth)},
+		LabelBitLength: 8,
+		Left:           generateDirtyTree(depth-1, path+"0"),
+		Right:          generateDirtyTree(depth-1, path+"1"),
+	}
+	switch depth % 3 {
+	case 0:
+		intNode.LeafNode = leafPtr
+	case 1:
+		intNode.Right = &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte(path))}
+	default:
+	}
+	return &Pointer{Node: intNode}
+}
+
+func updateHashesSerial(ptr *Pointer) hash.Hash {
+	if ptr == nil {
+		var h hash.Hash
+		h.Empty()
+		return h
+	}
+	if ptr.Clean {
+		return ptr.Hash
+	}
+
+	switch n := ptr.Node.(type) {
+	case *InternalNode:
+		updateHashesSerial(n.LeafNode)
+		updateHashesSerial(n.Left)
+		updateHashesSerial(n.Right)
+		n.UpdateHash()
+		ptr.Hash = n.Hash
+	case *LeafNode:
+		n.UpdateHash()
+		ptr.Hash = n.Hash
+	}
+	return ptr.Hash
+}
+
+func TestUpdateHashesParallel(t *testing.T) {
+	expected := updateHashesSerial(generateDirtyTree(10, ""))
+
+	for _, concurrency := range []int{0, 1, 2, 8, 64} {
+		t.Run(fmt.Sprintf("Concurrency%d", concurrency), func(t *testing.T) {
+			root := generateDirtyTree(10, "")
+			UpdateHashesParallel(root, concurrency)
+			require.Equal(t, expected, root.Hash, "parallel hashing should match serial hashing")
+			require.False(t, root.Clean, "nodes should not be marked clean")
+		})
+	}
+
+	// Clean pointers should not be modified.
+	h := hash.NewFromBytes([]byte("clean"))
+	ptr := &Pointer{Clean: true, Hash: h}
+	UpdateHashesParallel(ptr, 4)
+	require.Equal(t, h, ptr.Hash)
+
+	// Nil pointers should be handled.
+	UpdateHashesParallel(nil, 4)
+}
+
+func BenchmarkUpdateHashesParallel(b *testing.B) {
+	root := generateDirtyTree(14, "")
+
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("Concurrency%d", concurrency), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				UpdateHashesParallel(root, concurrency)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package node
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+)
+
+// Hasher is a hash function used to compute node hashes.
+type Hasher interface {
+	// Hash computes the hash of the concatenation of the given byte slices.
+	Hash(data ...[]byte) hash.Hash
+}
+
+type defaultHasher struct{}
+
+func (defaultHasher) Hash(data ...[]byte) hash.Hash {
+	return hash.NewFromBytes(data...)
+}
+
+// DefaultHasher is the default hasher used to compute primary node hashes.
+var DefaultHasher Hasher = defaultHasher{}
+
+// hasherState is the hasher used to compute primary node hashes together with the hash of the
+// root of an empty tree computed using that hasher.
+type hasherState struct {
+	hasher        Hasher
+	emptyTreeHash hash.Hash
+}
+
+func newHasherState(hasher Hasher) *hasherState {
+	return &hasherState{
+		hasher:        hasher,
+		emptyTreeHash: hasher.Hash(),
+	}
+}
+
+var (
+	defaultHasherState = newHasherState(DefaultHasher)
+	nodeHasher         atomic.Pointer[hasherState]
+)
+
+// SetHasher overrides the hasher used to compute primary node hashes (e.g., by UpdateHash and
+// Validate). Passing nil restores DefaultHasher.
+//
+// The hasher is global to the process and must be set before any tree is built or any node is
+// decoded, as nodes hashed with different hashers cannot be mixed. Nodes produced with a
+// non-default hasher are incompatible with the rest of the network and are only meant to be used
+// in experimental deployments.
+func SetHasher(hasher Hasher) {
+	if hasher == nil {
+		hasher = DefaultHasher
+	}
+	nodeHasher.Store(newHasherState(hasher))
+}
+
+func currentHasherState() *hasherState {
+	if state := nodeHasher.Load(); state != nil {
+		return state
+	}
+	return defaultHasherState
+}
+
+// CurrentHasher returns the hasher used to compute primary node hashes.
+func CurrentHasher() Hasher {
+	return currentHasherState().hasher
+}
+
+// EmptyTreeHash returns the hash of the root of an empty tree computed using the hasher used to
+// compute primary node hashes. The same hash is used for empty subtrees (e.g., nil child
+// pointers). With DefaultHasher this is the hash of an empty byte string.
+func EmptyTreeHash() hash.Hash {
+	return currentHasherState().emptyTreeHash
+}
+
+// IsEmptyTreeHash returns true iff the given hash is the hash of an empty tree or subtree. See
+// EmptyTreeHash.
+func IsEmptyTreeHash(h hash.Hash) bool {
+	emptyTreeHash := EmptyTreeHash()
+	return h.Equal(&emptyTreeHash)
+}
+
+// domainHasher is a hasher that prepends a domain separation tag to all hashed data.
+type domainHasher struct {
+	domain []byte
+	hasher Hasher
+}
+
+func (h domainHasher) Hash(data ...[]byte) hash.Hash {
+	return h.hasher.Hash(append([][]byte{h.domain}, data...)...)
+}
+
+// withDomain returns a hasher that prepends the given domain separation tag before hashing with
+// the given hasher. An empty domain leaves the hasher unchanged.
+func withDomain(hasher Hasher, domain []byte) Hasher {
+	if len(domain) == 0 {
+		return hasher
+	}
+	return domainHasher{domain: domain, hasher: hasher}
+}
+
+// HashWith computes the hash of the leaf node using the given hasher.
+//
+// The key and value lengths are hashed as little-endian uint32 values. The value length uses the
+// same little-endian encoding as the serialized node (see CompactMarshalBinaryV1) and both must
+// stay in lockstep with the Rust implementation, as any change would silently change all node
+// hashes. TestLeafNodeHashEndianness guards this invariant.
+//
+// Does not update the node's cached hash.
+func (n *LeafNode) HashWith(hasher Hasher) hash.Hash {
+	var keyLen, valueLen [4]byte
+	binary.LittleEndian.PutUint32(keyLen[:], uint32(len(n.Key)))
+	binary.LittleEndian.PutUint32(valueLen[:], uint32(len(n.Value)))
+
+	return hasher.Hash([]byte{PrefixLeafNode}, keyLen[:], n.Key[:], valueLen[:], n.Value[:])
+}
+
+// HashWith computes the hash of the internal node using the given hasher and
+// the given hashes of its leaf node and children. The child hashes must have
+// been computed using the same hasher.
+//
+// Does not update the node's cached hash.
+func (n *InternalNode) HashWith(hasher Hasher, leafNodeHash, leftHash, rightHash hash.Hash) hash.Hash {
+	labelBitLength := n.LabelBitLength.MarshalBinary()
+
+	return hasher.Hash(
+		[]byte{PrefixInternalNode},
+		labelBitLength,
+		n.Label[:],
+		leafNodeHash[:],
+		leftHash[:],
+		rightHash[:],
+	)
+}
@@ -6,14 +6,38 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/bits"
+	"strings"
 )
 
+// keyStringPrefix is the prefix of the string representation of a key.
+const keyStringPrefix = "0x"
+
 // Key holds variable-length key.
 type Key []byte
 
-// String returns a string representation of the key.
+// String returns a string representation of the key, which is the hex-encoded key with a 0x
+// prefix. ParseKey can be used to parse it back.
+//
+// The string representation is only meant for human-facing contexts (e.g., logs and test
+// vectors), use MarshalBinary for serialization.
 func (k Key) String() string {
-	return hex.EncodeToString(k[:])
+	return keyStringPrefix + hex.EncodeToString(k[:])
+}
+
+// ParseKey parses the string representation of a key as returned by Key.String.
+//
+// Note that nil and empty keys have the same string representation, which is parsed as an empty
+// key.
+func ParseKey(s string) (Key, error) {
+	encoded, ok := strings.CutPrefix(s, keyStringPrefix)
+	if !ok {
+		return nil, fmt.Errorf("mkvs: malformed key string: missing %s prefix", keyStringPrefix)
+	}
+	k, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("mkvs: malformed key string: %w", err)
+	}
+	return Key(k), nil
 }
 
 // MarshalBinary encodes a key length in bytes + key into binary form.
@@ -63,7 +87,7 @@ func (k Key) Equal(other Key) bool {
 }
 
 // Compare compares the key with some other key and returns 0 if both
-// keys are equal, -1 if the the key is smaller and 1 if the key is
+// keys are equal, -1 if the key is smaller and 1 if the key is
 // larger.
 func (k Key) Compare(other Key) int {
 	return bytes.Compare(k, other)
@@ -79,11 +103,30 @@ func (k Key) BitLength() Depth {
 	return Depth(len(k[:]) * 8)
 }
 
-// GetBit returns the given bit of the key.
+// GetBit returns the given bit of the key, counting from the most significant bit of the
+// first byte.
+//
+// When descending the tree, a false bit selects the left and a true bit the right subtree.
 func (k Key) GetBit(bit Depth) bool {
 	return k[bit/8]&(1<<(7-(bit%8))) != 0
 }
 
+// KeyPath returns the bits of the first keyBitLen bits of the key, most significant bit first.
+//
+// This is the sequence of bits the tree uses to decide between the left (false) and right
+// (true) subtrees when looking up the key.
+func KeyPath(key Key, keyBitLen Depth) []bool {
+	if keyBitLen > key.BitLength() {
+		panic(fmt.Sprintf("mkvs: keyBitLen %+v greater than key length %+v", keyBitLen, key.BitLength()))
+	}
+
+	path := make([]bool, keyBitLen)
+	for bit := Depth(0); bit < keyBitLen; bit++ {
+		path[bit] = key.GetBit(bit)
+	}
+	return path
+}
+
 // SetBit sets the bit at the given position bit to value val.
 //
 // This function is immutable and returns a new instance of Key
@@ -102,7 +145,10 @@ func (k Key) SetBit(bit Depth, val bool) Key {
 // Split performs bit-wise split of the key.
 //
 // keyLen is the length of the key in bits and splitPoint is the index of the
-// first suffix bit.
+// first suffix bit. Any bits of the last prefix byte past splitPoint are cleared
+// and the suffix is shifted so that its first bit is the most significant bit of
+// its first byte. This is how the tree derives internal node labels, so external
+// verifiers can use it to reproduce the descent.
 // This function is immutable and returns two new instances of Key.
 func (k Key) Split(splitPoint, keyLen Depth) (prefix, suffix Key) {
 	if splitPoint > keyLen {
@@ -211,3 +257,25 @@ func (k Key) CommonPrefixLen(keyBitLen Depth, k2 Key, k2bitLen Depth) (bitLength
 
 	return
 }
+
+// HammingDistance returns the number of bits in which the first bitLen bits of k and other
+// differ.
+//
+// An error is returned in case either key is shorter than bitLen bits.
+func (k Key) HammingDistance(other Key, bitLen Depth) (int, error) {
+	if bitLen > k.BitLength() || bitLen > other.BitLength() {
+		return 0, fmt.Errorf("mkvs: bitLen %d greater than key length", bitLen)
+	}
+
+	// Compare whole bytes first and then the remaining bits of the last partial byte.
+	var distance int
+	fullBytes := bitLen / 8
+	for i := Depth(0); i < fullBytes; i++ {
+		distance += bits.OnesCount8(k[i] ^ other[i])
+	}
+	if rem := bitLen % 8; rem != 0 {
+		mask := byte(0xff << (8 - rem))
+		distance += bits.OnesCount8((k[fullBytes] ^ other[fullBytes]) & mask)
+	}
+	return distance, nil
+}
@@ -1,6 +1,7 @@
 package node
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -113,6 +114,41 @@ func TestKeyAppendSplitMerge(t *testing.T) {
 	require.Equal(t, Key{0x41, 0x6b, 0x37}, newKey)
 }
 
+func TestKeyGetBit(t *testing.T) {
+	key := Key{0b10100000, 0b00000101}
+	expected := []bool{
+		true, false, true, false, false, false, false, false,
+		false, false, false, false, false, true, false, true,
+	}
+	for i, want := range expected {
+		require.Equal(t, want, key.GetBit(Depth(i)), "bit %d", i)
+	}
+}
+
+func TestKeySplitBoundaries(t *testing.T) {
+	key := Key{0x01, 0x23, 0x45, 0x67}
+	keyLen := key.BitLength()
+
+	for _, splitPoint := range []Depth{0, 1, 3, 7, 8, 9, 12, 15, 16, 17, 23, 24, 31, 32} {
+		prefix, suffix := key.Split(splitPoint, keyLen)
+		require.Len(t, prefix, splitPoint.ToBytes(), "prefix length at split point %d", splitPoint)
+		require.Len(t, suffix, (keyLen - splitPoint).ToBytes(), "suffix length at split point %d", splitPoint)
+
+		for i := Depth(0); i < splitPoint; i++ {
+			require.Equal(t, key.GetBit(i), prefix.GetBit(i), "prefix bit %d at split point %d", i, splitPoint)
+		}
+		// Unused trailing bits of the prefix must be cleared.
+		for i := splitPoint; i < prefix.BitLength(); i++ {
+			require.False(t, prefix.GetBit(i), "unused prefix bit %d at split point %d", i, splitPoint)
+		}
+		for i := Depth(0); i < keyLen-splitPoint; i++ {
+			require.Equal(t, key.GetBit(splitPoint+i), suffix.GetBit(i), "suffix bit %d at split point %d", i, splitPoint)
+		}
+
+		require.Equal(t, key, prefix.Merge(splitPoint, suffix, keyLen-splitPoint), "merge at split point %d", splitPoint)
+	}
+}
+
 func TestKeyCommonPrefixLen(t *testing.T) {
 	key := Key{}
 	require.Equal(t, Depth(0), key.CommonPrefixLen(0, Key{}, 0))
@@ -129,3 +165,117 @@ func TestKeyCommonPrefixLen(t *testing.T) {
 	key = Key{0xab, 0xcd, 0xef, 0xff}
 	require.Equal(t, Depth(23), key.CommonPrefixLen(32, Key{0xab, 0xcd, 0xee, 0xff}, 32))
 }
+
+func TestKeyCompare(t *testing.T) {
+	require.Equal(t, 0, Key{}.Compare(Key{}))
+	require.Equal(t, 0, Key{0xab, 0xcd}.Compare(Key{0xab, 0xcd}))
+	require.Equal(t, -1, Key{0xab}.Compare(Key{0xab, 0xcd}))
+	require.Equal(t, 1, Key{0xab, 0xcd}.Compare(Key{0xab}))
+	require.Equal(t, -1, Key{0xab, 0xcc}.Compare(Key{0xab, 0xcd}))
+	require.Equal(t, 1, Key{0xac}.Compare(Key{0xab, 0xff}))
+}
+
+func TestKeyBitLength(t *testing.T) {
+	require.Equal(t, Depth(0), Key{}.BitLength())
+	require.Equal(t, Depth(8), Key{0x00}.BitLength())
+	require.Equal(t, Depth(24), Key{0xab, 0xcd, 0xef}.BitLength())
+}
+
+func TestKeyPath(t *testing.T) {
+	require.Empty(t, KeyPath(Key{}, 0))
+	require.Empty(t, KeyPath(Key{0xff}, 0))
+
+	key := Key{0xa5, 0x0f}
+	require.Equal(t, []bool{true, false, true, false, false, true, false, true}, KeyPath(key, 8))
+	require.Equal(t, []bool{true, false, true}, KeyPath(key, 3), "partial path")
+	require.Equal(t, []bool{
+		true, false, true, false, false, true, false, true,
+		false, false, false, false, true, true, true, true,
+	}, KeyPath(key, 16))
+
+	// The path must match the decisions made by the tree, which go right iff GetBit is set.
+	for i, right := range KeyPath(key, key.BitLength()) {
+		require.Equal(t, key.GetBit(Depth(i)), right, "bit %d", i)
+	}
+
+	require.Panics(t, func() { KeyPath(key, 17) })
+}
+
+func TestKeyHammingDistance(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		k1, k2   Key
+		bitLen   Depth
+		distance int
+	}{
+		{Key{}, Key{}, 0, 0},
+		{Key{0xab, 0xcd}, Key{0xab, 0xcd}, 16, 0},
+		{Key{0x00, 0x00}, Key{0xff, 0xff}, 16, 16},
+		{Key{0x00, 0x00}, Key{0xff, 0xff}, 11, 11},
+		{Key{0x0f}, Key{0xf0}, 8, 8},
+		{Key{0x0f}, Key{0xf0}, 4, 4},
+		{Key{0x0f}, Key{0xf0}, 0, 0},
+		{Key{0xa5, 0x0f}, Key{0xa4, 0x1f}, 16, 2},
+		{Key{0xa5, 0x0f}, Key{0xa4, 0x1f}, 7, 0},
+		{Key{0xa5, 0x0f}, Key{0xa4, 0x1f}, 8, 1},
+		// Bits past bitLen are ignored even when the keys have different lengths.
+		{Key{0xa5}, Key{0xa5, 0xff}, 8, 0},
+	} {
+		distance, err := tc.k1.HammingDistance(tc.k2, tc.bitLen)
+		require.NoError(err, "HammingDistance(%s, %s, %d)", tc.k1, tc.k2, tc.bitLen)
+		require.Equal(tc.distance, distance, "HammingDistance(%s, %s, %d)", tc.k1, tc.k2, tc.bitLen)
+
+		// The distance is symmetric.
+		distance, err = tc.k2.HammingDistance(tc.k1, tc.bitLen)
+		require.NoError(err, "HammingDistance(%s, %s, %d)", tc.k2, tc.k1, tc.bitLen)
+		require.Equal(tc.distance, distance, "HammingDistance(%s, %s, %d)", tc.k2, tc.k1, tc.bitLen)
+	}
+
+	_, err := Key{0xa5}.HammingDistance(Key{0xa5, 0xff}, 9)
+	require.Error(err, "HammingDistance should fail for short keys")
+	_, err = Key{0xa5, 0xff}.HammingDistance(Key{0xa5}, 9)
+	require.Error(err, "HammingDistance should fail for short keys")
+}
+
+func TestKeyString(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		key Key
+		str string
+	}{
+		{Key{}, "0x"},
+		{Key("abc"), "0x616263"},
+		{Key{0x00, 0xff, 0x0a}, "0x00ff0a"},
+	} {
+		require.Equal(tc.str, tc.key.String())
+		parsed, err := ParseKey(tc.str)
+		require.NoError(err, "ParseKey")
+		require.Equal(tc.key, parsed)
+	}
+
+	// Upper case hex digits should also be accepted.
+	parsed, err := ParseKey("0x00FF0A")
+	require.NoError(err, "ParseKey")
+	require.Equal(Key{0x00, 0xff, 0x0a}, parsed)
+
+	for _, s := range []string{"", "616263", "0X616263", "0x6", "0xzz"} {
+		_, err = ParseKey(s)
+		require.Error(err, "ParseKey should fail for malformed string %q", s)
+	}
+}
+
+func FuzzKeyString(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("a golden key"))
+	f.Add([]byte{0x00, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		key := Key(data)
+		parsed, err := ParseKey(key.String())
+		require.NoError(t, err, "ParseKey")
+		require.True(t, bytes.Equal(key, parsed), "key should roundtrip")
+		require.Equal(t, key.String(), parsed.String())
+	})
+}
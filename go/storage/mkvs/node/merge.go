@@ -0,0 +1,90 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSubtreesNotDisjoint is the error returned when merging subtrees whose key spaces are not
+// disjoint at the divergence bit.
+var ErrSubtreesNotDisjoint = errors.New("mkvs: subtrees are not disjoint")
+
+// MergeSubtrees combines two independently built (root-level) subtrees into a single tree.
+//
+// All keys in both subtrees must share the first commonBitLen bits of commonLabel and the bit
+// following the common label must be unset for all keys in the left subtree and set for all keys
+// in the right subtree. The resulting tree is identical to the one obtained by inserting all keys
+// into a single tree.
+//
+// The roots of both subtrees must be loaded and have up-to-date hashes. The given pointers are not
+// modified. The returned root and any relabeled subtree roots are dirty, but have their hashes
+// computed.
+func MergeSubtrees(left, right *Pointer, commonLabel Key, commonBitLen Depth) (*Pointer, error) {
+	if commonLabel.BitLength() < commonBitLen {
+		return nil, fmt.Errorf("mkvs: common label shorter than %d bits", commonBitLen)
+	}
+
+	newLeft, err := rebaseSubtree(left, commonLabel, commonBitLen, false)
+	if err != nil {
+		return nil, fmt.Errorf("mkvs: bad left subtree: %w", err)
+	}
+	newRight, err := rebaseSubtree(right, commonLabel, commonBitLen, true)
+	if err != nil {
+		return nil, fmt.Errorf("mkvs: bad right subtree: %w", err)
+	}
+
+	label, _ := commonLabel.Split(commonBitLen, commonBitLen)
+	root := &InternalNode{
+		Label:          label,
+		LabelBitLength: commonBitLen,
+		Left:           newLeft,
+		Right:          newRight,
+	}
+	root.UpdateHash()
+
+	return &Pointer{Hash: root.Hash, Node: root}, nil
+}
+
+// rebaseSubtree validates that all keys in the given root-level subtree share the common label
+// and continue with the given divergence bit. It returns a pointer to the subtree with the root
+// relabeled so that it can be used as a child of an internal node with the common label.
+func rebaseSubtree(ptr *Pointer, commonLabel Key, commonBitLen Depth, bit bool) (*Pointer, error) {
+	if ptr == nil || ptr.Node == nil {
+		if ptr == nil || IsEmptyTreeHash(ptr.Hash) {
+			return nil, fmt.Errorf("%w: subtree is empty", ErrSubtreesNotDisjoint)
+		}
+		return nil, fmt.Errorf("mkvs: subtree root is not loaded")
+	}
+
+	switch n := ptr.Node.(type) {
+	case *InternalNode:
+		if n.LabelBitLength <= commonBitLen ||
+			n.Label.CommonPrefixLen(n.LabelBitLength, commonLabel, commonBitLen) < commonBitLen ||
+			n.Label.GetBit(commonBitLen) != bit {
+			return nil, ErrSubtreesNotDisjoint
+		}
+
+		_, label := n.Label.Split(commonBitLen, n.LabelBitLength)
+		nd := &InternalNode{
+			Label:          label,
+			LabelBitLength: n.LabelBitLength - commonBitLen,
+			LeafNode:       n.LeafNode,
+			Left:           n.Left,
+			Right:          n.Right,
+		}
+		nd.UpdateHash()
+
+		return &Pointer{Hash: nd.Hash, Node: nd}, nil
+	case *LeafNode:
+		// Leaf nodes contain full keys so they do not need to be relabeled.
+		keyBitLen := n.Key.BitLength()
+		if keyBitLen <= commonBitLen ||
+			n.Key.CommonPrefixLen(keyBitLen, commonLabel, commonBitLen) < commonBitLen ||
+			n.Key.GetBit(commonBitLen) != bit {
+			return nil, ErrSubtreesNotDisjoint
+		}
+		return ptr, nil
+	default:
+		return nil, fmt.Errorf("mkvs: unknown node type: %+v", n)
+	}
+}
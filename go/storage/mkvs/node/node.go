@@ -8,6 +8,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/oasisprotocol/oasis-core/go/common"
@@ -21,6 +23,16 @@ var (
 	// ErrMalformedKey is the error when a malformed key is encountered
 	// during deserialization.
 	ErrMalformedKey = errors.New("mkvs: malformed key")
+	// ErrUnsupportedCompactVersion is the error when an unsupported compact
+	// encoding version is requested.
+	ErrUnsupportedCompactVersion = errors.New("mkvs: unsupported compact encoding version")
+	// ErrNoSuccessor is the error when a successor is requested for a root
+	// that cannot have one.
+	ErrNoSuccessor = errors.New("mkvs: root has no successor")
+	// ErrInvalidValueLength is the error when a leaf node declares a value
+	// length that exceeds the maximum value size or the available data
+	// during deserialization. It wraps ErrMalformedNode.
+	ErrInvalidValueLength = fmt.Errorf("%w: invalid value length", ErrMalformedNode)
 )
 
 const (
@@ -40,8 +52,33 @@ const (
 
 	// ValueLengthSize is the size of the encoded value length.
 	ValueLengthSize = int(unsafe.Sizeof(uint32(0)))
+
+	// DefaultMaxValueSize is the default maximum size of a leaf node value that will be accepted
+	// during deserialization.
+	DefaultMaxValueSize = 128 * 1024 * 1024
 )
 
+var maxValueSize atomic.Int64
+
+func init() {
+	maxValueSize.Store(DefaultMaxValueSize)
+}
+
+// MaxValueSize returns the maximum size of a leaf node value that will be accepted during
+// deserialization.
+func MaxValueSize() int {
+	return int(maxValueSize.Load())
+}
+
+// SetMaxValueSize sets the maximum size of a leaf node value that will be accepted during
+// deserialization. Leaf nodes declaring larger values are rejected as malformed.
+func SetMaxValueSize(n int) {
+	if n < 0 {
+		panic("mkvs: negative maximum value size")
+	}
+	maxValueSize.Store(int64(n))
+}
+
 var (
 	_ encoding.BinaryMarshaler   = (*InternalNode)(nil)
 	_ encoding.BinaryUnmarshaler = (*InternalNode)(nil)
@@ -95,15 +132,23 @@ func (r Root) String() string {
 	return fmt.Sprintf("<Root ns=%s version=%d type=%v hash=%s>", r.Namespace, r.Version, r.Type, r.Hash)
 }
 
-// Empty sets the storage root to an empty root.
+// Empty sets the storage root to an empty root. The namespace, version and type are reset to
+// their zero values and the hash is set to the empty tree hash.
+//
+// Use EmptyRoot to get the root of an empty tree with a specific namespace, version and type.
 func (r *Root) Empty() {
 	var emptyNs common.Namespace
 	r.Namespace = emptyNs
 	r.Version = 0
-	r.Hash.Empty()
+	r.Type = RootTypeInvalid
+	r.Hash = EmptyTreeHash()
 }
 
-// IsEmpty checks whether the storage root is empty.
+// IsEmpty checks whether the storage root is empty, meaning that its namespace and version are
+// zero and its hash is the empty tree hash.
+//
+// NOTE: The type is not checked so that empty roots of any type are considered empty. Note that
+// Equal and Follows do check the type.
 func (r *Root) IsEmpty() bool {
 	var emptyNs common.Namespace
 	if !r.Namespace.Equal(&emptyNs) {
@@ -114,7 +159,24 @@ func (r *Root) IsEmpty() bool {
 		return false
 	}
 
-	return r.Hash.IsEmpty()
+	return r.IsEmptyTree()
+}
+
+// EmptyRoot returns the root of an empty tree of the given type in the given namespace and
+// version.
+func EmptyRoot(ns common.Namespace, version uint64, t RootType) Root {
+	return Root{
+		Namespace: ns,
+		Version:   version,
+		Type:      t,
+		Hash:      EmptyTreeHash(),
+	}
+}
+
+// IsEmptyTree checks whether the storage root is the root of an empty tree, regardless of its
+// namespace, version and type. See EmptyTreeHash.
+func (r *Root) IsEmptyTree() bool {
+	return IsEmptyTreeHash(r.Hash)
 }
 
 // Equal compares against another root for equality.
@@ -154,6 +216,32 @@ func (r *Root) Follows(other *Root) bool {
 	return true
 }
 
+// Successor returns the root with the given hash that succeeds the given root in the next
+// version. The successor has the same namespace and type, so it follows the given root.
+//
+// Roots of type RootTypeIO cannot have successors as each version builds its IO root from
+// scratch, starting with an empty root of that version (see EmptyRoot). ErrNoSuccessor is
+// returned for these roots, as well as for roots of an invalid type or the maximum version.
+func (r Root) Successor(newHash hash.Hash) (Root, error) {
+	switch r.Type {
+	case RootTypeState:
+	case RootTypeIO:
+		return Root{}, fmt.Errorf("%w: %v roots cannot have children", ErrNoSuccessor, r.Type)
+	default:
+		return Root{}, fmt.Errorf("%w: invalid root type %v", ErrNoSuccessor, r.Type)
+	}
+	if r.Version == math.MaxUint64 {
+		return Root{}, fmt.Errorf("%w: version overflow", ErrNoSuccessor)
+	}
+
+	return Root{
+		Namespace: r.Namespace,
+		Version:   r.Version + 1,
+		Type:      r.Type,
+		Hash:      newHash,
+	}, nil
+}
+
 // EncodedHash returns the encoded cryptographic hash of the storage root.
 func (r *Root) EncodedHash() hash.Hash {
 	return hash.NewFrom(r)
@@ -192,12 +280,11 @@ func (p *Pointer) Size() uint64 {
 	return size
 }
 
-// GetHash returns the pointers's cached hash.
+// GetHash returns the pointers's cached hash. A nil pointer has the empty tree hash, see
+// EmptyTreeHash.
 func (p *Pointer) GetHash() hash.Hash {
 	if p == nil {
-		var h hash.Hash
-		h.Empty()
-		return h
+		return EmptyTreeHash()
 	}
 
 	return p.Hash
@@ -222,6 +309,86 @@ func (p *Pointer) SetDirty() {
 	}
 }
 
+// ResolveWith returns the node the pointer points to, using the given resolver to fetch
+// the node in case it is not yet loaded. A resolved node is cached in the pointer.
+//
+// A nil pointer represents an empty subtree and resolves to a nil node.
+func (p *Pointer) ResolveWith(resolve func(*Pointer) (Node, error)) (Node, error) {
+	if p == nil {
+		return nil, nil
+	}
+	if p.Node != nil {
+		return p.Node, nil
+	}
+
+	nd, err := resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	p.Node = nd
+	return nd, nil
+}
+
+// NodeGetter is a source of nodes. It is satisfied by node databases (see db/api.NodeDB).
+type NodeGetter interface {
+	// GetNode lookups up a node in the source.
+	GetNode(root Root, ptr *Pointer) (Node, error)
+}
+
+// Resolve returns the node the pointer points to, loading it from the given node database under
+// the given root in case it is not yet loaded. A loaded node is cached in the pointer while its
+// Clean flag and Hash are left unchanged.
+//
+// Nil pointers, dirty pointers without a node and pointers to empty subtrees resolve to a nil
+// node without consulting the database.
+func (p *Pointer) Resolve(db NodeGetter, root Root) (Node, error) {
+	return p.ResolveWith(func(ptr *Pointer) (Node, error) {
+		if !ptr.Clean || IsEmptyTreeHash(ptr.Hash) {
+			return nil, nil
+		}
+		return db.GetNode(root, ptr)
+	})
+}
+
+// Freeze drops the node the pointer points to in order to reclaim memory, keeping its hash so
+// that the node can later be resolved again (e.g., via Resolve).
+//
+// This is a no-op for dirty pointers as their nodes have not been persisted and could not be
+// resolved again. It is also a no-op for pointers tracked by an in-memory tree cache (i.e. with
+// LRU set) as such nodes are evicted by the cache itself.
+func (p *Pointer) Freeze() {
+	if p == nil || !p.Clean || p.LRU != nil {
+		return
+	}
+	p.Node = nil
+}
+
+// IsFullyMaterialized returns true iff all nodes in the subtree rooted at this pointer are
+// loaded in memory so the subtree can be processed without resolving nodes from a database.
+func (p *Pointer) IsFullyMaterialized() bool {
+	return IsFullyMaterialized(p)
+}
+
+// IsFullyMaterialized returns true iff every non-nil pointer reachable from the given pointer
+// has its node populated. Pointers to empty subtrees (with an empty hash) do not need to be
+// resolved and are considered materialized.
+func IsFullyMaterialized(ptr *Pointer) bool {
+	if ptr == nil {
+		return true
+	}
+
+	switch n := ptr.Node.(type) {
+	case nil:
+		return IsEmptyTreeHash(ptr.Hash)
+	case *InternalNode:
+		return IsFullyMaterialized(n.LeafNode) &&
+			IsFullyMaterialized(n.Left) &&
+			IsFullyMaterialized(n.Right)
+	default:
+		return true
+	}
+}
+
 // Extract makes a copy of the pointer containing only hash references.
 func (p *Pointer) Extract() *Pointer {
 	if !p.IsClean() {
@@ -232,6 +399,8 @@ func (p *Pointer) Extract() *Pointer {
 
 // ExtractUnchecked makes a copy of the pointer containing only hash references
 // without checking the dirty flag.
+//
+// The reference count is not copied, so the extracted pointer is unreferenced.
 func (p *Pointer) ExtractUnchecked() *Pointer {
 	if p == nil {
 		return nil
@@ -270,6 +439,25 @@ func (p *Pointer) ExtractWithNodeUnchecked() *Pointer {
 	return ptr
 }
 
+// ExtractToDepth makes a copy of the pointer together with a copy of the node pointed to (if
+// loaded), where nodes at most maxDepth levels below that node are also copied and any deeper
+// nodes are replaced with hash-only pointers.
+func (p *Pointer) ExtractToDepth(maxDepth Depth) *Pointer {
+	ptr := p.Extract()
+	if ptr == nil {
+		return nil
+	}
+
+	switch n := p.Node.(type) {
+	case nil:
+	case *InternalNode:
+		ptr.Node = n.ExtractToDepth(maxDepth)
+	default:
+		ptr.Node = n.Extract()
+	}
+	return ptr
+}
+
 // Equal compares two pointers for equality.
 func (p *Pointer) Equal(other *Pointer) bool {
 	if (p == nil || other == nil) && p != other {
@@ -297,6 +485,14 @@ type Node interface {
 	// pointers, for version 1 proofs.
 	CompactMarshalBinaryV1() ([]byte, error)
 
+	// CompactMarshalBinaryV2 encodes a node into binary form without any hash
+	// pointers, using varint-encoded lengths.
+	CompactMarshalBinaryV2() ([]byte, error)
+
+	// CompactMarshalBinary encodes a node into binary form using the compact
+	// encoding of the given version.
+	CompactMarshalBinary(version uint16) ([]byte, error)
+
 	// GetHash returns the node's cached hash.
 	GetHash() hash.Hash
 
@@ -353,19 +549,19 @@ func (n *InternalNode) Size() uint64 {
 //
 // Does not mark the node as clean.
 func (n *InternalNode) UpdateHash() {
-	leafNodeHash := n.LeafNode.GetHash()
-	leftHash := n.Left.GetHash()
-	rightHash := n.Right.GetHash()
-	labelBitLength := n.LabelBitLength.MarshalBinary()
+	n.Hash = n.HashWith(CurrentHasher(), n.LeafNode.GetHash(), n.Left.GetHash(), n.Right.GetHash())
+}
 
-	n.Hash.FromBytes(
-		[]byte{PrefixInternalNode},
-		labelBitLength,
-		n.Label[:],
-		leafNodeHash[:],
-		leftHash[:],
-		rightHash[:],
-	)
+// UpdateHashWithDomain updates the node's cached hash by recomputing it with the given domain
+// separation tag prepended to the hash input. An empty domain is equivalent to UpdateHash.
+//
+// The hashes of the leaf node and children must have been computed using the same domain. The
+// domain must be fixed for the whole tree as mixing domains within a tree corrupts it.
+//
+// Does not mark the node as clean.
+func (n *InternalNode) UpdateHashWithDomain(domain []byte) {
+	hasher := withDomain(CurrentHasher(), domain)
+	n.Hash = n.HashWith(hasher, n.LeafNode.GetHash(), n.Left.GetHash(), n.Right.GetHash())
 }
 
 // GetHash returns the node's cached hash.
@@ -389,6 +585,29 @@ func (n *InternalNode) Extract() Node {
 	}
 }
 
+// ExtractToDepth makes a copy of the node where nodes at most maxDepth levels below it are also
+// copied and any deeper nodes are replaced with hash-only pointers. The copy hashes to the same
+// value as the original node.
+//
+// ExtractToDepth(0) is equivalent to Extract.
+func (n *InternalNode) ExtractToDepth(maxDepth Depth) Node {
+	if maxDepth == 0 {
+		return n.Extract()
+	}
+	if !n.Clean {
+		panic("mkvs: extract called on dirty node")
+	}
+	return &InternalNode{
+		Clean:          true,
+		Hash:           n.Hash,
+		Label:          n.Label,
+		LabelBitLength: n.LabelBitLength,
+		LeafNode:       n.LeafNode.ExtractToDepth(maxDepth - 1),
+		Left:           n.Left.ExtractToDepth(maxDepth - 1),
+		Right:          n.Right.ExtractToDepth(maxDepth - 1),
+	}
+}
+
 // ExtractUnchecked makes a copy of the node containing only hash references without
 // checking the dirty flag.
 func (n *InternalNode) ExtractUnchecked() Node {
@@ -526,13 +745,13 @@ func (n *InternalNode) SizedUnmarshalBinary(data []byte) (int, error) {
 		}
 		pos += hash.Size
 
-		if leftHash.IsEmpty() {
+		if IsEmptyTreeHash(leftHash) {
 			n.Left = nil
 		} else {
 			n.Left = &Pointer{Clean: true, Hash: leftHash}
 		}
 
-		if rightHash.IsEmpty() {
+		if IsEmptyTreeHash(rightHash) {
 			n.Right = nil
 		} else {
 			n.Right = &Pointer{Clean: true, Hash: rightHash}
@@ -597,11 +816,17 @@ func (n *LeafNode) GetHash() hash.Hash {
 //
 // Does not mark the node as clean.
 func (n *LeafNode) UpdateHash() {
-	var keyLen, valueLen [4]byte
-	binary.LittleEndian.PutUint32(keyLen[:], uint32(len(n.Key)))
-	binary.LittleEndian.PutUint32(valueLen[:], uint32(len(n.Value)))
+	n.Hash = n.HashWith(CurrentHasher())
+}
 
-	n.Hash.FromBytes([]byte{PrefixLeafNode}, keyLen[:], n.Key[:], valueLen[:], n.Value[:])
+// UpdateHashWithDomain updates the node's cached hash by recomputing it with the given domain
+// separation tag prepended to the hash input. An empty domain is equivalent to UpdateHash.
+//
+// The domain must be fixed for the whole tree as mixing domains within a tree corrupts it.
+//
+// Does not mark the node as clean.
+func (n *LeafNode) UpdateHashWithDomain(domain []byte) {
+	n.Hash = n.HashWith(withDomain(CurrentHasher(), domain))
 }
 
 // Extract makes a copy of the node containing only hash references.
@@ -630,6 +855,9 @@ func (n *LeafNode) CompactMarshalBinaryV0() ([]byte, error) {
 }
 
 // CompactMarshalBinaryV1 encodes a leaf node into binary form.
+//
+// The value length is encoded as a little-endian uint32, the same as when computing the node
+// hash (see HashWith).
 func (n *LeafNode) CompactMarshalBinaryV1() (data []byte, err error) {
 	keyData, err := n.Key.MarshalBinary()
 	if err != nil {
@@ -658,32 +886,71 @@ func (n *LeafNode) UnmarshalBinary(data []byte) error {
 
 // SizedUnmarshalBinary decodes a binary marshaled leaf node.
 func (n *LeafNode) SizedUnmarshalBinary(data []byte) (int, error) {
+	return n.sizedUnmarshalBinary(data, nil, nil)
+}
+
+// UnmarshalBinaryInto decodes a binary marshaled leaf node into an existing node, reusing the
+// backing arrays of its Key and Value when they have enough capacity. New slices are only
+// allocated when the existing ones need to grow.
+//
+// Since the backing arrays are overwritten in place, any slices previously obtained from the
+// node's Key or Value (including copies held by other nodes) observe the new contents. Nodes
+// decoded this way must therefore not be shared, e.g. they must not be stored in the node cache,
+// inserted into a tree or retained after the next decode into the same node. On error, the node
+// is left unmodified.
+func (n *LeafNode) UnmarshalBinaryInto(data []byte) error {
+	_, err := n.sizedUnmarshalBinary(data, n.Key, n.Value)
+	return err
+}
+
+// decodeValueLength decodes the value length stored at the given position, which must be
+// followed by at least ValueLengthSize bytes, and checks that the value fits into the remaining
+// data and does not exceed the maximum value size.
+//
+// The checks are done using uint64 arithmetic as the length does not necessarily fit into an int
+// on 32-bit platforms.
+func decodeValueLength(data []byte, pos int) (int, error) {
+	valueSize := uint64(binary.LittleEndian.Uint32(data[pos : pos+ValueLengthSize]))
+	if valueSize > uint64(MaxValueSize()) {
+		return 0, ErrInvalidValueLength
+	}
+	if valueSize > uint64(len(data)-pos-ValueLengthSize) {
+		return 0, ErrInvalidValueLength
+	}
+	return int(valueSize), nil
+}
+
+func (n *LeafNode) sizedUnmarshalBinary(data, keyBuf, valueBuf []byte) (int, error) {
 	if len(data) < 1+DepthSize+ValueLengthSize || data[0] != PrefixLeafNode {
 		return 0, ErrMalformedNode
 	}
 
 	pos := 1
 
-	var key Key
-	keySize, err := key.SizedUnmarshalBinary(data[pos:])
-	if err != nil {
-		return 0, err
+	keySize := int(binary.LittleEndian.Uint16(data[pos : pos+DepthSize]))
+	pos += DepthSize
+	if pos+keySize > len(data) {
+		return 0, ErrMalformedKey
 	}
+	keyData := data[pos : pos+keySize]
 	pos += keySize
 	if pos+ValueLengthSize > len(data) {
 		return 0, ErrMalformedNode
 	}
 
-	valueSize := int(binary.LittleEndian.Uint32(data[pos : pos+ValueLengthSize]))
-	pos += ValueLengthSize
-	if pos+valueSize > len(data) {
-		return 0, ErrMalformedNode
+	valueSize, err := decodeValueLength(data, pos)
+	if err != nil {
+		return 0, err
 	}
-
-	value := make([]byte, valueSize)
-	copy(value, data[pos:pos+valueSize])
+	pos += ValueLengthSize
+	valueData := data[pos : pos+valueSize]
 	pos += valueSize
 
+	key := resizeBuffer(keyBuf, keySize)
+	copy(key, keyData)
+	value := resizeBuffer(valueBuf, valueSize)
+	copy(value, valueData)
+
 	n.Clean = true
 	n.Key = key
 	n.Value = value
@@ -693,6 +960,15 @@ func (n *LeafNode) SizedUnmarshalBinary(data []byte) (int, error) {
 	return pos, nil
 }
 
+// resizeBuffer returns a slice of the given length, reusing the backing array of buf in case it
+// has enough capacity. A nil buffer always results in a new (non-nil) slice.
+func resizeBuffer(buf []byte, size int) []byte {
+	if buf == nil || cap(buf) < size {
+		return make([]byte, size)
+	}
+	return buf[:size]
+}
+
 // Equal compares a node with some other node.
 func (n *LeafNode) Equal(other Node) bool {
 	if n == nil && other == nil {
@@ -738,3 +1014,80 @@ func UnmarshalBinary(bytes []byte) (Node, error) {
 	}
 	return node, nil
 }
+
+// Peek inspects the beginning of a binary marshaled node and returns its kind (either
+// PrefixLeafNode or PrefixInternalNode) together with the total length of its encoding, without
+// decoding the node or copying its key, label or value.
+//
+// Internal nodes are expected to be in the full (non-compact) serialization format as produced
+// by MarshalBinary, i.e. including the left and right hashes. This makes it possible to walk over
+// a sequence of concatenated node encodings by advancing totalLen bytes at a time.
+func Peek(data []byte) (kind byte, totalLen int, err error) {
+	if len(data) < 1 {
+		return 0, 0, ErrMalformedNode
+	}
+
+	switch data[0] {
+	case PrefixLeafNode:
+		totalLen, err = peekLeafNode(data)
+	case PrefixInternalNode:
+		totalLen, err = peekInternalNode(data)
+	default:
+		return 0, 0, ErrMalformedNode
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return data[0], totalLen, nil
+}
+
+func peekLeafNode(data []byte) (int, error) {
+	if len(data) < 1+DepthSize+ValueLengthSize || data[0] != PrefixLeafNode {
+		return 0, ErrMalformedNode
+	}
+
+	pos := 1
+	keySize := int(binary.LittleEndian.Uint16(data[pos : pos+DepthSize]))
+	pos += DepthSize + keySize
+	if pos+ValueLengthSize > len(data) {
+		return 0, ErrMalformedNode
+	}
+
+	valueSize, err := decodeValueLength(data, pos)
+	if err != nil {
+		return 0, err
+	}
+	return pos + ValueLengthSize + valueSize, nil
+}
+
+func peekInternalNode(data []byte) (int, error) {
+	if len(data) < 1+DepthSize+1 {
+		return 0, ErrMalformedNode
+	}
+
+	pos := 1
+	var labelBitLength Depth
+	if _, err := labelBitLength.UnmarshalBinary(data[pos:]); err != nil {
+		return 0, fmt.Errorf("mkvs: failed to unmarshal LabelBitLength: %w", err)
+	}
+	pos += DepthSize + labelBitLength.ToBytes()
+	if pos >= len(data) {
+		return 0, ErrMalformedNode
+	}
+
+	if data[pos] == PrefixNilNode {
+		pos++
+	} else {
+		leafNodeSize, err := peekLeafNode(data[pos:])
+		if err != nil {
+			return 0, fmt.Errorf("mkvs: failed to peek leaf node: %w", err)
+		}
+		pos += leafNodeSize
+	}
+
+	pos += 2 * hash.Size
+	if pos > len(data) {
+		return 0, ErrMalformedNode
+	}
+	return pos, nil
+}
@@ -1,10 +1,16 @@
 package node
 
 import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/oasisprotocol/oasis-core/go/common"
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 )
 
@@ -32,6 +38,143 @@ func TestSerializationLeafNode(t *testing.T) {
 	}
 }
 
+func TestLeafNodeUnmarshalBinaryInto(t *testing.T) {
+	require := require.New(t)
+
+	small := &LeafNode{Key: []byte("key"), Value: []byte("value")}
+	small.UpdateHash()
+	rawSmall, err := small.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	large := &LeafNode{Key: []byte("a much longer key"), Value: []byte("a much longer value")}
+	large.UpdateHash()
+	rawLarge, err := large.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+
+	var decoded LeafNode
+	err = decoded.UnmarshalBinaryInto(rawLarge)
+	require.NoError(err, "UnmarshalBinaryInto")
+	require.True(decoded.Clean)
+	require.EqualValues(large.Key, decoded.Key)
+	require.EqualValues(large.Value, decoded.Value)
+	require.Equal(large.GetHash(), decoded.GetHash())
+
+	// Decoding a smaller node should reuse the existing buffers.
+	keyPtr, valuePtr := &decoded.Key[0], &decoded.Value[0]
+	err = decoded.UnmarshalBinaryInto(rawSmall)
+	require.NoError(err, "UnmarshalBinaryInto")
+	require.EqualValues(small.Key, decoded.Key)
+	require.EqualValues(small.Value, decoded.Value)
+	require.Equal(small.GetHash(), decoded.GetHash())
+	require.Same(keyPtr, &decoded.Key[0], "key buffer should be reused")
+	require.Same(valuePtr, &decoded.Value[0], "value buffer should be reused")
+
+	// Decoding a larger node into a node with small buffers should grow them.
+	decoded = LeafNode{Key: make([]byte, 0, 1), Value: make([]byte, 0, 1)}
+	err = decoded.UnmarshalBinaryInto(rawLarge)
+	require.NoError(err, "UnmarshalBinaryInto")
+	require.EqualValues(large.Key, decoded.Key)
+	require.EqualValues(large.Value, decoded.Value)
+
+	// Malformed input should leave the node unmodified.
+	err = decoded.UnmarshalBinaryInto(rawSmall[:len(rawSmall)-1])
+	require.ErrorIs(err, ErrMalformedNode, "UnmarshalBinaryInto should fail on truncated input")
+	require.EqualValues(large.Key, decoded.Key)
+	require.EqualValues(large.Value, decoded.Value)
+
+	// Empty keys and values should decode the same as with UnmarshalBinary.
+	empty := &LeafNode{Key: []byte{}, Value: []byte{}}
+	rawEmpty, err := empty.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	var expected LeafNode
+	err = expected.UnmarshalBinary(rawEmpty)
+	require.NoError(err, "UnmarshalBinary")
+	decoded = LeafNode{}
+	err = decoded.UnmarshalBinaryInto(rawEmpty)
+	require.NoError(err, "UnmarshalBinaryInto")
+	require.Equal(expected, decoded)
+}
+
+func BenchmarkLeafNodeUnmarshalBinary(b *testing.B) {
+	leafNode := &LeafNode{
+		Key:   []byte("a typical key of moderate size"),
+		Value: make([]byte, 256),
+	}
+	data, err := leafNode.MarshalBinary()
+	require.NoError(b, err, "MarshalBinary")
+
+	b.Run("New", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			var decoded LeafNode
+			if err := decoded.UnmarshalBinary(data); err != nil {
+				b.Fatalf("UnmarshalBinary: %s", err)
+			}
+		}
+	})
+	b.Run("Into", func(b *testing.B) {
+		b.ReportAllocs()
+		var decoded LeafNode
+		for n := 0; n < b.N; n++ {
+			if err := decoded.UnmarshalBinaryInto(data); err != nil {
+				b.Fatalf("UnmarshalBinaryInto: %s", err)
+			}
+		}
+	})
+}
+
+func TestSerializationLeafNodeMaxValueSize(t *testing.T) {
+	defer SetMaxValueSize(DefaultMaxValueSize)
+
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	rawLeafNode, err := leafNode.MarshalBinary()
+	require.NoError(t, err, "MarshalBinary")
+
+	SetMaxValueSize(len(leafNode.Value))
+	var decodedLeafNode LeafNode
+	err = decodedLeafNode.UnmarshalBinary(rawLeafNode)
+	require.NoError(t, err, "UnmarshalBinary should accept value at the limit")
+
+	SetMaxValueSize(len(leafNode.Value) - 1)
+	err = decodedLeafNode.UnmarshalBinary(rawLeafNode)
+	require.ErrorIs(t, err, ErrMalformedNode, "UnmarshalBinary should reject value over the limit")
+
+	// A declared value length larger than the limit should be rejected even if the data is
+	// truncated.
+	SetMaxValueSize(DefaultMaxValueSize)
+	binary.LittleEndian.PutUint32(rawLeafNode[len(rawLeafNode)-len(leafNode.Value)-ValueLengthSize:], 0xffffffff)
+	err = decodedLeafNode.UnmarshalBinary(rawLeafNode)
+	require.ErrorIs(t, err, ErrMalformedNode, "UnmarshalBinary should reject huge declared value")
+}
+
+func TestSerializationLeafNodeValueLengthOverflow(t *testing.T) {
+	defer SetMaxValueSize(DefaultMaxValueSize)
+	SetMaxValueSize(math.MaxInt32)
+
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	rawLeafNode, err := leafNode.MarshalBinary()
+	require.NoError(t, err, "MarshalBinary")
+	lengthPos := len(rawLeafNode) - len(leafNode.Value) - ValueLengthSize
+
+	// Lengths around the boundary of a 32-bit int must be rejected without panicking.
+	for _, length := range []uint32{math.MaxInt32 - 1, math.MaxInt32, math.MaxInt32 + 1, math.MaxUint32} {
+		binary.LittleEndian.PutUint32(rawLeafNode[lengthPos:], length)
+
+		var decodedLeafNode LeafNode
+		err = decodedLeafNode.UnmarshalBinary(rawLeafNode)
+		require.ErrorIs(t, err, ErrInvalidValueLength, "UnmarshalBinary should reject length %d", length)
+		require.ErrorIs(t, err, ErrMalformedNode, "UnmarshalBinary should reject length %d", length)
+
+		_, _, err = Peek(rawLeafNode)
+		require.ErrorIs(t, err, ErrInvalidValueLength, "Peek should reject length %d", length)
+	}
+}
+
 func TestSerializationInternalNode(t *testing.T) {
 	leafNode := &LeafNode{
 		Key:   []byte("a golden key"),
@@ -83,6 +226,64 @@ func TestSerializationInternalNode(t *testing.T) {
 	}
 }
 
+func TestPeek(t *testing.T) {
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	leafNode.UpdateHash()
+	rawLeafNode, err := leafNode.MarshalBinary()
+	require.NoError(t, err, "MarshalBinary")
+
+	intNode := &InternalNode{
+		Label:          Key("abc"),
+		LabelBitLength: Depth(24),
+		LeafNode:       &Pointer{Clean: true, Node: leafNode, Hash: leafNode.Hash},
+		Left:           &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("everyone move to the left"))},
+		Right:          &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("everyone move to the right"))},
+	}
+	rawIntNode, err := intNode.MarshalBinary()
+	require.NoError(t, err, "MarshalBinary")
+
+	emptyIntNode := &InternalNode{}
+	rawEmptyIntNode, err := emptyIntNode.MarshalBinary()
+	require.NoError(t, err, "MarshalBinary")
+
+	// Peek over a sequence of concatenated nodes.
+	var data []byte
+	data = append(data, rawLeafNode...)
+	data = append(data, rawIntNode...)
+	data = append(data, rawEmptyIntNode...)
+
+	expected := []struct {
+		kind byte
+		raw  []byte
+	}{
+		{PrefixLeafNode, rawLeafNode},
+		{PrefixInternalNode, rawIntNode},
+		{PrefixInternalNode, rawEmptyIntNode},
+	}
+	for _, e := range expected {
+		kind, totalLen, err := Peek(data)
+		require.NoError(t, err, "Peek")
+		require.Equal(t, e.kind, kind)
+		require.Equal(t, len(e.raw), totalLen)
+		require.Equal(t, e.raw, data[:totalLen])
+		data = data[totalLen:]
+	}
+	require.Empty(t, data)
+
+	// Truncated and malformed encodings should be rejected.
+	for _, raw := range [][]byte{rawLeafNode, rawIntNode, rawEmptyIntNode} {
+		for i := 0; i < len(raw); i++ {
+			_, _, err = Peek(raw[:i])
+			require.Error(t, err, "Peek should fail on truncated data (length %d)", i)
+		}
+	}
+	_, _, err = Peek([]byte{PrefixNilNode})
+	require.ErrorIs(t, err, ErrMalformedNode)
+}
+
 func TestHashLeafNode(t *testing.T) {
 	leafNode := &LeafNode{
 		Key:   []byte("a golden key"),
@@ -94,6 +295,31 @@ func TestHashLeafNode(t *testing.T) {
 	require.Equal(t, "5c05183d4158b5920b16833acb78ccda464da83f720f824177b3a55a75f9fd88", leafNode.Hash.String())
 }
 
+func TestLeafNodeHashEndianness(t *testing.T) {
+	require := require.New(t)
+
+	// The value length (258) differs between little-endian and big-endian encodings in more than
+	// one byte, so any change of endianness changes the hash.
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: bytes.Repeat([]byte("v"), 0x0102),
+	}
+	leafNode.UpdateHash()
+	require.Equal("82c5093922bedb300375a8fb252bb8f78758629f19f8b1492d86cdf45e969062", leafNode.Hash.String())
+
+	// The serialized value length must match the one used when hashing.
+	data, err := leafNode.CompactMarshalBinaryV1()
+	require.NoError(err, "CompactMarshalBinaryV1")
+	valueLenOffset := 1 + DepthSize + len(leafNode.Key)
+	valueLen := data[valueLenOffset : valueLenOffset+ValueLengthSize]
+	require.Equal([]byte{0x02, 0x01, 0x00, 0x00}, valueLen, "value length should be little-endian")
+
+	var keyLen [4]byte
+	binary.LittleEndian.PutUint32(keyLen[:], uint32(len(leafNode.Key)))
+	expected := hash.NewFromBytes([]byte{PrefixLeafNode}, keyLen[:], leafNode.Key, valueLen, leafNode.Value)
+	require.Equal(expected, leafNode.Hash, "hash should use the serialized value length")
+}
+
 func TestHashInternalNode(t *testing.T) {
 	leafNodeHash := hash.NewFromBytes([]byte("everyone stop here"))
 	leftHash := hash.NewFromBytes([]byte("everyone move to the left"))
@@ -112,6 +338,76 @@ func TestHashInternalNode(t *testing.T) {
 	require.Equal(t, "75c37c67c265e2c836f76dec35173fa336e976938ea46f088390a983e46efced", intNode.Hash.String())
 }
 
+func TestHashWithDomain(t *testing.T) {
+	require := require.New(t)
+
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+
+	// An empty domain should not change the hash.
+	leafNode.UpdateHashWithDomain(nil)
+	require.Equal("5c05183d4158b5920b16833acb78ccda464da83f720f824177b3a55a75f9fd88", leafNode.Hash.String())
+	leafNode.UpdateHashWithDomain([]byte("test domain"))
+	require.Equal("9f2b940937147a75e3b2394cac522867adcfacefe296ca86aa5f51bca2eb7248", leafNode.Hash.String())
+
+	intNode := &InternalNode{
+		Label:          Key("abc"),
+		LabelBitLength: 23,
+		LeafNode:       &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("everyone stop here"))},
+		Left:           &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("everyone move to the left"))},
+		Right:          &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("everyone move to the right"))},
+	}
+
+	intNode.UpdateHashWithDomain(nil)
+	require.Equal("75c37c67c265e2c836f76dec35173fa336e976938ea46f088390a983e46efced", intNode.Hash.String())
+	intNode.UpdateHashWithDomain([]byte("test domain"))
+	require.Equal("b99f6ef26ca0e37768afc0303b6cdde81d155e21baf5b015c5f9df52c9a91d46", intNode.Hash.String())
+}
+
+type prefixHasher struct {
+	prefix []byte
+}
+
+func (h prefixHasher) Hash(data ...[]byte) hash.Hash {
+	return hash.NewFromBytes(append([][]byte{h.prefix}, data...)...)
+}
+
+func TestSetHasher(t *testing.T) {
+	t.Cleanup(func() { SetHasher(nil) })
+
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	leafNode.UpdateHash()
+	defaultHash := leafNode.Hash
+	require.Equal(t, DefaultHasher, CurrentHasher())
+
+	hasher := prefixHasher{prefix: []byte("experimental")}
+	SetHasher(hasher)
+	require.Equal(t, hasher, CurrentHasher())
+
+	leafNode.UpdateHash()
+	require.NotEqual(t, defaultHash, leafNode.Hash, "leaf node hash should depend on the hasher")
+	require.Equal(t, leafNode.HashWith(hasher), leafNode.Hash)
+	require.NoError(t, Validate(leafNode))
+
+	intNode := &InternalNode{
+		LeafNode: &Pointer{Clean: true, Node: leafNode, Hash: leafNode.Hash},
+	}
+	intNode.UpdateHash()
+	require.Equal(t, intNode.HashWith(hasher, leafNode.Hash, intNode.Left.GetHash(), intNode.Right.GetHash()), intNode.Hash)
+	require.NoError(t, Validate(intNode))
+
+	SetHasher(nil)
+	require.Equal(t, DefaultHasher, CurrentHasher())
+	require.Error(t, Validate(leafNode), "node hashed with a different hasher should not validate")
+	leafNode.UpdateHash()
+	require.Equal(t, defaultHash, leafNode.Hash)
+}
+
 func TestExtractLeafNode(t *testing.T) {
 	leafNode := &LeafNode{
 		Clean: true,
@@ -150,6 +446,200 @@ func TestExtractInternalNode(t *testing.T) {
 	require.Equal(t, true, exIntNode.Right.Clean, "extracted right pointer must be clean")
 }
 
+func TestPointerResolveWith(t *testing.T) {
+	leafNode := &LeafNode{
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	leafNode.UpdateHash()
+
+	var calls int
+	resolve := func(ptr *Pointer) (Node, error) {
+		calls++
+		require.Equal(t, leafNode.Hash, ptr.Hash, "resolver should be called with the pointer")
+		return leafNode, nil
+	}
+
+	ptr := &Pointer{Clean: true, Hash: leafNode.Hash}
+	nd, err := ptr.ResolveWith(resolve)
+	require.NoError(t, err, "ResolveWith")
+	require.Equal(t, leafNode, nd)
+	require.Equal(t, leafNode, ptr.Node, "resolved node should be cached in the pointer")
+	require.Equal(t, 1, calls)
+
+	nd, err = ptr.ResolveWith(resolve)
+	require.NoError(t, err, "ResolveWith")
+	require.Equal(t, leafNode, nd)
+	require.Equal(t, 1, calls, "resolver should not be called for an already resolved pointer")
+
+	// Nil pointers resolve to nil nodes.
+	var nilPtr *Pointer
+	nd, err = nilPtr.ResolveWith(resolve)
+	require.NoError(t, err, "ResolveWith")
+	require.Nil(t, nd)
+	require.Equal(t, 1, calls)
+
+	// Errors are propagated and nothing is cached.
+	errResolve := errors.New("resolve failed")
+	ptr = &Pointer{Clean: true, Hash: leafNode.Hash}
+	_, err = ptr.ResolveWith(func(*Pointer) (Node, error) {
+		return nil, errResolve
+	})
+	require.ErrorIs(t, err, errResolve)
+	require.Nil(t, ptr.Node)
+}
+
+type testNodeGetter struct {
+	nodes map[hash.Hash]Node
+	roots []Root
+}
+
+func (g *testNodeGetter) GetNode(root Root, ptr *Pointer) (Node, error) {
+	g.roots = append(g.roots, root)
+	nd, ok := g.nodes[ptr.Hash]
+	if !ok {
+		return nil, errors.New("node not found")
+	}
+	return nd, nil
+}
+
+func TestPointerResolve(t *testing.T) {
+	require := require.New(t)
+
+	leafNode := &LeafNode{
+		Clean: true,
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	leafNode.UpdateHash()
+
+	root := Root{Version: 1, Type: RootTypeState, Hash: leafNode.Hash}
+	getter := &testNodeGetter{nodes: map[hash.Hash]Node{leafNode.Hash: leafNode}}
+
+	ptr := &Pointer{Clean: true, Hash: leafNode.Hash}
+	nd, err := ptr.Resolve(getter, root)
+	require.NoError(err, "Resolve")
+	require.Equal(leafNode, nd)
+	require.Equal(leafNode, ptr.Node, "resolved node should be cached in the pointer")
+	require.True(ptr.Clean, "pointer should remain clean")
+	require.Equal(leafNode.Hash, ptr.Hash, "pointer hash should not change")
+	require.Equal([]Root{root}, getter.roots, "node should be loaded under the given root")
+
+	nd, err = ptr.Resolve(getter, root)
+	require.NoError(err, "Resolve")
+	require.Equal(leafNode, nd)
+	require.Len(getter.roots, 1, "database should not be consulted for a resolved pointer")
+
+	// Nil pointers, pointers to empty subtrees and dirty pointers resolve to nil nodes.
+	var nilPtr *Pointer
+	var emptyHash hash.Hash
+	emptyHash.Empty()
+	for _, p := range []*Pointer{
+		nilPtr,
+		{Clean: true, Hash: emptyHash},
+		{Clean: false, Hash: leafNode.Hash},
+	} {
+		nd, err = p.Resolve(getter, root)
+		require.NoError(err, "Resolve")
+		require.Nil(nd)
+	}
+	require.Len(getter.roots, 1, "database should not be consulted for unresolvable pointers")
+
+	// Errors are propagated and nothing is cached.
+	ptr = &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("missing"))}
+	_, err = ptr.Resolve(getter, root)
+	require.Error(err, "Resolve should fail for missing nodes")
+	require.Nil(ptr.Node)
+}
+
+func TestPointerFreeze(t *testing.T) {
+	require := require.New(t)
+
+	leafNode := &LeafNode{
+		Clean: true,
+		Key:   []byte("a golden key"),
+		Value: []byte("value"),
+	}
+	leafNode.UpdateHash()
+
+	root := Root{Version: 1, Type: RootTypeState, Hash: leafNode.Hash}
+	getter := &testNodeGetter{nodes: map[hash.Hash]Node{leafNode.Hash: leafNode}}
+
+	// Clean pointers should drop their node and be resolvable again.
+	ptr := &Pointer{Clean: true, Hash: leafNode.Hash, Node: leafNode}
+	ptr.Freeze()
+	require.Nil(ptr.Node, "frozen pointer should drop its node")
+	require.True(ptr.Clean, "frozen pointer should remain clean")
+	require.Equal(leafNode.Hash, ptr.Hash, "frozen pointer should keep its hash")
+
+	nd, err := ptr.Resolve(getter, root)
+	require.NoError(err, "Resolve")
+	require.Equal(leafNode, nd, "frozen pointer should be resolvable again")
+
+	// Dirty pointers and pointers tracked by a cache should be left unchanged.
+	dirtyPtr := &Pointer{Clean: false, Hash: leafNode.Hash, Node: leafNode}
+	dirtyPtr.Freeze()
+	require.Equal(leafNode, dirtyPtr.Node, "dirty pointer should keep its node")
+
+	cachedPtr := &Pointer{Clean: true, Hash: leafNode.Hash, Node: leafNode}
+	cachedPtr.LRU = list.New().PushFront(cachedPtr)
+	cachedPtr.Freeze()
+	require.Equal(leafNode, cachedPtr.Node, "cached pointer should keep its node")
+
+	var nilPtr *Pointer
+	nilPtr.Freeze()
+}
+
+func TestPointerIsFullyMaterialized(t *testing.T) {
+	newLeaf := func(key string) *Pointer {
+		leafNode := &LeafNode{
+			Clean: true,
+			Key:   []byte(key),
+			Value: []byte("value " + key),
+		}
+		leafNode.UpdateHash()
+		return &Pointer{Clean: true, Hash: leafNode.Hash, Node: leafNode}
+	}
+	newInternal := func(leaf, left, right *Pointer) *Pointer {
+		intNode := &InternalNode{
+			Clean:          true,
+			Label:          Key("a"),
+			LabelBitLength: 8,
+			LeafNode:       leaf,
+			Left:           left,
+			Right:          right,
+		}
+		intNode.UpdateHash()
+		return &Pointer{Clean: true, Hash: intNode.Hash, Node: intNode}
+	}
+
+	// A fully built tree.
+	root := newInternal(
+		newLeaf("a"),
+		newInternal(nil, newLeaf("aa"), newLeaf("ab")),
+		newLeaf("b"),
+	)
+	require.True(t, root.IsFullyMaterialized(), "fully built tree should be materialized")
+	require.True(t, IsFullyMaterialized(root), "fully built tree should be materialized")
+
+	// Empty trees and dead nodes need no resolution.
+	var nilPtr *Pointer
+	require.True(t, nilPtr.IsFullyMaterialized(), "nil pointer should be materialized")
+	var deadPtr Pointer
+	deadPtr.Hash.Empty()
+	require.True(t, deadPtr.IsFullyMaterialized(), "dead pointer should be materialized")
+
+	// A fully extracted (hash-only) tree.
+	require.False(t, root.Extract().IsFullyMaterialized(), "extracted tree should not be materialized")
+
+	// A tree with a single hash-only pointer deep in the subtree.
+	intNode := root.Node.(*InternalNode)
+	left := intNode.Left.Node.(*InternalNode)
+	left.Right = left.Right.Extract()
+	require.False(t, root.IsFullyMaterialized(), "partially extracted tree should not be materialized")
+	require.True(t, intNode.Right.IsFullyMaterialized(), "sibling subtree should be materialized")
+}
+
 func FuzzNode(f *testing.F) {
 	// Seed corpus.
 	leafNode := &LeafNode{
@@ -201,5 +691,202 @@ func FuzzNode(f *testing.F) {
 		if err != nil {
 			panic(err)
 		}
+
+		// Valid nodes must round-trip into equal valid nodes.
+		if Validate(n) != nil {
+			return
+		}
+		raw, err := n.MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		decoded, err := UnmarshalBinary(raw)
+		if err != nil {
+			panic(err)
+		}
+		if err = Validate(decoded); err != nil {
+			panic(err)
+		}
+		if !n.Equal(decoded) {
+			panic("round-tripped node is not equal to the original node")
+		}
 	})
 }
+
+func TestEmptyRoot(t *testing.T) {
+	require := require.New(t)
+
+	ns := common.NewTestNamespaceFromSeed([]byte("empty root test ns"), 0)
+	root := EmptyRoot(ns, 42, RootTypeIO)
+	require.Equal(ns, root.Namespace, "namespace should be set")
+	require.EqualValues(42, root.Version, "version should be set")
+	require.Equal(RootTypeIO, root.Type, "type should be retained")
+	require.True(root.IsEmptyTree(), "root should be the root of an empty tree")
+	require.False(root.IsEmpty(), "root with a namespace and version should not be empty")
+
+	var emptyHash hash.Hash
+	emptyHash.Empty()
+	require.Equal(emptyHash, root.Hash, "hash should be the empty tree hash")
+
+	root.Hash = hash.NewFromBytes([]byte("non-empty"))
+	require.False(root.IsEmptyTree(), "root with a non-empty hash should not be the root of an empty tree")
+}
+
+func TestEmptyTreeHash(t *testing.T) {
+	t.Cleanup(func() { SetHasher(nil) })
+	require := require.New(t)
+
+	h := EmptyTreeHash()
+	require.Equal("c672b8d1ef56ed28ab87c3622c5114069bdd3ad7b8f9737498d0c01ecef0967a", h.String())
+	require.True(h.IsEmpty(), "empty tree hash should be the hash of an empty byte string")
+
+	// The empty tree hash should respect the configured hasher.
+	SetHasher(prefixHasher{prefix: []byte("test prefix")})
+	h = EmptyTreeHash()
+	require.Equal("e75fcf4e3ef69012f29251be53830b3f670930c837d9efff0dbc144f0ceef615", h.String())
+
+	root := EmptyRoot(common.Namespace{}, 0, RootTypeState)
+	require.Equal(h, root.Hash, "EmptyRoot should use the empty tree hash")
+	require.True(root.IsEmptyTree(), "root should be the root of an empty tree")
+	root.Hash.Empty()
+	require.False(root.IsEmptyTree(), "default empty hash should not be the empty tree hash")
+
+	// Empty roots and subtrees should also use the empty tree hash.
+	root.Empty()
+	require.Equal(h, root.Hash, "Empty should use the empty tree hash")
+	require.True(root.IsEmpty(), "root should be empty")
+	var nilPtr *Pointer
+	require.Equal(h, nilPtr.GetHash(), "nil pointers should have the empty tree hash")
+
+	intNode := &InternalNode{
+		LeafNode: &Pointer{Clean: true, Node: &LeafNode{Key: []byte("key"), Value: []byte("value")}},
+	}
+	intNode.LeafNode.Node.UpdateHash()
+	intNode.LeafNode.Hash = intNode.LeafNode.Node.GetHash()
+	intNode.UpdateHash()
+	require.Equal(intNode.HashWith(CurrentHasher(), intNode.LeafNode.Hash, h, h), intNode.Hash)
+
+	data, err := intNode.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	decoded, err := UnmarshalBinary(data)
+	require.NoError(err, "UnmarshalBinary")
+	require.Nil(decoded.(*InternalNode).Left, "empty left child should decode as nil")
+	require.Nil(decoded.(*InternalNode).Right, "empty right child should decode as nil")
+}
+
+func TestRootSuccessor(t *testing.T) {
+	require := require.New(t)
+
+	ns := common.NewTestNamespaceFromSeed([]byte("successor test ns"), 0)
+	root := Root{
+		Namespace: ns,
+		Version:   42,
+		Type:      RootTypeState,
+		Hash:      hash.NewFromBytes([]byte("old")),
+	}
+	newHash := hash.NewFromBytes([]byte("new"))
+
+	next, err := root.Successor(newHash)
+	require.NoError(err, "Successor")
+	require.Equal(Root{Namespace: ns, Version: 43, Type: RootTypeState, Hash: newHash}, next)
+	require.True(next.Follows(&root), "successor should follow the root")
+
+	// IO roots cannot have successors.
+	ioRoot := root
+	ioRoot.Type = RootTypeIO
+	_, err = ioRoot.Successor(newHash)
+	require.ErrorIs(err, ErrNoSuccessor, "Successor should fail for IO roots")
+
+	// Neither can roots of an invalid type.
+	invalidRoot := root
+	invalidRoot.Type = RootTypeInvalid
+	_, err = invalidRoot.Successor(newHash)
+	require.ErrorIs(err, ErrNoSuccessor, "Successor should fail for invalid roots")
+
+	// The version must not overflow.
+	lastRoot := root
+	lastRoot.Version = math.MaxUint64
+	_, err = lastRoot.Successor(newHash)
+	require.ErrorIs(err, ErrNoSuccessor, "Successor should fail on version overflow")
+}
+
+func TestRootEmpty(t *testing.T) {
+	require := require.New(t)
+
+	root := Root{
+		Namespace: common.NewTestNamespaceFromSeed([]byte("empty root test ns"), 0),
+		Version:   42,
+		Type:      RootTypeIO,
+		Hash:      hash.NewFromBytes([]byte("non-empty")),
+	}
+	root.Empty()
+	require.Equal(RootTypeInvalid, root.Type, "Empty should reset the type")
+	require.True(root.IsEmpty(), "root should be empty")
+	require.True(root.IsEmptyTree(), "root should be the root of an empty tree")
+	require.Equal(Root{Hash: root.Hash}, root, "Empty should reset all other fields")
+
+	// Empty roots of any type are considered empty, but are not equal.
+	typed := root
+	typed.Type = RootTypeState
+	require.True(typed.IsEmpty(), "empty root with a type should be empty")
+	require.False(typed.Equal(&root), "empty roots of different types should not be equal")
+}
+
+func TestEqualStructural(t *testing.T) {
+	require := require.New(t)
+
+	newTree := func(value string, updateHash bool) *InternalNode {
+		leaf := &LeafNode{Key: Key("ab"), Value: []byte(value)}
+		left := &LeafNode{Key: Key("aa"), Value: []byte("left")}
+		n := &InternalNode{
+			Label:          Key("a"),
+			LabelBitLength: 8,
+			LeafNode:       &Pointer{Node: leaf},
+			Left:           &Pointer{Node: left},
+			Right:          &Pointer{Hash: hash.NewFromBytes([]byte("unresolved"))},
+		}
+		if updateHash {
+			leaf.UpdateHash()
+			left.UpdateHash()
+			n.LeafNode.Hash = leaf.Hash
+			n.Left.Hash = left.Hash
+			n.UpdateHash()
+			leaf.Clean, left.Clean, n.Clean = true, true, true
+			n.LeafNode.Clean, n.Left.Clean, n.Right.Clean = true, true, true
+		}
+		return n
+	}
+
+	hashed := newTree("value", true)
+	unhashed := newTree("value", false)
+	require.True(EqualStructural(hashed, unhashed), "nodes with the same structure should be equal")
+	require.True(EqualStructural(unhashed, hashed), "EqualStructural should be symmetric")
+
+	// A stale cached hash must not be trusted.
+	stale := newTree("value", true)
+	stale.Hash = hash.NewFromBytes([]byte("stale"))
+	require.False(hashed.Equal(stale), "Equal should compare cached hashes of clean nodes")
+	require.True(EqualStructural(hashed, stale), "EqualStructural should ignore cached hashes")
+
+	other := newTree("other", true)
+	other.Hash = hashed.Hash
+	require.True(hashed.Equal(other), "Equal should trust matching cached hashes")
+	require.False(EqualStructural(hashed, other), "EqualStructural should compare leaf values")
+
+	// Labels and child structure.
+	relabeled := newTree("value", false)
+	relabeled.LabelBitLength = 7
+	require.False(EqualStructural(hashed, relabeled), "label bit length should be compared")
+	unresolved := newTree("value", false)
+	unresolved.Right = &Pointer{Hash: hash.NewFromBytes([]byte("other unresolved"))}
+	require.False(EqualStructural(hashed, unresolved), "unresolved children should be compared by hash")
+	noLeaf := newTree("value", false)
+	noLeaf.LeafNode = nil
+	require.False(EqualStructural(hashed, noLeaf), "missing leaf should be detected")
+
+	// Nil and mismatched nodes.
+	require.True(EqualStructural(nil, nil))
+	require.True(EqualStructural(nil, (*LeafNode)(nil)))
+	require.False(EqualStructural(hashed, nil))
+	require.False(EqualStructural(hashed, hashed.LeafNode.Node))
+}
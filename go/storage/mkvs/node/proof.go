@@ -0,0 +1,197 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+)
+
+// ErrMalformedProof is the error returned when a proof path does not have the structure
+// expected for the given key.
+var ErrMalformedProof = errors.New("mkvs: malformed proof path")
+
+// ProofPath returns the nodes needed to prove the inclusion (or absence) of the given key in the
+// subtree rooted at the given pointer, ordered from the root towards the key.
+//
+// For each internal node on the path, the internal node is followed by its siblings, i.e. the
+// children (including the leaf node) that are not on the path to the key. The path ends with the
+// leaf node for the key in case the key is present. In case the key is absent, the path ends at
+// the node where the lookup terminates.
+//
+// Pointers without a loaded node are resolved using the given function, which may be nil in case
+// all nodes are loaded in memory. Resolved nodes are cached in the pointers (see ResolveWith). In
+// case a node cannot be resolved, ErrUnresolvedPointer is returned.
+func ProofPath(root *Pointer, key Key, resolve func(*Pointer) (Node, error)) ([]Node, error) {
+	var (
+		path     []Node
+		bitDepth Depth
+	)
+	ptr := root
+	for {
+		nd, err := proofResolve(ptr, resolve)
+		if err != nil {
+			return nil, err
+		}
+
+		switch n := nd.(type) {
+		case nil:
+			return path, nil
+		case *LeafNode:
+			return append(path, n), nil
+		case *InternalNode:
+			path = append(path, n)
+			bitLength := bitDepth + n.LabelBitLength
+
+			var next *Pointer
+			switch {
+			case key.BitLength() < bitLength:
+				// The key is too short for the label, it is not stored.
+				return path, nil
+			case key.BitLength() == bitLength:
+				next = n.LeafNode
+			case key.GetBit(bitLength):
+				next = n.Right
+			default:
+				next = n.Left
+			}
+
+			for _, sibling := range []*Pointer{n.LeafNode, n.Left, n.Right} {
+				if sibling == next {
+					continue
+				}
+				var sn Node
+				if sn, err = proofResolve(sibling, resolve); err != nil {
+					return nil, err
+				}
+				if sn != nil {
+					path = append(path, sn)
+				}
+			}
+
+			ptr = next
+			bitDepth = bitLength
+		default:
+			panic(fmt.Sprintf("mkvs: unknown node type: %+v", n))
+		}
+	}
+}
+
+func proofResolve(ptr *Pointer, resolve func(*Pointer) (Node, error)) (Node, error) {
+	switch {
+	case ptr == nil:
+		return nil, nil
+	case ptr.Node != nil:
+		return ptr.Node, nil
+	case IsEmptyTreeHash(ptr.Hash):
+		return nil, nil
+	case resolve == nil:
+		return nil, fmt.Errorf("%w: %s", ErrUnresolvedPointer, ptr.Hash)
+	}
+
+	nd, err := ptr.ResolveWith(resolve)
+	if err != nil {
+		return nil, err
+	}
+	if nd == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnresolvedPointer, ptr.Hash)
+	}
+	return nd, nil
+}
+
+// VerifyProof verifies that the given proof path, as returned by ProofPath, proves that the given
+// key has the given value in the tree with the given root hash. A nil value means that the proof
+// must prove the absence of the key.
+//
+// The hashes of all nodes in the path are recomputed from their contents and checked against the
+// root hash and the child hashes of their parents. The function returns false in case the proof
+// does not verify or proves a different claim, and ErrMalformedProof in case the path is not a
+// proof path for the given key.
+func VerifyProof(root hash.Hash, path []Node, key Key, value []byte) (bool, error) {
+	var bitDepth Depth
+	expected := root
+	for i := 0; ; i++ {
+		if IsEmptyTreeHash(expected) {
+			// Reached an empty subtree, the key is absent.
+			if i != len(path) {
+				return false, fmt.Errorf("%w: unexpected node after empty subtree", ErrMalformedProof)
+			}
+			return value == nil, nil
+		}
+		if i >= len(path) {
+			return false, fmt.Errorf("%w: path ends at non-empty subtree", ErrMalformedProof)
+		}
+
+		switch n := path[i].(type) {
+		case *LeafNode:
+			if h := proofHash(n); !h.Equal(&expected) {
+				return false, nil
+			}
+			if i != len(path)-1 {
+				return false, fmt.Errorf("%w: unexpected node after leaf", ErrMalformedProof)
+			}
+			if !n.Key.Equal(key) {
+				// Reached a different leaf, the key is absent.
+				return value == nil, nil
+			}
+			return value != nil && bytes.Equal(n.Value, value), nil
+		case *InternalNode:
+			if h := proofHash(n); !h.Equal(&expected) {
+				return false, nil
+			}
+			bitLength := bitDepth + n.LabelBitLength
+
+			var next *Pointer
+			switch {
+			case key.BitLength() < bitLength:
+				// The key is too short for the label, it is not stored.
+				if i != len(path)-1 {
+					return false, fmt.Errorf("%w: unexpected node after absent key", ErrMalformedProof)
+				}
+				return value == nil, nil
+			case key.BitLength() == bitLength:
+				next = n.LeafNode
+			case key.GetBit(bitLength):
+				next = n.Right
+			default:
+				next = n.Left
+			}
+
+			// Siblings do not contribute to the root hash other than through the hashes stored in
+			// the internal node, but they must be consistent with them.
+			for _, sibling := range []*Pointer{n.LeafNode, n.Left, n.Right} {
+				siblingHash := sibling.GetHash()
+				if sibling == next || IsEmptyTreeHash(siblingHash) {
+					continue
+				}
+				i++
+				if i >= len(path) || path[i] == nil {
+					return false, fmt.Errorf("%w: missing sibling", ErrMalformedProof)
+				}
+				if h := proofHash(path[i]); !h.Equal(&siblingHash) {
+					return false, nil
+				}
+			}
+
+			expected = next.GetHash()
+			bitDepth = bitLength
+		case nil:
+			return false, fmt.Errorf("%w: nil node", ErrMalformedProof)
+		default:
+			return false, fmt.Errorf("%w: unknown node type %T", ErrMalformedProof, n)
+		}
+	}
+}
+
+// proofHash recomputes the hash of the given node from its contents.
+func proofHash(nd Node) hash.Hash {
+	switch n := nd.(type) {
+	case *InternalNode:
+		return n.HashWith(CurrentHasher(), n.LeafNode.GetHash(), n.Left.GetHash(), n.Right.GetHash())
+	case *LeafNode:
+		return n.HashWith(CurrentHasher())
+	default:
+		return EmptyTreeHash()
+	}
+}
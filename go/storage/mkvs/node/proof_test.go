@@ -0,0 +1,204 @@
+package node
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+)
+
+type proofTestTree struct {
+	root, inner          *InternalNode
+	leafA, leafB, leafAB *LeafNode
+	rootPtr              *Pointer
+}
+
+// newProofTestTree builds a tree containing keys "a" (0x61) and "b" (0x62), which share the first
+// 6 bits, and key "ab", which is below "a".
+func newProofTestTree() *proofTestTree {
+	newLeaf := func(key string) *LeafNode {
+		leafNode := &LeafNode{
+			Clean: true,
+			Key:   []byte(key),
+			Value: []byte("value " + key),
+		}
+		leafNode.UpdateHash()
+		return leafNode
+	}
+	newInternal := func(label Key, labelBitLength Depth, leaf, left, right *Pointer) *InternalNode {
+		intNode := &InternalNode{
+			Clean:          true,
+			Label:          label,
+			LabelBitLength: labelBitLength,
+			LeafNode:       leaf,
+			Left:           left,
+			Right:          right,
+		}
+		intNode.UpdateHash()
+		return intNode
+	}
+	ptrTo := func(nd Node) *Pointer {
+		return &Pointer{Clean: true, Hash: nd.GetHash(), Node: nd}
+	}
+
+	tt := &proofTestTree{}
+	tt.leafA, tt.leafB, tt.leafAB = newLeaf("a"), newLeaf("b"), newLeaf("ab")
+	tt.inner = newInternal(Key{0x40}, 2, ptrTo(tt.leafA), ptrTo(tt.leafAB), nil)
+	tt.root = newInternal(Key{0x60}, 6, nil, ptrTo(tt.inner), ptrTo(tt.leafB))
+	tt.rootPtr = ptrTo(tt.root)
+	return tt
+}
+
+func TestProofPath(t *testing.T) {
+	require := require.New(t)
+
+	tt := newProofTestTree()
+	root, inner := tt.root, tt.inner
+	leafA, leafB, leafAB := tt.leafA, tt.leafB, tt.leafAB
+	rootPtr := tt.rootPtr
+
+	for _, tc := range []struct {
+		name     string
+		key      Key
+		expected []Node
+	}{
+		{"Leaf", Key("b"), []Node{root, inner, leafB}},
+		{"InternalLeaf", Key("a"), []Node{root, leafB, inner, leafAB, leafA}},
+		{"Nested", Key("ab"), []Node{root, leafB, inner, leafA, leafAB}},
+		{"AbsentLeaf", Key("c"), []Node{root, inner, leafB}},
+		{"AbsentShortKey", Key(""), []Node{root}},
+		{"AbsentEmbeddedLeaf", Key("ac"), []Node{root, leafB, inner, leafA, leafAB}},
+	} {
+		path, err := ProofPath(rootPtr, tc.key, nil)
+		require.NoError(err, "ProofPath(%s)", tc.name)
+		require.Equal(tc.expected, path, "ProofPath(%s)", tc.name)
+	}
+
+	path, err := ProofPath(nil, Key("a"), nil)
+	require.NoError(err, "ProofPath(nil)")
+	require.Empty(path, "ProofPath(nil)")
+
+	// Hash-only pointers are resolved lazily.
+	nodes := map[hash.Hash]Node{
+		root.Hash:   root,
+		inner.Hash:  inner,
+		leafA.Hash:  leafA,
+		leafB.Hash:  leafB,
+		leafAB.Hash: leafAB,
+	}
+	var resolved []hash.Hash
+	resolve := func(ptr *Pointer) (Node, error) {
+		resolved = append(resolved, ptr.Hash)
+		nd, ok := nodes[ptr.Hash]
+		if !ok {
+			return nil, errors.New("node not found")
+		}
+		return nd, nil
+	}
+
+	hashOnlyRoot := &InternalNode{
+		Clean:          true,
+		Label:          root.Label,
+		LabelBitLength: root.LabelBitLength,
+		Left:           &Pointer{Clean: true, Hash: inner.Hash},
+		Right:          &Pointer{Clean: true, Hash: leafB.Hash},
+	}
+	hashOnlyRoot.UpdateHash()
+	nodes[hashOnlyRoot.Hash] = hashOnlyRoot
+	hashOnlyPtr := &Pointer{Clean: true, Hash: hashOnlyRoot.Hash}
+
+	_, err = ProofPath(hashOnlyPtr, Key("b"), nil)
+	require.ErrorIs(err, ErrUnresolvedPointer, "ProofPath without resolver")
+
+	path, err = ProofPath(hashOnlyPtr, Key("b"), resolve)
+	require.NoError(err, "ProofPath with resolver")
+	require.Equal([]Node{hashOnlyRoot, inner, leafB}, path)
+	require.Equal([]hash.Hash{hashOnlyRoot.Hash, inner.Hash, leafB.Hash}, resolved)
+
+	// Resolver errors are propagated.
+	errResolve := errors.New("resolve failed")
+	_, err = ProofPath(&Pointer{Clean: true, Hash: leafA.Hash}, Key("a"), func(*Pointer) (Node, error) {
+		return nil, errResolve
+	})
+	require.ErrorIs(err, errResolve)
+}
+
+func TestVerifyProof(t *testing.T) {
+	require := require.New(t)
+
+	tt := newProofTestTree()
+	rootHash := tt.root.Hash
+
+	// Inclusion.
+	for _, key := range []string{"a", "b", "ab"} {
+		path, err := ProofPath(tt.rootPtr, Key(key), nil)
+		require.NoError(err, "ProofPath(%s)", key)
+
+		ok, err := VerifyProof(rootHash, path, Key(key), []byte("value "+key))
+		require.NoError(err, "VerifyProof(%s)", key)
+		require.True(ok, "VerifyProof(%s) should prove inclusion", key)
+
+		ok, err = VerifyProof(rootHash, path, Key(key), []byte("other value"))
+		require.NoError(err, "VerifyProof(%s)", key)
+		require.False(ok, "VerifyProof(%s) should reject a different value", key)
+
+		ok, err = VerifyProof(rootHash, path, Key(key), nil)
+		require.NoError(err, "VerifyProof(%s)", key)
+		require.False(ok, "VerifyProof(%s) should reject absence", key)
+
+		var otherRoot hash.Hash
+		otherRoot.FromBytes([]byte("other root"))
+		ok, err = VerifyProof(otherRoot, path, Key(key), []byte("value "+key))
+		require.NoError(err, "VerifyProof(%s)", key)
+		require.False(ok, "VerifyProof(%s) should reject a different root", key)
+	}
+
+	// Exclusion, ending at a different leaf, at an internal node and at an empty subtree.
+	for _, key := range []string{"c", "ac", "", "a\xff"} {
+		path, err := ProofPath(tt.rootPtr, Key(key), nil)
+		require.NoError(err, "ProofPath(%q)", key)
+
+		ok, err := VerifyProof(rootHash, path, Key(key), nil)
+		require.NoError(err, "VerifyProof(%q)", key)
+		require.True(ok, "VerifyProof(%q) should prove absence", key)
+
+		ok, err = VerifyProof(rootHash, path, Key(key), []byte("value"))
+		require.NoError(err, "VerifyProof(%q)", key)
+		require.False(ok, "VerifyProof(%q) should reject inclusion", key)
+	}
+
+	// Empty trees.
+	var emptyRoot hash.Hash
+	emptyRoot.Empty()
+	ok, err := VerifyProof(emptyRoot, nil, Key("a"), nil)
+	require.NoError(err, "VerifyProof(empty)")
+	require.True(ok, "VerifyProof(empty) should prove absence")
+
+	// Tampered nodes.
+	path, err := ProofPath(tt.rootPtr, Key("ab"), nil)
+	require.NoError(err, "ProofPath")
+	tampered := make([]Node, len(path))
+	copy(tampered, path)
+	tampered[len(tampered)-1] = &LeafNode{Key: Key("ab"), Value: []byte("forged")}
+	ok, err = VerifyProof(rootHash, tampered, Key("ab"), []byte("forged"))
+	require.NoError(err, "VerifyProof(tampered leaf)")
+	require.False(ok, "VerifyProof should reject a tampered leaf")
+
+	copy(tampered, path)
+	tampered[1] = &LeafNode{Key: Key("b"), Value: []byte("forged")}
+	ok, err = VerifyProof(rootHash, tampered, Key("ab"), []byte("value ab"))
+	require.NoError(err, "VerifyProof(tampered sibling)")
+	require.False(ok, "VerifyProof should reject a tampered sibling")
+
+	// Malformed paths.
+	_, err = VerifyProof(rootHash, path[:len(path)-1], Key("ab"), []byte("value ab"))
+	require.ErrorIs(err, ErrMalformedProof, "VerifyProof(truncated)")
+	_, err = VerifyProof(rootHash, append(path, tt.leafA), Key("ab"), []byte("value ab"))
+	require.ErrorIs(err, ErrMalformedProof, "VerifyProof(trailing)")
+	_, err = VerifyProof(rootHash, []Node{tt.root}, Key("b"), []byte("value b"))
+	require.ErrorIs(err, ErrMalformedProof, "VerifyProof(missing sibling)")
+	_, err = VerifyProof(rootHash, []Node{nil}, Key("b"), []byte("value b"))
+	require.ErrorIs(err, ErrMalformedProof, "VerifyProof(nil node)")
+}
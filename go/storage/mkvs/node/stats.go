@@ -0,0 +1,73 @@
+package node
+
+// SubtreeStats are statistics of an in-memory subtree.
+type SubtreeStats struct {
+	// MaxDepth is the number of nodes on the longest path from the root to a resolved node,
+	// counting an internal node's leaf as being one level below the internal node.
+	MaxDepth int
+	// InternalNodes is the number of resolved internal nodes.
+	InternalNodes int
+	// LeafNodes is the number of resolved leaf nodes.
+	LeafNodes int
+	// Unresolved is the number of hash-only pointers whose nodes are not loaded.
+	Unresolved int
+	// Size is the total in-memory size of the subtree in bytes, as reported by DeepSize.
+	Size uint64
+}
+
+// Stats computes statistics of the subtree rooted at the given pointer in a single traversal.
+//
+// Hash-only pointers are counted as unresolved and are not descended into.
+func Stats(ptr *Pointer) SubtreeStats {
+	var stats SubtreeStats
+	stats.update(ptr, 1)
+	return stats
+}
+
+func (s *SubtreeStats) update(ptr *Pointer, depth int) {
+	if ptr == nil {
+		return
+	}
+	s.Size += PointerSize
+
+	switch n := ptr.Node.(type) {
+	case nil:
+		if !IsEmptyTreeHash(ptr.Hash) {
+			s.Unresolved++
+		}
+		return
+	case *InternalNode:
+		s.InternalNodes++
+		s.Size += InternalNodeSize + uint64(len(n.Label))
+		s.update(n.LeafNode, depth+1)
+		s.update(n.Left, depth+1)
+		s.update(n.Right, depth+1)
+	case *LeafNode:
+		s.LeafNodes++
+		s.Size += n.Size()
+	}
+
+	s.MaxDepth = max(s.MaxDepth, depth)
+}
+
+// DeepSize returns the in-memory size in bytes of the subtree rooted at the given pointer.
+//
+// Only resolved nodes are accounted for. Each pointer counts as PointerSize, each resolved
+// internal node as InternalNodeSize plus the length of its label and each resolved leaf node as
+// LeafNodeSize plus the lengths of its key and value. Hash-only pointers are not descended into
+// and thus count as PointerSize. A nil pointer has a size of zero.
+func DeepSize(ptr *Pointer) uint64 {
+	if ptr == nil {
+		return 0
+	}
+
+	size := PointerSize
+	switch n := ptr.Node.(type) {
+	case *InternalNode:
+		size += InternalNodeSize + uint64(len(n.Label))
+		size += DeepSize(n.LeafNode) + DeepSize(n.Left) + DeepSize(n.Right)
+	case *LeafNode:
+		size += LeafNodeSize + uint64(len(n.Key)) + uint64(len(n.Value))
+	}
+	return size
+}
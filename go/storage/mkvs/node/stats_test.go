@@ -0,0 +1,95 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+)
+
+func TestStats(t *testing.T) {
+	require := require.New(t)
+
+	newLeaf := func(key string) *Pointer {
+		leafNode := &LeafNode{
+			Clean: true,
+			Key:   []byte(key),
+			Value: []byte("value " + key),
+		}
+		leafNode.UpdateHash()
+		return &Pointer{Clean: true, Hash: leafNode.Hash, Node: leafNode}
+	}
+	newInternal := func(leaf, left, right *Pointer) *Pointer {
+		intNode := &InternalNode{
+			Clean:          true,
+			Label:          Key("a"),
+			LabelBitLength: 8,
+			LeafNode:       leaf,
+			Left:           left,
+			Right:          right,
+		}
+		intNode.UpdateHash()
+		return &Pointer{Clean: true, Hash: intNode.Hash, Node: intNode}
+	}
+
+	require.Equal(SubtreeStats{}, Stats(nil), "empty subtree")
+
+	leaf := newLeaf("a")
+	require.Equal(SubtreeStats{MaxDepth: 1, LeafNodes: 1, Size: leaf.Size()}, Stats(leaf), "single leaf")
+
+	root := newInternal(
+		newLeaf("a"),
+		newInternal(nil, newLeaf("aa"), newLeaf("ab")),
+		newLeaf("b"),
+	)
+	require.Equal(SubtreeStats{
+		MaxDepth:      3,
+		InternalNodes: 2,
+		LeafNodes:     4,
+		Size:          root.Size(),
+	}, Stats(root), "fully resolved subtree")
+
+	// Hash-only pointers are not descended into.
+	partial := newInternal(newLeaf("a"), root.Node.(*InternalNode).Left.Extract(), newLeaf("b"))
+	require.Equal(SubtreeStats{
+		MaxDepth:      2,
+		InternalNodes: 1,
+		LeafNodes:     2,
+		Unresolved:    1,
+		Size:          partial.Size(),
+	}, Stats(partial), "partially resolved subtree")
+
+	require.Equal(SubtreeStats{Unresolved: 1, Size: PointerSize}, Stats(root.Extract()), "hash-only root")
+}
+
+func TestDeepSize(t *testing.T) {
+	require := require.New(t)
+
+	require.EqualValues(0, DeepSize(nil), "nil pointer")
+
+	leafA := &LeafNode{Clean: true, Key: []byte("a"), Value: []byte("value a")}
+	leafA.UpdateHash()
+	leafB := &LeafNode{Clean: true, Key: []byte("b"), Value: []byte("value b")}
+	leafB.UpdateHash()
+	unresolved := &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("unresolved"))}
+
+	root := &Pointer{
+		Clean: true,
+		Node: &InternalNode{
+			Clean:          true,
+			Label:          Key("a"),
+			LabelBitLength: 8,
+			LeafNode:       &Pointer{Clean: true, Hash: leafA.Hash, Node: leafA},
+			Left:           &Pointer{Clean: true, Hash: leafB.Hash, Node: leafB},
+			Right:          unresolved,
+		},
+	}
+
+	// Four pointers, one internal node with a 1-byte label and two leaf nodes with 1-byte keys
+	// and 7-byte values.
+	expected := 4*PointerSize + (InternalNodeSize + 1) + 2*(LeafNodeSize+1+7)
+	require.Equal(expected, DeepSize(root), "partially resolved subtree")
+	require.Equal(root.Size(), DeepSize(root), "DeepSize should match Pointer.Size")
+	require.Equal(PointerSize, DeepSize(unresolved), "hash-only pointer")
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidNode is the error returned when a node violates a structural invariant.
+var ErrInvalidNode = errors.New("mkvs: invalid node")
+
+// Validate checks that the given node satisfies the following invariants, which are stricter
+// than what is enforced when decoding a node:
+//
+//   - Leaf node keys must be non-empty.
+//   - The length of an internal node's label in bytes must match the number of bytes needed to
+//     fit its label bit length.
+//   - Internal nodes with a non-empty label (i.e. any internal node except the root) must have at
+//     least one non-nil left or right child.
+//   - In case an internal node's leaf node is loaded, it must be a leaf node.
+//   - The cached node hash must match the hash recomputed from the node's contents (and, for
+//     internal nodes, the cached hashes of its leaf node and children).
+//
+// Child nodes are not validated.
+func Validate(n Node) error {
+	switch n := n.(type) {
+	case *LeafNode:
+		if n == nil {
+			return fmt.Errorf("%w: nil leaf node", ErrInvalidNode)
+		}
+		if len(n.Key) == 0 {
+			return fmt.Errorf("%w: empty leaf node key", ErrInvalidNode)
+		}
+		if h := n.HashWith(CurrentHasher()); !h.Equal(&n.Hash) {
+			return fmt.Errorf("%w: leaf node hash mismatch (expected: %s got: %s)", ErrInvalidNode, h, n.Hash)
+		}
+	case *InternalNode:
+		if n == nil {
+			return fmt.Errorf("%w: nil internal node", ErrInvalidNode)
+		}
+		if len(n.Label) != n.LabelBitLength.ToBytes() {
+			return fmt.Errorf("%w: label length %d inconsistent with label bit length %d",
+				ErrInvalidNode, len(n.Label), n.LabelBitLength,
+			)
+		}
+		if n.LabelBitLength != 0 && n.Left == nil && n.Right == nil {
+			return fmt.Errorf("%w: non-root internal node without children", ErrInvalidNode)
+		}
+		if n.LeafNode != nil && n.LeafNode.Node != nil {
+			if _, ok := n.LeafNode.Node.(*LeafNode); !ok {
+				return fmt.Errorf("%w: internal node leaf is not a leaf node", ErrInvalidNode)
+			}
+		}
+		h := n.HashWith(CurrentHasher(), n.LeafNode.GetHash(), n.Left.GetHash(), n.Right.GetHash())
+		if !h.Equal(&n.Hash) {
+			return fmt.Errorf("%w: internal node hash mismatch (expected: %s got: %s)", ErrInvalidNode, h, n.Hash)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported node type %T", ErrInvalidNode, n)
+	}
+	return nil
+}
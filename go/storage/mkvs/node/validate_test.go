@@ -0,0 +1,78 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+)
+
+func TestValidate(t *testing.T) {
+	require := require.New(t)
+
+	newLeaf := func(key string) *LeafNode {
+		leafNode := &LeafNode{Clean: true, Key: []byte(key), Value: []byte("value")}
+		leafNode.UpdateHash()
+		return leafNode
+	}
+	newInternal := func(label Key, labelBitLength Depth, leaf *LeafNode, left, right *Pointer) *InternalNode {
+		intNode := &InternalNode{
+			Clean:          true,
+			Label:          label,
+			LabelBitLength: labelBitLength,
+			Left:           left,
+			Right:          right,
+		}
+		if leaf != nil {
+			intNode.LeafNode = &Pointer{Clean: true, Hash: leaf.Hash, Node: leaf}
+		}
+		intNode.UpdateHash()
+		return intNode
+	}
+	childPtr := &Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("child"))}
+
+	leafNode := newLeaf("key")
+	require.NoError(Validate(leafNode), "valid leaf node")
+
+	intNode := newInternal(Key("ab"), 12, leafNode, childPtr, nil)
+	require.NoError(Validate(intNode), "valid internal node")
+
+	rootNode := newInternal(nil, 0, leafNode, nil, nil)
+	require.NoError(Validate(rootNode), "root node without children")
+
+	// Decoded nodes should remain valid.
+	raw, err := intNode.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	decoded, err := UnmarshalBinary(raw)
+	require.NoError(err, "UnmarshalBinary")
+	require.NoError(Validate(decoded), "decoded internal node")
+
+	// Invalid nodes.
+	emptyKeyLeaf := newLeaf("")
+	tamperedLeaf := newLeaf("key")
+	tamperedLeaf.Value = []byte("tampered")
+	nonLeafLeaf := newInternal(Key("ab"), 12, nil, childPtr, nil)
+	nonLeafLeaf.LeafNode = &Pointer{Clean: true, Hash: intNode.Hash, Node: intNode}
+	nonLeafLeaf.UpdateHash()
+	tamperedInternal := newInternal(Key("ab"), 12, leafNode, childPtr, nil)
+	tamperedInternal.Right = childPtr
+
+	for _, tc := range []struct {
+		name string
+		n    Node
+	}{
+		{"nil node", nil},
+		{"nil leaf node", (*LeafNode)(nil)},
+		{"nil internal node", (*InternalNode)(nil)},
+		{"empty leaf key", emptyKeyLeaf},
+		{"leaf hash mismatch", tamperedLeaf},
+		{"label too short", newInternal(Key("a"), 12, leafNode, childPtr, nil)},
+		{"label too long", newInternal(Key("abc"), 12, leafNode, childPtr, nil)},
+		{"non-root internal node without children", newInternal(Key("ab"), 12, leafNode, nil, nil)},
+		{"internal node leaf is not a leaf", nonLeafLeaf},
+		{"internal hash mismatch", tamperedInternal},
+	} {
+		require.ErrorIs(Validate(tc.n), ErrInvalidNode, tc.name)
+	}
+}
@@ -131,16 +131,17 @@ func (t *tree) doRemove(
 			// If child is an internal node, also fix the label.
 			switch inode := ndChild.(type) {
 			case *node.InternalNode:
-				inode.Label = n.Label.Merge(n.LabelBitLength, inode.Label, inode.LabelBitLength)
-				inode.LabelBitLength += n.LabelBitLength
 				if inode.Clean {
 					// Node was clean so old node is eligible for removal.
 					t.pendingRemovedNodes = append(t.pendingRemovedNodes, nodePtr.ExtractUnchecked())
 				}
+				// No longer eligible for eviction as it is dirty. This must be done before the
+				// node is modified so that the cache accounts for its previous size.
+				t.cache.rollbackNode(nodePtr)
+				inode.Label = n.Label.Merge(n.LabelBitLength, inode.Label, inode.LabelBitLength)
+				inode.LabelBitLength += n.LabelBitLength
 				inode.Clean = false
 				nodePtr.SetDirty()
-				// No longer eligible for eviction as it is dirty.
-				t.cache.rollbackNode(nodePtr)
 			}
 
 			t.pendingRemovedNodes = append(t.pendingRemovedNodes, ptr)
@@ -0,0 +1,203 @@
+package mkvs
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
+)
+
+// SharedCache bounds the combined size of the in-memory caches of all trees that use it.
+//
+// Whenever caching a node would cause the combined size of the cached clean nodes to exceed the
+// shared capacity, the least recently used clean nodes are evicted, starting with the trees that
+// have least recently cached a node. Dirty nodes are never evicted, so the combined size may
+// temporarily exceed the capacity while trees hold uncommitted changes.
+//
+// The per-tree capacity configured via Capacity is enforced independently.
+type SharedCache struct {
+	mu sync.Mutex
+
+	// caches are the caches of the trees using the shared cache, ordered from the most to the
+	// least recently used.
+	caches *list.List
+
+	maxBytes  uint64
+	bytes     atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// CacheStats are the statistics of a shared cache.
+type CacheStats struct {
+	// Bytes is the combined size in bytes of the cached clean nodes.
+	Bytes uint64
+	// Evictions is the number of nodes evicted in order to stay within the shared capacity.
+	Evictions uint64
+}
+
+// NewSharedCache creates a new shared cache holding up to maxBytes worth of clean nodes across
+// all trees using it. A capacity of 0 means unlimited, in which case the shared cache is only
+// used to collect statistics.
+//
+// A typical capacity is the MaxCacheSize of the node database backing the trees.
+func NewSharedCache(maxBytes uint64) *SharedCache {
+	return &SharedCache{
+		caches:   list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+// CacheStats returns the current shared cache statistics.
+func (sc *SharedCache) CacheStats() CacheStats {
+	return CacheStats{
+		Bytes:     sc.bytes.Load(),
+		Evictions: sc.evictions.Load(),
+	}
+}
+
+// WithSharedCache makes the in-memory cache of the tree count towards the capacity of the given
+// shared cache. The tree must be closed to release its share of the capacity.
+func WithSharedCache(sc *SharedCache) Option {
+	return func(t *tree) {
+		if t.cache.shared != nil {
+			panic("mkvs: tree already uses a shared cache")
+		}
+		t.cache.shared = sc
+		t.cache.sharedElem = sc.register(t.cache)
+	}
+}
+
+func (sc *SharedCache) register(c *cache) *list.Element {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.caches.PushFront(c)
+}
+
+func (sc *SharedCache) unregister(elem *list.Element) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.caches.Remove(elem)
+}
+
+func (sc *SharedCache) hasRoom(size uint64) bool {
+	return sc.maxBytes == 0 || sc.bytes.Load()+size <= sc.maxBytes
+}
+
+func (sc *SharedCache) charge(elem *list.Element, size uint64) {
+	sc.bytes.Add(size)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.caches.MoveToFront(elem)
+}
+
+func (sc *SharedCache) release(size uint64) {
+	sc.bytes.Add(^(size - 1))
+}
+
+// makeRoom evicts clean nodes from the caches using the shared cache until a node of the given
+// size fits within the shared capacity or no more nodes can be evicted.
+//
+// The owner cache must be locked by the caller and the locked pointer is never evicted from it.
+// Other caches are skipped in case they are currently locked.
+func (sc *SharedCache) makeRoom(owner *cache, size uint64, lockedPtr *node.Pointer) {
+	if sc.hasRoom(size) {
+		return
+	}
+
+	sc.mu.Lock()
+	caches := make([]*cache, 0, sc.caches.Len())
+	for elem := sc.caches.Back(); elem != nil; elem = elem.Prev() {
+		caches = append(caches, elem.Value.(*cache))
+	}
+	sc.mu.Unlock()
+
+	for _, c := range caches {
+		var done bool
+		switch c {
+		case owner:
+			done = c.evictShared(size, lockedPtr)
+		default:
+			if !c.TryLock() {
+				continue
+			}
+			if !c.isClosed() {
+				done = c.evictShared(size, nil)
+			}
+			c.Unlock()
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// sharedCacheSize returns the size of the given node as accounted by the shared cache.
+//
+// As the size of an internal node includes the size of its children, only the node itself is
+// counted so that each cached node is accounted for exactly once.
+func sharedCacheSize(n node.Node) uint64 {
+	switch n := n.(type) {
+	case *node.InternalNode:
+		return node.InternalNodeSize + uint64(len(n.Label))
+	case *node.LeafNode:
+		return n.Size()
+	default:
+		return 0
+	}
+}
+
+// evictShared evicts the least recently used clean nodes from the cache until a node of the
+// given size fits within the capacity of the shared cache. It returns false in case the cache
+// has no more nodes that can be evicted.
+func (c *cache) evictShared(size uint64, lockedPtr *node.Pointer) bool {
+	for !c.shared.hasRoom(size) {
+		var elem *list.Element
+		switch {
+		case c.lruLeaf.Len() > 0:
+			elem = c.lruLeaf.Back()
+		case c.lruInternal.Len() > 0:
+			elem = c.lruInternal.Back()
+		default:
+			return false
+		}
+
+		n := elem.Value.(*node.Pointer)
+		if !n.Clean {
+			panic(fmt.Errorf("mkvs: tried to evict dirty node %v", n))
+		}
+
+		cached := c.lruLeaf.Len() + c.lruInternal.Len()
+		err := c.tryRemoveNode(n, lockedPtr)
+		c.shared.evictions.Add(uint64(cached - c.lruLeaf.Len() - c.lruInternal.Len()))
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// chargeShared accounts for a node being committed to the cache.
+func (c *cache) chargeShared(n node.Node) {
+	if c.shared == nil {
+		return
+	}
+	size := sharedCacheSize(n)
+	c.sharedBytes += size
+	c.shared.charge(c.sharedElem, size)
+}
+
+// releaseShared accounts for a node being removed from the cache.
+func (c *cache) releaseShared(n node.Node) {
+	if c.shared == nil {
+		return
+	}
+	size := sharedCacheSize(n)
+	c.sharedBytes -= size
+	c.shared.release(size)
+}
@@ -119,18 +119,11 @@ func (b *ProofBuilder) Include(n node.Node) {
 	}
 
 	// Node is available, serialize it.
+	// In version 0, the leaf is included in the internal node, while in version 1 the leaf
+	// node is added separately, as a child.
 	var err error
 	var pn proofNode
-	switch b.proofVersion {
-	case 0:
-		// In version 0, the leaf is included in the internal node.
-		pn.serialized, err = n.CompactMarshalBinaryV0()
-	case 1:
-		// In version 1, the leaf node is added separately, as a child.
-		pn.serialized, err = n.CompactMarshalBinaryV1()
-	default:
-		panic("proof: unexpected proof version")
-	}
+	pn.serialized, err = n.CompactMarshalBinary(b.proofVersion)
 	if err != nil {
 		panic(err)
 	}
@@ -160,7 +153,7 @@ func (b *ProofBuilder) Include(n node.Node) {
 		for _, child := range children {
 			var childHash hash.Hash
 			if child == nil {
-				childHash.Empty()
+				childHash = node.EmptyTreeHash()
 			} else {
 				childHash = child.Hash
 			}
@@ -221,7 +214,7 @@ func (b *ProofBuilder) build(ctx context.Context, proof *Proof, h hash.Hash) err
 		return ctx.Err()
 	}
 
-	if h.IsEmpty() {
+	if node.IsEmptyTreeHash(h) {
 		// Append nil for empty nodes.
 		proof.Entries = append(proof.Entries, nil)
 		return nil
@@ -323,7 +316,7 @@ func (pv *ProofVerifier) verifyProofOpts(ctx context.Context, root hash.Hash, pr
 		return nil, fmt.Errorf("verifier: unused entries in proof")
 	}
 	rootNodeHash := rootPtr.GetHash()
-	if rootNodeHash.IsEmpty() {
+	if node.IsEmptyTreeHash(rootNodeHash) {
 		// Make sure that in case the root node is empty we always return nil
 		// and not a pointer that represents nil.
 		rootPtr = nil
@@ -360,7 +353,7 @@ func (pv *ProofVerifier) verifyProof(ctx context.Context, proof *Proof, idx int,
 	switch entry[0] {
 	case proofEntryFull:
 		// Full node.
-		n, err := node.UnmarshalBinary(entry[1:])
+		n, err := node.UnmarshalCompact(proof.V, entry[1:])
 		if err != nil {
 			return -1, nil, err
 		}
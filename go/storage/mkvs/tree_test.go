@@ -2,12 +2,17 @@ package mkvs
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -914,6 +919,57 @@ func testDoubleInsertWithEviction(t *testing.T, ndb db.NodeDB, _ NodeDBFactory)
 	require.NoError(t, err, "Commit")
 }
 
+func testSharedCache(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	const maxBytes = 16 * 1024
+	sc := NewSharedCache(maxBytes)
+
+	sharedBytes := func(tree *tree) uint64 {
+		var size uint64
+		for _, lru := range []*list.List{tree.cache.lruInternal, tree.cache.lruLeaf} {
+			for elem := lru.Front(); elem != nil; elem = elem.Next() {
+				size += sharedCacheSize(elem.Value.(*node.Pointer).Node)
+			}
+		}
+		return size
+	}
+
+	tree1 := New(nil, ndb, node.RootTypeState, Capacity(0, 0), WithSharedCache(sc)).(*tree)
+	defer tree1.Close()
+
+	keys, values := generateKeyValuePairs()
+	for i := 0; i < len(keys); i++ {
+		err := tree1.Insert(ctx, keys[i], values[i])
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree1.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+
+	stats := sc.CacheStats()
+	require.LessOrEqual(t, stats.Bytes, uint64(maxBytes), "shared capacity should be enforced")
+	require.NotZero(t, stats.Evictions, "nodes should be evicted")
+	require.EqualValues(t, sharedBytes(tree1), stats.Bytes, "cached nodes should be accounted for")
+
+	// A second tree should evict nodes of the first tree.
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	tree2 := NewWithRoot(nil, ndb, root, Capacity(0, 0), WithSharedCache(sc)).(*tree)
+	for i := 0; i < len(keys); i++ {
+		value, err := tree2.Get(ctx, keys[i])
+		require.NoError(t, err, "Get")
+		require.Equal(t, values[i], value, "Get should return the correct value")
+	}
+
+	prevEvictions := stats.Evictions
+	stats = sc.CacheStats()
+	require.LessOrEqual(t, stats.Bytes, uint64(maxBytes), "shared capacity should be enforced")
+	require.Greater(t, stats.Evictions, prevEvictions, "nodes should be evicted")
+	require.EqualValues(t, sharedBytes(tree1)+sharedBytes(tree2), stats.Bytes, "cached nodes should be accounted for")
+
+	// Closing a tree should release its share.
+	tree2.Close()
+	require.EqualValues(t, sharedBytes(tree1), sc.CacheStats().Bytes, "closed tree should release its share")
+}
+
 func testDebugDumpLocal(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
 	ctx := context.Background()
 	tree := New(nil, ndb, node.RootTypeState)
@@ -1414,92 +1470,1510 @@ func testPruneBasic(t *testing.T, ndb db.NodeDB, factory NodeDBFactory) {
 	require.EqualValues(t, 2, latestVersion, "latest version should be correct")
 	require.True(t, exists, "latest version should exist")
 
-	// Prune version 0.
-	err = ndb.Prune(0)
-	require.NoError(t, err, "Prune")
+	// Prune version 0.
+	err = ndb.Prune(0)
+	require.NoError(t, err, "Prune")
+
+	// Reopen database to force compaction.
+	ndb.Close()
+	ndb, err = factory(testNs)
+	require.NoError(t, err, "ndb.New")
+	defer ndb.Close()
+
+	earliestVersion = ndb.GetEarliestVersion()
+	require.EqualValues(t, 1, earliestVersion, "earliest version should be correct")
+	latestVersion, exists = ndb.GetLatestVersion()
+	require.EqualValues(t, 2, latestVersion, "latest version should be correct")
+	require.True(t, exists, "latest version should exist")
+
+	// Keys must still be available in version 2.
+	tree = NewWithRoot(nil, ndb, node.Root{Namespace: testNs, Version: 2, Type: node.RootTypeState, Hash: rootHash3})
+	value, err := tree.Get(ctx, []byte("blah"))
+	require.NoError(t, err, "Get")
+	require.EqualValues(t, []byte("ugh"), value)
+	value, err = tree.Get(ctx, []byte("moo"))
+	require.NoError(t, err, "Get")
+	require.EqualValues(t, []byte("bar"), value)
+	value, err = tree.Get(ctx, []byte("another"))
+	require.NoError(t, err, "Get")
+	require.EqualValues(t, []byte("value"), value)
+	// Removed key must be gone.
+	value, err = tree.Get(ctx, []byte("foo"))
+	require.NoError(t, err, "Get")
+	require.Nil(t, value, "removed key must be gone")
+
+	// Version 0 must be gone.
+	tree = NewWithRoot(nil, ndb, node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash1})
+	_, err = tree.Get(ctx, []byte("foo"))
+	require.Error(t, err, "Get")
+}
+
+func testPruneManyVersions(t *testing.T, ndb db.NodeDB, factory NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState)
+
+	const numVersions = 50
+	const numPairsPerVersion = 50
+
+	for r := 0; r < numVersions; r++ {
+		for p := 0; p < numPairsPerVersion; p++ {
+			key := []byte(fmt.Sprintf("key %d/%d", r, p))
+			value := []byte(fmt.Sprintf("value %d/%d", r, p))
+			err := tree.Insert(ctx, key, value)
+			require.NoError(t, err, "Insert")
+		}
+
+		_, rootHash, err := tree.Commit(ctx, testNs, uint64(r))
+		require.NoError(t, err, "Commit")
+		root := node.Root{
+			Namespace: testNs,
+			Version:   uint64(r),
+			Type:      node.RootTypeState,
+			Hash:      rootHash,
+		}
+		err = ndb.Finalize([]node.Root{root})
+		require.NoError(t, err, "Finalize")
+	}
+
+	// Prune all versions except the last one.
+	for r := 0; r < numVersions-1; r++ {
+		err := ndb.Prune(uint64(r))
+		require.NoError(t, err, "Prune")
+	}
+
+	// Reopen database to force compaction.
+	ndb.Close()
+	ndb, err := factory(testNs)
+	require.NoError(t, err, "ndb.New")
+	defer ndb.Close()
+
+	// Check that the latest version has all the keys.
+	for r := 0; r < numVersions; r++ {
+		for p := 0; p < numPairsPerVersion; p++ {
+			key := []byte(fmt.Sprintf("key %d/%d", r, p))
+			value, err := tree.Get(ctx, key)
+			require.NoError(t, err, "Get")
+			require.EqualValues(t, value, fmt.Sprintf("value %d/%d", r, p))
+		}
+	}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(error) {}
+
+type nopTracer struct{}
+
+func (nopTracer) StartSpan(ctx context.Context, _ string, _ ...db.SpanAttribute) (context.Context, db.Span) {
+	return ctx, nopSpan{}
+}
+
+func testCommitFinalizeAndPrune(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState)
+
+	const numVersions = 20
+	const window = 3
+
+	var (
+		roots    []node.Root
+		inserted []uint64
+	)
+	for r := uint64(0); r < numVersions; r++ {
+		var (
+			root  node.Root
+			batch db.Batch
+			err   error
+		)
+		switch {
+		case r%3 == 2:
+			// Carry the previous root over unchanged using a batch that is committed together
+			// with the finalization and pruning. Traced batches must be accepted as well.
+			root = roots[r-1]
+			root.Version = r
+			batch, err = db.NewBatchContext(db.WithTracer(ctx, nopTracer{}), ndb, roots[r-1], r, false)
+			require.NoError(t, err, "NewBatchContext")
+		default:
+			err = tree.Insert(ctx, []byte(fmt.Sprintf("key %d", r)), []byte(fmt.Sprintf("value %d", r)))
+			require.NoError(t, err, "Insert")
+			var rootHash hash.Hash
+			_, rootHash, err = tree.Commit(ctx, testNs, r)
+			require.NoError(t, err, "Commit")
+			root = node.Root{
+				Namespace: testNs,
+				Version:   r,
+				Type:      node.RootTypeState,
+				Hash:      rootHash,
+			}
+			inserted = append(inserted, r)
+		}
+		roots = append(roots, root)
+
+		if r < window {
+			if batch != nil {
+				err = batch.Commit(root)
+				require.NoError(t, err, "Commit")
+			}
+			err = ndb.Finalize([]node.Root{root})
+			require.NoError(t, err, "Finalize")
+			tree = NewWithRoot(nil, ndb, root)
+			continue
+		}
+
+		// Pruning anything but the earliest version must be refused without changing anything.
+		err = ndb.CommitFinalizeAndPrune(batch, []node.Root{root}, r-window+1)
+		require.ErrorIs(t, err, db.ErrNotEarliest, "CommitFinalizeAndPrune")
+		err = ndb.CommitFinalizeAndPrune(batch, []node.Root{root}, r)
+		require.Error(t, err, "CommitFinalizeAndPrune")
+		latest, _ := ndb.GetLatestVersion()
+		require.EqualValues(t, r-1, latest, "refused CommitFinalizeAndPrune must not finalize")
+		if batch != nil {
+			require.False(t, ndb.HasRoot(root), "refused CommitFinalizeAndPrune must not commit")
+		}
+
+		// Finalizing unknown roots must fail without committing or finalizing anything.
+		bogusRoot := node.Root{
+			Namespace: testNs,
+			Version:   r,
+			Type:      node.RootTypeIO,
+			Hash:      hash.NewFromBytes([]byte("bogus root")),
+		}
+		err = ndb.CommitFinalizeAndPrune(batch, []node.Root{root, bogusRoot}, r-window)
+		require.ErrorIs(t, err, db.ErrRootNotFound, "CommitFinalizeAndPrune")
+		latest, _ = ndb.GetLatestVersion()
+		require.EqualValues(t, r-1, latest, "failed CommitFinalizeAndPrune must not finalize")
+		require.EqualValues(t, r-window, ndb.GetEarliestVersion(), "failed CommitFinalizeAndPrune must not prune")
+		if batch != nil {
+			require.False(t, ndb.HasRoot(root), "failed CommitFinalizeAndPrune must not commit")
+
+			// The failed batch may have been partially flushed, so use a fresh one.
+			batch.Discard()
+			batch, err = db.NewBatchContext(db.WithTracer(ctx, nopTracer{}), ndb, roots[r-1], r, false)
+			require.NoError(t, err, "NewBatchContext")
+		}
+
+		err = ndb.CommitFinalizeAndPrune(batch, []node.Root{root}, r-window)
+		require.NoError(t, err, "CommitFinalizeAndPrune")
+
+		// The retention window must be maintained.
+		latest, _ = ndb.GetLatestVersion()
+		require.EqualValues(t, r, latest, "GetLatestVersion")
+		require.EqualValues(t, r-window+1, ndb.GetEarliestVersion(), "GetEarliestVersion")
+		require.False(t, ndb.HasRoot(roots[r-window]), "pruned root should be gone")
+		for _, retained := range roots[r-window+1:] {
+			require.True(t, ndb.HasRoot(retained), "retained root should exist")
+		}
+		tree = NewWithRoot(nil, ndb, root)
+	}
+
+	// Foreign batches must be rejected.
+	nopDb, _ := db.NewNopNodeDB()
+	batch, err := nopDb.NewBatch(roots[numVersions-1], numVersions, false)
+	require.NoError(t, err, "NewBatch")
+	root := roots[numVersions-1]
+	root.Version = numVersions
+	err = ndb.CommitFinalizeAndPrune(batch, []node.Root{root}, numVersions-window)
+	require.Error(t, err, "CommitFinalizeAndPrune should reject foreign batches")
+
+	// All keys should still be available in the latest version.
+	tree = NewWithRoot(nil, ndb, roots[numVersions-1])
+	for _, r := range inserted {
+		value, err := tree.Get(ctx, []byte(fmt.Sprintf("key %d", r)))
+		require.NoError(t, err, "Get")
+		require.EqualValues(t, fmt.Sprintf("value %d", r), value)
+	}
+}
+
+func testVerifyAgainstManifest(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState)
+
+	manifest := make(map[uint64][]node.Root)
+	for r := uint64(0); r < 3; r++ {
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", r)), []byte(fmt.Sprintf("value %d", r)))
+		require.NoError(t, err, "Insert")
+		_, rootHash, err := tree.Commit(ctx, testNs, r)
+		require.NoError(t, err, "Commit")
+		root := node.Root{
+			Namespace: testNs,
+			Version:   r,
+			Type:      node.RootTypeState,
+			Hash:      rootHash,
+		}
+		err = ndb.Finalize([]node.Root{root})
+		require.NoError(t, err, "Finalize")
+
+		manifest[r] = []node.Root{root}
+	}
+
+	// Matching manifest.
+	mismatches, err := ndb.VerifyAgainstManifest(ctx, manifest)
+	require.NoError(t, err, "VerifyAgainstManifest")
+	require.Empty(t, mismatches, "matching manifest should not report any mismatches")
+
+	// Tampered manifest.
+	actual := manifest[1][0]
+	tampered := actual
+	tampered.Hash = hash.NewFromBytes([]byte("tampered"))
+	manifest[1] = []node.Root{tampered}
+
+	mismatches, err = ndb.VerifyAgainstManifest(ctx, manifest)
+	require.NoError(t, err, "VerifyAgainstManifest")
+	require.Len(t, mismatches, 1, "tampered manifest should report a mismatch")
+	require.EqualValues(t, 1, mismatches[0].Version)
+	require.Equal(t, &tampered, mismatches[0].Expected)
+	require.Equal(t, &actual, mismatches[0].Actual)
+
+	// Manifest with a version missing from the database.
+	missing := node.Root{
+		Namespace: testNs,
+		Version:   10,
+		Type:      node.RootTypeState,
+		Hash:      hash.NewFromBytes([]byte("missing")),
+	}
+	mismatches, err = ndb.VerifyAgainstManifest(ctx, map[uint64][]node.Root{10: {missing}})
+	require.NoError(t, err, "VerifyAgainstManifest")
+	require.Len(t, mismatches, 1, "missing root should be reported")
+	require.Equal(t, &missing, mismatches[0].Expected)
+	require.Nil(t, mismatches[0].Actual)
+}
+
+func testNearestVersionAtOrBefore(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState)
+
+	_, ok := ndb.NearestVersionAtOrBefore(10)
+	require.False(t, ok, "NearestVersionAtOrBefore should fail on an empty database")
+
+	for r := uint64(0); r < 4; r++ {
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", r)), []byte(fmt.Sprintf("value %d", r)))
+		require.NoError(t, err, "Insert")
+		_, rootHash, err := tree.Commit(ctx, testNs, r)
+		require.NoError(t, err, "Commit")
+		err = ndb.Finalize([]node.Root{{Namespace: testNs, Version: r, Type: node.RootTypeState, Hash: rootHash}})
+		require.NoError(t, err, "Finalize")
+	}
+	err := ndb.Prune(0)
+	require.NoError(t, err, "Prune")
+
+	_, ok = ndb.NearestVersionAtOrBefore(0)
+	require.False(t, ok, "NearestVersionAtOrBefore should not return pruned versions")
+
+	version, ok := ndb.NearestVersionAtOrBefore(2)
+	require.True(t, ok, "NearestVersionAtOrBefore")
+	require.EqualValues(t, 2, version)
+
+	version, ok = ndb.NearestVersionAtOrBefore(10)
+	require.True(t, ok, "NearestVersionAtOrBefore")
+	require.EqualValues(t, 3, version, "NearestVersionAtOrBefore should return the latest version")
+}
+
+// countingNodeDB is a node database wrapper that counts GetNode calls.
+type countingNodeDB struct {
+	db.NodeDB
+
+	getNodeCalls int
+}
+
+func (c *countingNodeDB) GetNode(root node.Root, ptr *node.Pointer) (node.Node, error) {
+	c.getNodeCalls++
+	return c.NodeDB.GetNode(root, ptr)
+}
+
+func testPrefetchKeys(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState)
+
+	// Prefetching from an empty root is a no-op.
+	emptyRoot := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState}
+	emptyRoot.Hash.Empty()
+	batch, err := ndb.NewBatch(emptyRoot, 0, false)
+	require.NoError(t, err, "NewBatch")
+	err = batch.PrefetchKeys([]node.Key{[]byte("foo")})
+	require.NoError(t, err, "PrefetchKeys")
+	batch.Reset()
+
+	var keys []node.Key
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key %d", i))
+		keys = append(keys, key)
+		err = tree.Insert(ctx, key, []byte(fmt.Sprintf("value %d", i)))
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	err = ndb.Finalize([]node.Root{root})
+	require.NoError(t, err, "Finalize")
+
+	batch, err = ndb.NewBatch(root, 1, false)
+	require.NoError(t, err, "NewBatch")
+	err = batch.PrefetchKeys(append(keys, []byte("missing"), []byte("k"), nil))
+	require.NoError(t, err, "PrefetchKeys")
+	batch.Reset()
+
+	// Prefetching all keys should resolve each node exactly once, same as visiting the tree.
+	visitNdb := &countingNodeDB{NodeDB: ndb}
+	err = db.Visit(ctx, visitNdb, root, func(context.Context, node.Node) bool {
+		return true
+	})
+	require.NoError(t, err, "Visit")
+
+	prefetchNdb := &countingNodeDB{NodeDB: ndb}
+	err = db.PrefetchKeys(ctx, prefetchNdb, root, keys)
+	require.NoError(t, err, "PrefetchKeys")
+	require.Equal(t, visitNdb.getNodeCalls, prefetchNdb.getNodeCalls, "each node should be resolved exactly once")
+}
+
+func testCommitExpecting(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState).(*tree)
+
+	for i := 0; i < 10; i++ {
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %d", i)))
+		require.NoError(t, err, "Insert")
+	}
+
+	emptyRoot := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState}
+	emptyRoot.Hash.Empty()
+	commitBatch := func(expectedRoot node.Root) (hash.Hash, error) {
+		batch, err := ndb.NewBatch(emptyRoot, 0, false)
+		require.NoError(t, err, "NewBatch")
+		defer batch.Reset()
+
+		rootHash, err := doCommit(ctx, tree.cache, batch, tree.cache.pendingRoot, nil)
+		require.NoError(t, err, "doCommit")
+		return rootHash, batch.CommitExpecting(expectedRoot)
+	}
+
+	// Committing with a wrong expected root should fail without persisting anything.
+	wrongRoot := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: hash.NewFromBytes([]byte("wrong"))}
+	rootHash, err := commitBatch(wrongRoot)
+	require.ErrorIs(t, err, db.ErrRootMismatch, "CommitExpecting should fail with a wrong root")
+	require.ErrorContains(t, err, rootHash.String(), "error should include the computed root hash")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	require.False(t, ndb.HasRoot(root), "root should not be persisted")
+	require.False(t, ndb.HasRoot(wrongRoot), "wrong root should not be persisted")
+
+	// Committing with the correct expected root should succeed.
+	_, err = commitBatch(root)
+	require.NoError(t, err, "CommitExpecting")
+	require.True(t, ndb.HasRoot(root), "root should be persisted")
+	err = ndb.Finalize([]node.Root{root})
+	require.NoError(t, err, "Finalize")
+	tree.cache.setSyncRoot(root)
+
+	// An unchanged root should be verified as well.
+	nextRoot := node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}
+	for _, tc := range []struct {
+		expected node.Root
+		fails    bool
+	}{
+		{wrongRoot, true},
+		{nextRoot, false},
+	} {
+		batch, err := ndb.NewBatch(root, 1, false)
+		require.NoError(t, err, "NewBatch")
+		_, err = doCommit(ctx, tree.cache, batch, tree.cache.pendingRoot, nil)
+		require.NoError(t, err, "doCommit")
+		tc.expected.Version = 1
+		err = batch.CommitExpecting(tc.expected)
+		batch.Reset()
+		switch tc.fails {
+		case true:
+			require.ErrorIs(t, err, db.ErrRootMismatch, "CommitExpecting should fail with a wrong root")
+		case false:
+			require.NoError(t, err, "CommitExpecting")
+		}
+	}
+	require.True(t, ndb.HasRoot(nextRoot), "unchanged root should be persisted")
+}
+
+func testCachingNodeDB(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	countingNdb := &countingNodeDB{NodeDB: ndb}
+	cachingNdb := db.NewCachingNodeDB(countingNdb, 16*1024*1024)
+
+	readAll := func(root node.Root, expected map[string]string) {
+		tree := NewWithRoot(nil, cachingNdb, root)
+		defer tree.Close()
+		for key, value := range expected {
+			v, err := tree.Get(ctx, []byte(key))
+			require.NoError(t, err, "Get")
+			require.EqualValues(t, value, v, "Get should return the correct value")
+		}
+	}
+
+	expected := make(map[string]string)
+	tree := New(nil, cachingNdb, node.RootTypeState)
+	for i := 0; i < 100; i++ {
+		key, value := fmt.Sprintf("key %d", i), fmt.Sprintf("value %d", i)
+		expected[key] = value
+		err := tree.Insert(ctx, []byte(key), []byte(value))
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	root0 := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	err = cachingNdb.Finalize([]node.Root{root0})
+	require.NoError(t, err, "Finalize")
+
+	// Committed nodes should be served from the cache.
+	readAll(root0, expected)
+	require.Zero(t, countingNdb.getNodeCalls, "committed nodes should be cached")
+
+	expected["key 1"] = "updated value"
+	err = tree.Insert(ctx, []byte("key 1"), []byte("updated value"))
+	require.NoError(t, err, "Insert")
+	_, rootHash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(t, err, "Commit")
+	root1 := node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}
+	err = cachingNdb.Finalize([]node.Root{root1})
+	require.NoError(t, err, "Finalize")
+	tree.Close()
+
+	readAll(root1, expected)
+	require.Zero(t, countingNdb.getNodeCalls, "committed nodes should be cached")
+
+	// Cached nodes should not be served for unknown roots.
+	unknownRoot := root1
+	unknownRoot.Hash = hash.NewFromBytes([]byte("unknown root"))
+	rootPtr := &node.Pointer{Clean: true, Hash: root1.Hash}
+	_, err = cachingNdb.GetNode(unknownRoot, rootPtr)
+	require.Error(t, err, "GetNode should fail for unknown roots")
+	ok, _ := cachingNdb.HasNode(unknownRoot, rootPtr)
+	require.False(t, ok, "HasNode should fail for unknown roots")
+
+	// Nodes of roots discarded during finalization should be evicted.
+	var roots2 []node.Root
+	for _, key := range []string{"discarded key", "finalized key"} {
+		tree = NewWithRoot(nil, cachingNdb, root1)
+		err = tree.Insert(ctx, []byte(key), []byte("value"))
+		require.NoError(t, err, "Insert")
+		_, rootHash, err = tree.Commit(ctx, testNs, 2)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+		roots2 = append(roots2, node.Root{Namespace: testNs, Version: 2, Type: node.RootTypeState, Hash: rootHash})
+	}
+	err = cachingNdb.Finalize(roots2[1:])
+	require.NoError(t, err, "Finalize")
+	discardedPtr := &node.Pointer{Clean: true, Hash: roots2[0].Hash}
+	_, err = cachingNdb.GetNode(roots2[0], discardedPtr)
+	require.Error(t, err, "GetNode should fail for discarded roots")
+	ok, _ = cachingNdb.HasNode(roots2[1], discardedPtr)
+	require.False(t, ok, "nodes of discarded roots should be evicted")
+	expected["finalized key"] = "value"
+	readAll(roots2[1], expected)
+	delete(expected, "finalized key")
+	countingNdb.getNodeCalls = 0
+
+	// Pruning should only evict nodes which were not accessed under later versions.
+	err = cachingNdb.Prune(0)
+	require.NoError(t, err, "Prune")
+	readAll(root1, expected)
+	require.Zero(t, countingNdb.getNodeCalls, "nodes accessed under later versions should remain cached")
+
+	_, err = cachingNdb.GetNode(root0, &node.Pointer{Clean: true, Hash: root0.Hash})
+	require.Error(t, err, "GetNode should fail for pruned versions")
+
+	// Nodes should be resolved from the inner database when not cached.
+	uncachedNdb := db.NewCachingNodeDB(countingNdb, 1)
+	countingNdb.getNodeCalls = 0
+	tree = NewWithRoot(nil, uncachedNdb, root1)
+	defer tree.Close()
+	v, err := tree.Get(ctx, []byte("key 1"))
+	require.NoError(t, err, "Get")
+	require.EqualValues(t, "updated value", v, "Get should return the correct value")
+	require.NotZero(t, countingNdb.getNodeCalls, "nodes too large for the cache should not be cached")
+}
+
+func testHasNode(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	var roots []node.Root
+	for version := uint64(0); version < 2; version++ {
+		tree := New(nil, ndb, node.RootTypeState)
+		for i := 0; i < 10; i++ {
+			err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d %d", version, i)), []byte(fmt.Sprintf("value %d", i)))
+			require.NoError(t, err, "Insert")
+		}
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+
+		root := node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}
+		err = ndb.Finalize([]node.Root{root})
+		require.NoError(t, err, "Finalize")
+		roots = append(roots, root)
+	}
+
+	rootPtr := &node.Pointer{Clean: true, Hash: roots[0].Hash}
+	ok, err := ndb.HasNode(roots[0], rootPtr)
+	require.NoError(t, err, "HasNode")
+	require.True(t, ok, "HasNode should report the root node as present")
+
+	rootNode, err := ndb.GetNode(roots[0], rootPtr)
+	require.NoError(t, err, "GetNode")
+	childPtr := rootNode.(*node.InternalNode).Left
+	ok, err = ndb.HasNode(roots[0], childPtr)
+	require.NoError(t, err, "HasNode")
+	require.True(t, ok, "HasNode should report child nodes as present")
+
+	// Nodes of pruned versions should be reported as missing.
+	err = ndb.Prune(0)
+	require.NoError(t, err, "Prune")
+	ok, err = ndb.HasNode(roots[0], rootPtr)
+	require.NoError(t, err, "HasNode")
+	require.False(t, ok, "HasNode should report nodes of pruned versions as missing")
+
+	ok, err = ndb.HasNode(roots[1], &node.Pointer{Clean: true, Hash: roots[1].Hash})
+	require.NoError(t, err, "HasNode")
+	require.True(t, ok, "HasNode should report the root node as present")
+}
+
+func testGetPendingVersions(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	versions, err := ndb.GetPendingVersions()
+	require.NoError(t, err, "GetPendingVersions")
+	require.Empty(t, versions, "there should be no pending versions in an empty database")
+
+	var roots []node.Root
+	for _, version := range []uint64{0, 1, 2, 4} {
+		tree := New(nil, ndb, node.RootTypeState)
+		err = tree.Insert(ctx, []byte(fmt.Sprintf("key %d", version)), []byte("value"))
+		require.NoError(t, err, "Insert")
+		var rootHash hash.Hash
+		_, rootHash, err = tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+		roots = append(roots, node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash})
+	}
+
+	versions, err = ndb.GetPendingVersions()
+	require.NoError(t, err, "GetPendingVersions")
+	require.Equal(t, []uint64{0, 1, 2, 4}, versions, "all committed versions should be pending")
+
+	for _, root := range roots[:2] {
+		err = ndb.Finalize([]node.Root{root})
+		require.NoError(t, err, "Finalize")
+	}
+
+	versions, err = ndb.GetPendingVersions()
+	require.NoError(t, err, "GetPendingVersions")
+	require.Equal(t, []uint64{2, 4}, versions, "finalized versions should no longer be pending")
+}
+
+func testPruneDryRun(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	var roots []node.Root
+	for version := uint64(0); version < 2; version++ {
+		tree := New(nil, ndb, node.RootTypeIO)
+		for i := 0; i < 10; i++ {
+			err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d %d", version, i)), []byte(fmt.Sprintf("value %d", i)))
+			require.NoError(t, err, "Insert")
+		}
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+
+		root := node.Root{Namespace: testNs, Version: version, Type: node.RootTypeIO, Hash: rootHash}
+		err = ndb.Finalize([]node.Root{root})
+		require.NoError(t, err, "Finalize")
+		roots = append(roots, root)
+	}
+
+	_, err := ndb.PruneDryRun(1)
+	require.ErrorIs(t, err, db.ErrNotEarliest, "PruneDryRun should fail for a version that is not the earliest")
+
+	estimate, err := ndb.PruneDryRun(0)
+	require.NoError(t, err, "PruneDryRun")
+	require.NotZero(t, estimate.Nodes, "some nodes should be removed")
+	require.NotZero(t, estimate.Size, "some bytes should be reclaimed")
+	require.True(t, ndb.HasRoot(roots[0]), "PruneDryRun should not modify the database")
+	require.EqualValues(t, 0, ndb.GetEarliestVersion(), "PruneDryRun should not modify the database")
+
+	again, err := ndb.PruneDryRun(0)
+	require.NoError(t, err, "PruneDryRun")
+	require.Equal(t, estimate, again, "PruneDryRun should be deterministic")
+
+	err = ndb.Prune(0)
+	require.NoError(t, err, "Prune")
+
+	_, err = ndb.PruneDryRun(1)
+	require.ErrorIs(t, err, db.ErrCannotPruneLatestVersion, "PruneDryRun should fail for the latest version")
+}
+
+func testGetWriteLogForPrefix(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	tree := New(nil, ndb, node.RootTypeState)
+	for _, key := range []string{"acc/a/1", "acc/a/2", "acc/b/1", "other"} {
+		err := tree.Insert(ctx, []byte(key), []byte("value "+key))
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	root0 := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	err = tree.Insert(ctx, []byte("acc/a/1"), []byte("updated"))
+	require.NoError(t, err, "Insert")
+	err = tree.Insert(ctx, []byte("acc/ab/1"), []byte("new"))
+	require.NoError(t, err, "Insert")
+	err = tree.Remove(ctx, []byte("acc/b/1"))
+	require.NoError(t, err, "Remove")
+	err = tree.Insert(ctx, []byte("other2"), []byte("new"))
+	require.NoError(t, err, "Insert")
+	_, rootHash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(t, err, "Commit")
+	tree.Close()
+	root1 := node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}
+
+	wli, err := ndb.GetWriteLog(ctx, root0, root1)
+	require.NoError(t, err, "GetWriteLog")
+	fullWriteLog := writeLogToMap(foldWriteLogIterator(t, wli))
+
+	for _, tc := range []struct {
+		prefix   string
+		expected map[string]string
+	}{
+		{"", fullWriteLog},
+		{"acc/", map[string]string{"acc/a/1": "updated", "acc/ab/1": "new", "acc/b/1": ""}},
+		{"acc/a", map[string]string{"acc/a/1": "updated", "acc/ab/1": "new"}},
+		{"acc/a/", map[string]string{"acc/a/1": "updated"}},
+		{"acc/b", map[string]string{"acc/b/1": ""}},
+		{"other", map[string]string{"other2": "new"}},
+		{"missing", map[string]string{}},
+	} {
+		wli, err = ndb.GetWriteLogForPrefix(ctx, root0, root1, node.Key(tc.prefix))
+		require.NoError(t, err, "GetWriteLogForPrefix(%s)", tc.prefix)
+		require.Equal(t, tc.expected, writeLogToMap(foldWriteLogIterator(t, wli)), "write log for prefix %s", tc.prefix)
+	}
+
+	_, err = ndb.GetWriteLogForPrefix(ctx, root1, root0, node.Key("acc/"))
+	require.ErrorIs(t, err, db.ErrRootMustFollowOld, "GetWriteLogForPrefix should fail for non-following roots")
+}
+
+func testGetWriteLogAnnotations(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	tree := New(nil, ndb, node.RootTypeState)
+	for _, key := range []string{"a", "b", "c"} {
+		err := tree.Insert(ctx, []byte(key), []byte("value "+key))
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	root0 := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	err = tree.Insert(ctx, []byte("a"), []byte("updated"))
+	require.NoError(t, err, "Insert")
+	err = tree.Insert(ctx, []byte("d"), []byte("new"))
+	require.NoError(t, err, "Insert")
+	err = tree.Remove(ctx, []byte("b"))
+	require.NoError(t, err, "Remove")
+	_, rootHash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(t, err, "Commit")
+	tree.Close()
+	root1 := node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}
+
+	wli, err := ndb.GetWriteLog(ctx, root0, root1)
+	require.NoError(t, err, "GetWriteLog")
+	writeLog := foldWriteLogIterator(t, wli)
+	require.Len(t, writeLog, 3, "write log should contain all changes")
+
+	annotations, err := ndb.GetWriteLogAnnotations(ctx, root0, root1)
+	require.NoError(t, err, "GetWriteLogAnnotations")
+	require.Len(t, annotations, len(writeLog), "annotations should correspond to write log entries")
+	for i, entry := range writeLog {
+		if entry.Value == nil {
+			require.Nil(t, annotations[i].InsertedNode, "removed entry %s should not have an inserted node", entry.Key)
+			continue
+		}
+
+		ptr := annotations[i].InsertedNode
+		require.NotNil(t, ptr, "inserted entry %s should have an inserted node", entry.Key)
+		n, err := ndb.GetNode(root1, ptr)
+		require.NoError(t, err, "GetNode(%s)", entry.Key)
+		leaf, ok := n.(*node.LeafNode)
+		require.True(t, ok, "inserted node should be a leaf node")
+		require.EqualValues(t, entry.Key, leaf.Key, "inserted node key")
+		require.EqualValues(t, entry.Value, leaf.Value, "inserted node value")
+	}
+
+	_, err = ndb.GetWriteLogAnnotations(ctx, root1, root0)
+	require.ErrorIs(t, err, db.ErrRootMustFollowOld, "GetWriteLogAnnotations should fail for non-following roots")
+
+	otherRoot := root1
+	otherRoot.Hash.FromBytes([]byte("unknown root"))
+	_, err = ndb.GetWriteLogAnnotations(ctx, root0, otherRoot)
+	require.Error(t, err, "GetWriteLogAnnotations should fail for unknown roots")
+}
+
+func testCheckComplete(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	tree := New(nil, ndb, node.RootTypeState)
+	for i := 0; i < 50; i++ {
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %d", i)))
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	tree.Close()
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	missing, err := ndb.CheckComplete(ctx, root)
+	require.NoError(t, err, "CheckComplete")
+	require.Empty(t, missing, "committed root should be complete")
+
+	var emptyRoot node.Root
+	emptyRoot.Namespace = testNs
+	emptyRoot.Type = node.RootTypeState
+	emptyRoot.Hash.Empty()
+	missing, err = ndb.CheckComplete(ctx, emptyRoot)
+	require.NoError(t, err, "CheckComplete")
+	require.Empty(t, missing, "empty root should be complete")
+
+	unknownRoot := root
+	unknownRoot.Hash = hash.NewFromBytes([]byte("unknown root"))
+	missing, err = ndb.CheckComplete(ctx, unknownRoot)
+	require.NoError(t, err, "CheckComplete")
+	require.Equal(t, []hash.Hash{unknownRoot.Hash}, missing, "unknown root should be missing")
+}
+
+func testCompact(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	var roots []node.Root
+	for version := uint64(0); version < 2; version++ {
+		tree := New(nil, ndb, node.RootTypeState)
+		for i := 0; i < 50; i++ {
+			err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %d/%d", version, i)))
+			require.NoError(t, err, "Insert")
+		}
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+
+		root := node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}
+		err = ndb.Finalize([]node.Root{root})
+		require.NoError(t, err, "Finalize")
+		roots = append(roots, root)
+	}
+	err := ndb.Prune(0)
+	require.NoError(t, err, "Prune")
+
+	// Reads should proceed while compacting.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ndb.Compact(ctx)
+	}()
+	tree := NewWithRoot(nil, ndb, roots[1])
+	for i := 0; i < 50; i++ {
+		value, err := tree.Get(ctx, []byte(fmt.Sprintf("key %d", i)))
+		require.NoError(t, err, "Get")
+		require.Equal(t, []byte(fmt.Sprintf("value 1/%d", i)), value)
+	}
+	tree.Close()
+	require.NoError(t, <-errCh, "Compact")
+
+	// Data should remain readable after compaction.
+	tree = NewWithRoot(nil, ndb, roots[1])
+	value, err := tree.Get(ctx, []byte("key 0"))
+	require.NoError(t, err, "Get")
+	require.Equal(t, []byte("value 1/0"), value)
+	tree.Close()
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = ndb.Compact(canceledCtx)
+	if err != nil {
+		require.ErrorIs(t, err, context.Canceled, "Compact should respect context cancellation")
+	}
+}
+
+func testPruneWriteLogs(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	var root node.Root
+	root.Namespace = testNs
+	root.Type = node.RootTypeState
+	root.Hash.Empty()
+	roots := []node.Root{root}
+	for version := uint64(0); version < 3; version++ {
+		tree := NewWithRoot(nil, ndb, root)
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", version)), []byte(fmt.Sprintf("value %d", version)))
+		require.NoError(t, err, "Insert")
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+
+		root = node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}
+		err = ndb.Finalize([]node.Root{root})
+		require.NoError(t, err, "Finalize")
+		roots = append(roots, root)
+	}
+
+	err := ndb.PruneWriteLogs(4)
+	require.ErrorIs(t, err, db.ErrNotFinalized, "PruneWriteLogs should fail for non-finalized versions")
+
+	err = ndb.PruneWriteLogs(2)
+	require.NoError(t, err, "PruneWriteLogs")
+	err = ndb.PruneWriteLogs(1)
+	require.NoError(t, err, "PruneWriteLogs with an already pruned version should be a no-op")
+
+	for i := 0; i < 2; i++ {
+		_, err = ndb.GetWriteLog(ctx, roots[i], roots[i+1])
+		require.ErrorIs(t, err, db.ErrWriteLogNotFound, "write log for version %d should be pruned", i)
+	}
+	it, err := ndb.GetWriteLog(ctx, roots[2], roots[3])
+	require.NoError(t, err, "GetWriteLog")
+	require.NoError(t, writelog.DrainIterator(it), "write log for version 2 should be retained")
+
+	// Node data and roots should not be affected.
+	require.EqualValues(t, 0, ndb.GetEarliestVersion(), "PruneWriteLogs should not prune versions")
+	for i := 1; i < len(roots); i++ {
+		require.True(t, ndb.HasRoot(roots[i]), "PruneWriteLogs should not remove roots")
+	}
+	tree := NewWithRoot(nil, ndb, roots[1])
+	value, err := tree.Get(ctx, []byte("key 0"))
+	require.NoError(t, err, "Get")
+	require.Equal(t, []byte("value 0"), value)
+	tree.Close()
+
+	// Pruning versions should still work after their write logs were pruned.
+	err = ndb.Prune(0)
+	require.NoError(t, err, "Prune")
+}
+
+func testFinalizeVersions(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	var root node.Root
+	root.Namespace = testNs
+	root.Type = node.RootTypeState
+	root.Hash.Empty()
+	var roots []node.Root
+	for version := uint64(0); version < 5; version++ {
+		tree := NewWithRoot(nil, ndb, root)
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", version)), []byte(fmt.Sprintf("value %d", version)))
+		require.NoError(t, err, "Insert")
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+
+		root = node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}
+		roots = append(roots, root)
+	}
+
+	err := ndb.FinalizeVersions(map[uint64][]node.Root{
+		0: {roots[0]},
+		2: {roots[2]},
+	})
+	require.Error(t, err, "FinalizeVersions should fail for non-contiguous versions")
+
+	err = ndb.FinalizeVersions(map[uint64][]node.Root{
+		0: {roots[0]},
+		1: {roots[1]},
+		2: {roots[2]},
+	})
+	require.NoError(t, err, "FinalizeVersions")
+	latest, ok := ndb.GetLatestVersion()
+	require.True(t, ok, "GetLatestVersion")
+	require.EqualValues(t, 2, latest, "all versions should be finalized")
+
+	// Add an alternative root in version 3, which is removed once the version is finalized.
+	altTree := NewWithRoot(nil, ndb, roots[2])
+	err = altTree.Insert(ctx, []byte("alt key"), []byte("alt value"))
+	require.NoError(t, err, "Insert")
+	_, altRootHash, err := altTree.Commit(ctx, testNs, 3)
+	require.NoError(t, err, "Commit")
+	altTree.Close()
+	altRoot := node.Root{Namespace: testNs, Version: 3, Type: node.RootTypeState, Hash: altRootHash}
+
+	// In case finalizing any version fails, no version should be finalized.
+	bogusRoot := roots[4]
+	bogusRoot.Hash = hash.NewFromBytes([]byte("bogus root"))
+	err = ndb.FinalizeVersions(map[uint64][]node.Root{
+		3: {roots[3]},
+		4: {bogusRoot},
+	})
+	require.ErrorIs(t, err, db.ErrRootNotFound, "FinalizeVersions should fail for unknown roots")
+	latest, _ = ndb.GetLatestVersion()
+	require.EqualValues(t, 2, latest, "no version should be finalized after a failure")
+
+	// Nothing should be removed either.
+	require.True(t, ndb.HasRoot(altRoot), "alternative root should still exist after a failure")
+	altTree = NewWithRoot(nil, ndb, altRoot)
+	value, err := altTree.Get(ctx, []byte("alt key"))
+	require.NoError(t, err, "Get")
+	require.Equal(t, []byte("alt value"), value)
+	altTree.Close()
+
+	err = ndb.FinalizeVersions(map[uint64][]node.Root{
+		3: {roots[3]},
+		4: {roots[4]},
+	})
+	require.NoError(t, err, "FinalizeVersions should succeed when retried")
+	latest, _ = ndb.GetLatestVersion()
+	require.EqualValues(t, 4, latest, "all versions should be finalized")
+
+	tree := NewWithRoot(nil, ndb, roots[4])
+	defer tree.Close()
+	for version := 0; version < 5; version++ {
+		value, err := tree.Get(ctx, []byte(fmt.Sprintf("key %d", version)))
+		require.NoError(t, err, "Get")
+		require.Equal(t, []byte(fmt.Sprintf("value %d", version)), value)
+	}
+}
+
+func testBatchPendingSize(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState).(*tree)
+
+	for i := 0; i < 10; i++ {
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %d", i)))
+		require.NoError(t, err, "Insert")
+	}
+
+	emptyRoot := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState}
+	emptyRoot.Hash.Empty()
+
+	batch, err := ndb.NewBatch(emptyRoot, 0, false)
+	require.NoError(t, err, "NewBatch")
+	defer batch.Reset()
+
+	nodes, bytes := batch.PendingSize()
+	require.Zero(t, nodes, "new batch should have no pending nodes")
+	require.Zero(t, bytes, "new batch should have no pending bytes")
+
+	rootHash, err := doCommit(ctx, tree.cache, batch, tree.cache.pendingRoot, nil)
+	require.NoError(t, err, "doCommit")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	// Ten leaves and nine internal nodes.
+	nodes, bytes = batch.PendingSize()
+	require.Equal(t, 19, nodes, "pending nodes should include all put nodes")
+	require.NotZero(t, bytes, "pending bytes should include all put nodes")
+
+	removed := &node.Pointer{Clean: true, Hash: hash.NewFromBytes([]byte("removed"))}
+	err = batch.RemoveNodes([]*node.Pointer{removed})
+	require.NoError(t, err, "RemoveNodes")
+	removedNodes, removedBytes := batch.PendingSize()
+	require.Equal(t, nodes+1, removedNodes, "pending nodes should include removed nodes")
+	require.Equal(t, bytes+removed.Size(), removedBytes, "pending bytes should include removed nodes")
+
+	// Resetting should clear the pending size.
+	batch.Reset()
+	nodes, bytes = batch.PendingSize()
+	require.Zero(t, nodes, "pending nodes should be cleared by Reset")
+	require.Zero(t, bytes, "pending bytes should be cleared by Reset")
+
+	// Committing should clear the pending size.
+	batch, err = ndb.NewBatch(emptyRoot, 0, false)
+	require.NoError(t, err, "NewBatch")
+	_, err = doCommit(ctx, tree.cache, batch, tree.cache.pendingRoot, nil)
+	require.NoError(t, err, "doCommit")
+	err = batch.Commit(root)
+	require.NoError(t, err, "Commit")
+	nodes, bytes = batch.PendingSize()
+	require.Zero(t, nodes, "pending nodes should be cleared by Commit")
+	require.Zero(t, bytes, "pending bytes should be cleared by Commit")
+}
+
+func testAutoPruneRoots(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	policy := db.PolicyForRoot(node.Root{Type: node.RootTypeIO})
+	policy.AutoPruneAfter = 2
+	defer func() {
+		policy.AutoPruneAfter = 0
+	}()
+
+	const numVersions = 5
+	var stateRoots, ioRoots []node.Root
+	for v := uint64(0); v < numVersions; v++ {
+		key := []byte(fmt.Sprintf("key %d", v))
+
+		tree := New(nil, ndb, node.RootTypeState)
+		err := tree.Insert(ctx, key, []byte("state"))
+		require.NoError(t, err, "Insert")
+		_, stateHash, err := tree.Commit(ctx, testNs, v)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+
+		tree = New(nil, ndb, node.RootTypeIO)
+		err = tree.Insert(ctx, key, []byte("io"))
+		require.NoError(t, err, "Insert")
+		_, ioHash, err := tree.Commit(ctx, testNs, v)
+		require.NoError(t, err, "Commit")
+		tree.Close()
+
+		stateRoot := node.Root{Namespace: testNs, Version: v, Type: node.RootTypeState, Hash: stateHash}
+		ioRoot := node.Root{Namespace: testNs, Version: v, Type: node.RootTypeIO, Hash: ioHash}
+		err = ndb.Finalize([]node.Root{stateRoot, ioRoot})
+		require.NoError(t, err, "Finalize")
+
+		stateRoots = append(stateRoots, stateRoot)
+		ioRoots = append(ioRoots, ioRoot)
+	}
+
+	for v := uint64(0); v < numVersions; v++ {
+		require.True(t, ndb.HasRoot(stateRoots[v]), "state root in version %d should not be pruned", v)
+
+		tree := NewWithRoot(nil, ndb, ioRoots[v])
+		value, err := tree.Get(ctx, []byte(fmt.Sprintf("key %d", v)))
+		tree.Close()
+
+		if v+policy.AutoPruneAfter <= numVersions-1 {
+			require.False(t, ndb.HasRoot(ioRoots[v]), "IO root in version %d should be pruned", v)
+
+			roots, err := ndb.GetRootsForVersionByType(v, node.RootTypeIO)
+			require.NoError(t, err, "GetRootsForVersionByType")
+			require.Empty(t, roots, "IO roots in version %d should be pruned", v)
+			continue
+		}
+
+		require.True(t, ndb.HasRoot(ioRoots[v]), "IO root in version %d should not be pruned", v)
+		require.NoError(t, err, "Get")
+		require.EqualValues(t, []byte("io"), value)
+	}
+
+	// Regular pruning should still work for versions with auto-pruned roots.
+	err := ndb.Prune(0)
+	require.NoError(t, err, "Prune")
+}
+
+func testMultipartState(t *testing.T, ndb db.NodeDB, factory NodeDBFactory) {
+	inProgress, version, err := ndb.MultipartState()
+	require.NoError(t, err, "MultipartState")
+	require.False(t, inProgress, "no multipart insert should be in progress")
+	require.EqualValues(t, 0, version)
+
+	err = ndb.StartMultipartInsert(42)
+	require.NoError(t, err, "StartMultipartInsert")
+	inProgress, version, err = ndb.MultipartState()
+	require.NoError(t, err, "MultipartState")
+	require.True(t, inProgress, "multipart insert should be in progress")
+	require.EqualValues(t, 42, version)
+
+	err = ndb.AbortMultipartInsert()
+	require.NoError(t, err, "AbortMultipartInsert")
+	inProgress, _, err = ndb.MultipartState()
+	require.NoError(t, err, "MultipartState")
+	require.False(t, inProgress, "multipart insert should be aborted")
+
+	// Multipart inserts that were not completed should be cleaned up on open.
+	err = ndb.StartMultipartInsert(42)
+	require.NoError(t, err, "StartMultipartInsert")
+	ndb.Close()
+	ndb, err = factory(testNs)
+	require.NoError(t, err, "ndb.New")
+	defer ndb.Close()
+
+	inProgress, _, err = ndb.MultipartState()
+	require.NoError(t, err, "MultipartState")
+	require.False(t, inProgress, "multipart insert should be cleaned up on open")
+}
+
+func testBatchDiscard(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState).(*tree)
+
+	for i := 0; i < 10; i++ {
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %d", i)))
+		require.NoError(t, err, "Insert")
+	}
+
+	emptyRoot := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState}
+	emptyRoot.Hash.Empty()
+
+	// Discarding a batch should not persist anything and should make it unusable.
+	batch, err := ndb.NewBatch(emptyRoot, 0, false)
+	require.NoError(t, err, "NewBatch")
+	rootHash, err := doCommit(ctx, tree.cache, batch, tree.cache.pendingRoot, nil)
+	require.NoError(t, err, "doCommit")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	batch.Discard()
+	batch.Discard() // Discarding twice should be a no-op.
+
+	err = batch.Commit(root)
+	require.ErrorIs(t, err, db.ErrBatchDiscarded, "Commit after Discard")
+	err = batch.CommitExpecting(root)
+	require.ErrorIs(t, err, db.ErrBatchDiscarded, "CommitExpecting after Discard")
+	err = batch.PutWriteLog(writelog.WriteLog{}, nil)
+	require.ErrorIs(t, err, db.ErrBatchDiscarded, "PutWriteLog after Discard")
+	err = batch.RemoveNodes(nil)
+	require.ErrorIs(t, err, db.ErrBatchDiscarded, "RemoveNodes after Discard")
+	err = batch.PutNode(&node.Pointer{Clean: true, Hash: rootHash})
+	require.ErrorIs(t, err, db.ErrBatchDiscarded, "PutNode after Discard")
+
+	// Resetting should not make a discarded batch usable again.
+	batch.Reset()
+	err = batch.Commit(root)
+	require.ErrorIs(t, err, db.ErrBatchDiscarded, "Commit after Discard and Reset")
+	require.False(t, ndb.HasRoot(root), "root should not be persisted")
+
+	// A new batch should still be able to commit.
+	batch, err = ndb.NewBatch(emptyRoot, 0, false)
+	require.NoError(t, err, "NewBatch")
+	defer batch.Reset()
+	_, err = doCommit(ctx, tree.cache, batch, tree.cache.pendingRoot, nil)
+	require.NoError(t, err, "doCommit")
+	err = batch.Commit(root)
+	require.NoError(t, err, "Commit")
+	require.True(t, ndb.HasRoot(root), "root should be persisted")
+}
+
+func testGetRootsForVersionByType(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	var stateRoots, ioRoots []node.Root
+	for i := 0; i < 2; i++ {
+		for _, rootType := range []node.RootType{node.RootTypeState, node.RootTypeIO} {
+			tree := New(nil, ndb, rootType)
+			err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", i)), []byte(fmt.Sprintf("value %s %d", rootType, i)))
+			require.NoError(t, err, "Insert")
+			_, rootHash, err := tree.Commit(ctx, testNs, 0)
+			require.NoError(t, err, "Commit")
+			tree.Close()
+
+			root := node.Root{Namespace: testNs, Version: 0, Type: rootType, Hash: rootHash}
+			switch rootType {
+			case node.RootTypeState:
+				stateRoots = append(stateRoots, root)
+			case node.RootTypeIO:
+				ioRoots = append(ioRoots, root)
+			}
+		}
+	}
+
+	roots, err := ndb.GetRootsForVersionByType(0, node.RootTypeState)
+	require.NoError(t, err, "GetRootsForVersionByType")
+	require.ElementsMatch(t, stateRoots, roots, "only state roots should be returned")
+
+	roots, err = ndb.GetRootsForVersionByType(0, node.RootTypeIO)
+	require.NoError(t, err, "GetRootsForVersionByType")
+	require.ElementsMatch(t, ioRoots, roots, "only IO roots should be returned")
+
+	roots, err = ndb.GetRootsForVersionByType(1, node.RootTypeState)
+	require.NoError(t, err, "GetRootsForVersionByType")
+	require.Empty(t, roots, "no roots should be returned for a missing version")
+
+	_, err = ndb.GetRootsForVersionByType(0, node.RootTypeInvalid)
+	require.ErrorIs(t, err, db.ErrInvalidRootType, "GetRootsForVersionByType should reject invalid root types")
+	_, err = ndb.GetRootsForVersionByType(0, node.RootTypeMax+1)
+	require.ErrorIs(t, err, db.ErrInvalidRootType, "GetRootsForVersionByType should reject unknown root types")
+}
+
+func testScanPrefix(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	keys := []string{"a", "ac", "acc", "acc/a/1", "acc/ab/1", "acc/b/1", "acd", "b", "other"}
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("acc/n/%03d", i))
+	}
+	slices.Sort(keys)
+
+	tree := New(nil, ndb, node.RootTypeState)
+	for _, key := range keys {
+		err := tree.Insert(ctx, []byte(key), []byte("value "+key))
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	tree.Close()
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	scan := func(prefix string) []string {
+		var scanned []string
+		err := ndb.ScanPrefix(ctx, root, node.Key(prefix), func(key node.Key, value []byte) bool {
+			require.Equal(t, "value "+string(key), string(value), "value should match key")
+			scanned = append(scanned, string(key))
+			return true
+		})
+		require.NoError(t, err, "ScanPrefix")
+		return scanned
+	}
+
+	for _, prefix := range []string{"", "a", "ac", "acc", "acc/", "acc/a", "acc/n/05", "acd", "o", "other", "otherwise", "z"} {
+		var expected []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				expected = append(expected, key)
+			}
+		}
+		require.Equal(t, expected, scan(prefix), "ScanPrefix(%q)", prefix)
+	}
+
+	// The scan should stop early when requested.
+	var scanned []string
+	err = ndb.ScanPrefix(ctx, root, node.Key("acc/n/"), func(key node.Key, _ []byte) bool {
+		scanned = append(scanned, string(key))
+		return len(scanned) < 3
+	})
+	require.NoError(t, err, "ScanPrefix")
+	require.Equal(t, []string{"acc/n/000", "acc/n/001", "acc/n/002"}, scanned, "ScanPrefix should stop early")
+
+	// Scanning an empty tree should not call the callback.
+	emptyRoot := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState}
+	emptyRoot.Hash.Empty()
+	err = ndb.ScanPrefix(ctx, emptyRoot, nil, func(node.Key, []byte) bool {
+		t.Fatal("callback should not be called for an empty tree")
+		return false
+	})
+	require.NoError(t, err, "ScanPrefix")
+}
+
+func testIterateNodes(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+
+	// An empty database should not yield any nodes.
+	err := ndb.IterateNodes(ctx, func(*node.Pointer, node.Node) error {
+		t.Fatal("callback should not be called for an empty database")
+		return nil
+	})
+	require.NoError(t, err, "IterateNodes")
+
+	keys := make(map[string]struct{})
+	tree := New(nil, ndb, node.RootTypeState)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key %02d", i)
+		err = tree.Insert(ctx, []byte(key), []byte("value "+key))
+		require.NoError(t, err, "Insert")
+		keys[key] = struct{}{}
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	tree.Close()
+
+	seenHashes := make(map[hash.Hash]struct{})
+	seenKeys := make(map[string]struct{})
+	err = ndb.IterateNodes(ctx, func(ptr *node.Pointer, n node.Node) error {
+		require.True(t, ptr.IsClean(), "pointer should be clean")
+		require.Equal(t, n, ptr.Node, "pointer should refer to the node")
+		require.Equal(t, n.GetHash(), ptr.Hash, "pointer hash should match node hash")
+		require.NoError(t, node.Validate(n), "node should be valid")
+
+		_, seen := seenHashes[ptr.Hash]
+		require.False(t, seen, "each node should be yielded exactly once")
+		seenHashes[ptr.Hash] = struct{}{}
+
+		switch n := n.(type) {
+		case *node.LeafNode:
+			require.Equal(t, "value "+string(n.Key), string(n.Value))
+			seenKeys[string(n.Key)] = struct{}{}
+		case *node.InternalNode:
+			if n.LeafNode != nil {
+				seenKeys[string(n.LeafNode.Node.(*node.LeafNode).Key)] = struct{}{}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "IterateNodes")
+	require.Contains(t, seenHashes, rootHash, "root node should be yielded")
+	require.Equal(t, keys, seenKeys, "all leaf nodes should be yielded")
+
+	// Errors returned by the callback should stop the iteration.
+	errStop := errors.New("stop")
+	var count int
+	err = ndb.IterateNodes(ctx, func(*node.Pointer, node.Node) error {
+		count++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop, "IterateNodes should propagate callback errors")
+	require.Equal(t, 1, count, "IterateNodes should stop after the first error")
+
+	// Context cancellation should be respected.
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = ndb.IterateNodes(cancelledCtx, func(*node.Pointer, node.Node) error {
+		t.Fatal("callback should not be called with a cancelled context")
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled, "IterateNodes should respect context cancellation")
+}
+
+func testRootsChain(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
 
-	// Reopen database to force compaction.
-	ndb.Close()
-	ndb, err = factory(testNs)
-	require.NoError(t, err, "ndb.New")
-	defer ndb.Close()
+	var root node.Root
+	root.Namespace = testNs
+	root.Type = node.RootTypeState
+	root.Hash.Empty()
+	var roots []node.Root
+	for version := uint64(0); version < 3; version++ {
+		tree := NewWithRoot(nil, ndb, root)
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("key %d", version)), []byte(fmt.Sprintf("value %d", version)))
+		require.NoError(t, err, "Insert")
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		tree.Close()
 
-	earliestVersion = ndb.GetEarliestVersion()
-	require.EqualValues(t, 1, earliestVersion, "earliest version should be correct")
-	latestVersion, exists = ndb.GetLatestVersion()
-	require.EqualValues(t, 2, latestVersion, "latest version should be correct")
-	require.True(t, exists, "latest version should exist")
+		root = node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}
+		roots = append(roots, root)
+	}
 
-	// Keys must still be available in version 2.
-	tree = NewWithRoot(nil, ndb, node.Root{Namespace: testNs, Version: 2, Type: node.RootTypeState, Hash: rootHash3})
-	value, err := tree.Get(ctx, []byte("blah"))
-	require.NoError(t, err, "Get")
-	require.EqualValues(t, []byte("ugh"), value)
-	value, err = tree.Get(ctx, []byte("moo"))
-	require.NoError(t, err, "Get")
-	require.EqualValues(t, []byte("bar"), value)
-	value, err = tree.Get(ctx, []byte("another"))
-	require.NoError(t, err, "Get")
-	require.EqualValues(t, []byte("value"), value)
-	// Removed key must be gone.
-	value, err = tree.Get(ctx, []byte("foo"))
-	require.NoError(t, err, "Get")
-	require.Nil(t, value, "removed key must be gone")
+	// Consecutive roots should be chained.
+	for i := 1; i < len(roots); i++ {
+		ok, err := db.RootsChain(ctx, ndb, roots[i-1], roots[i])
+		require.NoError(t, err, "RootsChain")
+		require.True(t, ok, "consecutive roots should be chained")
+	}
+	ok, err := db.RootsChain(ctx, ndb, roots[1], roots[1])
+	require.NoError(t, err, "RootsChain")
+	require.True(t, ok, "a root should be chained to itself")
+
+	// Roots that do not follow each other should not be chained.
+	ok, err = db.RootsChain(ctx, ndb, roots[0], roots[2])
+	require.ErrorIs(t, err, db.ErrRootsNotChained, "RootsChain should fail for roots that do not follow")
+	require.False(t, ok)
+	ok, err = db.RootsChain(ctx, ndb, roots[1], roots[0])
+	require.ErrorIs(t, err, db.ErrRootsNotChained, "RootsChain should fail for reversed roots")
+	require.False(t, ok)
+
+	// Roots that were not derived from each other should not be chained.
+	forkRoot := node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState}
+	forkRoot.Hash.Empty()
+	tree := NewWithRoot(nil, ndb, forkRoot)
+	err = tree.Insert(ctx, []byte("fork"), []byte("fork"))
+	require.NoError(t, err, "Insert")
+	_, forkRoot.Hash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(t, err, "Commit")
+	tree.Close()
 
-	// Version 0 must be gone.
-	tree = NewWithRoot(nil, ndb, node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash1})
-	_, err = tree.Get(ctx, []byte("foo"))
-	require.Error(t, err, "Get")
+	ok, err = db.RootsChain(ctx, ndb, roots[0], forkRoot)
+	require.ErrorIs(t, err, db.ErrRootsNotChained, "RootsChain should fail for diverging roots")
+	require.False(t, ok)
+
+	// Unknown roots should be rejected.
+	bogusRoot := roots[2]
+	bogusRoot.Hash = hash.NewFromBytes([]byte("bogus root"))
+	ok, err = db.RootsChain(ctx, ndb, roots[1], bogusRoot)
+	require.ErrorIs(t, err, db.ErrRootNotFound, "RootsChain should fail for unknown roots")
+	require.False(t, ok)
 }
 
-func testPruneManyVersions(t *testing.T, ndb db.NodeDB, factory NodeDBFactory) {
+func testGetWriteLogReverse(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
 	ctx := context.Background()
-	tree := New(nil, ndb, node.RootTypeState)
-
-	const numVersions = 50
-	const numPairsPerVersion = 50
 
-	for r := 0; r < numVersions; r++ {
-		for p := 0; p < numPairsPerVersion; p++ {
-			key := []byte(fmt.Sprintf("key %d/%d", r, p))
-			value := []byte(fmt.Sprintf("value %d/%d", r, p))
-			err := tree.Insert(ctx, key, value)
-			require.NoError(t, err, "Insert")
+	drain := func(it writelog.Iterator) writelog.WriteLog {
+		var wl writelog.WriteLog
+		for {
+			more, err := it.Next()
+			require.NoError(t, err, "Next")
+			if !more {
+				return wl
+			}
+			entry, err := it.Value()
+			require.NoError(t, err, "Value")
+			wl = append(wl, entry)
 		}
+	}
 
-		_, rootHash, err := tree.Commit(ctx, testNs, uint64(r))
+	// Each version updates a shared key and adds a new one.
+	root := node.EmptyRoot(testNs, 0, node.RootTypeState)
+	roots := []node.Root{root}
+	for version := uint64(0); version < 4; version++ {
+		tree := NewWithRoot(nil, ndb, root)
+		err := tree.Insert(ctx, []byte("shared"), []byte(fmt.Sprintf("shared %d", version)))
+		require.NoError(t, err, "Insert")
+		err = tree.Insert(ctx, []byte(fmt.Sprintf("key %d", version)), []byte(fmt.Sprintf("value %d", version)))
+		require.NoError(t, err, "Insert")
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
 		require.NoError(t, err, "Commit")
-		root := node.Root{
-			Namespace: testNs,
-			Version:   uint64(r),
-			Type:      node.RootTypeState,
-			Hash:      rootHash,
-		}
-		err = ndb.Finalize([]node.Root{root})
-		require.NoError(t, err, "Finalize")
+		tree.Close()
+
+		root = node.Root{Namespace: testNs, Version: version, Type: node.RootTypeState, Hash: rootHash}
+		roots = append(roots, root)
 	}
 
-	// Prune all versions except the last one.
-	for r := 0; r < numVersions-1; r++ {
-		err := ndb.Prune(uint64(r))
-		require.NoError(t, err, "Prune")
+	// The write logs should be returned newest first.
+	var expected writelog.WriteLog
+	for i := len(roots) - 1; i > 1; i-- {
+		it, err := ndb.GetWriteLog(ctx, roots[i-1], roots[i])
+		require.NoError(t, err, "GetWriteLog")
+		expected = append(expected, drain(it)...)
 	}
+	it, err := db.GetWriteLogReverse(ctx, ndb, roots[1], roots[4])
+	require.NoError(t, err, "GetWriteLogReverse")
+	wl := drain(it)
+	require.Equal(t, expected, wl, "GetWriteLogReverse should return write logs newest first")
+	// Order within a single write log is not defined, so look up the first change of the key.
+	idx := slices.IndexFunc(wl, func(entry writelog.LogEntry) bool {
+		return bytes.Equal(entry.Key, []byte("shared"))
+	})
+	require.GreaterOrEqual(t, idx, 0, "shared key should be changed")
+	require.Equal(t, []byte("shared 3"), wl[idx].Value, "the first change of a key should be the latest one")
 
-	// Reopen database to force compaction.
-	ndb.Close()
-	ndb, err := factory(testNs)
-	require.NoError(t, err, "ndb.New")
-	defer ndb.Close()
+	// A single version step should match the forward write log.
+	it, err = ndb.GetWriteLog(ctx, roots[2], roots[3])
+	require.NoError(t, err, "GetWriteLog")
+	expected = drain(it)
+	it, err = db.GetWriteLogReverse(ctx, ndb, roots[2], roots[3])
+	require.NoError(t, err, "GetWriteLogReverse")
+	require.Equal(t, expected, drain(it), "GetWriteLogReverse should match GetWriteLog for a single step")
+
+	// The write log from a root to itself should be empty.
+	it, err = db.GetWriteLogReverse(ctx, ndb, roots[2], roots[2])
+	require.NoError(t, err, "GetWriteLogReverse")
+	require.Empty(t, drain(it), "write log from a root to itself should be empty")
+
+	// Reversed roots should be rejected.
+	_, err = db.GetWriteLogReverse(ctx, ndb, roots[3], roots[1])
+	require.ErrorIs(t, err, db.ErrRootMustFollowOld, "GetWriteLogReverse should fail for reversed roots")
+
+	// Roots that are not connected by write logs should be rejected.
+	forkRoot := node.EmptyRoot(testNs, 2, node.RootTypeState)
+	tree := NewWithRoot(nil, ndb, forkRoot)
+	err = tree.Insert(ctx, []byte("fork"), []byte("fork"))
+	require.NoError(t, err, "Insert")
+	_, forkRoot.Hash, err = tree.Commit(ctx, testNs, 2)
+	require.NoError(t, err, "Commit")
+	tree.Close()
 
-	// Check that the latest version has all the keys.
-	for r := 0; r < numVersions; r++ {
-		for p := 0; p < numPairsPerVersion; p++ {
-			key := []byte(fmt.Sprintf("key %d/%d", r, p))
-			value, err := tree.Get(ctx, key)
-			require.NoError(t, err, "Get")
-			require.EqualValues(t, value, fmt.Sprintf("value %d/%d", r, p))
-		}
-	}
+	_, err = db.GetWriteLogReverse(ctx, ndb, roots[1], forkRoot)
+	require.ErrorIs(t, err, db.ErrWriteLogNotFound, "GetWriteLogReverse should fail for unconnected roots")
+}
+
+func testQuiesce(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState)
+
+	err := tree.Insert(ctx, []byte("foo"), []byte("bar"))
+	require.NoError(t, err, "Insert")
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	err = ndb.Finalize([]node.Root{root})
+	require.NoError(t, err, "Finalize")
+
+	resume, err := ndb.Quiesce(ctx)
+	require.NoError(t, err, "Quiesce")
+
+	// Commits should be rejected while quiesced.
+	err = tree.Insert(ctx, []byte("moo"), []byte("goo"))
+	require.NoError(t, err, "Insert")
+	_, _, err = tree.Commit(ctx, testNs, 1)
+	require.ErrorIs(t, err, db.ErrQuiesced, "Commit should fail while quiesced")
+
+	// Reads should not be affected.
+	readTree := NewWithRoot(nil, ndb, root)
+	defer readTree.Close()
+	value, err := readTree.Get(ctx, []byte("foo"))
+	require.NoError(t, err, "Get")
+	require.EqualValues(t, "bar", value)
+	require.True(t, ndb.HasRoot(root), "HasRoot")
+
+	// Commits should succeed after resume.
+	resume()
+	_, rootHash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(t, err, "Commit after resume")
+	err = ndb.Finalize([]node.Root{{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}})
+	require.NoError(t, err, "Finalize after resume")
 }
 
 func testPruneForkedRoots(t *testing.T, ndb db.NodeDB, factory NodeDBFactory) {
@@ -2102,6 +3576,38 @@ func testPruneLatest(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
 	require.Error(t, err, "Prune should fail for the only finalized version")
 }
 
+func testProofOverheadRatio(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
+	ctx := context.Background()
+	tree := New(nil, ndb, node.RootTypeState)
+
+	for i := 0; i < 32; i++ {
+		err := tree.Insert(ctx, []byte(fmt.Sprintf("tiny/%d", i)), []byte{byte(i)})
+		require.NoError(t, err, "Insert")
+		err = tree.Insert(ctx, []byte(fmt.Sprintf("large/%d", i)), bytes.Repeat([]byte{byte(i)}, 1024))
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+
+	tinyPrefix := node.Key("tiny/")
+	tinyRatio, err := db.ProofOverheadRatio(ctx, ndb, root, tinyPrefix, tinyPrefix.BitLength())
+	require.NoError(t, err, "ProofOverheadRatio")
+	largePrefix := node.Key("large/")
+	largeRatio, err := db.ProofOverheadRatio(ctx, ndb, root, largePrefix, largePrefix.BitLength())
+	require.NoError(t, err, "ProofOverheadRatio")
+
+	require.Greater(t, tinyRatio, 1.0, "proofs of tiny values should be dominated by overhead")
+	require.Less(t, largeRatio, 1.0, "proofs of large values should be dominated by values")
+	require.Greater(t, tinyRatio, largeRatio)
+
+	// Prefix without any values.
+	missingPrefix := node.Key("missing/")
+	ratio, err := db.ProofOverheadRatio(ctx, ndb, root, missingPrefix, missingPrefix.BitLength())
+	require.NoError(t, err, "ProofOverheadRatio")
+	require.EqualValues(t, 0, ratio)
+}
+
 func testErrors(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
 	ctx := context.Background()
 
@@ -2343,6 +3849,282 @@ func testLargeUpdates(t *testing.T, ndb db.NodeDB, _ NodeDBFactory) {
 	require.NoError(t, err, "Finalize")
 }
 
+func TestMergeSubtrees(t *testing.T) {
+	ctx := context.Background()
+
+	// All keys share the "k" prefix. Left keys have the following bit unset, right keys have it set.
+	leftKeys := [][]byte{[]byte("k\x01foo"), []byte("k\x02bar"), []byte("k\x03baz"), []byte("k\x03bazz")}
+	rightKeys := [][]byte{[]byte("k\x81x"), []byte("k\x82y"), []byte("k\xff")}
+
+	build := func(keys ...[]byte) *node.Pointer {
+		tree := New(nil, nil, node.RootTypeState).(*tree)
+		for _, key := range keys {
+			err := tree.Insert(ctx, key, append([]byte("value "), key...))
+			require.NoError(t, err, "Insert")
+		}
+		_, _, err := tree.Commit(ctx, testNs, 0)
+		require.NoError(t, err, "Commit")
+		return tree.cache.pendingRoot
+	}
+
+	left := build(leftKeys...)
+	right := build(rightKeys...)
+	full := build(append(append([][]byte{}, leftKeys...), rightKeys...)...)
+
+	merged, err := node.MergeSubtrees(left, right, node.Key("k"), 8)
+	require.NoError(t, err, "MergeSubtrees")
+	require.Equal(t, full.Hash, merged.Hash, "merged root hash should match a single-pass build")
+	merged.Node.UpdateHash()
+	require.Equal(t, full.Hash, merged.Node.GetHash(), "merged root node hash should match")
+
+	// Single-leaf subtrees do not need relabeling.
+	merged, err = node.MergeSubtrees(build(leftKeys[0]), build(rightKeys[0]), node.Key("k"), 8)
+	require.NoError(t, err, "MergeSubtrees")
+	require.Equal(t, build(leftKeys[0], rightKeys[0]).Hash, merged.Hash)
+
+	// Subtrees that are not disjoint at the divergence bit.
+	_, err = node.MergeSubtrees(right, left, node.Key("k"), 8)
+	require.ErrorIs(t, err, node.ErrSubtreesNotDisjoint, "swapped subtrees")
+	_, err = node.MergeSubtrees(build(leftKeys[0], rightKeys[0]), right, node.Key("k"), 8)
+	require.ErrorIs(t, err, node.ErrSubtreesNotDisjoint, "overlapping subtrees")
+	_, err = node.MergeSubtrees(left, build([]byte("x\x81")), node.Key("k"), 8)
+	require.ErrorIs(t, err, node.ErrSubtreesNotDisjoint, "subtree without common label")
+	_, err = node.MergeSubtrees(left, nil, node.Key("k"), 8)
+	require.ErrorIs(t, err, node.ErrSubtreesNotDisjoint, "empty subtree")
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+
+	build := func(kvs map[string]string) *node.Pointer {
+		tree := New(nil, nil, node.RootTypeState).(*tree)
+		for key, value := range kvs {
+			err := tree.Insert(ctx, []byte(key), []byte(value))
+			require.NoError(t, err, "Insert")
+		}
+		_, _, err := tree.Commit(ctx, testNs, 0)
+		require.NoError(t, err, "Commit")
+		return tree.cache.pendingRoot
+	}
+
+	oldKvs := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		oldKvs[fmt.Sprintf("key %d", i)] = fmt.Sprintf("value %d", i)
+	}
+	newKvs := maps.Clone(oldKvs)
+	// Key "key 1" is a prefix of other keys so its leaf is stored in an internal node.
+	newKvs["key 1"] = "changed value"
+	delete(newKvs, "key 2")
+	newKvs["key 20x"] = "added value"
+
+	a := build(oldKvs)
+	b := build(newKvs)
+
+	expected := []node.DiffEntry{
+		{Key: node.Key("key 1"), Op: node.DiffChanged, OldValue: []byte("value 1"), NewValue: []byte("changed value")},
+		{Key: node.Key("key 2"), Op: node.DiffRemoved, OldValue: []byte("value 2")},
+		{Key: node.Key("key 20x"), Op: node.DiffAdded, NewValue: []byte("added value")},
+	}
+	diff, err := node.Diff(a, b)
+	require.NoError(t, err, "Diff")
+	require.Equal(t, expected, diff)
+
+	// The reverse diff swaps additions and removals.
+	diff, err = node.Diff(b, a)
+	require.NoError(t, err, "Diff")
+	require.Len(t, diff, len(expected))
+	require.Equal(t, node.DiffChanged, diff[0].Op)
+	require.Equal(t, []byte("changed value"), diff[0].OldValue)
+	require.Equal(t, []byte("value 1"), diff[0].NewValue)
+	require.Equal(t, node.DiffAdded, diff[1].Op)
+	require.Equal(t, node.DiffRemoved, diff[2].Op)
+
+	diff, err = node.Diff(a, a)
+	require.NoError(t, err, "Diff")
+	require.Empty(t, diff, "identical subtrees should not differ")
+
+	diff, err = node.Diff(nil, a)
+	require.NoError(t, err, "Diff")
+	require.Len(t, diff, len(oldKvs), "all keys should be added to an empty subtree")
+
+	// Subtrees with equal hashes are not descended into, so they need not be resolved.
+	rootA := a.Node.(*node.InternalNode)
+	rootB := b.Node.(*node.InternalNode)
+	require.True(t, rootA.Right.Hash.Equal(&rootB.Right.Hash), "right subtree should be unchanged")
+	prunedA := &node.Pointer{
+		Clean: true,
+		Hash:  a.Hash,
+		Node: &node.InternalNode{
+			Clean:          true,
+			Hash:           rootA.Hash,
+			Label:          rootA.Label,
+			LabelBitLength: rootA.LabelBitLength,
+			LeafNode:       rootA.LeafNode,
+			Left:           rootA.Left,
+			Right:          rootA.Right.Extract(),
+		},
+	}
+	diff, err = node.Diff(prunedA, b)
+	require.NoError(t, err, "Diff")
+	require.Equal(t, expected, diff)
+
+	// Subtrees which need to be descended into must be resolved.
+	_, err = node.Diff(a.Extract(), b)
+	require.ErrorIs(t, err, node.ErrUnresolvedPointer)
+}
+
+func TestExtractToDepth(t *testing.T) {
+	ctx := context.Background()
+	keys, values := generateKeyValuePairsEx("", 100)
+
+	tree := New(nil, nil, node.RootTypeState).(*tree)
+	for i, key := range keys {
+		err := tree.Insert(ctx, key, values[i])
+		require.NoError(t, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	root := tree.cache.pendingRoot
+
+	// loadedDepth returns the number of levels of loaded nodes and recomputes their hashes.
+	var loadedDepth func(ptr *node.Pointer) node.Depth
+	loadedDepth = func(ptr *node.Pointer) node.Depth {
+		if ptr == nil || ptr.Node == nil {
+			return 0
+		}
+		var depth node.Depth
+		if n, ok := ptr.Node.(*node.InternalNode); ok {
+			depth = max(loadedDepth(n.LeafNode), loadedDepth(n.Left), loadedDepth(n.Right))
+		}
+		ptr.Node.UpdateHash()
+		ptr.Hash = ptr.Node.GetHash()
+		return depth + 1
+	}
+	fullDepth := loadedDepth(root.ExtractWithNode())
+	require.EqualValues(t, 1, fullDepth, "ExtractWithNode should only include the root node")
+	fullDepth = loadedDepth(root.ExtractToDepth(1000))
+	require.Greater(t, fullDepth, node.Depth(3), "tree should have more than three levels")
+
+	for maxDepth := node.Depth(0); maxDepth <= fullDepth; maxDepth++ {
+		extracted := root.ExtractToDepth(maxDepth)
+		require.Equal(t, min(maxDepth+1, fullDepth), loadedDepth(extracted), "extracted levels at depth %d", maxDepth)
+		require.Equal(t, rootHash, extracted.Hash, "extracted root hash at depth %d", maxDepth)
+		require.Equal(t, maxDepth+1 >= fullDepth, extracted.IsFullyMaterialized())
+
+		n := root.Node.(*node.InternalNode).ExtractToDepth(maxDepth)
+		n.UpdateHash()
+		require.Equal(t, rootHash, n.GetHash(), "extracted node hash at depth %d", maxDepth)
+	}
+
+	// Extracting from a dirty tree is not allowed.
+	err = tree.Insert(ctx, []byte("foo"), []byte("bar"))
+	require.NoError(t, err, "Insert")
+	require.Panics(t, func() { tree.cache.pendingRoot.ExtractToDepth(1) })
+}
+
+type prefixHasher struct {
+	prefix []byte
+}
+
+func (h prefixHasher) Hash(data ...[]byte) hash.Hash {
+	return hash.NewFromBytes(append([][]byte{h.prefix}, data...)...)
+}
+
+func TestCustomHasherEmptyTree(t *testing.T) {
+	ctx := context.Background()
+	node.SetHasher(prefixHasher{prefix: []byte("experimental")})
+	t.Cleanup(func() { node.SetHasher(nil) })
+
+	ndb, err := badgerDb.New(&db.Config{
+		MemoryOnly:   true,
+		NoFsync:      true,
+		Namespace:    testNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+	})
+	require.NoError(t, err, "New")
+	defer ndb.Close()
+
+	emptyTreeHash := node.EmptyTreeHash()
+	var defaultEmptyHash hash.Hash
+	defaultEmptyHash.Empty()
+	require.NotEqual(t, defaultEmptyHash, emptyTreeHash, "empty tree hash should depend on the hasher")
+
+	// Committing an empty tree should result in the root of an empty tree.
+	tree := New(nil, ndb, node.RootTypeState)
+	defer tree.Close()
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(t, err, "Commit")
+	require.Equal(t, emptyTreeHash, rootHash, "empty tree should have the empty tree hash")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	require.True(t, root.IsEmptyTree(), "committed root should be the root of an empty tree")
+	require.True(t, ndb.HasRoot(root), "empty root should be present")
+
+	// Removing all keys should result in the root of an empty tree again.
+	err = tree.Insert(ctx, []byte("key"), []byte("value"))
+	require.NoError(t, err, "Insert")
+	_, rootHash, err = tree.Commit(ctx, testNs, 1)
+	require.NoError(t, err, "Commit")
+	root = node.Root{Namespace: testNs, Version: 1, Type: node.RootTypeState, Hash: rootHash}
+	require.False(t, root.IsEmptyTree(), "non-empty tree should not have the empty tree hash")
+	require.True(t, ndb.HasRoot(root), "committed root should be present")
+
+	err = tree.Remove(ctx, []byte("key"))
+	require.NoError(t, err, "Remove")
+	_, rootHash, err = tree.Commit(ctx, testNs, 2)
+	require.NoError(t, err, "Commit")
+	require.Equal(t, emptyTreeHash, rootHash, "empty tree should have the empty tree hash")
+
+	value, err := tree.Get(ctx, []byte("key"))
+	require.NoError(t, err, "Get")
+	require.Nil(t, value, "removed key should not be present")
+}
+
+func TestKeyPathMatchesTree(t *testing.T) {
+	ctx := context.Background()
+	keys, values := generateKeyValuePairsEx("", 100)
+	// Include keys which are prefixes of other keys, so their leaves are stored in internal nodes.
+	keys = append(keys, []byte("k"), []byte("ke"), []byte("key 1"))
+	values = append(values, []byte("v1"), []byte("v2"), []byte("v3"))
+
+	tree := New(nil, nil, node.RootTypeState).(*tree)
+	for i, key := range keys {
+		err := tree.Insert(ctx, key, values[i])
+		require.NoError(t, err, "Insert")
+	}
+
+	for _, key := range keys {
+		k := node.Key(key)
+		path := node.KeyPath(k, k.BitLength())
+
+		// Follow the key path from the root and make sure we end up at the leaf for the key.
+		ptr := tree.cache.pendingRoot
+		var bitDepth node.Depth
+		for {
+			n, ok := ptr.Node.(*node.InternalNode)
+			if !ok {
+				break
+			}
+			bitLength := bitDepth + n.LabelBitLength
+			if bitLength == k.BitLength() {
+				ptr = n.LeafNode
+				break
+			}
+			require.Less(t, int(bitLength), len(path), "key path should be long enough")
+			switch path[bitLength] {
+			case false:
+				ptr = n.Left
+			case true:
+				ptr = n.Right
+			}
+			bitDepth = bitLength
+		}
+
+		leaf, ok := ptr.Node.(*node.LeafNode)
+		require.True(t, ok, "key path should end in a leaf node")
+		require.EqualValues(t, key, leaf.Key, "key path should lead to the key's leaf")
+	}
+}
+
 func testBackend(
 	t *testing.T,
 	initBackend func(t *testing.T) (NodeDBFactory, func()),
@@ -2368,6 +4150,7 @@ func testBackend(
 		{"ValueEviction", testValueEviction},
 		{"NodeEviction", testNodeEviction},
 		{"DoubleInsertWithEviction", testDoubleInsertWithEviction},
+		{"SharedCache", testSharedCache},
 		{"DebugDump", testDebugDumpLocal},
 		{"OnCommitHooks", testOnCommitHooks},
 		{"CommitNoPersist", testCommitNoPersist},
@@ -2385,6 +4168,31 @@ func testBackend(
 		{"PruneLoneRootsShared3", testPruneLoneRootsShared3},
 		{"PruneLoneRootsShared4", testPruneLoneRootsShared4},
 		{"PruneForkedRoots", testPruneForkedRoots},
+		{"CommitFinalizeAndPrune", testCommitFinalizeAndPrune},
+		{"VerifyAgainstManifest", testVerifyAgainstManifest},
+		{"Quiesce", testQuiesce},
+		{"NearestVersionAtOrBefore", testNearestVersionAtOrBefore},
+		{"PrefetchKeys", testPrefetchKeys},
+		{"CommitExpecting", testCommitExpecting},
+		{"CachingNodeDB", testCachingNodeDB},
+		{"HasNode", testHasNode},
+		{"GetPendingVersions", testGetPendingVersions},
+		{"PruneDryRun", testPruneDryRun},
+		{"GetWriteLogForPrefix", testGetWriteLogForPrefix},
+		{"GetWriteLogAnnotations", testGetWriteLogAnnotations},
+		{"CheckComplete", testCheckComplete},
+		{"Compact", testCompact},
+		{"PruneWriteLogs", testPruneWriteLogs},
+		{"FinalizeVersions", testFinalizeVersions},
+		{"MultipartState", testMultipartState},
+		{"AutoPruneRoots", testAutoPruneRoots},
+		{"BatchDiscard", testBatchDiscard},
+		{"BatchPendingSize", testBatchPendingSize},
+		{"GetRootsForVersionByType", testGetRootsForVersionByType},
+		{"ScanPrefix", testScanPrefix},
+		{"IterateNodes", testIterateNodes},
+		{"RootsChain", testRootsChain},
+		{"GetWriteLogReverse", testGetWriteLogReverse},
 		{"PruneLatest", testPruneLatest},
 		{"SpecialCase1", testSpecialCase1},
 		{"SpecialCase2", testSpecialCase2},
@@ -2393,6 +4201,7 @@ func testBackend(
 		{"SpecialCase5", testSpecialCase5},
 		{"SpecialCase6", testSpecialCase6},
 		{"LargeUpdates", testLargeUpdates},
+		{"ProofOverheadRatio", testProofOverheadRatio},
 		{"Errors", testErrors},
 		{"IncompatibleDB", testIncompatibleDB},
 	}
@@ -2417,6 +4226,85 @@ func testBackend(
 	}
 }
 
+func TestEqualAtVersion(t *testing.T) {
+	ctx := context.Background()
+
+	ndbA, err := badgerDb.New(&db.Config{
+		DB:           t.TempDir(),
+		NoFsync:      true,
+		Namespace:    testNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+	})
+	require.NoError(t, err, "badger.New")
+	defer ndbA.Close()
+
+	ndbB, err := pathBadgerDb.New(&db.Config{
+		DB:           t.TempDir(),
+		NoFsync:      true,
+		Namespace:    testNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+	})
+	require.NoError(t, err, "pathbadger.New")
+	defer ndbB.Close()
+
+	commit := func(ndb db.NodeDB, version uint64, rootType node.RootType, keys ...string) node.Root {
+		tree := New(nil, ndb, rootType)
+		defer tree.Close()
+		for _, key := range keys {
+			err := tree.Insert(ctx, []byte(key), []byte("value "+key))
+			require.NoError(t, err, "Insert")
+		}
+		_, rootHash, err := tree.Commit(ctx, testNs, version)
+		require.NoError(t, err, "Commit")
+		return node.Root{Namespace: testNs, Version: version, Type: rootType, Hash: rootHash}
+	}
+
+	// Identical contents in both databases.
+	keys := []string{"foo", "foo/bar", "moo", "goo"}
+	for _, ndb := range []db.NodeDB{ndbA, ndbB} {
+		stateRoot := commit(ndb, 0, node.RootTypeState, keys...)
+		ioRoot := commit(ndb, 0, node.RootTypeIO, "input")
+		err = ndb.Finalize([]node.Root{stateRoot, ioRoot})
+		require.NoError(t, err, "Finalize")
+	}
+
+	equal, diffs, err := db.EqualAtVersion(ndbA, ndbB, 0)
+	require.NoError(t, err, "EqualAtVersion")
+	require.True(t, equal, "databases should be equal")
+	require.Empty(t, diffs)
+
+	// Different state and an IO root only present in the first database.
+	stateRootA := commit(ndbA, 1, node.RootTypeState, append(keys, "foo/baz")...)
+	ioRootA := commit(ndbA, 1, node.RootTypeIO, "input")
+	err = ndbA.Finalize([]node.Root{stateRootA, ioRootA})
+	require.NoError(t, err, "Finalize")
+
+	stateRootB := commit(ndbB, 1, node.RootTypeState, keys...)
+	err = ndbB.Finalize([]node.Root{stateRootB})
+	require.NoError(t, err, "Finalize")
+
+	equal, diffs, err = db.EqualAtVersion(ndbA, ndbB, 1)
+	require.NoError(t, err, "EqualAtVersion")
+	require.False(t, equal, "databases should not be equal")
+	require.Len(t, diffs, 3)
+
+	var stateDiffs []node.Root
+	for _, diff := range diffs {
+		switch diff.Type {
+		case node.RootTypeIO:
+			require.EqualValues(t, ioRootA, diff, "IO root should be reported as is")
+		case node.RootTypeState:
+			stateDiffs = append(stateDiffs, diff)
+		}
+	}
+	require.Len(t, stateDiffs, 2)
+
+	// The first differing nodes should be below the state roots.
+	require.NotEqual(t, stateRootA.Hash, stateDiffs[0].Hash, "difference should be below the root")
+	require.NotEqual(t, stateRootB.Hash, stateDiffs[1].Hash, "difference should be below the root")
+	require.NotEqual(t, stateDiffs[0].Hash, stateDiffs[1].Hash)
+}
+
 func TestBadgerBackend(t *testing.T) {
 	testBackend(t, func(t *testing.T) (NodeDBFactory, func()) {
 		// Create a new random temporary directory under /tmp.
@@ -2499,6 +4387,78 @@ func BenchmarkInsertNoCommitBatch1000(b *testing.B) {
 	benchmarkInsertBatch(b, 1000, false)
 }
 
+func BenchmarkMutateNoPrefetch(b *testing.B) {
+	benchmarkMutatePrefetch(b, false)
+}
+
+func BenchmarkMutatePrefetch(b *testing.B) {
+	benchmarkMutatePrefetch(b, true)
+}
+
+// benchmarkMutatePrefetch measures the time taken to mutate a set of keys in a freshly opened
+// database, optionally prefetching the affected paths beforehand (not included in the timing).
+func benchmarkMutatePrefetch(b *testing.B, prefetch bool) {
+	ctx := context.Background()
+
+	dir, err := os.MkdirTemp("", "mkvs.bench.badgerdb")
+	require.NoError(b, err, "TempDir")
+	defer os.RemoveAll(dir)
+	cfg := &db.Config{
+		DB:           dir,
+		Namespace:    testNs,
+		MaxCacheSize: 16 * 1024 * 1024,
+	}
+
+	// Populate the database.
+	ndb, err := badgerDb.New(cfg)
+	require.NoError(b, err, "New")
+	tree := New(nil, ndb, node.RootTypeState)
+	keys, values := generateKeyValuePairsEx("", 10_000)
+	for i := range keys {
+		err = tree.Insert(ctx, keys[i], values[i])
+		require.NoError(b, err, "Insert")
+	}
+	_, rootHash, err := tree.Commit(ctx, testNs, 0)
+	require.NoError(b, err, "Commit")
+	root := node.Root{Namespace: testNs, Version: 0, Type: node.RootTypeState, Hash: rootHash}
+	err = ndb.Finalize([]node.Root{root})
+	require.NoError(b, err, "Finalize")
+	ndb.Close()
+
+	// Mutate every 100th key.
+	var mutatedKeys []node.Key
+	for i := 0; i < len(keys); i += 100 {
+		mutatedKeys = append(mutatedKeys, keys[i])
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		ndb, err = badgerDb.New(cfg)
+		require.NoError(b, err, "New")
+		if prefetch {
+			var batch db.Batch
+			batch, err = ndb.NewBatch(root, 1, false)
+			require.NoError(b, err, "NewBatch")
+			err = batch.PrefetchKeys(mutatedKeys)
+			require.NoError(b, err, "PrefetchKeys")
+			batch.Reset()
+		}
+		tree = NewWithRoot(nil, ndb, root)
+		b.StartTimer()
+
+		for _, key := range mutatedKeys {
+			_ = tree.Insert(ctx, key, []byte("updated value"))
+		}
+
+		b.StopTimer()
+		tree.Close()
+		ndb.Close()
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(mutatedKeys)), "ns/mutation")
+}
+
 func benchmarkInsertBatch(b *testing.B, numValues int, commit bool) {
 	ctx := context.Background()
 
@@ -28,6 +28,7 @@ import (
 	"github.com/oasisprotocol/oasis-core/go/storage/mkvs/checkpoint"
 	dbApi "github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
 	mkvsDB "github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+	mkvsNode "github.com/oasisprotocol/oasis-core/go/storage/mkvs/node"
 	workerCommon "github.com/oasisprotocol/oasis-core/go/worker/common"
 	"github.com/oasisprotocol/oasis-core/go/worker/common/committee"
 	"github.com/oasisprotocol/oasis-core/go/worker/registration"
@@ -490,17 +491,10 @@ func (n *Node) initGenesis(rt *registryApi.Runtime, genesisBlock *block.Block) e
 		)
 
 		for v := latestVersion + 1; v < genesisBlock.Header.Round; v++ {
-			roots, err := n.localStorage.NodeDB().GetRootsForVersion(v)
+			stateRoots, err := n.localStorage.NodeDB().GetRootsForVersionByType(v, storageApi.RootTypeState)
 			if err != nil {
 				return fmt.Errorf("failed to fetch roots for version %d: %w", v, err)
 			}
-
-			var stateRoots []storageApi.Root
-			for _, root := range roots {
-				if root.Type == storageApi.RootTypeState {
-					stateRoots = append(stateRoots, root)
-				}
-			}
 			if len(stateRoots) != 1 {
 				break // We must have exactly one non-finalized state root to continue.
 			}
@@ -1215,18 +1209,10 @@ mainLoop:
 					Namespace: blk.Header.Namespace,
 					Round:     lastFullyAppliedRound + 1,
 					Roots: []storageApi.Root{
-						{
-							Version: lastFullyAppliedRound + 1,
-							Type:    storageApi.RootTypeIO,
-						},
-						{
-							Version: lastFullyAppliedRound + 1,
-							Type:    storageApi.RootTypeState,
-						},
+						mkvsNode.EmptyRoot(blk.Header.Namespace, lastFullyAppliedRound+1, storageApi.RootTypeIO),
+						mkvsNode.EmptyRoot(blk.Header.Namespace, lastFullyAppliedRound+1, storageApi.RootTypeState),
 					},
 				}
-				dummy.Roots[0].Empty()
-				dummy.Roots[1].Empty()
 				summaryCache[lastFullyAppliedRound] = &dummy
 			}
 			// Determine if we need to fetch any old block summaries. In case the first